@@ -1,81 +1,684 @@
 package build
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io/ioutil"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
+	"github.com/turtledex/errors"
 	"github.com/turtledex/fastrand"
 )
 
+var (
+	// ErrAPIPasswordIntegrity is returned by APIPassword if the api password
+	// file doesn't match the checksum stored in its sidecar file.
+	ErrAPIPasswordIntegrity = errors.New("api password file failed integrity check")
+
+	// ErrAPIPasswordPermissions is returned by APIPassword in strict mode if
+	// the api password file is accessible by anyone but its owner.
+	ErrAPIPasswordPermissions = errors.New("api password file permissions are too open")
+
+	// ErrAPIPasswordFromEnv is returned by RotateAPIPassword if the api
+	// password is set through the environment variable, since a rotated
+	// password file would never be used.
+	ErrAPIPasswordFromEnv = errors.New("api password is set by " + siaAPIPassword + " and can't be rotated")
+
+	// ErrSymlink is returned when reading or writing the api password file if
+	// the file is a symlink. Otherwise an attacker with write access to the
+	// TurtleDex data directory could point it at an arbitrary file.
+	ErrSymlink = errors.New("refusing to follow symlink")
+
+	// ErrDataDirTooNew is returned by CheckDataDirVersion if the TurtleDex data
+	// directory was last used by a newer version than the running one.
+	ErrDataDirTooNew = errors.New("data directory was written by a newer version")
+
+	// ErrReadOnlyDataDir is returned by APIPassword if there is no api password
+	// file yet and it can't be created because the data directory is
+	// read-only.
+	ErrReadOnlyDataDir = errors.New("data dir is read-only; set " + siaAPIPassword + " to provide the api password")
+
+	// ErrExchangeRateFormat is returned by ParsedExchangeRate if the exchange
+	// rate has neither the format "<rate> <currency>" nor "<currency>:<rate>".
+	ErrExchangeRateFormat = errors.New("exchange rate has an unexpected format")
+
+	// ErrExchangeRateValue is returned by ParsedExchangeRate if the rate isn't
+	// a positive and finite number.
+	ErrExchangeRateValue = errors.New("exchange rate must be a positive and finite number")
+
+	// exchangeRateRegExp describes the "<rate> <currency>" format of an
+	// exchange rate, e.g. "0.002 USD". The space is optional.
+	exchangeRateRegExp = regexp.MustCompile(`^\s*([0-9.]+) ?([A-Za-z_]+)\s*$`)
+
+	// exchangeRateColonRegExp describes the "<currency>:<rate>" format of an
+	// exchange rate, e.g. "USD:0.002".
+	exchangeRateColonRegExp = regexp.MustCompile(`^\s*([A-Za-z_]+)\s*:\s*([0-9.]+)\s*$`)
+)
+
+const (
+	// apiPasswordFingerprintSalt is the domain separation salt which is
+	// hashed together with the api password to compute its fingerprint.
+	apiPasswordFingerprintSalt = "turtledex/apipassword/fingerprint/v1"
+
+	// apiPasswordFingerprintLen is the number of bytes of the hash which are
+	// used as the fingerprint.
+	apiPasswordFingerprintLen = 8
+
+	// minAPIPasswordLen is the minimum length of an api password provided
+	// through the environment. Generated passwords are always 32 characters
+	// long.
+	minAPIPasswordLen = 8
+
+	// dataDirVersionFilename is the name of the file within the TurtleDex
+	// data directory which contains the version that last used it.
+	dataDirVersionFilename = "version"
+
+	// defaultTurtleDexdDataDirName is the name of the directory within the
+	// TurtleDex data directory which is used by TurtleDexdDataDirOrDefault if
+	// the ttdxd data directory isn't set.
+	defaultTurtleDexdDataDirName = "ttdxd"
+
+	// apiPasswordReadAttempts is the number of times the api password file
+	// is read if it fails the integrity check because it changed while it
+	// was read.
+	apiPasswordReadAttempts = 10
+)
+
+var (
+	// apiPasswordChecksumExt is the extension of the sidecar file which
+	// contains the checksum of the api password file.
+	apiPasswordChecksumExt = ".sha256"
+
+	// apiPasswordChecksumHash is the hash algorithm used to compute the
+	// checksum of the api password file.
+	apiPasswordChecksumHash func() hash.Hash = sha256.New
+
+	// randSource is the source of randomness used to generate the api
+	// password. It defaults to fastrand.Bytes and can be replaced, e.g. for
+	// deterministic tests or to use an approved RNG. Whoever replaces it is
+	// responsible for making sure the new source is cryptographically secure.
+	randSource func(n int) []byte = fastrand.Bytes
+
+	// ephemeralAPIPassword is the api password which is generated in
+	// ephemeral mode. It is kept for the lifetime of the process.
+	ephemeralAPIPassword   string
+	ephemeralAPIPasswordMu sync.Mutex
+
+	// apiPasswordLogger is called with a message whenever a new api password
+	// file is generated. It is nil by default.
+	apiPasswordLogger   func(msg string)
+	apiPasswordLoggerMu sync.Mutex
+
+	// atomicStrictAPIPasswordPerms is set to 1 if an api password file with
+	// permissions broader than 0600 should be rejected instead of fixed.
+	atomicStrictAPIPasswordPerms uint32
+)
+
+type (
+	// DataDir is a resolved data directory or file of a TurtleDex node.
+	DataDir struct {
+		Path   string `json:"path"`
+		Exists bool   `json:"exists"`
+	}
+
+	// DataDirPaths contains the resolved locations of a TurtleDex node's data.
+	DataDirPaths struct {
+		TurtleDexDir      DataDir `json:"turtledexdir"`
+		TurtleDexdDataDir DataDir `json:"turtledexddatadir"`
+		SkynetDir         DataDir `json:"skynetdir"`
+		ProfileDir        DataDir `json:"profiledir"`
+		APIPasswordFile   DataDir `json:"apipasswordfile"`
+	}
+)
+
 // APIPassword returns the TurtleDex API Password either from the environment variable
 // or from the password file. If no environment variable is set and no file
 // exists, a password file is created and that password is returned
 func APIPassword() (string, error) {
-	// Check the environment variable.
-	pw := os.Getenv(siaAPIPassword)
-	if pw != "" {
-		return pw, nil
-	}
+	return APIPasswordFromPath("")
+}
 
-	// Try to read the password from disk.
-	path := apiPasswordFilePath()
-	pwFile, err := ioutil.ReadFile(path)
-	if err == nil {
-		// This is the "normal" case, so don't print anything.
-		return strings.TrimSpace(string(pwFile)), nil
-	} else if !os.IsNotExist(err) {
-		return "", err
+// APIPasswordFromPath returns the TurtleDex API Password from the password
+// file at path. If the file doesn't exist, it is created together with its
+// parent directory and the new password is returned. An empty path selects the
+// default password file within the TurtleDex data directory, in which case the
+// environment variable takes precedence over the file. In ephemeral mode the
+// default password is generated in memory instead and never written to disk.
+func APIPasswordFromPath(path string) (string, error) {
+	pw, path, err := readAPIPassword(path)
+	if !os.IsNotExist(err) {
+		return pw, err
 	}
 
 	// No password file; generate a secure one.
 	// Generate a password file.
-	pw, err = createAPIPasswordFile()
+	pw, err = createAPIPasswordFile(path)
+	if err != nil {
+		return "", err
+	}
+	recordSource(resolvedAPIPassword, SourceGenerated)
+	return pw, nil
+}
+
+// readAPIPassword is like APIPasswordFromPath but never creates a password
+// file. If the password file doesn't exist, an error for which os.IsNotExist
+// returns true is returned together with the path of the file.
+func readAPIPassword(path string) (string, string, error) {
+	if path == "" {
+		// Check the environment variable.
+		pw := os.Getenv(siaAPIPassword)
+		if pw != "" {
+			if err := validateAPIPassword(pw); err != nil {
+				return "", "", errors.AddContext(err, fmt.Sprintf("invalid api password in %v", siaAPIPassword))
+			}
+			recordSource(resolvedAPIPassword, SourceEnv)
+			return pw, "", nil
+		}
+		if LoadEnvironment().Ephemeral() {
+			recordSource(resolvedAPIPassword, SourceGenerated)
+			return ephemeralPassword(), "", nil
+		}
+		path = apiPasswordFilePath()
+	}
+
+	// Try to read the password from disk.
+	pwFile, err := readFileNoFollow(path)
+	if err != nil {
+		return "", path, err
+	}
+	// This is the "normal" case, so don't print anything.
+	if err := checkAPIPasswordPermissions(path); err != nil {
+		return "", path, err
+	}
+	pwFile, err = verifiedAPIPasswordFile(path, pwFile)
+	if err != nil {
+		return "", path, err
+	}
+	recordSource(resolvedAPIPassword, SourceFile)
+	return strings.TrimSpace(string(pwFile)), path, nil
+}
+
+// CheckAPIPassword reports whether provided matches the current API password.
+// Both passwords are hashed before they are compared in constant time, so
+// neither the content nor the length of the real password leaks through the
+// duration of the comparison. Callers authenticating requests should always
+// use this instead of comparing against APIPassword themselves. Unlike
+// APIPassword, CheckAPIPassword never creates a password file, so checking a
+// password can't create credentials.
+func CheckAPIPassword(provided string) (bool, error) {
+	pw, _, err := readAPIPassword("")
+	if err != nil {
+		return false, errors.AddContext(err, "failed to get api password")
+	}
+	expected := sha256.Sum256([]byte(pw))
+	actual := sha256.Sum256([]byte(provided))
+	return subtle.ConstantTimeCompare(expected[:], actual[:]) == 1, nil
+}
+
+// APIPasswordFingerprint returns a fingerprint of the current API password.
+// The fingerprint is a truncated hash of the password and a fixed salt, so it
+// is the same on every machine with the same password without revealing the
+// password. It is only meant for comparing passwords across machines and must
+// never be used for authentication.
+func APIPasswordFingerprint() (string, error) {
+	pw, err := APIPassword()
 	if err != nil {
 		return "", err
 	}
+	return apiPasswordFingerprint(pw), nil
+}
+
+// RotateAPIPassword replaces the password in the default api password file
+// with a newly generated one and returns it. The checksum of the new password
+// is added to the sidecar before the file is replaced atomically, so readers
+// see either the old or the new password and both pass the integrity check. In
+// ephemeral mode the in-memory password is replaced instead. A password set
+// through the environment variable can't be rotated and ErrAPIPasswordFromEnv
+// is returned.
+func RotateAPIPassword() (string, error) {
+	if os.Getenv(siaAPIPassword) != "" {
+		return "", ErrAPIPasswordFromEnv
+	}
+	if LoadEnvironment().Ephemeral() {
+		return rotateEphemeralPassword(), nil
+	}
+	pw, err := createAPIPasswordFile(apiPasswordFilePath())
+	if err != nil {
+		return "", errors.AddContext(err, "failed to rotate api password")
+	}
 	return pw, nil
 }
 
+// DataDirs returns the resolved locations of the node's data and whether they
+// currently exist on disk. An empty TurtleDexdDataDir means that the consensus
+// is stored in the current working directory.
+func DataDirs() DataDirPaths {
+	return DataDirPaths{
+		TurtleDexDir:      newDataDir(TurtleDexDir()),
+		TurtleDexdDataDir: newDataDir(TurtleDexdDataDir()),
+		SkynetDir:         newDataDir(SkynetDir()),
+		ProfileDir:        newDataDir(ProfileDir()),
+		APIPasswordFile:   newDataDir(apiPasswordFilePath()),
+	}
+}
+
+// newDataDir returns a DataDir for path with the existence flag set.
+func newDataDir(path string) DataDir {
+	if path == "" {
+		return DataDir{}
+	}
+	_, err := os.Stat(path)
+	return DataDir{
+		Path:   path,
+		Exists: err == nil,
+	}
+}
+
+// EnsureDir creates the directory at path and its parents if they don't exist
+// and makes sure that the directory has 0700 permissions. We specifically use
+// 0700 in order to prevent potential attackers from accessing the sensitive
+// information inside, both by reading the contents of the directory and/or by
+// creating files with specific names which ttdxd would later on read from
+// and/or write to.
+func EnsureDir(path string) error {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("failed to create directory '%v'", path))
+	}
+	// Ensure the directory has the correct mode as MkdirAll won't change the
+	// mode of an existent directory.
+	err = os.Chmod(path, 0700)
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("failed to set permissions of directory '%v'", path))
+	}
+	return nil
+}
+
+// EnsureTurtleDexDir returns the TurtleDex data directory after ensuring it
+// with EnsureDir.
+func EnsureTurtleDexDir() (string, error) {
+	return ensuredDir(TurtleDexDir())
+}
+
+// EnsureSkynetDir returns the Skynet data directory after ensuring it with
+// EnsureDir.
+func EnsureSkynetDir() (string, error) {
+	return ensuredDir(SkynetDir())
+}
+
+// EnsureProfileDir returns the profile directory after ensuring it with
+// EnsureDir.
+func EnsureProfileDir() (string, error) {
+	return ensuredDir(ProfileDir())
+}
+
+// ensuredDir ensures dir with EnsureDir and returns it.
+func ensuredDir(dir string) (string, error) {
+	if err := EnsureDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 // ProfileDir returns the directory where any profiles for the running ttdxd
 // instance will be stored
 func ProfileDir() string {
 	return filepath.Join(TurtleDexdDataDir(), "profile")
 }
 
+// CleanProfileDir removes all but the newest keep files from the profile
+// directory, using their modification time to determine their age.
+// Subdirectories are left alone. The profile directory is created if it
+// doesn't exist.
+func CleanProfileDir(keep int) error {
+	if keep < 0 {
+		return fmt.Errorf("can't keep a negative number of profiles: %v", keep)
+	}
+	dir, err := EnsureProfileDir()
+	if err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.AddContext(err, "failed to read profile directory")
+	}
+	var files []os.FileInfo
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() {
+			files = append(files, fi)
+		}
+	}
+	if len(files) <= keep {
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	var errs error
+	for _, fi := range files[keep:] {
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			errs = errors.Compose(errs, err)
+		}
+	}
+	return errors.AddContext(errs, "failed to remove old profiles")
+}
+
+// CheckDataDirVersion compares the version stored in the TurtleDex data
+// directory against current, which is usually Version. If the stored version
+// is newer, ErrDataDirTooNew is returned since downgrading might corrupt the
+// data. Otherwise the stored version is updated to current. On the first run
+// the data directory is created and current is stored.
+func CheckDataDirVersion(current string) error {
+	if !IsVersion(current) {
+		return fmt.Errorf("invalid version '%v'", current)
+	}
+	dir := TurtleDexDir()
+	path := filepath.Join(dir, dataDirVersionFilename)
+	stored, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.AddContext(err, "failed to read data directory version")
+	}
+	if err == nil {
+		storedVersion := strings.TrimSpace(string(stored))
+		if !IsVersion(storedVersion) {
+			return fmt.Errorf("data directory version file '%v' contains an invalid version '%v'", path, storedVersion)
+		}
+		switch VersionCmp(storedVersion, current) {
+		case 1:
+			return errors.AddContext(ErrDataDirTooNew, fmt.Sprintf("'%v' was used by version %v but this is version %v", dir, storedVersion, current))
+		case 0:
+			return nil
+		}
+	}
+	if err := EnsureDir(dir); err != nil {
+		return err
+	}
+	return errors.AddContext(writeFileAtomic(path, []byte(current+"\n"), 0600), "failed to write data directory version")
+}
+
+// CheckConsensusDirWritable checks whether the ttdxd consensus data directory
+// is writable by the current process. If no consensus directory is set, the
+// current working directory is checked since that is where ttdxd will store
+// the consensus.
+func CheckConsensusDirWritable() error {
+	return checkConsensusDirWritable(TurtleDexdDataDir())
+}
+
+// checkConsensusDirWritable checks whether dir is writable. An empty dir
+// stands for the current working directory.
+func checkConsensusDirWritable(dir string) error {
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return errors.AddContext(err, "failed to resolve the current working directory as consensus directory")
+		}
+		dir = wd
+	}
+	return errors.AddContext(checkDirWritable(dir), fmt.Sprintf("consensus directory '%v' is not writable", dir))
+}
+
 // TurtleDexdDataDir returns the ttdxd consensus data directory from the
 // environment variable. If there is no environment variable it returns an empty
 // string, instructing ttdxd to store the consensus in the current directory.
 func TurtleDexdDataDir() string {
-	return os.Getenv(ttdxdDataDir)
+	env := LoadEnvironment()
+	recordSource(resolvedTurtleDexdDataDir, envSource(env.SiadDataDir))
+	return env.TurtleDexdDataDir()
+}
+
+// TurtleDexdDataDirOrDefault is like TurtleDexdDataDir but instead of an empty
+// string it returns the ttdxd subdirectory of the TurtleDex data directory if
+// there is no environment variable. That way the consensus doesn't end up in
+// whatever directory ttdxd was started from. The returned directory is ensured
+// by EnsureDir.
+func TurtleDexdDataDirOrDefault() (string, error) {
+	env := LoadEnvironment()
+	dir := env.TurtleDexdDataDir()
+	if dir == "" {
+		dir = filepath.Join(env.TurtleDexDir(), defaultTurtleDexdDataDirName)
+	}
+	if err := EnsureDir(dir); err != nil {
+		return "", errors.AddContext(err, "failed to ensure ttdxd data directory")
+	}
+	return dir, nil
 }
 
 // TurtleDexDir returns the TurtleDex data directory either from the environment variable or
 // the default.
 func TurtleDexDir() string {
-	siaDir := os.Getenv(siaDataDir)
-	if siaDir == "" {
-		siaDir = defaultTurtleDexDir()
-	}
-	return siaDir
+	env := LoadEnvironment()
+	recordSource(resolvedTurtleDexDir, envSource(env.SiaDataDir))
+	return env.TurtleDexDir()
 }
 
 // SkynetDir returns the Skynet data directory.
 func SkynetDir() string {
-	return defaultSkynetDir()
+	return LoadEnvironment().SkynetDir()
 }
 
-// WalletPassword returns the TurtleDexWalletPassword environment variable.
-func WalletPassword() string {
-	return os.Getenv(siaWalletPassword)
+// WalletPassword returns the TurtleDexWalletPassword environment variable or, if
+// it isn't set, the contents of the file the TurtleDexWalletPasswordFile
+// environment variable points to.
+func WalletPassword() (string, error) {
+	env := LoadEnvironment()
+	source := envSource(env.SiaWalletPassword)
+	if source == SourceDefault && env.SiaWalletPasswordFile != "" {
+		source = SourceFile
+	}
+	pw, err := env.WalletPassword()
+	if err != nil {
+		return "", err
+	}
+	recordSource(resolvedWalletPassword, source)
+	return pw, nil
 }
 
 // ExchangeRate returns the siaExchangeRate environment variable.
 func ExchangeRate() string {
-	return os.Getenv(siaExchangeRate)
+	env := LoadEnvironment()
+	recordSource(resolvedExchangeRate, envSource(env.SiaExchangeRate))
+	return env.ExchangeRate()
+}
+
+// ParsedExchangeRate parses the siaExchangeRate environment variable. The
+// format is either "<rate> <currency>", e.g. "0.002 USD", or
+// "<currency>:<rate>", e.g. "USD:0.002". Both formats are accepted by
+// SplitExchangeRate, which is also used by types.ParseExchangeRate, so every
+// consumer of the variable agrees on what is valid. If the variable isn't set,
+// an empty currency and a zero rate are returned without an error.
+func ParsedExchangeRate() (currency string, rate float64, err error) {
+	return parseExchangeRate(ExchangeRate())
+}
+
+// ParsedExchangeRates parses the siaExchangeRate environment variable as a
+// comma-separated list of exchange rates, e.g. "USD:1.23,1.10 EUR", and returns
+// the rates by their currency. Every rate is validated like by
+// ParsedExchangeRate. A currency which appears more than once is rejected
+// since it's unclear which rate is meant. If the variable isn't set, an empty
+// map is returned.
+func ParsedExchangeRates() (map[string]float64, error) {
+	return parseExchangeRates(ExchangeRate())
+}
+
+// parseExchangeRates parses a comma-separated list of exchange rates.
+func parseExchangeRates(s string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	if strings.TrimSpace(s) == "" {
+		return rates, nil
+	}
+	for _, token := range strings.Split(s, ",") {
+		if strings.TrimSpace(token) == "" {
+			return nil, errors.AddContext(ErrExchangeRateFormat, fmt.Sprintf("empty exchange rate in '%v'", s))
+		}
+		currency, rate, err := parseExchangeRate(token)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("invalid exchange rate '%v'", strings.TrimSpace(token)))
+		}
+		if _, exists := rates[currency]; exists {
+			return nil, errors.AddContext(ErrExchangeRateFormat, fmt.Sprintf("duplicate currency '%v'", currency))
+		}
+		rates[currency] = rate
+	}
+	return rates, nil
+}
+
+// SplitExchangeRate splits an exchange rate of the format "<rate> <currency>"
+// or "<currency>:<rate>" into its rate and currency without parsing the rate.
+// An empty string results in an empty rate and currency without an error.
+func SplitExchangeRate(s string) (rate, currency string, err error) {
+	if strings.TrimSpace(s) == "" {
+		return "", "", nil
+	}
+	if matches := exchangeRateRegExp.FindStringSubmatch(s); len(matches) == 3 {
+		return matches[1], matches[2], nil
+	}
+	if matches := exchangeRateColonRegExp.FindStringSubmatch(s); len(matches) == 3 {
+		return matches[2], matches[1], nil
+	}
+	return "", "", errors.AddContext(ErrExchangeRateFormat, fmt.Sprintf("'%v'", strings.TrimSpace(s)))
+}
+
+// parseExchangeRate parses an exchange rate with SplitExchangeRate and
+// validates its rate.
+func parseExchangeRate(s string) (string, float64, error) {
+	rateStr, currency, err := SplitExchangeRate(s)
+	if err != nil || rateStr == "" {
+		return "", 0, err
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil {
+		return "", 0, errors.AddContext(ErrExchangeRateFormat, fmt.Sprintf("'%v' has an invalid rate", strings.TrimSpace(s)))
+	}
+	if rate <= 0 || math.IsInf(rate, 0) {
+		return "", 0, errors.AddContext(ErrExchangeRateValue, fmt.Sprintf("'%v'", strings.TrimSpace(s)))
+	}
+	return currency, rate, nil
+}
+
+// ephemeralPassword returns the api password of the process in ephemeral
+// mode. It is generated on the first call.
+func ephemeralPassword() string {
+	ephemeralAPIPasswordMu.Lock()
+	defer ephemeralAPIPasswordMu.Unlock()
+	if ephemeralAPIPassword == "" {
+		ephemeralAPIPassword = hex.EncodeToString(randSource(16))
+	}
+	return ephemeralAPIPassword
+}
+
+// rotateEphemeralPassword replaces the api password of the process in
+// ephemeral mode with a newly generated one and returns it.
+func rotateEphemeralPassword() string {
+	ephemeralAPIPasswordMu.Lock()
+	defer ephemeralAPIPasswordMu.Unlock()
+	ephemeralAPIPassword = hex.EncodeToString(randSource(16))
+	return ephemeralAPIPassword
+}
+
+// readFileNoFollow is like ioutil.ReadFile but returns ErrSymlink if the file
+// at path is a symlink. Other errors are returned unchanged so they can still
+// be checked with os.IsNotExist.
+func readFileNoFollow(path string) ([]byte, error) {
+	if openFlagNoFollow == 0 {
+		if err := checkNotSymlink(path); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|openFlagNoFollow, 0)
+	if err != nil {
+		// Opening a symlink with O_NOFOLLOW fails with ELOOP, which is
+		// turned into ErrSymlink for a clear error message.
+		if symlinkErr := checkNotSymlink(path); symlinkErr != nil && !os.IsNotExist(err) {
+			return nil, symlinkErr
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	return data, errors.Compose(err, f.Close())
+}
+
+// checkNotSymlink returns ErrSymlink if the file at path is a symlink. A
+// missing file is not an error.
+func checkNotSymlink(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return errors.AddContext(ErrSymlink, path)
+	}
+	return nil
+}
+
+// SetAPIPasswordLogger sets the function which is called with a message
+// whenever a new api password file is generated. The message contains the path
+// of the file but never the password. A nil function disables the messages.
+func SetAPIPasswordLogger(logger func(msg string)) {
+	apiPasswordLoggerMu.Lock()
+	defer apiPasswordLoggerMu.Unlock()
+	apiPasswordLogger = logger
+}
+
+// logAPIPasswordCreated calls the api password logger, if set, to report that
+// a new api password file was written to path.
+func logAPIPasswordCreated(path string) {
+	apiPasswordLoggerMu.Lock()
+	logger := apiPasswordLogger
+	apiPasswordLoggerMu.Unlock()
+	if logger != nil {
+		logger(fmt.Sprintf("event=apipassword_generated path=%q", path))
+	}
+}
+
+// SetStrictAPIPasswordPermissions sets whether an api password file which is
+// accessible by anyone but its owner is rejected. By default the permissions
+// of such a file are reset to 0600.
+func SetStrictAPIPasswordPermissions(strict bool) {
+	var v uint32
+	if strict {
+		v = 1
+	}
+	atomic.StoreUint32(&atomicStrictAPIPasswordPerms, v)
+}
+
+// checkAPIPasswordPermissions makes sure that the api password file at path
+// has no permissions beyond 0600. Like ssh does for private keys, a file with
+// broader permissions is rejected in strict mode. Otherwise its permissions
+// are reset to 0600. The check is skipped on Windows, which doesn't use unix
+// permissions.
+func checkAPIPasswordPermissions(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.AddContext(err, "failed to stat api password file")
+	}
+	perm := fi.Mode().Perm()
+	if perm&^0600 == 0 {
+		return nil
+	}
+	if atomic.LoadUint32(&atomicStrictAPIPasswordPerms) == 1 {
+		return errors.AddContext(ErrAPIPasswordPermissions, fmt.Sprintf("'%v' has mode %v but should have mode 0600", path, perm))
+	}
+	return errors.AddContext(os.Chmod(path, 0600), "failed to fix api password file permissions")
 }
 
 // apiPasswordFilePath returns the path to the API's password file. The password
@@ -84,60 +687,245 @@ func apiPasswordFilePath() string {
 	return filepath.Join(TurtleDexDir(), "apipassword")
 }
 
-// createAPIPasswordFile creates an api password file in the TurtleDex data directory
-// and returns the newly created password
-func createAPIPasswordFile() (string, error) {
-	err := os.MkdirAll(TurtleDexDir(), 0700)
+// apiPasswordChecksumFilePath returns the path to the sidecar file containing
+// the checksum of the API's password file at pwPath.
+func apiPasswordChecksumFilePath(pwPath string) string {
+	return pwPath + apiPasswordChecksumExt
+}
+
+// apiPasswordChecksum returns the hex encoded checksum of the provided api
+// password file contents.
+func apiPasswordChecksum(pwFile []byte) string {
+	h := apiPasswordChecksumHash()
+	_, _ = h.Write(pwFile)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validateAPIPassword checks that pw is long enough to be used as api
+// password. Surrounding whitespace doesn't count towards the length.
+func validateAPIPassword(pw string) error {
+	if trimmed := strings.TrimSpace(pw); len(trimmed) < minAPIPasswordLen {
+		return fmt.Errorf("api password must be at least %v characters long but was %v", minAPIPasswordLen, len(trimmed))
+	}
+	return nil
+}
+
+// apiPasswordFingerprint returns the hex encoded fingerprint of the provided
+// api password.
+func apiPasswordFingerprint(pw string) string {
+	h := sha256.Sum256([]byte(apiPasswordFingerprintSalt + pw))
+	return hex.EncodeToString(h[:apiPasswordFingerprintLen])
+}
+
+// verifyAPIPasswordChecksum compares the checksum of the provided contents of
+// the api password file at pwPath against the checksums in its sidecar file.
+// The sidecar usually contains a single checksum, but while the password is
+// replaced it contains the checksums of both the old and the new password. If
+// there is no sidecar file the check is skipped to remain compatible with
+// password files created before the checksum was introduced.
+func verifyAPIPasswordChecksum(pwPath string, pwFile []byte) error {
+	checksums, err := readFileNoFollow(apiPasswordChecksumFilePath(pwPath))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.AddContext(err, "failed to read api password checksum file")
+	}
+	actual := []byte(apiPasswordChecksum(pwFile))
+	for _, expected := range bytes.Fields(checksums) {
+		if bytes.Equal(expected, actual) {
+			return nil
+		}
+	}
+	return errors.AddContext(ErrAPIPasswordIntegrity, pwPath)
+}
+
+// verifiedAPIPasswordFile verifies the contents of the api password file at
+// pwPath which were read before and returns the verified contents. The sidecar
+// always contains the checksum of the current password file, so a mismatch can
+// also mean that the password was replaced after the file was read. In that
+// case the file is read and verified again. Only a mismatch of a file which
+// didn't change in the meantime is reported as ErrAPIPasswordIntegrity.
+func verifiedAPIPasswordFile(pwPath string, pwFile []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		err := verifyAPIPasswordChecksum(pwPath, pwFile)
+		if !errors.Contains(err, ErrAPIPasswordIntegrity) || attempt == apiPasswordReadAttempts {
+			return pwFile, err
+		}
+		current, readErr := readFileNoFollow(pwPath)
+		if readErr != nil || bytes.Equal(current, pwFile) {
+			return nil, err
+		}
+		pwFile = current
+	}
+}
+
+// trustedAPIPasswordChecksums returns the checksums which the current api
+// password file at pwPath is verified against. Without a sidecar file that is
+// the checksum of the current password file, since it isn't verified at all.
+func trustedAPIPasswordChecksums(pwPath string) ([]string, error) {
+	checksums, err := readFileNoFollow(apiPasswordChecksumFilePath(pwPath))
+	if err == nil {
+		return strings.Fields(string(checksums)), nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.AddContext(err, "failed to read api password checksum file")
+	}
+	pwFile, err := readFileNoFollow(pwPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []string{apiPasswordChecksum(pwFile)}, nil
+}
+
+// canonicalDir canonicalizes a directory provided by the user through an
+// environment variable for the operating system goos. Surrounding whitespace
+// and quotes are removed and the path is cleaned lexically without resolving
+// any symlinks. On Windows both '/' and '\' are accepted as separators and
+// converted to '\' and the drive letter is upper-cased. The rest of the path
+// keeps its case since only the drive letter is known to be case-insensitive
+// on every filesystem. An empty dir remains empty.
+func canonicalDir(dir, goos string) string {
+	dir = strings.TrimSpace(dir)
+	dir = strings.Trim(dir, `"'`)
+	if dir == "" {
+		return ""
+	}
+	if goos != "windows" {
+		return path.Clean(dir)
+	}
+	// Split off the volume. It's either a drive letter or the '\\' prefix of a
+	// UNC path.
+	dir = strings.Replace(dir, `\`, "/", -1)
+	var volume string
+	if len(dir) >= 2 && dir[1] == ':' && isASCIILetter(dir[0]) {
+		volume, dir = strings.ToUpper(dir[:2]), dir[2:]
+	} else if strings.HasPrefix(dir, "//") {
+		volume, dir = "//", strings.TrimLeft(dir, "/")
+	}
+	if dir != "" {
+		dir = path.Clean(dir)
+	}
+	if volume == "//" && dir == "." {
+		dir = ""
+	}
+	return strings.Replace(volume+dir, "/", `\`, -1)
+}
+
+// isASCIILetter returns true if b is an ASCII letter.
+func isASCIILetter(b byte) bool {
+	return ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// checkDirWritable checks whether dir is writable by creating and removing a
+// temporary file within it. This is the only writability probe of the package,
+// use isReadOnlyError to tell whether a failure means that dir is read-only.
+func checkDirWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".writetest")
+	if err != nil {
+		return err
+	}
+	return errors.Compose(f.Close(), os.Remove(f.Name()))
+}
+
+// closestExistingDir returns dir if it exists or otherwise its closest
+// existing parent, which is where dir would have to be created.
+func closestExistingDir(dir string) (string, error) {
+	for {
+		_, err := os.Stat(dir)
+		if err == nil {
+			return dir, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+// isReadOnlyError returns true if err was returned by checkDirWritable because
+// the directory is read-only, either due to its permissions or a read-only
+// file system.
+func isReadOnlyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if os.IsPermission(err) {
+		return true
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.EROFS
+}
+
+// isReadOnlyDir returns true if dir is read-only or, if it doesn't exist, if
+// it can't be created within its closest existing parent.
+func isReadOnlyDir(dir string) bool {
+	dir, err := closestExistingDir(dir)
+	return err == nil && isReadOnlyError(checkDirWritable(dir))
+}
+
+// createAPIPasswordFile creates an api password file at path and returns the
+// newly created password. If that fails because the data directory is
+// read-only, ErrReadOnlyDataDir is returned instead of the raw error.
+func createAPIPasswordFile(path string) (string, error) {
+	pw, err := writeAPIPasswordFile(path)
+	if err != nil && isReadOnlyDir(filepath.Dir(path)) {
+		return "", errors.AddContext(ErrReadOnlyDataDir, filepath.Dir(path))
+	}
+	return pw, err
+}
+
+// writeAPIPasswordFile writes a new api password file and its checksum sidecar
+// to path. The checksum of the new password is added to the sidecar before the
+// password file is replaced, and the old checksums are only removed
+// afterwards. That way the password file always matches its sidecar, even if
+// one of the writes fails or a reader reads the files in between.
+func writeAPIPasswordFile(path string) (string, error) {
+	err := EnsureDir(filepath.Dir(path))
 	if err != nil {
 		return "", err
 	}
-	// Ensure TurtleDexDir has the correct mode as MkdirAll won't change the mode of
-	// an existent directory. We specifically use 0700 in order to prevent
-	// potential attackers from accessing the sensitive information inside, both
-	// by reading the contents of the directory and/or by creating files with
-	// specific names which ttdxd would later on read from and/or write to.
-	err = os.Chmod(TurtleDexDir(), 0700)
+	// writeFileAtomic replaces a symlink instead of following it, but an
+	// unexpected symlink is still a sign of tampering.
+	checksumPath := apiPasswordChecksumFilePath(path)
+	for _, p := range []string{path, checksumPath} {
+		if err := checkNotSymlink(p); err != nil {
+			return "", err
+		}
+	}
+	oldChecksums, err := trustedAPIPasswordChecksums(path)
 	if err != nil {
 		return "", err
 	}
-	pw := hex.EncodeToString(fastrand.Bytes(16))
-	err = ioutil.WriteFile(apiPasswordFilePath(), []byte(pw+"\n"), 0600)
+	pw := hex.EncodeToString(randSource(16))
+	pwFile := []byte(pw + "\n")
+	checksum := apiPasswordChecksum(pwFile)
+
+	// Write the checksum sidecar to be able to detect tampering with the
+	// password file later on.
+	err = writeFileAtomic(checksumPath, []byte(strings.Join(append(oldChecksums, checksum), "\n")+"\n"), 0600)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to add new api password checksum")
+	}
+	err = writeFileAtomic(path, pwFile, 0600)
 	if err != nil {
 		return "", err
 	}
+	// Removing the old checksums is best effort. If it fails, the password
+	// file still matches the sidecar, which just keeps trusting the old
+	// checksums until the password is replaced again.
+	_ = writeFileAtomic(checksumPath, []byte(checksum+"\n"), 0600)
+	logAPIPasswordCreated(path)
 	return pw, nil
 }
 
-// defaultTurtleDexDir returns the default data directory of ttdxd. The values for
-// supported operating systems are:
-//
-// Linux:   $HOME/.sia
-// MacOS:   $HOME/Library/Application Support/TurtleDex
-// Windows: %LOCALAPPDATA%\TurtleDex
+// defaultTurtleDexDir returns the default data directory of ttdxd for the
+// current environment.
 func defaultTurtleDexDir() string {
-	switch runtime.GOOS {
-	case "windows":
-		return filepath.Join(os.Getenv("LOCALAPPDATA"), "TurtleDex")
-	case "darwin":
-		return filepath.Join(os.Getenv("HOME"), "Library", "Application Support", "TurtleDex")
-	default:
-		return filepath.Join(os.Getenv("HOME"), ".sia")
-	}
-}
-
-// defaultSkynetDir returns default data directory for miscellaneous Skynet data,
-// e.g. skykeys. The values for supported operating systems are:
-//
-// Linux:   $HOME/.skynet
-// MacOS:   $HOME/Library/Application Support/Skynet
-// Windows: %LOCALAPPDATA%\Skynet
-func defaultSkynetDir() string {
-	switch runtime.GOOS {
-	case "windows":
-		return filepath.Join(os.Getenv("LOCALAPPDATA"), "Skynet")
-	case "darwin":
-		return filepath.Join(os.Getenv("HOME"), "Library", "Application Support", "Skynet")
-	default:
-		return filepath.Join(os.Getenv("HOME"), ".skynet")
-	}
+	return LoadEnvironment().defaultTurtleDexDir()
 }
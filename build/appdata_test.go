@@ -1,8 +1,19 @@
 package build
 
 import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/turtledex/errors"
 )
 
 // TestAPIPassword tests getting and setting the API Password
@@ -25,7 +36,7 @@ func TestAPIPassword(t *testing.T) {
 	}
 
 	// Test setting the env variable
-	newPW := "abc123"
+	newPW := "abc12345"
 	err = os.Setenv(siaAPIPassword, newPW)
 	if err != nil {
 		t.Error(err)
@@ -39,6 +50,954 @@ func TestAPIPassword(t *testing.T) {
 	}
 }
 
+// TestAPIPasswordEphemeral probes that the api password is kept in memory in
+// ephemeral mode.
+func TestAPIPasswordEphemeral(t *testing.T) {
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Setenv(siaEphemeral, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := errors.Compose(os.Unsetenv(siaDataDir), os.Unsetenv(siaEphemeral)); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	err = os.Unsetenv(siaAPIPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Repeated calls return the same password.
+	pw, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateAPIPassword(pw); err != nil {
+		t.Fatal(err)
+	}
+	pw2, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != pw2 {
+		t.Fatalf("Expected password to be %v but was %v", pw, pw2)
+	}
+	// No file is created.
+	if _, err := os.Stat(apiPasswordFilePath()); !os.IsNotExist(err) {
+		t.Fatal("api password file shouldn't exist", err)
+	}
+	if _, err := os.Stat(TurtleDexDir()); !os.IsNotExist(err) {
+		t.Fatal("TurtleDex dir shouldn't exist", err)
+	}
+
+	// Rotating replaces the password in memory without creating a file.
+	rotated, err := RotateAPIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == pw {
+		t.Fatal("rotation didn't change the password")
+	}
+	if pw, err := APIPassword(); err != nil || pw != rotated {
+		t.Fatalf("Expected password to be %v but was %v: %v", rotated, pw, err)
+	}
+	if _, err := os.Stat(TurtleDexDir()); !os.IsNotExist(err) {
+		t.Fatal("TurtleDex dir shouldn't exist", err)
+	}
+}
+
+// TestCheckAPIPassword tests CheckAPIPassword.
+func TestCheckAPIPassword(t *testing.T) {
+	err := os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	tests := []struct {
+		provided string
+		match    bool
+	}{
+		{"abc12345", true},   // match
+		{"abc12346", false},  // same length
+		{"abc1234", false},   // shorter
+		{"abc123456", false}, // longer
+		{"", false},          // empty
+	}
+	for _, test := range tests {
+		ok, err := CheckAPIPassword(test.provided)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.match {
+			t.Errorf("CheckAPIPassword(%q): expected %v but got %v", test.provided, test.match, ok)
+		}
+	}
+
+	// Without a password file, the check fails without creating one.
+	if err := os.Unsetenv(siaAPIPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(siaDataDir, TempDir(t.Name())); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if ok, err := CheckAPIPassword(""); err == nil || ok {
+		t.Fatal("expected the check to fail without a password file", ok, err)
+	}
+	if _, err := os.Stat(apiPasswordFilePath()); !os.IsNotExist(err) {
+		t.Fatal("api password file shouldn't exist", err)
+	}
+
+	// Once the file exists, its password is checked.
+	pw, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CheckAPIPassword(pw); err != nil || !ok {
+		t.Fatal("expected the password to match", ok, err)
+	}
+}
+
+// TestAPIPasswordFromPath tests APIPasswordFromPath.
+func TestAPIPasswordFromPath(t *testing.T) {
+	// The environment variable should be ignored for explicit paths.
+	err := os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing file should be created.
+	path := filepath.Join(dir, "apipassword")
+	pw, err := APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw == "" || pw == "abc12345" {
+		t.Fatal("expected a new password but got", pw)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0600), fi.Mode().Perm())
+	}
+	pw2, err := APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw2 != pw {
+		t.Fatalf("Expected password to be %v but was %v", pw, pw2)
+	}
+
+	// An existing file with trailing whitespace should be trimmed.
+	path = filepath.Join(dir, "whitespace")
+	if err := ioutil.WriteFile(path, []byte("foo \t\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pw, err = APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != "foo" {
+		t.Fatalf("Expected password to be %v but was %v", "foo", pw)
+	}
+
+	// A missing parent directory should be created with mode 0700.
+	parent := filepath.Join(dir, "missing", "parent")
+	pw, err = APIPasswordFromPath(filepath.Join(parent, "apipassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw == "" {
+		t.Fatal("Password should not be blank")
+	}
+	fi, err = os.Stat(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0700), fi.Mode().Perm())
+	}
+
+	// An empty path should honor the environment variable.
+	pw, err = APIPasswordFromPath("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != "abc12345" {
+		t.Fatalf("Expected password to be %v but was %v", "abc12345", pw)
+	}
+}
+
+// TestValidateAPIPassword tests validateAPIPassword and that APIPassword
+// rejects weak passwords from the environment.
+func TestValidateAPIPassword(t *testing.T) {
+	tests := []struct {
+		pw    string
+		valid bool
+	}{
+		{"", false},
+		{" \t\n ", false},
+		{"abc", false},
+		{"  abc1234  ", false},
+		{"abc12345", true},
+		{"01234567890123456789012345678901", true},
+	}
+	for _, test := range tests {
+		if err := validateAPIPassword(test.pw); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid to be %v but got %v", test.pw, test.valid, err)
+		}
+	}
+
+	err := os.Setenv(siaAPIPassword, "short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := APIPassword(); err == nil {
+		t.Fatal("expected short password to be rejected")
+	}
+}
+
+// TestAPIPasswordFingerprint tests APIPasswordFingerprint.
+func TestAPIPasswordFingerprint(t *testing.T) {
+	err := os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// The fingerprint should be deterministic and not contain the password.
+	fp, err := APIPasswordFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp2, err := APIPasswordFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != fp2 {
+		t.Fatalf("fingerprints don't match: %v != %v", fp, fp2)
+	}
+	if len(fp) != 2*apiPasswordFingerprintLen || strings.Contains(fp, "abc12345") {
+		t.Fatal("invalid fingerprint", fp)
+	}
+
+	// A different password should result in a different fingerprint.
+	if fp == apiPasswordFingerprint("abc124") {
+		t.Fatal("different passwords have the same fingerprint")
+	}
+}
+
+// TestAPIPasswordChecksum tests the integrity check of the API password file.
+func TestAPIPasswordChecksum(t *testing.T) {
+	// Use a fresh data dir and make sure the password is read from disk.
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	err = os.Unsetenv(siaAPIPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create the password file. This should also create the sidecar.
+	pw, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(apiPasswordChecksumFilePath(apiPasswordFilePath())); err != nil {
+		t.Fatal("checksum file wasn't created", err)
+	}
+
+	// Reading the password again should pass the integrity check.
+	pw2, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != pw2 {
+		t.Fatalf("Expected password to be %v but was %v", pw, pw2)
+	}
+
+	// A sidecar with the checksums of both the old and the new password, as
+	// left behind by an interrupted replacement, accepts either of them.
+	checksumPath := apiPasswordChecksumFilePath(apiPasswordFilePath())
+	oldChecksum, err := ioutil.ReadFile(checksumPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPWFile := []byte("new12345\n")
+	err = ioutil.WriteFile(checksumPath, append(oldChecksum, apiPasswordChecksum(newPWFile)+"\n"...), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := APIPassword(); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(apiPasswordFilePath(), newPWFile, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw, err := APIPassword(); err != nil || pw != "new12345" {
+		t.Fatalf("Expected password to be %v but was %v: %v", "new12345", pw, err)
+	}
+
+	// Replacing the password leaves only its checksum in the sidecar.
+	pw, err = RotateAPIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ioutil.ReadFile(checksumPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(checksums) != apiPasswordChecksum([]byte(pw+"\n"))+"\n" {
+		t.Fatal("unexpected checksums", string(checksums))
+	}
+
+	// Tamper with the password file.
+	err = ioutil.WriteFile(apiPasswordFilePath(), []byte("tampered\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = APIPassword()
+	if !errors.Contains(err, ErrAPIPasswordIntegrity) {
+		t.Fatalf("Expected %v but got %v", ErrAPIPasswordIntegrity, err)
+	}
+
+	// Without a sidecar the password should be read as before.
+	err = os.Remove(apiPasswordChecksumFilePath(apiPasswordFilePath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err = APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != "tampered" {
+		t.Fatalf("Expected password to be %v but was %v", "tampered", pw)
+	}
+}
+
+// TestAPIPasswordPermissions probes that an api password file with too open
+// permissions is either fixed or rejected in strict mode.
+func TestAPIPasswordPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't use unix permissions")
+	}
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "apipassword")
+	pw, err := APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the file world readable. By default the permissions are fixed.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pw2, err := APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != pw2 {
+		t.Fatalf("Expected password to be %v but was %v", pw, pw2)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	// In strict mode the file is rejected and left untouched.
+	SetStrictAPIPasswordPermissions(true)
+	defer SetStrictAPIPasswordPermissions(false)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = APIPasswordFromPath(path)
+	if !errors.Contains(err, ErrAPIPasswordPermissions) {
+		t.Fatalf("expected %v but got %v", ErrAPIPasswordPermissions, err)
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0644), fi.Mode().Perm())
+	}
+
+	// Narrower permissions are fine in strict mode too.
+	if err := os.Chmod(path, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := APIPasswordFromPath(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAPIPasswordLogger probes that the api password logger is only called
+// when a new password file is generated.
+func TestAPIPasswordLogger(t *testing.T) {
+	dir := TempDir(t.Name())
+	var msgs []string
+	SetAPIPasswordLogger(func(msg string) {
+		msgs = append(msgs, msg)
+	})
+	defer SetAPIPasswordLogger(nil)
+
+	// A new file is reported.
+	path := filepath.Join(dir, "apipassword")
+	pw, err := APIPasswordFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message but got %v", len(msgs))
+	}
+	if !strings.Contains(msgs[0], path) {
+		t.Fatal("message should contain the path", msgs[0])
+	}
+	if strings.Contains(msgs[0], pw) {
+		t.Fatal("message shouldn't contain the password", msgs[0])
+	}
+
+	// Reading the existing file isn't reported.
+	if _, err := APIPasswordFromPath(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message but got %v", len(msgs))
+	}
+
+	// A nil logger is fine.
+	SetAPIPasswordLogger(nil)
+	if _, err := APIPasswordFromPath(filepath.Join(dir, "apipassword2")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAPIPasswordSymlink probes that a symlinked api password file is neither
+// read nor written.
+func TestAPIPasswordSymlink(t *testing.T) {
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "target")
+	targetData := []byte("secret\n")
+	if err := ioutil.WriteFile(target, targetData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "apipassword")
+	if err := os.Symlink(target, path); err != nil {
+		t.Skip("can't create symlinks", err)
+	}
+
+	// Reading the password is refused.
+	if _, err := APIPasswordFromPath(path); !errors.Contains(err, ErrSymlink) {
+		t.Fatalf("expected %v but got %v", ErrSymlink, err)
+	}
+	// Writing the password is refused.
+	if _, err := createAPIPasswordFile(path); !errors.Contains(err, ErrSymlink) {
+		t.Fatalf("expected %v but got %v", ErrSymlink, err)
+	}
+	// A dangling symlink is refused as well.
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := APIPasswordFromPath(path); !errors.Contains(err, ErrSymlink) {
+		t.Fatalf("expected %v but got %v", ErrSymlink, err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("symlink target shouldn't have been created", err)
+	}
+}
+
+// TestAPIPasswordReadOnlyDataDir tests that a read-only data directory results
+// in ErrReadOnlyDataDir if there is no api password yet.
+func TestAPIPasswordReadOnlyDataDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows doesn't use unix permissions")
+	}
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	roDir := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(roDir, 0500); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chmod(roDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if !isReadOnlyDir(roDir) {
+		t.Skip("permissions aren't enforced for the current user")
+	}
+	err := os.Setenv(siaDataDir, filepath.Join(roDir, "sia"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	err = os.Unsetenv(siaAPIPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the environment variable the friendly error is returned and
+	// the preflight fails.
+	if _, err := APIPassword(); !errors.Contains(err, ErrReadOnlyDataDir) {
+		t.Fatalf("expected %v but got %v", ErrReadOnlyDataDir, err)
+	}
+	if r := preflightTurtleDexDirWritable(); r.OK || !r.Fatal {
+		t.Fatal("expected fatal preflight failure", r)
+	}
+	// With the environment variable the data dir isn't touched.
+	err = os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if pw, err := APIPassword(); err != nil || pw != "abc12345" {
+		t.Fatal("expected password from the environment", pw, err)
+	}
+	// The preflight only warns about the read-only data dir then, so ttdxd
+	// can start.
+	if r := preflightTurtleDexDirWritable(); r.OK || r.Fatal {
+		t.Fatal("expected preflight warning", r)
+	}
+
+	// A writable data dir is not reported as read-only.
+	if isReadOnlyDir(dir) {
+		t.Fatal("writable dir reported as read-only")
+	}
+}
+
+// TestRotateAPIPassword tests RotateAPIPassword.
+func TestRotateAPIPassword(t *testing.T) {
+	dir := TempDir(t.Name())
+	err := os.Setenv(siaDataDir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	err = os.Unsetenv(siaAPIPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate an existing password.
+	oldPW, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPW, err := RotateAPIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newPW == oldPW || len(newPW) != 32 {
+		t.Fatalf("expected a new password but got %v", newPW)
+	}
+	pw, err := APIPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != newPW {
+		t.Fatalf("Expected password to be %v but was %v", newPW, pw)
+	}
+	pwFile, err := ioutil.ReadFile(apiPasswordFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pwFile) != newPW+"\n" {
+		t.Fatalf("Expected file to contain %v but was %v", newPW, string(pwFile))
+	}
+	fi, err := os.Stat(apiPasswordFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	// A password from the environment variable can't be rotated.
+	err = os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RotateAPIPassword()
+	if err := errors.Compose(err, os.Unsetenv(siaAPIPassword)); !errors.Contains(err, ErrAPIPasswordFromEnv) {
+		t.Fatalf("Expected %v but got %v", ErrAPIPasswordFromEnv, err)
+	}
+	if pw, err := APIPassword(); err != nil || pw != newPW {
+		t.Fatalf("Expected password to be %v but was %v: %v", newPW, pw, err)
+	}
+
+	// Rotating should fail if the data directory can't be written.
+	notADir := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(notADir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = os.Setenv(siaDataDir, notADir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RotateAPIPassword(); err == nil {
+		t.Fatal("rotation should fail if the data directory isn't writable")
+	}
+}
+
+// TestEnsureDir probes that EnsureDir creates directories with 0700
+// permissions, tightens the permissions of existing ones and is idempotent.
+func TestEnsureDir(t *testing.T) {
+	dir := filepath.Join(TempDir(t.Name()), "a", "b")
+	for i := 0; i < 2; i++ {
+		if err := EnsureDir(dir); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() {
+			t.Fatal("expected a directory")
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != 0700 {
+			t.Fatalf("expected mode %v but got %v", os.FileMode(0700), fi.Mode().Perm())
+		}
+	}
+
+	// An existing 0755 directory is tightened.
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected mode %v but got %v", os.FileMode(0700), fi.Mode().Perm())
+	}
+
+	// The data dir getters return ensured directories.
+	err = os.Setenv(siaDataDir, filepath.Join(TempDir(t.Name()), "sia"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	siaDir, err := EnsureTurtleDexDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if siaDir != TurtleDexDir() {
+		t.Fatalf("expected %v but got %v", TurtleDexDir(), siaDir)
+	}
+	if fi, err := os.Stat(siaDir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Fatal("TurtleDex dir wasn't ensured", err)
+	}
+}
+
+// TestDataDirs probes that DataDirs matches the individual getters and
+// reports whether the paths exist.
+func TestDataDirs(t *testing.T) {
+	dir := TempDir(t.Name())
+	err := os.Setenv(siaDataDir, filepath.Join(dir, "sia"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Setenv(ttdxdDataDir, filepath.Join(dir, "siad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := errors.Compose(os.Unsetenv(siaDataDir), os.Unsetenv(ttdxdDataDir)); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Nothing exists yet. The Skynet dir is derived from the home dir and
+	// might exist, so its existence isn't checked.
+	dd := DataDirs()
+	paths := []struct {
+		dd   DataDir
+		path string
+	}{
+		{dd.TurtleDexDir, TurtleDexDir()},
+		{dd.TurtleDexdDataDir, TurtleDexdDataDir()},
+		{dd.SkynetDir, SkynetDir()},
+		{dd.ProfileDir, ProfileDir()},
+		{dd.APIPasswordFile, apiPasswordFilePath()},
+	}
+	for _, p := range paths {
+		if p.dd.Path != p.path {
+			t.Errorf("expected path %v but got %v", p.path, p.dd.Path)
+		}
+		if p.dd.Exists && p.path != SkynetDir() {
+			t.Errorf("%v shouldn't exist", p.path)
+		}
+	}
+
+	// Create the TurtleDex dir and the profile dir.
+	if _, err := EnsureTurtleDexDir(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnsureProfileDir(); err != nil {
+		t.Fatal(err)
+	}
+	dd = DataDirs()
+	if !dd.TurtleDexDir.Exists || !dd.TurtleDexdDataDir.Exists || !dd.ProfileDir.Exists {
+		t.Error("created dirs should exist", dd)
+	}
+	if dd.APIPasswordFile.Exists {
+		t.Error("api password file shouldn't exist", dd)
+	}
+}
+
+// TestCheckDataDirVersion probes CheckDataDirVersion for the first run as
+// well as older, equal and newer stored versions.
+func TestCheckDataDirVersion(t *testing.T) {
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	path := filepath.Join(TurtleDexDir(), dataDirVersionFilename)
+	storedVersion := func() string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	// First run stores the version.
+	if err := CheckDataDirVersion("1.5.0"); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(); v != "1.5.0" {
+		t.Fatalf("expected version %v but got %v", "1.5.0", v)
+	}
+	// Same version.
+	if err := CheckDataDirVersion("1.5.0"); err != nil {
+		t.Fatal(err)
+	}
+	// An older stored version is upgraded.
+	if err := CheckDataDirVersion("1.5.1"); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(); v != "1.5.1" {
+		t.Fatalf("expected version %v but got %v", "1.5.1", v)
+	}
+	// A newer stored version is rejected and left untouched.
+	if err := CheckDataDirVersion("1.4.9"); !errors.Contains(err, ErrDataDirTooNew) {
+		t.Fatalf("expected %v but got %v", ErrDataDirTooNew, err)
+	}
+	if v := storedVersion(); v != "1.5.1" {
+		t.Fatalf("expected version %v but got %v", "1.5.1", v)
+	}
+	// Invalid versions are rejected.
+	if err := CheckDataDirVersion("abc"); err == nil {
+		t.Fatal("expected an error for an invalid version")
+	}
+}
+
+// TestCleanProfileDir probes that CleanProfileDir only keeps the newest
+// profiles.
+func TestCleanProfileDir(t *testing.T) {
+	err := os.Setenv(ttdxdDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(ttdxdDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// The profile dir is created if missing.
+	if err := CleanProfileDir(1); err != nil {
+		t.Fatal(err)
+	}
+	dir := ProfileDir()
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create profiles with staggered modification times. The newest profile
+	// is profile0.
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("profile%v", i))
+		if err := ioutil.WriteFile(path, []byte("profile"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Subdirectories are ignored.
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CleanProfileDir(2); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if strings.Join(names, ",") != "profile0,profile1,subdir" {
+		t.Fatal("unexpected profile dir contents", names)
+	}
+
+	// A negative keep is rejected.
+	if err := CleanProfileDir(-1); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// TestRotateAPIPasswordConcurrentRead probes that a concurrent reader sees
+// either the old or the new api password but never a partially written file or
+// a password which fails the integrity check.
+func TestRotateAPIPasswordConcurrentRead(t *testing.T) {
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := RotateAPIPassword(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the file continuously while rotating the password.
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(readErr)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			b, err := ioutil.ReadFile(apiPasswordFilePath())
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if pw := strings.TrimSpace(string(b)); len(pw) != 32 || len(b) != 33 {
+				readErr <- fmt.Errorf("read partial password file %q", b)
+				return
+			}
+		}
+	}()
+	// Read the password through APIPassword as well. The password file
+	// always has to pass the integrity check.
+	apiErr := make(chan error, 1)
+	go func() {
+		defer close(apiErr)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := APIPassword(); err != nil {
+				apiErr <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := RotateAPIPassword(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	if err := errors.Compose(<-readErr, <-apiErr); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestTurtleDexdDataDir tests getting and setting the TurtleDex consensus directory
 func TestTurtleDexdDataDir(t *testing.T) {
 	// Unset any defaults, this only affects in memory state. Any Env Vars will
@@ -66,6 +1025,152 @@ func TestTurtleDexdDataDir(t *testing.T) {
 	}
 }
 
+// TestTurtleDexdDataDirOrDefault tests the fallback of TurtleDexdDataDirOrDefault.
+func TestTurtleDexdDataDirOrDefault(t *testing.T) {
+	dir := TempDir(t.Name())
+	err := errors.Compose(os.Setenv(siaDataDir, filepath.Join(dir, "sia")), os.Unsetenv(ttdxdDataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := errors.Compose(os.Unsetenv(siaDataDir), os.Unsetenv(ttdxdDataDir)); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Without the env variable the dir within the TurtleDex dir is returned
+	// and created.
+	ttdxdDir, err := TurtleDexdDataDirOrDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(dir, "sia", defaultTurtleDexdDataDirName)
+	if ttdxdDir != expected {
+		t.Fatalf("Expected ttdxdDir to be %v but was %v", expected, ttdxdDir)
+	}
+	fi, err := os.Stat(ttdxdDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Fatalf("expected dir with 0700 permissions but got %v", fi.Mode())
+	}
+	// The default of TurtleDexdDataDir is unchanged.
+	if TurtleDexdDataDir() != "" {
+		t.Fatal("TurtleDexdDataDir should still be empty")
+	}
+
+	// With the env variable its value is returned and created.
+	expected = filepath.Join(dir, "custom")
+	if err := os.Setenv(ttdxdDataDir, expected); err != nil {
+		t.Fatal(err)
+	}
+	ttdxdDir, err = TurtleDexdDataDirOrDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttdxdDir != expected {
+		t.Fatalf("Expected ttdxdDir to be %v but was %v", expected, ttdxdDir)
+	}
+	if _, err := os.Stat(ttdxdDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAPIPasswordRandSource tests that createAPIPasswordFile uses randSource.
+func TestAPIPasswordRandSource(t *testing.T) {
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Replace the source with a deterministic one.
+	defer func(rs func(int) []byte) {
+		randSource = rs
+	}(randSource)
+	randSource = func(n int) []byte {
+		return bytes.Repeat([]byte{1}, n)
+	}
+	pw, err := createAPIPasswordFile(apiPasswordFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "01010101010101010101010101010101"
+	if pw != expected {
+		t.Fatalf("Expected password to be %v but was %v", expected, pw)
+	}
+}
+
+// TestAPIPasswordRandSourceReader tests that randSource can be backed by a
+// fixed io.Reader to generate a deterministic password.
+func TestAPIPasswordRandSourceReader(t *testing.T) {
+	dir := TempDir(t.Name())
+	defer func(rs func(int) []byte) {
+		randSource = rs
+	}(randSource)
+	reader := bytes.NewReader([]byte("0123456789abcdef"))
+	randSource = func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(reader, b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	pw, err := createAPIPasswordFile(filepath.Join(dir, "apipassword"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := hex.EncodeToString([]byte("0123456789abcdef"))
+	if pw != expected {
+		t.Fatalf("Expected password to be %v but was %v", expected, pw)
+	}
+}
+
+// TestCheckConsensusDirWritable tests CheckConsensusDirWritable.
+func TestCheckConsensusDirWritable(t *testing.T) {
+	defer func() {
+		if err := os.Unsetenv(ttdxdDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// The current working directory is used if the env var isn't set.
+	err := os.Unsetenv(ttdxdDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckConsensusDirWritable(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writable directory should pass the check.
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = os.Setenv(ttdxdDataDir, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckConsensusDirWritable(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory that doesn't exist should fail the check.
+	err = os.Setenv(ttdxdDataDir, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckConsensusDirWritable(); err == nil {
+		t.Fatal("check should fail for missing directory")
+	}
+}
+
 // TestTurtleDexDir tests getting and setting the TurtleDex data directory
 func TestTurtleDexDir(t *testing.T) {
 	// Unset any defaults, this only affects in memory state. Any Env Vars will
@@ -102,8 +1207,16 @@ func TestTurtleDexWalletPassword(t *testing.T) {
 		t.Error(err)
 	}
 
+	err = os.Unsetenv(siaWalletPasswordFile)
+	if err != nil {
+		t.Error(err)
+	}
+
 	// Test Default Wallet Password
-	pw := WalletPassword()
+	pw, err := WalletPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if pw != "" {
 		t.Errorf("Expected wallet password to be blank but was %v", pw)
 	}
@@ -114,12 +1227,54 @@ func TestTurtleDexWalletPassword(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	pw = WalletPassword()
+	pw, err = WalletPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if pw != newPW {
 		t.Errorf("Expected wallet password to be %v but was %v", newPW, pw)
 	}
 }
 
+// TestTurtleDexWalletPasswordFile tests reading the TurtleDex Wallet Password
+// from a file.
+func TestTurtleDexWalletPasswordFile(t *testing.T) {
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "walletpassword")
+	if err := ioutil.WriteFile(path, []byte("  filepw\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// File only.
+	e := Environment{SiaWalletPasswordFile: path}
+	pw, err := e.WalletPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != "filepw" {
+		t.Errorf("Expected wallet password to be %v but was %v", "filepw", pw)
+	}
+
+	// Both set, the env variable takes precedence.
+	e.SiaWalletPassword = "envpw"
+	pw, err = e.WalletPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw != "envpw" {
+		t.Errorf("Expected wallet password to be %v but was %v", "envpw", pw)
+	}
+
+	// Missing file.
+	e = Environment{SiaWalletPasswordFile: filepath.Join(dir, "missing")}
+	if _, err := e.WalletPassword(); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
 // TestTurtleDexExchangeRate tests getting and setting the TurtleDex Exchange Rate
 func TestTurtleDexExchangeRate(t *testing.T) {
 	// Unset any defaults, this only affects in memory state. Any Env Vars will
@@ -146,3 +1301,140 @@ func TestTurtleDexExchangeRate(t *testing.T) {
 		t.Errorf("Expected exchange rate to be %v but was %v", newRate, rate)
 	}
 }
+
+// TestParsedExchangeRate probes parsing the TurtleDex Exchange Rate.
+func TestParsedExchangeRate(t *testing.T) {
+	err := os.Unsetenv(siaExchangeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaExchangeRate); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// An unset rate is not an error.
+	currency, rate, err := ParsedExchangeRate()
+	if err != nil || currency != "" || rate != 0 {
+		t.Fatal("unexpected result for unset rate", currency, rate, err)
+	}
+
+	// Valid rates are parsed in both formats.
+	for _, s := range []string{"USD:123.45", " USD : 123.45 ", "123.45 USD", "123.45USD"} {
+		err = os.Setenv(siaExchangeRate, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		currency, rate, err = ParsedExchangeRate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if currency != "USD" || rate != 123.45 {
+			t.Fatalf("%q: expected USD:123.45 but got %v:%v", s, currency, rate)
+		}
+	}
+
+	// Invalid rates are rejected.
+	tests := []struct {
+		rate string
+		err  error
+	}{
+		{"USD123.45", ErrExchangeRateFormat},
+		{"USD 123.45", ErrExchangeRateFormat},
+		{":123.45", ErrExchangeRateFormat},
+		{"USD:abc", ErrExchangeRateFormat},
+		{"USD:", ErrExchangeRateFormat},
+		{"USD:1.2.3", ErrExchangeRateFormat},
+		{"USD:-1", ErrExchangeRateFormat},
+		{"USD:NaN", ErrExchangeRateFormat},
+		{"USD:Inf", ErrExchangeRateFormat},
+		{"1 USD EUR", ErrExchangeRateFormat},
+		{"USD:0", ErrExchangeRateValue},
+		{"0.00 USD", ErrExchangeRateValue},
+	}
+	for _, test := range tests {
+		_, _, err := parseExchangeRate(test.rate)
+		if !errors.Contains(err, test.err) {
+			t.Errorf("%q: expected %v but got %v", test.rate, test.err, err)
+		}
+	}
+}
+
+// TestParsedExchangeRates probes parsing multiple TurtleDex Exchange Rates.
+func TestParsedExchangeRates(t *testing.T) {
+	err := os.Setenv(siaExchangeRate, "USD:1.23, 1.10 EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaExchangeRate); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	rates, err := ParsedExchangeRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rates) != 2 || rates["USD"] != 1.23 || rates["EUR"] != 1.10 {
+		t.Fatal("unexpected rates", rates)
+	}
+
+	// Unset and single rates.
+	rates, err = parseExchangeRates("")
+	if err != nil || len(rates) != 0 {
+		t.Fatal("expected no rates", rates, err)
+	}
+	rates, err = parseExchangeRates("USD:1.23")
+	if err != nil || len(rates) != 1 || rates["USD"] != 1.23 {
+		t.Fatal("unexpected rates", rates, err)
+	}
+
+	// Invalid lists name the offending token.
+	tests := []struct {
+		rates string
+		token string
+		err   error
+	}{
+		{"USD:1.23,EUR", "EUR", ErrExchangeRateFormat},
+		{"USD:1.23,1.24 USD", "USD", ErrExchangeRateFormat},
+		{"USD:1.23,EUR:0", "EUR:0", ErrExchangeRateValue},
+		{"USD:1.23,,EUR:1.10", "empty", ErrExchangeRateFormat},
+		{"USD:1.23,USD:1.24", "USD", ErrExchangeRateFormat},
+	}
+	for _, test := range tests {
+		_, err := parseExchangeRates(test.rates)
+		if !errors.Contains(err, test.err) || !strings.Contains(err.Error(), test.token) {
+			t.Errorf("%q: expected %v naming %q but got %v", test.rates, test.err, test.token, err)
+		}
+	}
+}
+
+// TestCanonicalDir probes canonicalDir with mixed separators on both Windows
+// and Unix style paths.
+func TestCanonicalDir(t *testing.T) {
+	tests := []struct {
+		goos string
+		dir  string
+		want string
+	}{
+		{"windows", "", ""},
+		{"windows", `C:/foo\bar`, `C:\foo\bar`},
+		{"windows", `c:\foo//bar\`, `C:\foo\bar`},
+		{"windows", `C:\foo\.\bar\..\baz`, `C:\foo\baz`},
+		{"windows", `C:/`, `C:\`},
+		{"windows", ` "C:/Program Files\TurtleDex" `, `C:\Program Files\TurtleDex`},
+		{"windows", `//server/share\TurtleDex/`, `\\server\share\TurtleDex`},
+		{"windows", `foo/bar\baz`, `foo\bar\baz`},
+		{"linux", "", ""},
+		{"linux", "/foo//bar/", "/foo/bar"},
+		{"linux", "/foo/./bar/../baz", "/foo/baz"},
+		{"linux", `/foo\bar`, `/foo\bar`},
+		{"darwin", " /Users/foo/ ", "/Users/foo"},
+	}
+	for _, test := range tests {
+		if got := canonicalDir(test.dir, test.goos); got != test.want {
+			t.Errorf("%v: canonicalDir(%q) should be %q but was %q", test.goos, test.dir, test.want, got)
+		}
+	}
+}
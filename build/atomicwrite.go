@@ -0,0 +1,203 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/turtledex/errors"
+)
+
+// atomicwrite.go contains the helpers for writing files atomically. A file is
+// written to a temporary file first which is then renamed to the target path.
+// By default the temporary file is created next to the target. Operators can
+// configure a different directory for the temporary files, but a rename is
+// only atomic within a single filesystem. The configured directory therefore
+// needs to be on the same filesystem as the TurtleDex data directory, which is
+// checked when it is configured. Targets outside of the data directory might
+// still be on another filesystem, e.g. an api password file passed to
+// APIPasswordFromPath. If renaming the temporary file fails for such a target,
+// the file is copied to a temporary file next to the target instead, which is
+// then renamed.
+
+var (
+	// atomicTempDir is the directory for the temporary files of atomic
+	// writes. If it is empty, the directory of the target is used.
+	atomicTempDir   string
+	atomicTempDirMu sync.Mutex
+
+	// renameFile renames a file. It defaults to os.Rename and can be replaced
+	// in tests to simulate a temp dir on a different filesystem.
+	renameFile = os.Rename
+)
+
+// AtomicTempDir returns the directory for the temporary files of atomic
+// writes. An empty string means that the directory of the target is used.
+func AtomicTempDir() string {
+	atomicTempDirMu.Lock()
+	defer atomicTempDirMu.Unlock()
+	return atomicTempDir
+}
+
+// SetAtomicTempDir sets the directory for the temporary files of atomic
+// writes. An empty dir resets it to the directory of the target. The dir needs
+// to be on the same filesystem as the TurtleDex data directory. Writes to
+// targets on other filesystems fall back to a temporary file next to the
+// target.
+func SetAtomicTempDir(dir string) error {
+	if dir != "" {
+		if err := checkSameFilesystem(dir, nearestExistingDir(TurtleDexDir())); err != nil {
+			return errors.AddContext(err, fmt.Sprintf("invalid temp dir '%v'", dir))
+		}
+	}
+	atomicTempDirMu.Lock()
+	defer atomicTempDirMu.Unlock()
+	atomicTempDir = dir
+	return nil
+}
+
+// nearestExistingDir returns dir or its closest ancestor that exists.
+func nearestExistingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// checkSameFilesystem checks whether files can be renamed from src to dst by
+// renaming a probe file between them.
+func checkSameFilesystem(src, dst string) error {
+	f, err := ioutil.TempFile(src, ".fsprobe")
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Compose(err, os.Remove(f.Name()))
+	}
+	target := filepath.Join(dst, filepath.Base(f.Name()))
+	if err := os.Rename(f.Name(), target); err != nil {
+		err = fmt.Errorf("'%v' is not on the same filesystem as '%v': %v", src, dst, err)
+		return errors.Compose(err, os.Remove(f.Name()))
+	}
+	return os.Remove(target)
+}
+
+// CreateAtomicTempFile creates a temporary file for atomically replacing the
+// file at path. The file is created in the configured temp dir or next to path
+// if none is configured. Once it is written and closed, it should be moved to
+// path with RenameAtomic.
+func CreateAtomicTempFile(path string) (*os.File, error) {
+	dir := AtomicTempDir()
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	return ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+}
+
+// RenameAtomic moves the closed temporary file at tmpPath, which was created
+// by CreateAtomicTempFile, to path. If the rename fails because the temporary
+// file isn't next to path, e.g. because the configured temp dir is on another
+// filesystem than path, the file is copied to a temporary file next to path
+// which is then renamed instead. The temporary files are removed if moving
+// them fails.
+func RenameAtomic(tmpPath, path string) error {
+	err := renameFile(tmpPath, path)
+	if err == nil {
+		return nil
+	}
+	if filepath.Dir(tmpPath) == filepath.Dir(path) {
+		return errors.Compose(err, os.Remove(tmpPath))
+	}
+	sibling, copyErr := copyToSibling(tmpPath, path)
+	removeErr := os.Remove(tmpPath)
+	if copyErr != nil {
+		return errors.Compose(err, copyErr, removeErr)
+	}
+	if err := renameFile(sibling, path); err != nil {
+		return errors.Compose(err, os.Remove(sibling), removeErr)
+	}
+	// The file was replaced, so a temporary file which couldn't be removed
+	// from the temp dir is just left behind.
+	return nil
+}
+
+// copyToSibling copies the file at src to a new temporary file next to path
+// and returns the path of the copy. The copy has the same permissions as src
+// and is synced to disk.
+func copyToSibling(src, path string) (_ string, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		err = errors.Compose(err, in.Close())
+	}()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Compose(err, os.Remove(out.Name()))
+		}
+	}()
+	if err := out.Chmod(fi.Mode().Perm()); err != nil {
+		return "", errors.Compose(err, out.Close())
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return "", errors.Compose(err, out.Close())
+	}
+	if err := out.Sync(); err != nil {
+		return "", errors.Compose(err, out.Close())
+	}
+	return out.Name(), out.Close()
+}
+
+// writeFileAtomic writes data to the file at path by writing it to a temporary
+// file and renaming it to path afterwards. Readers either see the old or the
+// new content of the file but never a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := writeAtomicTempFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+	return RenameAtomic(tmpPath, path)
+}
+
+// writeAtomicTempFile writes data to a new temporary file for atomically
+// replacing the file at path and returns the path of the temporary file. The
+// file is synced and closed. If writing fails, the file is removed.
+func writeAtomicTempFile(path string, data []byte, perm os.FileMode) (_ string, err error) {
+	f, err := CreateAtomicTempFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Compose(err, os.Remove(f.Name()))
+		}
+	}()
+	if err := f.Chmod(perm); err != nil {
+		return "", errors.Compose(err, f.Close())
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", errors.Compose(err, f.Close())
+	}
+	if err := f.Sync(); err != nil {
+		return "", errors.Compose(err, f.Close())
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,96 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// TestWriteFileAtomic probes writeFileAtomic with and without a configured
+// temp dir.
+func TestWriteFileAtomic(t *testing.T) {
+	dir := TempDir(t.Name())
+	tmpDir := filepath.Join(dir, "tmp")
+	targetDir := filepath.Join(dir, "target")
+	for _, d := range []string{tmpDir, targetDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Setenv(siaDataDir, targetDir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := SetAtomicTempDir(""); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// check is a helper to write a file and check its content and that no
+	// temporary files are left behind.
+	path := filepath.Join(targetDir, "file")
+	check := func(data []byte) {
+		t.Helper()
+		if err := writeFileAtomic(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Fatalf("expected %q but got %q", data, read)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+			t.Fatal("wrong permissions", fi.Mode().Perm())
+		}
+		for _, d := range []string{tmpDir, targetDir} {
+			fis, err := ioutil.ReadDir(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, fi := range fis {
+				if fi.Name() != "file" {
+					t.Fatal("temporary file left behind", fi.Name())
+				}
+			}
+		}
+	}
+
+	// Write the file next to the target and overwrite it using the temp dir.
+	check([]byte("foo"))
+	if err := SetAtomicTempDir(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	if AtomicTempDir() != tmpDir {
+		t.Fatal("temp dir wasn't set", AtomicTempDir())
+	}
+	check([]byte("bar"))
+
+	// If the temp dir is on another filesystem than the target, the file is
+	// moved through a temporary file next to the target.
+	renameFile = func(oldpath, newpath string) error {
+		if filepath.Dir(oldpath) == tmpDir {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	check([]byte("baz"))
+	renameFile = os.Rename
+
+	// A missing temp dir is rejected and doesn't change the setting.
+	if err := SetAtomicTempDir(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected missing temp dir to be rejected")
+	}
+	if AtomicTempDir() != tmpDir {
+		t.Fatal("temp dir was changed", AtomicTempDir())
+	}
+}
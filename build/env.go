@@ -1,5 +1,16 @@
 package build
 
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/turtledex/errors"
+)
+
 var (
 	// siaAPIPassword is the environment variable that sets a custom API
 	// password if the default is not used
@@ -17,7 +28,184 @@ var (
 	// auto unlocking the wallet
 	siaWalletPassword = "SIA_WALLET_PASSWORD"
 
+	// siaWalletPasswordFile is the environment variable that can be set to a
+	// file containing the wallet password. That way the password doesn't have
+	// to be stored in the environment.
+	siaWalletPasswordFile = "SIA_WALLET_PASSWORD_FILE"
+
 	// siaExchangeRate is the environment variable that can be set to
 	// show amounts (additionally) in a different currency
 	siaExchangeRate = "SIA_EXCHANGE_RATE"
+
+	// siaEphemeral is the environment variable that can be set to 1 to keep
+	// the api password in memory instead of writing it to disk.
+	siaEphemeral = "SIA_EPHEMERAL"
 )
+
+type (
+	// Environment contains the values of the environment variables read by
+	// the build package. The package level getters load a new Environment on
+	// every call, so changes to the environment of the process are picked up
+	// immediately. An Environment can also be constructed directly, e.g. to
+	// test code depending on the environment without modifying it.
+	Environment struct {
+		// SiaDataDir is the value of the siaDataDir variable.
+		SiaDataDir string
+		// SiadDataDir is the value of the ttdxdDataDir variable.
+		SiadDataDir string
+		// SiaWalletPassword is the value of the siaWalletPassword variable.
+		SiaWalletPassword string
+		// SiaWalletPasswordFile is the value of the siaWalletPasswordFile
+		// variable.
+		SiaWalletPasswordFile string
+		// SiaExchangeRate is the value of the siaExchangeRate variable.
+		SiaExchangeRate string
+		// SiaEphemeral is the value of the siaEphemeral variable.
+		SiaEphemeral string
+
+		// Home, XDGDataHome, LocalAppData, AppData and UserProfile are the
+		// values of the HOME, XDG_DATA_HOME, LOCALAPPDATA, APPDATA and
+		// USERPROFILE variables. They are used to derive the default data
+		// directories.
+		Home         string
+		XDGDataHome  string
+		LocalAppData string
+		AppData      string
+		UserProfile  string
+	}
+)
+
+// LoadEnvironment reads the environment variables of the build package from
+// the environment of the process.
+func LoadEnvironment() Environment {
+	return loadEnvironment(os.Getenv)
+}
+
+// loadEnvironment reads the environment variables of the build package using
+// getenv.
+func loadEnvironment(getenv func(string) string) Environment {
+	return Environment{
+		SiaDataDir:            getenv(siaDataDir),
+		SiadDataDir:           getenv(ttdxdDataDir),
+		SiaWalletPassword:     getenv(siaWalletPassword),
+		SiaWalletPasswordFile: getenv(siaWalletPasswordFile),
+		SiaExchangeRate:       getenv(siaExchangeRate),
+		SiaEphemeral:          getenv(siaEphemeral),
+
+		Home:         getenv("HOME"),
+		XDGDataHome:  getenv("XDG_DATA_HOME"),
+		LocalAppData: getenv("LOCALAPPDATA"),
+		AppData:      getenv("APPDATA"),
+		UserProfile:  getenv("USERPROFILE"),
+	}
+}
+
+// TurtleDexdDataDir returns the ttdxd consensus data directory. If it is not
+// set it returns an empty string, instructing ttdxd to store the consensus in
+// the current directory.
+func (e Environment) TurtleDexdDataDir() string {
+	return canonicalDir(e.SiadDataDir, runtime.GOOS)
+}
+
+// TurtleDexDir returns the TurtleDex data directory or the default if it is
+// not set.
+func (e Environment) TurtleDexDir() string {
+	siaDir := canonicalDir(e.SiaDataDir, runtime.GOOS)
+	if siaDir == "" {
+		siaDir = e.defaultTurtleDexDir()
+	}
+	return siaDir
+}
+
+// SkynetDir returns the Skynet data directory.
+func (e Environment) SkynetDir() string {
+	return e.defaultSkynetDir()
+}
+
+// WalletPassword returns the wallet password. The password set directly takes
+// precedence. Otherwise the trimmed contents of the wallet password file are
+// returned, if set. If neither is set, an empty string is returned.
+func (e Environment) WalletPassword() (string, error) {
+	if e.SiaWalletPassword != "" || e.SiaWalletPasswordFile == "" {
+		return e.SiaWalletPassword, nil
+	}
+	pw, err := ioutil.ReadFile(e.SiaWalletPasswordFile)
+	if err != nil {
+		return "", errors.AddContext(err, fmt.Sprintf("failed to read wallet password file from %v", siaWalletPasswordFile))
+	}
+	return strings.TrimSpace(string(pw)), nil
+}
+
+// Ephemeral returns true if the api password should only be kept in memory.
+func (e Environment) Ephemeral() bool {
+	return e.SiaEphemeral == "1"
+}
+
+// ExchangeRate returns the exchange rate.
+func (e Environment) ExchangeRate() string {
+	return e.SiaExchangeRate
+}
+
+// defaultTurtleDexDir returns the default data directory of ttdxd. The values for
+// supported operating systems are:
+//
+// Linux:   $HOME/.sia if it exists or XDG_DATA_HOME is not set, else $XDG_DATA_HOME/sia
+// MacOS:   $HOME/Library/Application Support/TurtleDex
+// Windows: %LOCALAPPDATA%\TurtleDex
+//
+// See localAppDataDir for the fallbacks if %LOCALAPPDATA% is not set.
+func (e Environment) defaultTurtleDexDir() string {
+	return e.defaultDirFor(runtime.GOOS, "TurtleDex", "sia")
+}
+
+// defaultSkynetDir returns default data directory for miscellaneous Skynet data,
+// e.g. skykeys. The values for supported operating systems are:
+//
+// Linux:   $HOME/.skynet if it exists or XDG_DATA_HOME is not set, else $XDG_DATA_HOME/skynet
+// MacOS:   $HOME/Library/Application Support/Skynet
+// Windows: %LOCALAPPDATA%\Skynet
+//
+// See localAppDataDir for the fallbacks if %LOCALAPPDATA% is not set.
+func (e Environment) defaultSkynetDir() string {
+	return e.defaultDirFor(runtime.GOOS, "Skynet", "skynet")
+}
+
+// defaultDirFor returns the default data directory of an application on goos.
+// name is used on Windows and MacOS, unixName on all other systems. Taking
+// goos as an argument allows for testing every branch on any system.
+func (e Environment) defaultDirFor(goos, name, unixName string) string {
+	switch goos {
+	case "windows":
+		return filepath.Join(e.localAppDataDir(), name)
+	case "darwin":
+		return filepath.Join(e.Home, "Library", "Application Support", name)
+	default:
+		// An existing dir in $HOME takes precedence over XDG_DATA_HOME, so
+		// setting XDG_DATA_HOME doesn't hide the data of an existing node.
+		legacyDir := filepath.Join(e.Home, "."+unixName)
+		if e.XDGDataHome == "" {
+			return legacyDir
+		}
+		if _, err := os.Stat(legacyDir); err == nil {
+			return legacyDir
+		}
+		return filepath.Join(e.XDGDataHome, unixName)
+	}
+}
+
+// localAppDataDir returns %LOCALAPPDATA%. Some service accounts and CI
+// containers don't set it, which would result in a data directory relative to
+// the working directory. In that case %APPDATA%, %USERPROFILE%\AppData\Local
+// and the temporary directory are tried in that order.
+func (e Environment) localAppDataDir() string {
+	switch {
+	case e.LocalAppData != "":
+		return e.LocalAppData
+	case e.AppData != "":
+		return e.AppData
+	case e.UserProfile != "":
+		return filepath.Join(e.UserProfile, "AppData", "Local")
+	default:
+		return os.TempDir()
+	}
+}
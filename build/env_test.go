@@ -0,0 +1,196 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestEnvironment tests the getters of an Environment which is constructed
+// without touching the environment of the process.
+func TestEnvironment(t *testing.T) {
+	e := Environment{
+		SiaDataDir:        "/foo//bar/",
+		SiadDataDir:       "/consensus/",
+		SiaWalletPassword: "walletpw",
+		SiaExchangeRate:   "0.01 EUR",
+		Home:              "/home/foo",
+		LocalAppData:      `C:\Users\foo\AppData\Local`,
+	}
+	if dir := e.TurtleDexDir(); dir != canonicalDir(e.SiaDataDir, runtime.GOOS) {
+		t.Errorf("unexpected TurtleDexDir %v", dir)
+	}
+	if dir := e.TurtleDexdDataDir(); dir != canonicalDir(e.SiadDataDir, runtime.GOOS) {
+		t.Errorf("unexpected TurtleDexdDataDir %v", dir)
+	}
+	if pw, err := e.WalletPassword(); err != nil || pw != e.SiaWalletPassword {
+		t.Errorf("Expected wallet password to be %v but was %v", e.SiaWalletPassword, pw)
+	}
+	if rate := e.ExchangeRate(); rate != e.SiaExchangeRate {
+		t.Errorf("Expected exchange rate to be %v but was %v", e.SiaExchangeRate, rate)
+	}
+
+	// Without a data dir the default is derived from the home directory.
+	e.SiaDataDir = ""
+	base := e.Home
+	if runtime.GOOS == "windows" {
+		base = e.LocalAppData
+	}
+	if dir := e.TurtleDexDir(); !strings.HasPrefix(dir, base) {
+		t.Errorf("default TurtleDexDir %v should be within %v", dir, base)
+	}
+	if dir := e.SkynetDir(); !strings.HasPrefix(dir, base) {
+		t.Errorf("default SkynetDir %v should be within %v", dir, base)
+	}
+
+	// The zero Environment has no consensus dir, wallet password or exchange
+	// rate.
+	var zero Environment
+	if pw, err := zero.WalletPassword(); err != nil || pw != "" {
+		t.Error("zero Environment should return an empty wallet password", err)
+	}
+	if zero.TurtleDexdDataDir() != "" || zero.ExchangeRate() != "" {
+		t.Error("zero Environment should return empty values")
+	}
+}
+
+// TestLocalAppDataDir tests the fallbacks of localAppDataDir.
+func TestLocalAppDataDir(t *testing.T) {
+	tests := []struct {
+		env  Environment
+		want string
+	}{
+		{Environment{LocalAppData: `C:\local`, AppData: `C:\roaming`, UserProfile: `C:\user`}, `C:\local`},
+		{Environment{AppData: `C:\roaming`, UserProfile: `C:\user`}, `C:\roaming`},
+		{Environment{UserProfile: `C:\user`}, filepath.Join(`C:\user`, "AppData", "Local")},
+		{Environment{}, os.TempDir()},
+	}
+	for i, test := range tests {
+		if got := test.env.localAppDataDir(); got != test.want {
+			t.Errorf("%v: expected %v but got %v", i, test.want, got)
+		}
+	}
+}
+
+// TestDefaultDirFor tests the default dirs of every supported operating system
+// independent of the system running the test.
+func TestDefaultDirFor(t *testing.T) {
+	vars := map[string]string{
+		"HOME":         "/home/foo",
+		"LOCALAPPDATA": `C:\Users\foo\AppData\Local`,
+	}
+	withXDG := map[string]string{
+		"HOME":          "/home/foo",
+		"XDG_DATA_HOME": "/xdg/data",
+	}
+	tests := []struct {
+		goos      string
+		vars      map[string]string
+		turtleDex string
+		skynet    string
+	}{
+		{"windows", vars, filepath.Join(`C:\Users\foo\AppData\Local`, "TurtleDex"), filepath.Join(`C:\Users\foo\AppData\Local`, "Skynet")},
+		{"darwin", vars, filepath.Join("/home/foo", "Library", "Application Support", "TurtleDex"), filepath.Join("/home/foo", "Library", "Application Support", "Skynet")},
+		{"darwin", withXDG, filepath.Join("/home/foo", "Library", "Application Support", "TurtleDex"), filepath.Join("/home/foo", "Library", "Application Support", "Skynet")},
+		{"linux", vars, filepath.Join("/home/foo", ".sia"), filepath.Join("/home/foo", ".skynet")},
+		{"linux", withXDG, filepath.Join("/xdg/data", "sia"), filepath.Join("/xdg/data", "skynet")},
+	}
+	for i, test := range tests {
+		getenv := func(key string) string { return test.vars[key] }
+		e := loadEnvironment(getenv)
+		if dir := e.defaultDirFor(test.goos, "TurtleDex", "sia"); dir != test.turtleDex {
+			t.Errorf("%v (%v): expected TurtleDex dir %v but got %v", i, test.goos, test.turtleDex, dir)
+		}
+		if dir := e.defaultDirFor(test.goos, "Skynet", "skynet"); dir != test.skynet {
+			t.Errorf("%v (%v): expected Skynet dir %v but got %v", i, test.goos, test.skynet, dir)
+		}
+	}
+}
+
+// TestXDGDataHome tests that the default dirs respect XDG_DATA_HOME on Linux.
+func TestXDGDataHome(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.SkipNow()
+	}
+	oldXDG, xdgSet := os.LookupEnv("XDG_DATA_HOME")
+	defer func() {
+		var err error
+		if xdgSet {
+			err = os.Setenv("XDG_DATA_HOME", oldXDG)
+		} else {
+			err = os.Unsetenv("XDG_DATA_HOME")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Use an empty HOME since existing dirs in it take precedence.
+	oldHome := os.Getenv("HOME")
+	defer func() {
+		if err := os.Setenv("HOME", oldHome); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := os.Setenv("HOME", TempDir(t.Name())); err != nil {
+		t.Fatal(err)
+	}
+
+	// With XDG_DATA_HOME the dirs are within it.
+	if err := os.Setenv("XDG_DATA_HOME", "/xdg/data"); err != nil {
+		t.Fatal(err)
+	}
+	if dir := defaultTurtleDexDir(); dir != "/xdg/data/sia" {
+		t.Errorf("Expected default TurtleDexDir to be /xdg/data/sia but was %v", dir)
+	}
+	if dir := SkynetDir(); dir != "/xdg/data/skynet" {
+		t.Errorf("Expected SkynetDir to be /xdg/data/skynet but was %v", dir)
+	}
+
+	// An empty XDG_DATA_HOME is ignored.
+	home := os.Getenv("HOME")
+	for _, set := range []bool{true, false} {
+		var err error
+		if set {
+			err = os.Setenv("XDG_DATA_HOME", "")
+		} else {
+			err = os.Unsetenv("XDG_DATA_HOME")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir := defaultTurtleDexDir(); dir != filepath.Join(home, ".sia") {
+			t.Errorf("Expected default TurtleDexDir to be in %v but was %v", home, dir)
+		}
+		if dir := SkynetDir(); dir != filepath.Join(home, ".skynet") {
+			t.Errorf("Expected SkynetDir to be in %v but was %v", home, dir)
+		}
+	}
+}
+
+// TestDefaultDirForExistingLegacyDir tests that an existing dir in $HOME takes
+// precedence over XDG_DATA_HOME on Linux.
+func TestDefaultDirForExistingLegacyDir(t *testing.T) {
+	home := TempDir(t.Name())
+	if err := os.RemoveAll(home); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".sia"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]string{
+		"HOME":          home,
+		"XDG_DATA_HOME": "/xdg/data",
+	}
+	e := loadEnvironment(func(key string) string { return vars[key] })
+
+	// $HOME/.sia exists and is used while $HOME/.skynet doesn't exist.
+	if dir := e.defaultDirFor("linux", "TurtleDex", "sia"); dir != filepath.Join(home, ".sia") {
+		t.Errorf("expected TurtleDex dir %v but got %v", filepath.Join(home, ".sia"), dir)
+	}
+	if dir := e.defaultDirFor("linux", "Skynet", "skynet"); dir != filepath.Join("/xdg/data", "skynet") {
+		t.Errorf("expected Skynet dir %v but got %v", filepath.Join("/xdg/data", "skynet"), dir)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package build
+
+import "syscall"
+
+// openFlagNoFollow makes os.OpenFile fail if the last element of the path is a
+// symlink.
+const openFlagNoFollow = syscall.O_NOFOLLOW
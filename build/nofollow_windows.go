@@ -0,0 +1,5 @@
+package build
+
+// openFlagNoFollow is not supported on Windows, which is why readFileNoFollow
+// falls back to checking for symlinks with os.Lstat.
+const openFlagNoFollow = 0
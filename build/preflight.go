@@ -0,0 +1,187 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/turtledex/errors"
+)
+
+// preflight.go contains a single check of the whole environment ttdxd is
+// about to run in. It consolidates the individual startup checks into one
+// report that can be logged at startup.
+
+// Names of the preflight checks.
+const (
+	PreflightAPIPassword       = "api-password"
+	PreflightConsensusDir      = "consensus-dir-writable"
+	PreflightEnvVars           = "env-vars"
+	PreflightExchangeRate      = "exchange-rate"
+	PreflightTurtleDexDir      = "data-dir-writable"
+	PreflightTurtleDexDirPerms = "data-dir-permissions"
+)
+
+type (
+	// PreflightResult is the result of a single preflight check.
+	PreflightResult struct {
+		Name    string `json:"name"`
+		OK      bool   `json:"ok"`
+		Message string `json:"message"`
+
+		// Fatal indicates that ttdxd shouldn't start if the check failed.
+		// Failed checks that aren't fatal are warnings.
+		Fatal bool `json:"fatal"`
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (pr PreflightResult) String() string {
+	status := "OK"
+	if !pr.OK && pr.Fatal {
+		status = "FATAL"
+	} else if !pr.OK {
+		status = "WARN"
+	}
+	if pr.Message == "" {
+		return fmt.Sprintf("%v: %v", status, pr.Name)
+	}
+	return fmt.Sprintf("%v: %v: %v", status, pr.Name, pr.Message)
+}
+
+// PreflightCheck validates the environment ttdxd is running in. It checks
+// that the data directories are writable and have the right permissions, that
+// the environment variables are sane, that the api password file is intact and
+// that the exchange rate can be parsed. consensusDir is the directory ttdxd
+// stores its modules in after resolving its flags. An empty string stands for
+// the current working directory.
+func PreflightCheck(consensusDir string) []PreflightResult {
+	return []PreflightResult{
+		preflightTurtleDexDirWritable(),
+		preflightTurtleDexDirPermissions(),
+		preflightConsensusDirWritable(consensusDir),
+		preflightEnvVars(),
+		preflightAPIPassword(),
+		preflightExchangeRate(),
+	}
+}
+
+// PreflightFailed returns true if any of the provided results is a fatal
+// failure.
+func PreflightFailed(results []PreflightResult) bool {
+	for _, r := range results {
+		if !r.OK && r.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+// newPreflightResult creates a PreflightResult from an error.
+func newPreflightResult(name string, fatal bool, err error) PreflightResult {
+	pr := PreflightResult{
+		Name:  name,
+		OK:    err == nil,
+		Fatal: fatal,
+	}
+	if err != nil {
+		pr.Message = err.Error()
+	}
+	return pr
+}
+
+// preflightTurtleDexDirWritable checks that the TurtleDex data directory is
+// writable. If it doesn't exist yet, the closest existing parent directory
+// needs to be writable for ttdxd to be able to create it. A read-only data
+// directory is only a warning if the api password is set through the
+// environment, since that's the only file ttdxd needs to create in there.
+func preflightTurtleDexDirWritable() PreflightResult {
+	fatal := os.Getenv(siaAPIPassword) == ""
+	dir, err := closestExistingDir(TurtleDexDir())
+	if err != nil {
+		return newPreflightResult(PreflightTurtleDexDir, fatal, err)
+	}
+	err = checkDirWritable(dir)
+	if err != nil {
+		err = fmt.Errorf("'%v' is not writable: %v", dir, err)
+	}
+	return newPreflightResult(PreflightTurtleDexDir, fatal, err)
+}
+
+// preflightTurtleDexDirPermissions checks that the TurtleDex data directory
+// is only accessible by its owner. The directory contains the api password
+// and is created with mode 0700.
+func preflightTurtleDexDirPermissions() PreflightResult {
+	if runtime.GOOS == "windows" {
+		return newPreflightResult(PreflightTurtleDexDirPerms, false, nil)
+	}
+	fi, err := os.Stat(TurtleDexDir())
+	if os.IsNotExist(err) {
+		return newPreflightResult(PreflightTurtleDexDirPerms, false, nil)
+	} else if err != nil {
+		return newPreflightResult(PreflightTurtleDexDirPerms, false, err)
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		err = fmt.Errorf("'%v' has mode %v but should only be accessible by its owner", TurtleDexDir(), perm)
+	}
+	return newPreflightResult(PreflightTurtleDexDirPerms, false, err)
+}
+
+// preflightConsensusDirWritable checks that the consensus directory is
+// writable.
+func preflightConsensusDirWritable(dir string) PreflightResult {
+	return newPreflightResult(PreflightConsensusDir, true, checkConsensusDirWritable(dir))
+}
+
+// preflightEnvVars checks the environment variables which contain
+// directories. Relative paths depend on the working directory ttdxd is
+// started from, which is usually not what the user intended.
+func preflightEnvVars() PreflightResult {
+	var problems []string
+	for _, name := range []string{siaDataDir, ttdxdDataDir} {
+		val, set := os.LookupEnv(name)
+		if !set {
+			continue
+		}
+		if strings.TrimSpace(val) == "" {
+			problems = append(problems, fmt.Sprintf("%v is set but empty", name))
+		} else if !filepath.IsAbs(val) {
+			problems = append(problems, fmt.Sprintf("%v is not an absolute path: '%v'", name, val))
+		}
+	}
+	var err error
+	if len(problems) > 0 {
+		err = fmt.Errorf("%v", strings.Join(problems, ", "))
+	}
+	return newPreflightResult(PreflightEnvVars, false, err)
+}
+
+// preflightAPIPassword checks that the api password file passes its integrity
+// check. If the password is set through the environment, only its length is
+// checked. The check is skipped if no password file exists yet.
+func preflightAPIPassword() PreflightResult {
+	if pw := os.Getenv(siaAPIPassword); pw != "" {
+		return newPreflightResult(PreflightAPIPassword, true, validateAPIPassword(pw))
+	}
+	pwPath := apiPasswordFilePath()
+	pwFile, err := readFileNoFollow(pwPath)
+	if os.IsNotExist(err) {
+		return newPreflightResult(PreflightAPIPassword, true, nil)
+	} else if err != nil {
+		return newPreflightResult(PreflightAPIPassword, true, err)
+	}
+	return newPreflightResult(PreflightAPIPassword, true, verifyAPIPasswordChecksum(pwPath, pwFile))
+}
+
+// preflightExchangeRate checks that the exchange rate, if set, can be parsed.
+// An invalid exchange rate only affects how amounts are displayed, which is
+// why this is only a warning.
+func preflightExchangeRate() PreflightResult {
+	_, _, err := parseExchangeRate(ExchangeRate())
+	if err != nil {
+		err = errors.AddContext(err, fmt.Sprintf("invalid %v", siaExchangeRate))
+	}
+	return newPreflightResult(PreflightExchangeRate, false, err)
+}
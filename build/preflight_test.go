@@ -0,0 +1,94 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPreflightCheck probes PreflightCheck.
+func TestPreflightCheck(t *testing.T) {
+	dir := TempDir(t.Name())
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, name := range []string{siaDataDir, ttdxdDataDir, siaExchangeRate, siaAPIPassword} {
+			if err := os.Unsetenv(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	setEnv := func(name, value string) {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// result is a helper to find a result by name.
+	result := func(results []PreflightResult, name string) PreflightResult {
+		for _, r := range results {
+			if r.Name == name {
+				return r
+			}
+		}
+		t.Fatal("missing result", name)
+		return PreflightResult{}
+	}
+
+	// A sane environment should pass all checks.
+	setEnv(siaDataDir, filepath.Join(dir, "sia"))
+	setEnv(ttdxdDataDir, dir)
+	setEnv(siaExchangeRate, "0.01 usd")
+	if err := os.Unsetenv(siaAPIPassword); err != nil {
+		t.Fatal(err)
+	}
+	results := PreflightCheck(dir)
+	for _, r := range results {
+		if !r.OK {
+			t.Fatal("check failed", r)
+		}
+	}
+	if PreflightFailed(results) {
+		t.Fatal("preflight shouldn't fail")
+	}
+
+	// Warnings shouldn't fail the preflight.
+	setEnv(siaExchangeRate, "invalid")
+	results = PreflightCheck(dir)
+	if r := result(results, PreflightExchangeRate); r.OK || r.Fatal {
+		t.Fatal("expected exchange rate warning", r)
+	}
+	if PreflightFailed(results) {
+		t.Fatal("preflight shouldn't fail because of a warning")
+	}
+
+	// Both exchange rate formats are accepted.
+	for _, rate := range []string{"0.01 usd", "USD:0.01"} {
+		setEnv(siaExchangeRate, rate)
+		if r := result(PreflightCheck(dir), PreflightExchangeRate); !r.OK {
+			t.Fatal("expected valid exchange rate", r)
+		}
+	}
+
+	// A missing consensus dir is fatal.
+	results = PreflightCheck(filepath.Join(dir, "missing"))
+	if r := result(results, PreflightConsensusDir); r.OK || !r.Fatal {
+		t.Fatal("expected fatal consensus dir failure", r)
+	}
+	if !PreflightFailed(results) {
+		t.Fatal("preflight should fail")
+	}
+
+	// The consensus dir passed in takes precedence over the environment, e.g.
+	// if ttdxd was started with --sia-directory.
+	setEnv(ttdxdDataDir, filepath.Join(dir, "missing"))
+	if r := result(PreflightCheck(dir), PreflightConsensusDir); !r.OK {
+		t.Fatal("expected the passed consensus dir to be checked", r)
+	}
+
+	// Relative paths result in a warning.
+	setEnv(ttdxdDataDir, "relative")
+	if r := result(PreflightCheck(dir), PreflightEnvVars); r.OK || r.Fatal {
+		t.Fatal("expected env var warning", r)
+	}
+}
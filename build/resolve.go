@@ -0,0 +1,70 @@
+package build
+
+import (
+	"sync"
+)
+
+// resolve.go keeps track of where the values returned by the getters of the
+// build package came from. Only the source of a value is recorded, never the
+// value itself, so the report can be shared in support tickets without leaking
+// secrets. ttdxd prints the report once during startup.
+
+// ValueSource describes where a resolved value came from.
+type ValueSource string
+
+const (
+	// SourceEnv means that the value was set through an environment variable.
+	SourceEnv ValueSource = "env"
+	// SourceFile means that the value was read from a file.
+	SourceFile ValueSource = "file"
+	// SourceGenerated means that the value was generated, e.g. a new api
+	// password.
+	SourceGenerated ValueSource = "generated"
+	// SourceDefault means that no value was configured and the default was
+	// used.
+	SourceDefault ValueSource = "default"
+)
+
+const (
+	// Names of the resolved values in the ResolveReport.
+	resolvedAPIPassword       = "apipassword"
+	resolvedWalletPassword    = "walletpassword"
+	resolvedTurtleDexDir      = "turtledexdir"
+	resolvedTurtleDexdDataDir = "turtledexddatadir"
+	resolvedExchangeRate      = "exchangerate"
+)
+
+var (
+	// resolvedSources contains the source of the most recently resolved
+	// value for every name.
+	resolvedSources   = make(map[string]ValueSource)
+	resolvedSourcesMu sync.Mutex
+)
+
+// ResolveReport returns the sources of the most recently resolved values by
+// their name. Values which haven't been resolved yet are missing.
+func ResolveReport() map[string]ValueSource {
+	resolvedSourcesMu.Lock()
+	defer resolvedSourcesMu.Unlock()
+	report := make(map[string]ValueSource, len(resolvedSources))
+	for name, source := range resolvedSources {
+		report[name] = source
+	}
+	return report
+}
+
+// recordSource records the source of the value with the given name.
+func recordSource(name string, source ValueSource) {
+	resolvedSourcesMu.Lock()
+	defer resolvedSourcesMu.Unlock()
+	resolvedSources[name] = source
+}
+
+// envSource returns SourceEnv if the environment variable was set and
+// SourceDefault otherwise.
+func envSource(value string) ValueSource {
+	if value != "" {
+		return SourceEnv
+	}
+	return SourceDefault
+}
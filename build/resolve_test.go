@@ -0,0 +1,70 @@
+package build
+
+import (
+	"os"
+	"testing"
+)
+
+// TestResolveReport probes that the report contains the correct source of the
+// api password.
+func TestResolveReport(t *testing.T) {
+	err := os.Setenv(siaDataDir, TempDir(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaDataDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	err = os.Unsetenv(siaAPIPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first call generates the password.
+	if _, err := APIPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if source := ResolveReport()[resolvedAPIPassword]; source != SourceGenerated {
+		t.Fatalf("expected source %v but got %v", SourceGenerated, source)
+	}
+	if source := ResolveReport()[resolvedTurtleDexDir]; source != SourceEnv {
+		t.Fatalf("expected source %v but got %v", SourceEnv, source)
+	}
+
+	// The second call reads it from the file.
+	if _, err := APIPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if source := ResolveReport()[resolvedAPIPassword]; source != SourceFile {
+		t.Fatalf("expected source %v but got %v", SourceFile, source)
+	}
+
+	// The environment variable takes precedence.
+	err = os.Setenv(siaAPIPassword, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Unsetenv(siaAPIPassword); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := APIPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if source := ResolveReport()[resolvedAPIPassword]; source != SourceEnv {
+		t.Fatalf("expected source %v but got %v", SourceEnv, source)
+	}
+
+	// Without an environment variable the default TurtleDex dir is used.
+	err = os.Unsetenv(siaDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	TurtleDexDir()
+	if source := ResolveReport()[resolvedTurtleDexDir]; source != SourceDefault {
+		t.Fatalf("expected source %v but got %v", SourceDefault, source)
+	}
+}
@@ -657,9 +657,12 @@ func wallettransactionscmd() {
 func walletunlockcmd() {
 	// try reading from environment variable first, then fallback to
 	// interactive method. Also allow overriding auto-unlock via -p
-	password := build.WalletPassword()
+	password, err := build.WalletPassword()
+	if err != nil {
+		fmt.Println("Reading wallet password from environment failed:", err)
+	}
 	if password != "" && !initPassword {
-		fmt.Println("Using SIA_WALLET_PASSWORD environment variable")
+		fmt.Println("Using wallet password from environment")
 		err := httpClient.WalletUnlockPost(password)
 		if err != nil {
 			fmt.Println("Automatic unlock failed!")
@@ -668,7 +671,7 @@ func walletunlockcmd() {
 			return
 		}
 	}
-	password, err := passwordPrompt("Wallet password: ")
+	password, err = passwordPrompt("Wallet password: ")
 	if err != nil {
 		die("Reading password failed:", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -158,7 +159,12 @@ func installKillSignalHandler() chan os.Signal {
 // tryAutoUnlock will try to automatically unlock the server's wallet if the
 // environment variable is set.
 func tryAutoUnlock(srv *server.Server) {
-	if password := build.WalletPassword(); password != "" {
+	password, err := build.WalletPassword()
+	if err != nil {
+		fmt.Println("Auto-unlock failed:", err)
+		return
+	}
+	if password != "" {
 		fmt.Println("TurtleDex Wallet Password found, attempting to auto-unlock wallet")
 		if err := srv.Unlock(password); err != nil {
 			fmt.Println("Auto-unlock failed:", err)
@@ -168,11 +174,37 @@ func tryAutoUnlock(srv *server.Server) {
 	}
 }
 
+// printResolveReport prints where the values resolved by the build package
+// during startup came from. Only the sources are printed, never the values.
+func printResolveReport() {
+	report := build.ResolveReport()
+	if len(report) == 0 {
+		return
+	}
+	sources := make([]string, 0, len(report))
+	for name, source := range report {
+		sources = append(sources, fmt.Sprintf("%v=%v", name, source))
+	}
+	sort.Strings(sources)
+	fmt.Println("Config sources: " + strings.Join(sources, ", "))
+}
+
 // startDaemon uses the config parameters to initialize TurtleDex modules and start
 // ttdxd.
 func startDaemon(config Config) (err error) {
 	loadStart := time.Now()
 
+	// Check the environment and refuse to start if a critical check fails.
+	results := build.PreflightCheck(config.TurtleDexd.TurtleDexDir)
+	for _, result := range results {
+		if !result.OK {
+			fmt.Println(result)
+		}
+	}
+	if build.PreflightFailed(results) {
+		return errors.New("preflight check failed")
+	}
+
 	// Load API password.
 	config, err = loadAPIPassword(config)
 	if err != nil {
@@ -201,6 +233,10 @@ func startDaemon(config Config) (err error) {
 	// Attempt to auto-unlock the wallet using the SIA_WALLET_PASSWORD env variable
 	tryAutoUnlock(srv)
 
+	// Print where the config values came from now that all of them are
+	// resolved.
+	printResolveReport()
+
 	// listen for kill signals
 	sigChan := installKillSignalHandler()
 
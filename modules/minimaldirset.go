@@ -0,0 +1,161 @@
+package modules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/turtledex/errors"
+)
+
+// minimaldirset.go contains the MinimalDirSet which computes the minimal set of
+// directories that need to be processed if processing a directory implies
+// processing all of its ancestors, like a bubble does.
+
+type (
+	// MinimalDirSet is a set of directories which distinguishes between child
+	// dirs and parent dirs. A child dir is a directory which was added and
+	// has no descendant in the set. All ancestors of the added directories
+	// are parent dirs. No child dir is ever an ancestor of another child dir.
+	//
+	// The zero value is an empty set ready to use. A MinimalDirSet is not safe
+	// for concurrent use.
+	MinimalDirSet struct {
+		childDirs  map[TurtleDexPath]struct{}
+		parentDirs map[TurtleDexPath]struct{}
+	}
+)
+
+// Add adds a directory to the set. If the directory is already in the set,
+// either as child or parent dir, nothing changes. The path is cleaned first,
+// so equivalent representations like "a/b" and "a/b/" are the same directory.
+// An invalid path is rejected without changing the set.
+//
+// The empty path is deliberately not rejected, even though it can't name a
+// file or a directory below the root. It is the root itself, which callers add
+// whenever a file in the root changes or the whole tree is checked, and
+// rejecting it would leave the root without a refresh.
+//
+// Adding a directory walks up its ancestors only until the first one which is
+// already a parent dir. Once the root is a parent dir, every Add therefore
+// stops at the closest known ancestor. A root child dir doesn't make Add a
+// no-op though, since processing the root doesn't process its descendants.
+func (mds *MinimalDirSet) Add(path TurtleDexPath) error {
+	path = path.Clean()
+	if err := path.Validate(true); err != nil {
+		return errors.AddContext(err, fmt.Sprintf("can't add invalid path '%v'", path))
+	}
+	if mds.childDirs == nil {
+		mds.childDirs = make(map[TurtleDexPath]struct{})
+		mds.parentDirs = make(map[TurtleDexPath]struct{})
+	}
+
+	// Check if the path is in the parent directory map
+	if _, ok := mds.parentDirs[path]; ok {
+		return nil
+	}
+
+	// Check if the path is in the child directory map
+	if _, ok := mds.childDirs[path]; ok {
+		return nil
+	}
+
+	// Add path to the childDir map
+	mds.childDirs[path] = struct{}{}
+	return mds.addParents(path, TurtleDexPath.Dir)
+}
+
+// addParents walks up the ancestors of path using dir to get the parent of a
+// directory. Any parent directories are removed from the child directory map
+// and added to the parent directory map. Since every call to dir removes one
+// element of the path, the walk is bounded by the depth of path. That way a
+// dir which doesn't shorten the path returns an error instead of looping
+// forever.
+func (mds *MinimalDirSet) addParents(path TurtleDexPath, dir func(TurtleDexPath) (TurtleDexPath, error)) error {
+	start, maxSteps := path, path.Depth()
+	for steps := uint64(0); !path.IsRoot(); steps++ {
+		if steps >= maxSteps {
+			return fmt.Errorf("walking up the parent directories of '%v' didn't reach the root after %v steps", start, steps)
+		}
+		// Get the parentDir of the path
+		parentDir, err := dir(path)
+		if err != nil {
+			contextStr := fmt.Sprintf("unable to get parent directory of %v", path)
+			return errors.AddContext(err, contextStr)
+		}
+		// If the parentDir is already in the parentDirs map, so are all of its
+		// ancestors and none of them is in the childDirs map.
+		if _, ok := mds.parentDirs[parentDir]; ok {
+			break
+		}
+		// Remove the parentDir from the childDirs map and add it to the
+		// parentDirs map
+		delete(mds.childDirs, parentDir)
+		mds.parentDirs[parentDir] = struct{}{}
+		// Set path equal to the parentDir
+		path = parentDir
+	}
+	return nil
+}
+
+// Remove removes a child dir from the set. Nothing changes if path isn't a
+// child dir. The ancestors of path stay parent dirs even if path was their
+// only descendant, since their siblings might have been dropped when they
+// became parent dirs. Adding such an ancestor again is therefore a no-op.
+func (mds *MinimalDirSet) Remove(path TurtleDexPath) {
+	delete(mds.childDirs, path.Clean())
+}
+
+// Reset removes all directories from the set. The maps are cleared instead of
+// reallocated, so the set can be reused without allocating.
+func (mds *MinimalDirSet) Reset() {
+	for sp := range mds.childDirs {
+		delete(mds.childDirs, sp)
+	}
+	for sp := range mds.parentDirs {
+		delete(mds.parentDirs, sp)
+	}
+}
+
+// ChildDirs returns the sorted child dirs of the set.
+func (mds *MinimalDirSet) ChildDirs() []TurtleDexPath {
+	return sortedDirs(mds.childDirs)
+}
+
+// ParentDirs returns the sorted parent dirs of the set.
+func (mds *MinimalDirSet) ParentDirs() []TurtleDexPath {
+	return sortedDirs(mds.parentDirs)
+}
+
+// IsChildDir returns true if path is a child dir of the set.
+func (mds *MinimalDirSet) IsChildDir(path TurtleDexPath) bool {
+	_, ok := mds.childDirs[path.Clean()]
+	return ok
+}
+
+// IsParentDir returns true if path is a parent dir of the set.
+func (mds *MinimalDirSet) IsParentDir(path TurtleDexPath) bool {
+	_, ok := mds.parentDirs[path.Clean()]
+	return ok
+}
+
+// NumChildDirs returns the number of child dirs of the set.
+func (mds *MinimalDirSet) NumChildDirs() int {
+	return len(mds.childDirs)
+}
+
+// NumParentDirs returns the number of parent dirs of the set.
+func (mds *MinimalDirSet) NumParentDirs() int {
+	return len(mds.parentDirs)
+}
+
+// sortedDirs returns the keys of dirs sorted by their path.
+func sortedDirs(dirs map[TurtleDexPath]struct{}) []TurtleDexPath {
+	sorted := make([]TurtleDexPath, 0, len(dirs))
+	for sp := range dirs {
+		sorted = append(sorted, sp)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
@@ -0,0 +1,179 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turtledex/errors"
+)
+
+// TestMinimalDirSet probes the MinimalDirSet.
+func TestMinimalDirSet(t *testing.T) {
+	var mds MinimalDirSet
+	if len(mds.ChildDirs()) != 0 || len(mds.ParentDirs()) != 0 {
+		t.Fatal("zero value should be empty")
+	}
+
+	paths := []TurtleDexPath{
+		{Path: "a/b"},
+		{Path: "a/b/c"},
+		{Path: "a/d"},
+		{Path: "a"},
+		{Path: "e"},
+		{Path: "a/b/c"},
+	}
+	for _, path := range paths {
+		if err := mds.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedChildren := []TurtleDexPath{{Path: "a/b/c"}, {Path: "a/d"}, {Path: "e"}}
+	if fmt.Sprint(mds.ChildDirs()) != fmt.Sprint(expectedChildren) {
+		t.Fatal("wrong child dirs", mds.ChildDirs())
+	}
+	expectedParents := []TurtleDexPath{RootTurtleDexPath(), {Path: "a"}, {Path: "a/b"}}
+	if fmt.Sprint(mds.ParentDirs()) != fmt.Sprint(expectedParents) {
+		t.Fatal("wrong parent dirs", mds.ParentDirs())
+	}
+	if mds.NumChildDirs() != len(expectedChildren) || mds.NumParentDirs() != len(expectedParents) {
+		t.Fatal("wrong number of dirs", mds.NumChildDirs(), mds.NumParentDirs())
+	}
+	if !mds.IsChildDir(TurtleDexPath{Path: "e"}) || mds.IsChildDir(TurtleDexPath{Path: "a"}) {
+		t.Fatal("wrong IsChildDir result")
+	}
+	if !mds.IsParentDir(TurtleDexPath{Path: "a/b"}) || mds.IsParentDir(TurtleDexPath{Path: "e"}) {
+		t.Fatal("wrong IsParentDir result")
+	}
+
+	// Invalid paths are rejected.
+	for _, path := range []string{".", "a//b", "a/../b", "\xff/a"} {
+		if err := mds.Add(TurtleDexPath{Path: path}); !errors.Contains(err, ErrInvalidTurtleDexPath) {
+			t.Fatalf("expected %v for %q but got %v", ErrInvalidTurtleDexPath, path, err)
+		}
+	}
+	if mds.NumChildDirs() != len(expectedChildren) || mds.NumParentDirs() != len(expectedParents) {
+		t.Fatal("invalid paths shouldn't change the set")
+	}
+
+	// Removing a child dir keeps its ancestors as parent dirs.
+	mds.Remove(TurtleDexPath{Path: "a/b/c"})
+	if mds.IsChildDir(TurtleDexPath{Path: "a/b/c"}) || !mds.IsParentDir(TurtleDexPath{Path: "a/b"}) {
+		t.Fatal("wrong dirs after removal", mds.ChildDirs(), mds.ParentDirs())
+	}
+	mds.Remove(TurtleDexPath{Path: "a/b"})
+	if !mds.IsParentDir(TurtleDexPath{Path: "a/b"}) {
+		t.Fatal("parent dirs shouldn't be removed")
+	}
+	if err := mds.Add(TurtleDexPath{Path: "a/b/c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No child dir is an ancestor of another child dir.
+	for _, child := range mds.ChildDirs() {
+		for path := child; !path.IsRoot(); {
+			parent, err := path.Dir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mds.IsChildDir(parent) {
+				t.Fatalf("child dir %v is an ancestor of child dir %v", parent, child)
+			}
+			path = parent
+		}
+	}
+}
+
+// TestMinimalDirSetRoot probes that adding a directory below a root child dir
+// turns the root into a parent dir instead of dropping the directory.
+func TestMinimalDirSetRoot(t *testing.T) {
+	var mds MinimalDirSet
+	if err := mds.Add(RootTurtleDexPath()); err != nil {
+		t.Fatal(err)
+	}
+	deep := TurtleDexPath{Path: "a/b/c/d"}
+	if err := mds.Add(deep); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(mds.ChildDirs()) != fmt.Sprint([]TurtleDexPath{deep}) {
+		t.Fatal("wrong child dirs", mds.ChildDirs())
+	}
+	if !mds.IsParentDir(RootTurtleDexPath()) || mds.NumParentDirs() != 4 {
+		t.Fatal("wrong parent dirs", mds.ParentDirs())
+	}
+
+	// Adding a sibling stops at the closest known ancestor.
+	if err := mds.Add(TurtleDexPath{Path: "a/b/e"}); err != nil {
+		t.Fatal(err)
+	}
+	if mds.NumChildDirs() != 2 || mds.NumParentDirs() != 4 {
+		t.Fatal("wrong dirs", mds.ChildDirs(), mds.ParentDirs())
+	}
+}
+
+// TestMinimalDirSetClean probes that equivalent representations of a path are
+// the same directory.
+func TestMinimalDirSetClean(t *testing.T) {
+	var mds MinimalDirSet
+	for _, path := range []string{"a/b", "a/b/", "/a/b", "/a/b/"} {
+		if err := mds.Add(TurtleDexPath{Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(mds.ChildDirs()) != fmt.Sprint([]TurtleDexPath{{Path: "a/b"}}) {
+		t.Fatal("wrong child dirs", mds.ChildDirs())
+	}
+	if fmt.Sprint(mds.ParentDirs()) != fmt.Sprint([]TurtleDexPath{RootTurtleDexPath(), {Path: "a"}}) {
+		t.Fatal("wrong parent dirs", mds.ParentDirs())
+	}
+	if !mds.IsChildDir(TurtleDexPath{Path: "/a/b/"}) || !mds.IsParentDir(TurtleDexPath{Path: "a/"}) {
+		t.Fatal("lookups should clean the path")
+	}
+
+	// "/" is the root.
+	var root MinimalDirSet
+	if err := root.Add(TurtleDexPath{Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	if !root.IsChildDir(RootTurtleDexPath()) {
+		t.Fatal("root should be a child dir", root.ChildDirs())
+	}
+
+	// Removing an equivalent path removes the dir.
+	mds.Remove(TurtleDexPath{Path: "a/b/"})
+	if mds.NumChildDirs() != 0 {
+		t.Fatal("dir should have been removed", mds.ChildDirs())
+	}
+}
+
+// TestMinimalDirSetParentWalkGuard probes that walking up the parent dirs
+// fails instead of looping forever if getting the parent doesn't shorten the
+// path. Returning the path itself is already caught since it becomes a known
+// parent dir, so the pathological dir returns ever longer paths.
+func TestMinimalDirSetParentWalkGuard(t *testing.T) {
+	var mds MinimalDirSet
+	if err := mds.Add(TurtleDexPath{Path: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	growingDir := func(sp TurtleDexPath) (TurtleDexPath, error) {
+		return sp.Join("x")
+	}
+	done := make(chan error)
+	go func() {
+		done <- mds.addParents(TurtleDexPath{Path: "a/b/c"}, growingDir)
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "didn't reach the root") {
+			t.Fatal("expected the guard to trip but got", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("parent walk didn't terminate")
+	}
+
+	// A well-behaved dir is unaffected by the guard.
+	if err := mds.addParents(TurtleDexPath{Path: "a/b/c"}, TurtleDexPath.Dir); err != nil {
+		t.Fatal(err)
+	}
+}
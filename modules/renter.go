@@ -368,6 +368,7 @@ type DirectoryInfo struct {
 	// the totals of the ttdxdir and any sub ttdxdirs, or are calculated based on
 	// all the values in the subtree
 	AggregateHealth              float64   `json:"aggregatehealth"`
+	AggregateLastActivity        time.Time `json:"aggregatelastactivity"`
 	AggregateLastHealthCheckTime time.Time `json:"aggregatelasthealthchecktime"`
 	AggregateMaxHealth           float64   `json:"aggregatemaxhealth"`
 	AggregateMaxHealthPercentage float64   `json:"aggregatemaxhealthpercentage"`
@@ -407,6 +408,23 @@ type DirectoryInfo struct {
 	// Skynet Fields
 	SkynetFiles uint64 `json:"skynetfiles"`
 	SkynetSize  uint64 `json:"skynetsize"`
+
+	// BubbleDuration is a moving average of the time it took to bubble the
+	// ttdxdir.
+	BubbleDuration time.Duration `json:"bubbleduration"`
+
+	// AggregateCustom contains the custom aggregates computed during the
+	// bubble. Every value is a sum over all the files in the sub tree.
+	AggregateCustom map[string]uint64 `json:"aggregatecustom,omitempty"`
+
+	// Tags are user defined key value pairs attached to the ttdxdir.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Pinned and Excluded are user defined flags of the ttdxdir. A pinned dir
+	// is refreshed whenever the health loop checks its subtree and an
+	// excluded dir is skipped by the health loop.
+	Pinned   bool `json:"pinned,omitempty"`
+	Excluded bool `json:"excluded,omitempty"`
 }
 
 // Name implements os.FileInfo.
@@ -489,6 +507,7 @@ type FileInfo struct {
 	TurtleDexPath          TurtleDexPath           `json:"siapath"`
 	Stuck            bool              `json:"stuck"`
 	StuckHealth      float64           `json:"stuckhealth"`
+	Tags             map[string]string `json:"tags,omitempty"`
 	UID              uint64            `json:"uid"`
 	UploadedBytes    uint64            `json:"uploadedbytes"`
 	UploadProgress   float64           `json:"uploadprogress"`
@@ -0,0 +1,324 @@
+package renter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// auditlog.go contains the audit log of file operations. The log is disabled
+// by default. Once enabled, it records every file that is created by an
+// upload, deleted or renamed through the renter. Overwriting a file with a
+// forced upload is recorded as a deletion followed by a creation and renaming
+// a file is recorded as a modification. Deleting or renaming a directory, as
+// well as publishing one with PublishDir, records an event for every file
+// within it. The log is kept in memory and only
+// retains the most recent auditLogMaxEvents events.
+//
+// Every event is assigned a sequence number which is one larger than the one
+// of the previous event. The sequence numbers keep increasing while the log is
+// disabled, so they can be used as a cursor by ChangesSince. A cursor expires
+// once the events after it were dropped from the log, either because more than
+// auditLogMaxEvents newer events were recorded or because the log was disabled
+// in the meantime.
+//
+// Since the log isn't persisted, the sequence numbers of a renter don't start
+// at 0 but at the time the renter was started in nanoseconds. That way the
+// cursors of a previous run are older than every event of the current run and
+// expire instead of silently skipping the changes made in between. A cursor
+// which is newer than the latest event, e.g. because the clock was turned back
+// between two runs, expires as well.
+
+const (
+	// auditLogMaxEvents is the maximum number of events kept in the audit
+	// log.
+	auditLogMaxEvents = 100e3
+)
+
+const (
+	// fileOpCreated indicates that a file was created.
+	fileOpCreated fileOpType = iota
+	// fileOpModified indicates that a file was modified.
+	fileOpModified
+	// fileOpDeleted indicates that a file was deleted.
+	fileOpDeleted
+)
+
+var (
+	// errAuditLogDisabled is returned when the audit log is queried while it
+	// is disabled.
+	errAuditLogDisabled = errors.New("the audit log is disabled")
+
+	// errChangesCompacted is returned by ChangesSince if some of the changes
+	// after the cursor are no longer in the audit log.
+	errChangesCompacted = errors.New("changes after the cursor were dropped from the audit log")
+)
+
+type (
+	// fileOpType is the type of a file operation.
+	fileOpType int
+
+	// fileOpEvent is a single entry of the audit log. For renamed files,
+	// TurtleDexPath is the new path and OldTurtleDexPath the old one.
+	fileOpEvent struct {
+		Seq              uint64
+		Time             time.Time
+		Op               fileOpType
+		TurtleDexPath    modules.TurtleDexPath
+		OldTurtleDexPath modules.TurtleDexPath
+	}
+
+	// ChangeRecord is a single change returned by ChangesSince. Op is either
+	// "created", "modified" or "deleted". For renamed files, TurtleDexPath is
+	// the new path and OldTurtleDexPath the old one.
+	ChangeRecord struct {
+		Seq              uint64                `json:"seq"`
+		Time             time.Time             `json:"time"`
+		Op               string                `json:"op"`
+		TurtleDexPath    modules.TurtleDexPath `json:"siapath"`
+		OldTurtleDexPath modules.TurtleDexPath `json:"oldsiapath"`
+	}
+
+	// auditLog is an in-memory log of file operations.
+	auditLog struct {
+		enabled bool
+
+		// events is a ring buffer of the recorded events which grows up to
+		// auditLogMaxEvents. Once it is full, the oldest event at
+		// events[start] is overwritten. The sequence numbers of the events
+		// are consecutive.
+		events []fileOpEvent
+		start  int
+
+		lastSeq uint64
+		mu      sync.Mutex
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (op fileOpType) String() string {
+	switch op {
+	case fileOpCreated:
+		return "created"
+	case fileOpModified:
+		return "modified"
+	case fileOpDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// newAuditLog returns a disabled audit log whose sequence numbers start at the
+// current time.
+func newAuditLog() *auditLog {
+	return &auditLog{
+		lastSeq: uint64(time.Now().UnixNano()),
+	}
+}
+
+// callRecord appends an event to the log if it is enabled.
+func (al *auditLog) callRecord(e fileOpEvent) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.lastSeq++
+	e.Seq = al.lastSeq
+	if !al.enabled {
+		return
+	}
+	if len(al.events) < auditLogMaxEvents {
+		al.events = append(al.events, e)
+		return
+	}
+	al.events[al.start] = e
+	al.start = (al.start + 1) % len(al.events)
+}
+
+// callSetEnabled enables or disables the log. Disabling the log drops all of
+// its events.
+func (al *auditLog) callSetEnabled(enabled bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.enabled = enabled
+	if !enabled {
+		al.events = nil
+		al.start = 0
+	}
+}
+
+// event returns the i-th oldest event. It must be called while holding the
+// lock.
+func (al *auditLog) event(i int) fileOpEvent {
+	return al.events[(al.start+i)%len(al.events)]
+}
+
+// callEventsSince returns a copy of the events at or after since.
+func (al *auditLog) callEventsSince(since time.Time) ([]fileOpEvent, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if !al.enabled {
+		return nil, errAuditLogDisabled
+	}
+	var events []fileOpEvent
+	for i := range al.events {
+		if e := al.event(i); !e.Time.Before(since) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// callEventsAfter returns a copy of the events with a sequence number larger
+// than seq and the sequence number of the latest event.
+func (al *auditLog) callEventsAfter(seq uint64) ([]fileOpEvent, uint64, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if !al.enabled {
+		return nil, 0, errAuditLogDisabled
+	}
+	if seq == al.lastSeq {
+		return nil, seq, nil
+	}
+	oldest := al.lastSeq + 1
+	if len(al.events) > 0 {
+		oldest = al.event(0).Seq
+	}
+	if seq+1 < oldest || seq > al.lastSeq {
+		return nil, al.lastSeq, errChangesCompacted
+	}
+	// The sequence numbers are consecutive, so the first event after seq is
+	// at a known offset.
+	first := int(seq + 1 - oldest)
+	events := make([]fileOpEvent, 0, len(al.events)-first)
+	for i := first; i < len(al.events); i++ {
+		events = append(events, al.event(i))
+	}
+	return events, al.lastSeq, nil
+}
+
+// callEnabled returns whether the log is enabled.
+func (al *auditLog) callEnabled() bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.enabled
+}
+
+// managedAuditedFiles returns the sorted paths of all files within dir and its
+// subdirectories if the audit log is enabled. Operations on whole directories
+// use it to record an event for every affected file. If the audit log is
+// disabled, the directory isn't listed and nil is returned.
+func (r *Renter) managedAuditedFiles(dir modules.TurtleDexPath) ([]modules.TurtleDexPath, error) {
+	if !r.staticAuditLog.callEnabled() {
+		return nil, nil
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var files []modules.TurtleDexPath
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		files = append(files, fi.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(dir, true, flf, func(modules.DirectoryInfo) {})
+	if err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("failed to list the files within '%v' for the audit log", dir))
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].String() < files[j].String()
+	})
+	return files, nil
+}
+
+// callRecordFileOp records a file operation in the renter's audit log.
+func (r *Renter) callRecordFileOp(op fileOpType, siaPath, oldSiaPath modules.TurtleDexPath) {
+	r.staticAuditLog.callRecord(fileOpEvent{
+		Time:             time.Now(),
+		Op:               op,
+		TurtleDexPath:    siaPath,
+		OldTurtleDexPath: oldSiaPath,
+	})
+}
+
+// SetAuditLogEnabled enables or disables the audit log of file operations.
+// Disabling the log drops all the recorded operations.
+func (r *Renter) SetAuditLogEnabled(enabled bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	r.staticAuditLog.callSetEnabled(enabled)
+	return nil
+}
+
+// Churn counts the files within prefix that were created, modified and
+// deleted within the last window according to the audit log. A renamed file
+// counts as modified if either its old or its new path is within prefix. An
+// error is returned if the audit log is disabled.
+func (r *Renter) Churn(prefix modules.TurtleDexPath, window time.Duration) (created int, modified int, deleted int, err error) {
+	if err := r.tg.Add(); err != nil {
+		return 0, 0, 0, err
+	}
+	defer r.tg.Done()
+
+	events, err := r.staticAuditLog.callEventsSince(time.Now().Add(-window))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	within := func(sp modules.TurtleDexPath) bool {
+		_, err := sp.RelativeDepth(prefix)
+		return !sp.IsEmpty() && err == nil
+	}
+	for _, e := range events {
+		if !within(e.TurtleDexPath) && !within(e.OldTurtleDexPath) {
+			continue
+		}
+		switch e.Op {
+		case fileOpCreated:
+			created++
+		case fileOpModified:
+			modified++
+		case fileOpDeleted:
+			deleted++
+		}
+	}
+	return created, modified, deleted, nil
+}
+
+// ChangesSince returns the file operations recorded in the audit log after the
+// cursor seq, together with the cursor to use for the next call. If some of the
+// changes after the cursor are no longer in the log, errChangesCompacted is
+// returned together with the latest cursor. The client then needs to do a full
+// resync and can continue with that cursor afterwards. That is also how a
+// client without a cursor starts, by passing 0, and how a cursor of a previous
+// run of the renter is handled. An error is also returned if the audit log is
+// disabled.
+func (r *Renter) ChangesSince(seq uint64) ([]ChangeRecord, uint64, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, 0, err
+	}
+	defer r.tg.Done()
+
+	events, cursor, err := r.staticAuditLog.callEventsAfter(seq)
+	if err != nil {
+		return nil, cursor, err
+	}
+	changes := make([]ChangeRecord, 0, len(events))
+	for _, e := range events {
+		changes = append(changes, ChangeRecord{
+			Seq:              e.Seq,
+			Time:             e.Time,
+			Op:               e.Op.String(),
+			TurtleDexPath:    e.TurtleDexPath,
+			OldTurtleDexPath: e.OldTurtleDexPath,
+		})
+	}
+	return changes, cursor, nil
+}
@@ -0,0 +1,306 @@
+package renter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestChurn probes the audit log and Churn.
+func TestChurn(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// The audit log is disabled by default.
+	if _, _, _, err := r.Churn(modules.RootTurtleDexPath(), time.Hour); !errors.Contains(err, errAuditLogDisabled) {
+		t.Fatal("expected errAuditLogDisabled but got", err)
+	}
+	if err := r.SetAuditLogEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a, b and c, rename a out of dir and delete b.
+	for _, name := range []string{"dir/a", "dir/b", "other/c"} {
+		sp := newTurtleDexPath(name)
+		f, err := r.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r.callRecordFileOp(fileOpCreated, sp, modules.TurtleDexPath{})
+	}
+	if err := r.RenameFile(newTurtleDexPath("dir/a"), newTurtleDexPath("other/a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteFile(newTurtleDexPath("dir/b")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix                     string
+		created, modified, deleted int
+	}{
+		{"", 3, 1, 1},
+		{"dir", 2, 1, 1},
+		{"other", 1, 1, 0},
+		{"missing", 0, 0, 0},
+	}
+	for _, test := range tests {
+		prefix := modules.RootTurtleDexPath()
+		if test.prefix != "" {
+			prefix = newTurtleDexPath(test.prefix)
+		}
+		created, modified, deleted, err := r.Churn(prefix, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != test.created || modified != test.modified || deleted != test.deleted {
+			t.Errorf("'%v': expected %v/%v/%v but got %v/%v/%v", test.prefix, test.created, test.modified, test.deleted, created, modified, deleted)
+		}
+	}
+
+	// Events outside of the window aren't counted.
+	time.Sleep(10 * time.Millisecond)
+	created, modified, deleted, err := r.Churn(modules.RootTurtleDexPath(), 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created+modified+deleted != 0 {
+		t.Fatal("expected no events within the window", created, modified, deleted)
+	}
+}
+
+// TestAuditLogDirOps probes that renaming and deleting directories records an
+// event for every file within them.
+func TestAuditLogDirOps(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	if err := r.SetAuditLogEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create two files in a nested dir, rename the dir and delete the
+	// renamed dir.
+	for _, name := range []string{"dir/sub/a", "dir/sub/deeper/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.RenameDir(newTurtleDexPath("dir/sub"), newTurtleDexPath("moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteDir(newTurtleDexPath("moved")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix                     string
+		created, modified, deleted int
+	}{
+		{"dir", 0, 2, 0},
+		{"moved", 0, 2, 2},
+		{"moved/deeper", 0, 1, 1},
+	}
+	for _, test := range tests {
+		created, modified, deleted, err := r.Churn(newTurtleDexPath(test.prefix), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != test.created || modified != test.modified || deleted != test.deleted {
+			t.Errorf("'%v': expected %v/%v/%v but got %v/%v/%v", test.prefix, test.created, test.modified, test.deleted, created, modified, deleted)
+		}
+	}
+}
+
+// TestChangesSince probes ChangesSince.
+func TestChangesSince(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// The audit log is disabled by default.
+	if _, _, err := r.ChangesSince(0); !errors.Contains(err, errAuditLogDisabled) {
+		t.Fatal("expected errAuditLogDisabled but got", err)
+	}
+	if err := r.SetAuditLogEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client without a cursor starts with a full sync.
+	_, start, err := r.ChangesSince(0)
+	if !errors.Contains(err, errChangesCompacted) {
+		t.Fatal("expected errChangesCompacted but got", err)
+	}
+
+	// Record some changes.
+	a, b := newTurtleDexPath("a"), newTurtleDexPath("b")
+	r.callRecordFileOp(fileOpCreated, a, modules.TurtleDexPath{})
+	r.callRecordFileOp(fileOpModified, b, a)
+	changes, cursor, err := r.ChangesSince(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || cursor != start+2 {
+		t.Fatal("wrong changes", changes, cursor)
+	}
+	if changes[0].Op != "created" || !changes[0].TurtleDexPath.Equals(a) || changes[0].Seq != start+1 {
+		t.Fatal("wrong first change", changes[0])
+	}
+	if changes[1].Op != "modified" || !changes[1].OldTurtleDexPath.Equals(a) || changes[1].Seq != start+2 {
+		t.Fatal("wrong second change", changes[1])
+	}
+
+	// Polling with the cursor only returns new changes.
+	changes, cursor, err = r.ChangesSince(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || cursor != start+2 {
+		t.Fatal("expected no changes", changes, cursor)
+	}
+	r.callRecordFileOp(fileOpDeleted, b, modules.TurtleDexPath{})
+	changes, cursor, err = r.ChangesSince(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Op != "deleted" || cursor != start+3 {
+		t.Fatal("wrong changes", changes, cursor)
+	}
+
+	// A cursor newer than the latest change is unknown.
+	if _, _, err := r.ChangesSince(cursor + 1); !errors.Contains(err, errChangesCompacted) {
+		t.Fatal("expected errChangesCompacted but got", err)
+	}
+
+	// Changes that happen while the log is disabled expire the cursor.
+	if err := r.SetAuditLogEnabled(false); err != nil {
+		t.Fatal(err)
+	}
+	r.callRecordFileOp(fileOpCreated, a, modules.TurtleDexPath{})
+	if err := r.SetAuditLogEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+	_, cursor, err = r.ChangesSince(cursor)
+	if !errors.Contains(err, errChangesCompacted) {
+		t.Fatal("expected errChangesCompacted but got", err)
+	}
+	if cursor != start+4 {
+		t.Fatal("expected latest cursor", cursor)
+	}
+}
+
+// TestAuditLogRestart probes that the cursor of a previous run of the renter
+// expires instead of skipping the changes made since the restart.
+func TestAuditLogRestart(t *testing.T) {
+	t.Parallel()
+
+	sp := newTurtleDexPath("a")
+	old := newAuditLog()
+	old.callSetEnabled(true)
+	for i := 0; i < 500; i++ {
+		old.callRecord(fileOpEvent{Op: fileOpCreated, TurtleDexPath: sp})
+	}
+	_, cursor, err := old.callEventsAfter(old.lastSeq - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted log records fewer changes than the old one.
+	time.Sleep(time.Millisecond)
+	restarted := newAuditLog()
+	restarted.callSetEnabled(true)
+	restarted.callRecord(fileOpEvent{Op: fileOpDeleted, TurtleDexPath: sp})
+	if _, _, err := restarted.callEventsAfter(cursor); !errors.Contains(err, errChangesCompacted) {
+		t.Fatal("expected errChangesCompacted but got", err)
+	}
+}
+
+// TestAuditLogRingBuffer probes that the audit log keeps the most recent
+// events once it is full.
+func TestAuditLogRingBuffer(t *testing.T) {
+	t.Parallel()
+
+	al := newAuditLog()
+	al.callSetEnabled(true)
+	start := al.lastSeq
+	total := int(auditLogMaxEvents) + 10
+	for i := 0; i < total; i++ {
+		al.callRecord(fileOpEvent{Op: fileOpCreated})
+	}
+	if len(al.events) != auditLogMaxEvents {
+		t.Fatal("wrong number of events", len(al.events))
+	}
+
+	// The oldest events were dropped.
+	if _, _, err := al.callEventsAfter(start + 5); !errors.Contains(err, errChangesCompacted) {
+		t.Fatal("expected errChangesCompacted but got", err)
+	}
+	// The remaining events are returned in order.
+	events, cursor, err := al.callEventsAfter(start + 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != auditLogMaxEvents || cursor != start+uint64(total) {
+		t.Fatal("wrong events", len(events), cursor)
+	}
+	for i, e := range events {
+		if e.Seq != start+11+uint64(i) {
+			t.Fatalf("event %v has seq %v", i, e.Seq)
+		}
+	}
+	// Events in the middle of the buffer can be found as well.
+	events, _, err = al.callEventsAfter(cursor - 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[0].Seq != cursor-2 {
+		t.Fatal("wrong events", events)
+	}
+}
@@ -0,0 +1,337 @@
+package renter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// batch.go contains the code for applying a batch of file operations as a
+// single unit.
+//
+// A batch is validated as a whole before any operation is applied. The
+// following rules define a conflict and cause the whole batch to be rejected:
+//
+//   1. A path may be the source of at most one operation. A source is the file
+//      being renamed by a FileOpRename or deleted by a FileOpDelete.
+//   2. A path may be the target of at most one operation. A target is the new
+//      path of a FileOpRename or the directory created by a FileOpCreateDir.
+//   3. A path can't be both a source and a target within the same batch. This
+//      prevents moving a file onto a target which is also being deleted or
+//      moved away.
+//   4. Sources must exist before the batch is applied and targets must not.
+//   5. The root directory can never be a source or target.
+//
+// A batch without conflicts is still rejected if a target exceeds the maximum
+// TurtleDexPath depth or if applying it would exceed a directory quota. The
+// operations are checked together, so the deletes of a batch make room for its
+// renames.
+//
+// Operations are applied in order, with the exception of deletes which are
+// applied last since they can't be undone. If an operation fails, all
+// previously applied renames and directory creations are rolled back. Deleted
+// files can't be restored.
+
+var (
+	// errBatchConflict is returned if the operations of a batch conflict with
+	// each other.
+	errBatchConflict = errors.New("conflicting operations in batch")
+)
+
+// Types of operations that can be applied within a batch.
+const (
+	// FileOpCreateDir creates the directory at TurtleDexPath.
+	FileOpCreateDir FileOpType = iota
+	// FileOpDelete deletes the file at TurtleDexPath.
+	FileOpDelete
+	// FileOpRename renames the file at TurtleDexPath to NewTurtleDexPath.
+	FileOpRename
+)
+
+type (
+	// FileOpType is the type of a FileOp.
+	FileOpType int
+
+	// FileOp is a single operation which is applied as part of a batch by
+	// ApplyBatch.
+	FileOp struct {
+		Type             FileOpType
+		TurtleDexPath    modules.TurtleDexPath
+		NewTurtleDexPath modules.TurtleDexPath
+	}
+
+	// BatchPlan describes the effect of applying a batch. It is returned by
+	// ValidateBatch.
+	BatchPlan struct {
+		// Operations contains the effect of every operation of the batch in
+		// the same order as the batch.
+		Operations []BatchOpPlan
+
+		// Bubbles are the directories which would be bubbled after applying
+		// the batch. Bubbling these directories also updates their
+		// ancestors.
+		Bubbles []modules.TurtleDexPath
+	}
+
+	// BatchOpPlan describes the effect of a single operation of a batch.
+	BatchOpPlan struct {
+		Op      FileOp
+		Created []modules.TurtleDexPath
+		Removed []modules.TurtleDexPath
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (t FileOpType) String() string {
+	switch t {
+	case FileOpCreateDir:
+		return "createdir"
+	case FileOpDelete:
+		return "delete"
+	case FileOpRename:
+		return "rename"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// ApplyBatch applies a batch of file operations together. The batch is
+// validated first and either all operations are applied or, if one of them
+// fails, the operations that were already applied are rolled back where
+// possible. The metadata of all affected directories is bubbled once at the
+// end. Files are renamed and deleted through the same methods as RenameFile and
+// DeleteFile, so the operations show up in the audit log, including the
+// renames of a rollback.
+func (r *Renter) ApplyBatch(ops []FileOp) (err error) {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	// Validate the whole batch before touching the filesystem.
+	if err := r.managedValidateBatch(ops); err != nil {
+		return err
+	}
+
+	// Collect the directories that need to be bubbled.
+	urp := r.newUniqueRefreshPaths()
+	defer urp.callRefreshAll()
+
+	// Apply the operations. Deletes are applied last since they can't be
+	// rolled back.
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if rbErr := undo[i](); rbErr != nil {
+				err = errors.Compose(err, errors.AddContext(rbErr, "failed to roll back batch"))
+			}
+		}
+	}()
+	var deletes []FileOp
+	for _, op := range ops {
+		switch op.Type {
+		case FileOpCreateDir:
+			err = r.staticFileSystem.NewTurtleDexDir(op.TurtleDexPath, modules.DefaultDirPerm)
+			if err != nil {
+				return errors.AddContext(err, fmt.Sprintf("failed to create dir '%v'", op.TurtleDexPath))
+			}
+			sp := op.TurtleDexPath
+			undo = append(undo, func() error {
+				return r.staticFileSystem.DeleteDir(sp)
+			})
+			err = r.callAddBatchRefreshPaths(urp, op)
+		case FileOpRename:
+			err = r.managedRenameFile(op.TurtleDexPath, op.NewTurtleDexPath)
+			if err != nil {
+				return errors.AddContext(err, fmt.Sprintf("failed to rename '%v' to '%v'", op.TurtleDexPath, op.NewTurtleDexPath))
+			}
+			oldPath, newPath := op.TurtleDexPath, op.NewTurtleDexPath
+			undo = append(undo, func() error {
+				return r.managedRenameFile(newPath, oldPath)
+			})
+			err = r.callAddBatchRefreshPaths(urp, op)
+		case FileOpDelete:
+			deletes = append(deletes, op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, op := range deletes {
+		err = r.managedDeleteFile(op.TurtleDexPath)
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("failed to delete '%v'", op.TurtleDexPath))
+		}
+		err = r.callAddBatchRefreshPaths(urp, op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateBatch validates a batch of file operations the same way ApplyBatch
+// does without applying it. The returned plan describes the effect of every
+// operation and the directories that would be bubbled afterwards. The plan is
+// also returned if the batch is rejected, together with the reason.
+func (r *Renter) ValidateBatch(ops []FileOp) (BatchPlan, error) {
+	if err := r.tg.Add(); err != nil {
+		return BatchPlan{}, err
+	}
+	defer r.tg.Done()
+
+	// Validate the batch and describe the operations.
+	err := r.managedValidateBatch(ops)
+	var plan BatchPlan
+	urp := r.newUniqueRefreshPaths()
+	for _, op := range ops {
+		opPlan := BatchOpPlan{Op: op}
+		switch op.Type {
+		case FileOpCreateDir:
+			opPlan.Created = []modules.TurtleDexPath{op.TurtleDexPath}
+		case FileOpDelete:
+			opPlan.Removed = []modules.TurtleDexPath{op.TurtleDexPath}
+		case FileOpRename:
+			opPlan.Created = []modules.TurtleDexPath{op.NewTurtleDexPath}
+			opPlan.Removed = []modules.TurtleDexPath{op.TurtleDexPath}
+		}
+		plan.Operations = append(plan.Operations, opPlan)
+		if addErr := r.callAddBatchRefreshPaths(urp, op); addErr != nil && err == nil {
+			err = addErr
+		}
+	}
+	plan.Bubbles = urp.callChildDirs()
+	sort.Slice(plan.Bubbles, func(i, j int) bool {
+		return plan.Bubbles[i].String() < plan.Bubbles[j].String()
+	})
+	return plan, err
+}
+
+// callAddBatchRefreshPaths adds the directories which need to be bubbled after
+// applying op to the uniqueRefreshPaths.
+func (r *Renter) callAddBatchRefreshPaths(urp *uniqueRefreshPaths, op FileOp) error {
+	switch op.Type {
+	case FileOpCreateDir:
+		return urp.callAdd(op.TurtleDexPath)
+	case FileOpDelete:
+		return r.callAddParentDir(urp, op.TurtleDexPath)
+	case FileOpRename:
+		return errors.Compose(r.callAddParentDir(urp, op.TurtleDexPath), r.callAddParentDir(urp, op.NewTurtleDexPath))
+	}
+	return nil
+}
+
+// callAddParentDir adds the parent directory of a file to the
+// uniqueRefreshPaths.
+func (r *Renter) callAddParentDir(urp *uniqueRefreshPaths, siaPath modules.TurtleDexPath) error {
+	dir, err := siaPath.Dir()
+	if err != nil {
+		return err
+	}
+	return urp.callAdd(dir)
+}
+
+// managedValidateBatch checks a batch of operations for conflicts according to
+// the rules described at the top of this file.
+func (r *Renter) managedValidateBatch(ops []FileOp) error {
+	sources := make(map[modules.TurtleDexPath]struct{})
+	targets := make(map[modules.TurtleDexPath]struct{})
+
+	// addPath is a helper to add a path to either the sources or targets and
+	// check the rules which don't require disk access.
+	addPath := func(m map[modules.TurtleDexPath]struct{}, sp modules.TurtleDexPath, kind string) error {
+		if sp.IsRoot() {
+			return errors.AddContext(errBatchConflict, "root can't be part of a batch")
+		}
+		if err := sp.Validate(false); err != nil {
+			return err
+		}
+		if _, exists := m[sp]; exists {
+			return errors.AddContext(errBatchConflict, fmt.Sprintf("'%v' is used as %v more than once", sp, kind))
+		}
+		_, isSource := sources[sp]
+		_, isTarget := targets[sp]
+		if isSource || isTarget {
+			return errors.AddContext(errBatchConflict, fmt.Sprintf("'%v' is used as source and target", sp))
+		}
+		m[sp] = struct{}{}
+		return nil
+	}
+	// addTarget is a helper to add a target which, unlike a source, must not
+	// exceed the maximum depth since it is created by the batch.
+	addTarget := func(sp modules.TurtleDexPath) error {
+		if err := sp.ValidateDepth(); err != nil {
+			return err
+		}
+		return addPath(targets, sp, "target")
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case FileOpCreateDir:
+			err = addTarget(op.TurtleDexPath)
+		case FileOpDelete:
+			err = addPath(sources, op.TurtleDexPath, "source")
+		case FileOpRename:
+			err = errors.Compose(addPath(sources, op.TurtleDexPath, "source"), addTarget(op.NewTurtleDexPath))
+		default:
+			err = fmt.Errorf("unknown operation type %v", op.Type)
+		}
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("invalid operation %v (%v)", i, op.Type))
+		}
+	}
+
+	// Check that all sources exist and no target exists.
+	for sp := range sources {
+		exists, err := r.staticFileSystem.FileExists(sp)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.AddContext(errBatchConflict, fmt.Sprintf("source '%v' doesn't exist", sp))
+		}
+	}
+	for sp := range targets {
+		fileExists, err := r.staticFileSystem.FileExists(sp)
+		if err != nil {
+			return err
+		}
+		dirExists, err := r.staticFileSystem.DirExists(sp)
+		if err != nil {
+			return err
+		}
+		if fileExists || dirExists {
+			return errors.AddContext(errBatchConflict, fmt.Sprintf("target '%v' already exists", sp))
+		}
+	}
+	return r.managedCheckBatchQuota(ops)
+}
+
+// managedCheckBatchQuota checks whether applying a valid batch would exceed
+// any quota.
+func (r *Renter) managedCheckBatchQuota(ops []FileOp) error {
+	if len(r.staticQuotaPolicy.callRules()) == 0 {
+		return nil
+	}
+	qc := make(quotaChanges)
+	for _, op := range ops {
+		var err error
+		switch op.Type {
+		case FileOpRename:
+			err = r.managedQuotaMove(qc, op.TurtleDexPath, op.NewTurtleDexPath)
+		case FileOpDelete:
+			err = r.managedQuotaRemoveExisting(qc, op.TurtleDexPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return r.managedCheckQuotaChanges(qc)
+}
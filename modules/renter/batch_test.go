@@ -0,0 +1,199 @@
+package renter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestApplyBatch probes ApplyBatch and its conflict detection.
+func TestApplyBatch(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create some files.
+	fileA, fileB, fileC := newTurtleDexPath("a"), newTurtleDexPath("b"), newTurtleDexPath("c")
+	for _, sp := range []modules.TurtleDexPath{fileA, fileB, fileC} {
+		f, err := r.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := newTurtleDexPath("dir")
+	movedA := newTurtleDexPath("dir/a")
+
+	// Conflicting batches should be rejected without touching the filesystem.
+	conflicts := [][]FileOp{
+		// Moving onto a target that is also being deleted.
+		{
+			{Type: FileOpRename, TurtleDexPath: fileA, NewTurtleDexPath: movedA},
+			{Type: FileOpDelete, TurtleDexPath: movedA},
+		},
+		// Deleting the same file twice.
+		{
+			{Type: FileOpDelete, TurtleDexPath: fileA},
+			{Type: FileOpDelete, TurtleDexPath: fileA},
+		},
+		// Moving two files onto the same target.
+		{
+			{Type: FileOpRename, TurtleDexPath: fileA, NewTurtleDexPath: movedA},
+			{Type: FileOpRename, TurtleDexPath: fileB, NewTurtleDexPath: movedA},
+		},
+		// Moving onto an existing file.
+		{
+			{Type: FileOpRename, TurtleDexPath: fileA, NewTurtleDexPath: fileB},
+		},
+		// Deleting a file that doesn't exist.
+		{
+			{Type: FileOpDelete, TurtleDexPath: newTurtleDexPath("nonexistent")},
+		},
+		// Deleting the root.
+		{
+			{Type: FileOpDelete, TurtleDexPath: modules.RootTurtleDexPath()},
+		},
+	}
+	for i, ops := range conflicts {
+		if err := r.ApplyBatch(ops); !errors.Contains(err, errBatchConflict) {
+			t.Fatalf("batch %v: expected errBatchConflict but got %v", i, err)
+		}
+	}
+	for _, sp := range []modules.TurtleDexPath{fileA, fileB, fileC} {
+		if exists, err := r.staticFileSystem.FileExists(sp); err != nil || !exists {
+			t.Fatal("file should still exist", sp, err)
+		}
+	}
+
+	// Apply a valid batch.
+	if err := r.SetAuditLogEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+	ops := []FileOp{
+		{Type: FileOpDelete, TurtleDexPath: fileC},
+		{Type: FileOpCreateDir, TurtleDexPath: dir},
+		{Type: FileOpRename, TurtleDexPath: fileA, NewTurtleDexPath: movedA},
+	}
+	if err := r.ApplyBatch(ops); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := r.staticFileSystem.FileExists(movedA); err != nil || !exists {
+		t.Fatal("file should have been moved", err)
+	}
+	if exists, err := r.staticFileSystem.FileExists(fileA); err != nil || exists {
+		t.Fatal("file shouldn't exist at the old location", err)
+	}
+	if exists, err := r.staticFileSystem.FileExists(fileC); err != nil || exists {
+		t.Fatal("file should have been deleted", err)
+	}
+	if exists, err := r.staticFileSystem.DirExists(dir); err != nil || !exists {
+		t.Fatal("dir should have been created", err)
+	}
+
+	// The rename and delete are in the audit log like for RenameFile and
+	// DeleteFile.
+	created, modified, deleted, err := r.Churn(modules.RootTurtleDexPath(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 || modified != 1 || deleted != 1 {
+		t.Fatalf("expected 0/1/1 but got %v/%v/%v", created, modified, deleted)
+	}
+}
+
+// TestValidateBatch probes ValidateBatch.
+func TestValidateBatch(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create some files.
+	fileA, fileB := newTurtleDexPath("a"), newTurtleDexPath("b")
+	for _, sp := range []modules.TurtleDexPath{fileA, fileB} {
+		f, err := r.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := newTurtleDexPath("dir")
+	movedA := newTurtleDexPath("dir/a")
+
+	// Validate a valid batch.
+	plan, err := r.ValidateBatch([]FileOp{
+		{Type: FileOpCreateDir, TurtleDexPath: dir},
+		{Type: FileOpRename, TurtleDexPath: fileA, NewTurtleDexPath: movedA},
+		{Type: FileOpDelete, TurtleDexPath: fileB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Operations) != 3 {
+		t.Fatal("wrong number of operations", len(plan.Operations))
+	}
+	if created := plan.Operations[0].Created; len(created) != 1 || !created[0].Equals(dir) {
+		t.Fatal("wrong created dirs", created)
+	}
+	if op := plan.Operations[1]; len(op.Created) != 1 || !op.Created[0].Equals(movedA) || len(op.Removed) != 1 || !op.Removed[0].Equals(fileA) {
+		t.Fatal("wrong rename plan", op)
+	}
+	if removed := plan.Operations[2].Removed; len(removed) != 1 || !removed[0].Equals(fileB) {
+		t.Fatal("wrong removed files", removed)
+	}
+	if len(plan.Bubbles) != 1 || !plan.Bubbles[0].Equals(dir) {
+		t.Fatal("wrong bubbles", plan.Bubbles)
+	}
+
+	// Nothing should have been applied.
+	for _, sp := range []modules.TurtleDexPath{fileA, fileB} {
+		if exists, err := r.staticFileSystem.FileExists(sp); err != nil || !exists {
+			t.Fatal("file should still exist", sp, err)
+		}
+	}
+	if exists, err := r.staticFileSystem.DirExists(dir); err != nil || exists {
+		t.Fatal("dir shouldn't exist", err)
+	}
+
+	// A conflicting batch should still return a plan.
+	plan, err = r.ValidateBatch([]FileOp{
+		{Type: FileOpDelete, TurtleDexPath: fileA},
+		{Type: FileOpDelete, TurtleDexPath: fileA},
+	})
+	if !errors.Contains(err, errBatchConflict) {
+		t.Fatal("expected errBatchConflict but got", err)
+	}
+	if len(plan.Operations) != 2 {
+		t.Fatal("wrong number of operations", len(plan.Operations))
+	}
+}
@@ -0,0 +1,104 @@
+package renter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
+)
+
+// bubbleaggregate.go contains the custom bubble aggregates. A custom aggregate
+// is a named sum over all the files of a directory's subtree. Its value is
+// computed during a bubble by calling the registered BubbleAggregateFunc for
+// every file of the bubbled directory and adding the values of the
+// subdirectories. The result is stored in the AggregateCustom field of the
+// directory's metadata.
+//
+// Re-entrancy: the funcs are called concurrently by the workers of a bubble
+// while the renter is holding open files. They must be fast, must not have
+// side effects and must not call any method of the renter. Registering or
+// unregistering a func only affects bubbles that start afterwards, so the
+// values of existing directories are updated by their next bubble.
+
+const (
+	// maxBubbleAggregates is the max number of custom aggregates. It caps the
+	// size of the custom aggregates within the metadata of a directory.
+	maxBubbleAggregates = 16
+
+	// maxBubbleAggregateNameLen is the max length of the name of a custom
+	// aggregate.
+	maxBubbleAggregateNameLen = 64
+)
+
+type (
+	// BubbleAggregateFunc returns the value a file contributes to a custom
+	// bubble aggregate.
+	BubbleAggregateFunc func(sp modules.TurtleDexPath, md siafile.BubbledMetadata) uint64
+
+	// bubbleAggregates contains the registered custom aggregates.
+	bubbleAggregates struct {
+		aggregates map[string]BubbleAggregateFunc
+		mu         sync.Mutex
+	}
+)
+
+// callAggregates returns a copy of the registered custom aggregates.
+func (ba *bubbleAggregates) callAggregates() map[string]BubbleAggregateFunc {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+	if len(ba.aggregates) == 0 {
+		return nil
+	}
+	aggregates := make(map[string]BubbleAggregateFunc, len(ba.aggregates))
+	for name, fn := range ba.aggregates {
+		aggregates[name] = fn
+	}
+	return aggregates
+}
+
+// callRegister registers fn for the custom aggregate with the given name. A
+// nil fn removes the aggregate.
+func (ba *bubbleAggregates) callRegister(name string, fn BubbleAggregateFunc) error {
+	if name == "" {
+		return fmt.Errorf("name of a custom aggregate can't be empty")
+	}
+	if len(name) > maxBubbleAggregateNameLen {
+		return fmt.Errorf("name of a custom aggregate can't be longer than %v bytes", maxBubbleAggregateNameLen)
+	}
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+	if fn == nil {
+		delete(ba.aggregates, name)
+		return nil
+	}
+	_, exists := ba.aggregates[name]
+	if !exists && len(ba.aggregates) >= maxBubbleAggregates {
+		return fmt.Errorf("can't register more than %v custom aggregates", maxBubbleAggregates)
+	}
+	if ba.aggregates == nil {
+		ba.aggregates = make(map[string]BubbleAggregateFunc)
+	}
+	ba.aggregates[name] = fn
+	return nil
+}
+
+// addBubbleAggregate adds value to the custom aggregate with the given name.
+func addBubbleAggregate(metadata *ttdxdir.Metadata, name string, value uint64) {
+	if metadata.AggregateCustom == nil {
+		metadata.AggregateCustom = make(map[string]uint64)
+	}
+	metadata.AggregateCustom[name] += value
+}
+
+// RegisterBubbleAggregate registers fn to compute the custom aggregate with
+// the given name during future bubbles. Registering a nil fn removes the
+// aggregate. See bubbleaggregate.go for the rules fn needs to follow.
+func (r *Renter) RegisterBubbleAggregate(name string, fn BubbleAggregateFunc) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.staticBubbleAggregates.callRegister(name, fn)
+}
@@ -0,0 +1,108 @@
+package renter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestBubbleAggregates probes the custom bubble aggregates.
+func TestBubbleAggregates(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Invalid registrations should be rejected.
+	count := func(modules.TurtleDexPath, siafile.BubbledMetadata) uint64 { return 1 }
+	if err := r.RegisterBubbleAggregate("", count); err == nil {
+		t.Fatal("expected error for empty name")
+	}
+	if err := r.RegisterBubbleAggregate(strings.Repeat("a", maxBubbleAggregateNameLen+1), count); err == nil {
+		t.Fatal("expected error for long name")
+	}
+	for i := 0; i < maxBubbleAggregates; i++ {
+		if err := r.RegisterBubbleAggregate(fmt.Sprint(i), count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.RegisterBubbleAggregate("toomany", count); err == nil {
+		t.Fatal("expected error for too many aggregates")
+	}
+	for i := 0; i < maxBubbleAggregates; i++ {
+		if err := r.RegisterBubbleAggregate(fmt.Sprint(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Register an aggregate that counts the files with "match" in their
+	// name and one that sums up their sizes.
+	err = r.RegisterBubbleAggregate("matches", func(sp modules.TurtleDexPath, _ siafile.BubbledMetadata) uint64 {
+		if strings.Contains(sp.Name(), "match") {
+			return 1
+		}
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.RegisterBubbleAggregate("size", func(_ modules.TurtleDexPath, md siafile.BubbledMetadata) uint64 {
+		return md.Size
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a dir with some files and a subdir with more files.
+	for _, file := range []string{"agg/match1", "agg/other", "agg/sub/match2", "agg/sub/match3"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bubble the subdir and calculate the metadata of the dir.
+	if err := r.managedBubbleMetadata(newTurtleDexPath("agg/sub")); err != nil {
+		t.Fatal(err)
+	}
+	md, err := r.managedCalculateDirectoryMetadata(newTurtleDexPath("agg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.AggregateCustom["matches"] != 3 {
+		t.Fatal("wrong number of matches", md.AggregateCustom["matches"])
+	}
+	if md.AggregateCustom["size"] != md.AggregateSize {
+		t.Fatalf("size aggregate should be %v but was %v", md.AggregateSize, md.AggregateCustom["size"])
+	}
+
+	// Unregistered aggregates should be dropped.
+	if err := r.RegisterBubbleAggregate("size", nil); err != nil {
+		t.Fatal(err)
+	}
+	md, err = r.managedCalculateDirectoryMetadata(newTurtleDexPath("agg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := md.AggregateCustom["size"]; exists || len(md.AggregateCustom) != 1 {
+		t.Fatal("unregistered aggregate wasn't dropped", md.AggregateCustom)
+	}
+}
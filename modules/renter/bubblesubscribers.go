@@ -0,0 +1,156 @@
+package renter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/turtledex/errors"
+)
+
+// bubblesubscribers.go contains the subscriptions to completed bubbles. Every
+// subscriber gets its own buffered channel. Publishing an event never blocks
+// the bubble: if the buffer of a subscriber is full, the event is dropped for
+// that subscriber only and counted. The number of subscribers is limited to
+// keep the cost of publishing an event bounded.
+
+const (
+	// defaultMaxBubbleSubscribers is the default maximum number of
+	// subscribers to completed bubbles.
+	defaultMaxBubbleSubscribers = 16
+)
+
+var (
+	// errTooManyBubbleSubscribers is returned if a subscription would exceed
+	// the maximum number of subscribers.
+	errTooManyBubbleSubscribers = errors.New("maximum number of bubble subscribers reached")
+)
+
+type (
+	// bubbleSubscribers tracks the subscribers to completed bubbles.
+	bubbleSubscribers struct {
+		closed         bool
+		dropped        uint64
+		maxSubscribers int
+		nextID         uint64
+		subscribers    map[uint64]chan RefreshDiagEvent
+		mu             sync.Mutex
+	}
+)
+
+// newBubbleSubscribers creates a new bubbleSubscribers.
+func newBubbleSubscribers(maxSubscribers int) *bubbleSubscribers {
+	return &bubbleSubscribers{
+		maxSubscribers: maxSubscribers,
+		subscribers:    make(map[uint64]chan RefreshDiagEvent),
+	}
+}
+
+// callClose closes the channels of all subscribers. Events published
+// afterwards are ignored.
+func (bs *bubbleSubscribers) callClose() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.closed = true
+	for id, c := range bs.subscribers {
+		close(c)
+		delete(bs.subscribers, id)
+	}
+	return nil
+}
+
+// callPublish sends an event to every subscriber without blocking.
+func (bs *bubbleSubscribers) callPublish(e RefreshDiagEvent) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for _, c := range bs.subscribers {
+		select {
+		case c <- e:
+		default:
+			bs.dropped++
+		}
+	}
+}
+
+// callSetMaxSubscribers sets the maximum number of subscribers. Existing
+// subscriptions are kept even if they exceed the new maximum.
+func (bs *bubbleSubscribers) callSetMaxSubscribers(maxSubscribers int) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.maxSubscribers = maxSubscribers
+}
+
+// callStatus returns the number of subscribers, the maximum number of
+// subscribers and the number of dropped events.
+func (bs *bubbleSubscribers) callStatus() (numSubscribers, maxSubscribers int, dropped uint64) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return len(bs.subscribers), bs.maxSubscribers, bs.dropped
+}
+
+// callSubscribe adds a subscriber with a buffer of bufferSize events.
+func (bs *bubbleSubscribers) callSubscribe(bufferSize int) (uint64, <-chan RefreshDiagEvent, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.closed {
+		return 0, nil, errors.New("bubble subscriptions are closed")
+	}
+	if len(bs.subscribers) >= bs.maxSubscribers {
+		return 0, nil, errors.AddContext(errTooManyBubbleSubscribers, fmt.Sprintf("limit is %v", bs.maxSubscribers))
+	}
+	id := bs.nextID
+	bs.nextID++
+	c := make(chan RefreshDiagEvent, bufferSize)
+	bs.subscribers[id] = c
+	return id, c, nil
+}
+
+// callUnsubscribe removes a subscriber and closes its channel. Removing a
+// subscriber twice is a no-op.
+func (bs *bubbleSubscribers) callUnsubscribe(id uint64) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	c, exists := bs.subscribers[id]
+	if !exists {
+		return
+	}
+	close(c)
+	delete(bs.subscribers, id)
+}
+
+// SubscribeBubbles subscribes to completed bubbles. The returned channel
+// receives an event for every directory whose bubble completed. It buffers up
+// to bufferSize events, further events are dropped until the subscriber
+// catches up. The channel is closed by calling the returned unsubscribe
+// function or when the renter shuts down.
+func (r *Renter) SubscribeBubbles(bufferSize int) (<-chan RefreshDiagEvent, func(), error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, nil, err
+	}
+	defer r.tg.Done()
+	if bufferSize < 1 {
+		return nil, nil, fmt.Errorf("buffer size must be at least 1 but was %v", bufferSize)
+	}
+	id, c, err := r.staticBubbleSubscribers.callSubscribe(bufferSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	unsubscribe := func() {
+		r.staticBubbleSubscribers.callUnsubscribe(id)
+	}
+	return c, unsubscribe, nil
+}
+
+// SetMaxBubbleSubscribers sets the maximum number of subscribers to completed
+// bubbles. Subscriptions above the new maximum are not cancelled but prevent
+// new subscriptions until enough of them are cancelled.
+func (r *Renter) SetMaxBubbleSubscribers(maxSubscribers int) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if maxSubscribers < 0 {
+		return fmt.Errorf("max subscribers can't be negative but was %v", maxSubscribers)
+	}
+	r.staticBubbleSubscribers.callSetMaxSubscribers(maxSubscribers)
+	return nil
+}
@@ -0,0 +1,110 @@
+package renter
+
+import (
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestBubbleSubscribers probes the bubbleSubscribers.
+func TestBubbleSubscribers(t *testing.T) {
+	t.Parallel()
+
+	bs := newBubbleSubscribers(2)
+	id1, c1, err := bs.callSubscribe(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, c2, err := bs.callSubscribe(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bs.callSubscribe(1); !errors.Contains(err, errTooManyBubbleSubscribers) {
+		t.Fatal("expected errTooManyBubbleSubscribers but got", err)
+	}
+
+	// Publish 2 events. The second one is dropped for the first subscriber.
+	e := RefreshDiagEvent{Type: string(refreshEventBubbled), TurtleDexPath: newTurtleDexPath("dir")}
+	bs.callPublish(e)
+	bs.callPublish(e)
+	if len(c1) != 1 || len(c2) != 2 {
+		t.Fatal("wrong number of buffered events", len(c1), len(c2))
+	}
+	if numSubscribers, maxSubscribers, dropped := bs.callStatus(); numSubscribers != 2 || maxSubscribers != 2 || dropped != 1 {
+		t.Fatal("wrong status", numSubscribers, maxSubscribers, dropped)
+	}
+
+	// Unsubscribing closes the channel and frees a slot.
+	bs.callUnsubscribe(id1)
+	bs.callUnsubscribe(id1)
+	<-c1
+	if _, ok := <-c1; ok {
+		t.Fatal("channel should be closed")
+	}
+	if _, _, err := bs.callSubscribe(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing closes the remaining channels and prevents new subscriptions.
+	if err := bs.callClose(); err != nil {
+		t.Fatal(err)
+	}
+	<-c2
+	<-c2
+	if _, ok := <-c2; ok {
+		t.Fatal("channel should be closed")
+	}
+	if _, _, err := bs.callSubscribe(1); err == nil {
+		t.Fatal("subscribing after close should fail")
+	}
+	bs.callPublish(e)
+}
+
+// TestSubscribeBubbles probes SubscribeBubbles.
+func TestSubscribeBubbles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	if _, _, err := r.SubscribeBubbles(0); err == nil {
+		t.Fatal("expected error for buffer size 0")
+	}
+	if err := r.SetMaxBubbleSubscribers(1); err != nil {
+		t.Fatal(err)
+	}
+	c, unsubscribe, err := r.SubscribeBubbles(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if _, _, err := r.SubscribeBubbles(100); !errors.Contains(err, errTooManyBubbleSubscribers) {
+		t.Fatal("expected errTooManyBubbleSubscribers but got", err)
+	}
+	diag := r.RefreshDiagnostics()
+	if diag.NumBubbleSubscribers != 1 || diag.MaxBubbleSubscribers != 1 {
+		t.Fatal("wrong diagnostics", diag.NumBubbleSubscribers, diag.MaxBubbleSubscribers)
+	}
+
+	// A completed bubble should be published.
+	if err := r.managedBubbleMetadata(modules.HomeFolder); err != nil {
+		t.Fatal(err)
+	}
+	e := <-c
+	if e.Type != string(refreshEventBubbled) || !e.TurtleDexPath.Equals(modules.HomeFolder) {
+		t.Fatal("unexpected event", e)
+	}
+}
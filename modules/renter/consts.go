@@ -29,6 +29,36 @@ func AlertCauseTurtleDexfileLowRedundancy(siaPath modules.TurtleDexPath, health,
 	return fmt.Sprintf("TurtleDexfile '%v' has a health of %v and redundancy of %v", siaPath.String(), health, redundancy)
 }
 
+const (
+	// rebalanceDirPrefix is the prefix of the numbered directories created by
+	// RebalanceDir.
+	rebalanceDirPrefix = "rebalance_"
+)
+
+const (
+	// defaultMaxConcurrentListings is the default number of directory
+	// listings that are allowed to walk the filesystem at the same time.
+	defaultMaxConcurrentListings = 10
+)
+
+const (
+	// defaultHealthLoopBackoffFactor is the default factor by which the
+	// health loop's interval grows with every idle cycle.
+	defaultHealthLoopBackoffFactor = 2
+
+	// healthLoopMaxIntervalMultiple caps the max interval of the health
+	// loop's backoff at this multiple of the healthCheckInterval, so an idle
+	// renter still checks its files close to the healthCheckInterval.
+	healthLoopMaxIntervalMultiple = 2
+)
+
+const (
+	// bubbleDurationDecay is the decay of the exponential moving average of
+	// the bubble duration that is tracked for every directory. A higher decay
+	// gives more weight to older bubbles.
+	bubbleDurationDecay = 0.8
+)
+
 // Default redundancy parameters.
 var (
 	// syncCheckInterval is how often the repair heap checks the consensus code
@@ -201,6 +231,19 @@ var (
 		Testing:  5 * time.Second,
 	}).(time.Duration)
 
+	// defaultHealthLoopMaxInterval is the default max interval the health
+	// loop's interval can grow to while the renter is idle.
+	defaultHealthLoopMaxInterval = build.Select(build.Var{
+		Dev:      30 * time.Minute,
+		Standard: 2 * time.Hour,
+		Testing:  5 * time.Second,
+	}).(time.Duration)
+
+	// defaultDirStalenessThreshold is the default age of a directory's
+	// LastHealthCheckTime after which DirInfoCached reports the directory's
+	// info as stale.
+	defaultDirStalenessThreshold = healthCheckInterval
+
 	// healthLoopErrorSleepDuration indicates how long the health loop should
 	// sleep before retrying if there is an error preventing progress.
 	healthLoopErrorSleepDuration = build.Select(build.Var{
@@ -264,6 +307,11 @@ var (
 	// groups in various bubble methods
 	numBubbleWorkerThreads = 20
 
+	// defaultBubbleFileWorkers is the default number of workers used to
+	// calculate the metadata of the files within a single directory during a
+	// bubble. It matches the number of workers the other bubble methods use.
+	defaultBubbleFileWorkers = numBubbleWorkerThreads
+
 	// offlineCheckFrequency is how long the renter will wait to check the
 	// online status if it is offline.
 	offlineCheckFrequency = build.Select(build.Var{
@@ -343,6 +391,14 @@ var (
 		Testing:  time.Second,
 	}).(time.Duration)
 
+	// listingTimeout is the amount of time a directory listing waits for
+	// other listings to finish before giving up.
+	listingTimeout = build.Select(build.Var{
+		Dev:      30 * time.Second,
+		Standard: time.Minute,
+		Testing:  5 * time.Second,
+	}).(time.Duration)
+
 	// workerPoolUpdateTimeout is the amount of time that can pass before the
 	// worker pool should be updated.
 	workerPoolUpdateTimeout = build.Select(build.Var{
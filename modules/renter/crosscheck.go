@@ -0,0 +1,266 @@
+package renter
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
+	"github.com/turtledex/errors"
+)
+
+// crosscheck.go contains a reference implementation of the health related
+// fields computed by the bubble. It is deliberately simple and slow: every
+// directory's values are derived from scratch by looking at every single file
+// of the subtree instead of combining the values of its subdirectories. This
+// makes it independent of the bubble's aggregation code and suitable for
+// catching bugs in it.
+//
+// Both implementations use the cached health of the files. Files that changed
+// after the last bubble of their directory show up as discrepancies as well.
+//
+// HealthInconsistencies is a cheaper sanity check which doesn't recompute
+// anything. It only flags combinations of file and directory health that the
+// bubble can never produce, since a directory's health is the worst health of
+// its files.
+
+const (
+	// crossCheckHealthTolerance is the maximum difference between a value
+	// computed by the bubble and the reference implementation that is not
+	// reported as a discrepancy.
+	crossCheckHealthTolerance = 1e-9
+
+	// healthInconsistencyTolerance is the margin around the RepairThreshold
+	// used by HealthInconsistencies. A health is only considered unhealthy if
+	// it is at least RepairThreshold+healthInconsistencyTolerance and only
+	// healthy if it is below RepairThreshold-healthInconsistencyTolerance.
+	// Values within the margin are never flagged, which prevents reporting
+	// files whose health changed slightly since the last bubble.
+	healthInconsistencyTolerance = 0.05
+)
+
+// Reasons of a HealthInconsistency.
+const (
+	// InconsistencyUnhealthyFile indicates an unhealthy file within a
+	// directory whose health or aggregate health is healthy.
+	InconsistencyUnhealthyFile = "unhealthy file in healthy directory"
+	// InconsistencyUnhealthyDir indicates a directory whose health is
+	// unhealthy although all of its files are healthy.
+	InconsistencyUnhealthyDir = "unhealthy directory with only healthy files"
+)
+
+type (
+	// HealthDiscrepancy describes a field of a directory's metadata whose
+	// value computed by the bubble differs from the value computed by the
+	// reference implementation.
+	HealthDiscrepancy struct {
+		TurtleDexPath modules.TurtleDexPath `json:"siapath"`
+		Field         string                `json:"field"`
+		Bubbled       float64               `json:"bubbled"`
+		Reference     float64               `json:"reference"`
+	}
+
+	// HealthInconsistency describes a file and its directory whose health
+	// contradict each other. For InconsistencyUnhealthyDir the TurtleDexPath
+	// is the directory and FileHealth is the worst health of its files.
+	HealthInconsistency struct {
+		TurtleDexPath modules.TurtleDexPath `json:"siapath"`
+		Dir           modules.TurtleDexPath `json:"dir"`
+		FileHealth    float64               `json:"filehealth"`
+		DirHealth     float64               `json:"dirhealth"`
+		Reason        string                `json:"reason"`
+	}
+
+	// referenceHealth contains the fields computed by the reference
+	// implementation.
+	referenceHealth struct {
+		health               float64
+		stuckHealth          float64
+		numFiles             uint64
+		aggregateHealth      float64
+		aggregateStuckHealth float64
+		aggregateNumFiles    uint64
+	}
+)
+
+// computeReferenceHealth computes the health related fields of dir from all
+// the files in the filesystem.
+func computeReferenceHealth(dir modules.TurtleDexPath, files []modules.FileInfo) referenceHealth {
+	rh := referenceHealth{
+		health:               ttdxdir.DefaultDirHealth,
+		stuckHealth:          ttdxdir.DefaultDirHealth,
+		aggregateHealth:      ttdxdir.DefaultDirHealth,
+		aggregateStuckHealth: ttdxdir.DefaultDirHealth,
+	}
+	for _, fi := range files {
+		depth, err := fi.TurtleDexPath.RelativeDepth(dir)
+		if err != nil {
+			continue // not within dir
+		}
+		rh.aggregateNumFiles++
+		rh.aggregateHealth = math.Max(rh.aggregateHealth, fi.Health)
+		rh.aggregateStuckHealth = math.Max(rh.aggregateStuckHealth, fi.StuckHealth)
+		if depth == 1 {
+			rh.numFiles++
+			rh.health = math.Max(rh.health, fi.Health)
+			rh.stuckHealth = math.Max(rh.stuckHealth, fi.StuckHealth)
+		}
+	}
+	return rh
+}
+
+// CrossCheckHealth compares the health related fields of every directory
+// within siaPath, including siaPath itself, against the values computed by a
+// simple reference implementation. The returned discrepancies are sorted by
+// path. The check is expensive and meant to be run on demand.
+func (r *Renter) CrossCheckHealth(siaPath modules.TurtleDexPath) (discrepancies []HealthDiscrepancy, err error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var files []modules.FileInfo
+	var dirs []modules.DirectoryInfo
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		files = append(files, fi)
+		mu.Unlock()
+	}
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		dirs = append(dirs, di)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, dlf)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directory")
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].TurtleDexPath.String() < dirs[j].TurtleDexPath.String()
+	})
+
+	for _, di := range dirs {
+		rh := computeReferenceHealth(di.TurtleDexPath, files)
+		fields := []struct {
+			name               string
+			bubbled, reference float64
+		}{
+			{"health", di.Health, rh.health},
+			{"stuckhealth", di.StuckHealth, rh.stuckHealth},
+			{"numfiles", float64(di.NumFiles), float64(rh.numFiles)},
+			{"aggregatehealth", di.AggregateHealth, rh.aggregateHealth},
+			{"aggregatestuckhealth", di.AggregateStuckHealth, rh.aggregateStuckHealth},
+			{"aggregatenumfiles", float64(di.AggregateNumFiles), float64(rh.aggregateNumFiles)},
+		}
+		for _, f := range fields {
+			if math.Abs(f.bubbled-f.reference) <= crossCheckHealthTolerance {
+				continue
+			}
+			discrepancies = append(discrepancies, HealthDiscrepancy{
+				TurtleDexPath: di.TurtleDexPath,
+				Field:         f.name,
+				Bubbled:       f.bubbled,
+				Reference:     f.reference,
+			})
+		}
+	}
+	return discrepancies, nil
+}
+
+// healthyWithTolerance and unhealthyWithTolerance classify a health using the
+// healthInconsistencyTolerance. A health within the margin around the
+// RepairThreshold is neither.
+func healthyWithTolerance(health float64) bool {
+	return health < modules.RepairThreshold-healthInconsistencyTolerance
+}
+func unhealthyWithTolerance(health float64) bool {
+	return health >= modules.RepairThreshold+healthInconsistencyTolerance
+}
+
+// HealthInconsistencies walks the subtree of siaPath and returns the files and
+// directories whose cached health contradicts the health of their directory or
+// files. A file can't be unhealthy within a directory whose health or
+// aggregate health is healthy, and a directory can't be unhealthy if all of
+// its files are healthy. The returned inconsistencies are sorted by directory
+// and path.
+func (r *Renter) HealthInconsistencies(siaPath modules.TurtleDexPath) ([]HealthInconsistency, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var files []modules.FileInfo
+	dirs := make(map[modules.TurtleDexPath]modules.DirectoryInfo)
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		files = append(files, fi)
+		mu.Unlock()
+	}
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		dirs[di.TurtleDexPath] = di
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, dlf)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directory")
+	}
+
+	var inconsistencies []HealthInconsistency
+	worstFileHealth := make(map[modules.TurtleDexPath]float64)
+	for _, fi := range files {
+		dir, err := fi.TurtleDexPath.Dir()
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to get directory of file")
+		}
+		di, ok := dirs[dir]
+		if !ok {
+			continue
+		}
+		if worst, ok := worstFileHealth[dir]; !ok || fi.Health > worst {
+			worstFileHealth[dir] = fi.Health
+		}
+		dirHealth := math.Min(di.Health, di.AggregateHealth)
+		if unhealthyWithTolerance(fi.Health) && healthyWithTolerance(dirHealth) {
+			inconsistencies = append(inconsistencies, HealthInconsistency{
+				TurtleDexPath: fi.TurtleDexPath,
+				Dir:           dir,
+				FileHealth:    fi.Health,
+				DirHealth:     dirHealth,
+				Reason:        InconsistencyUnhealthyFile,
+			})
+		}
+	}
+	for dir, worst := range worstFileHealth {
+		di := dirs[dir]
+		if unhealthyWithTolerance(di.Health) && healthyWithTolerance(worst) {
+			inconsistencies = append(inconsistencies, HealthInconsistency{
+				TurtleDexPath: dir,
+				Dir:           dir,
+				FileHealth:    worst,
+				DirHealth:     di.Health,
+				Reason:        InconsistencyUnhealthyDir,
+			})
+		}
+	}
+	sort.Slice(inconsistencies, func(i, j int) bool {
+		if di, dj := inconsistencies[i].Dir.String(), inconsistencies[j].Dir.String(); di != dj {
+			return di < dj
+		}
+		return inconsistencies[i].TurtleDexPath.String() < inconsistencies[j].TurtleDexPath.String()
+	})
+	return inconsistencies, nil
+}
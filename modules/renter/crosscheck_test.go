@@ -0,0 +1,166 @@
+package renter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestCrossCheckHealth probes CrossCheckHealth.
+func TestCrossCheckHealth(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	for _, name := range []string{"a/f1", "a/f2", "a/b/f3", "c/f4"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Bubble the tree. Once the bubbles reached the root, the bubble and the
+	// reference implementation should agree.
+	for _, dir := range []string{"a/b", "c"} {
+		if err := r.managedBubbleMetadata(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		discrepancies, err := r.CrossCheckHealth(modules.RootTurtleDexPath())
+		if err != nil {
+			return err
+		}
+		if len(discrepancies) > 0 {
+			return fmt.Errorf("unexpected discrepancies %v", discrepancies)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the health of a.
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(newTurtleDexPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := dir.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md.Health += 1
+	if err := dir.UpdateMetadata(md); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.Close(); err != nil {
+		t.Fatal(err)
+	}
+	discrepancies, err := r.CrossCheckHealth(newTurtleDexPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) != 1 {
+		t.Fatal("expected one discrepancy", discrepancies)
+	}
+	d := discrepancies[0]
+	if !d.TurtleDexPath.Equals(newTurtleDexPath("a")) || d.Field != "health" || d.Bubbled != d.Reference+1 {
+		t.Fatal("unexpected discrepancy", d)
+	}
+}
+
+// TestHealthInconsistencies probes HealthInconsistencies.
+func TestHealthInconsistencies(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// The test files have no hosts and are therefore unhealthy.
+	for _, name := range []string{"a/f1", "a/f2"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := newTurtleDexPath("a")
+	if err := r.managedBubbleMetadata(a); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		inconsistencies, err := r.HealthInconsistencies(modules.RootTurtleDexPath())
+		if err != nil {
+			return err
+		}
+		if len(inconsistencies) > 0 {
+			return fmt.Errorf("unexpected inconsistencies %v", inconsistencies)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mark a as healthy. Both files should be flagged.
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := dir.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md.Health = 0
+	if err := dir.UpdateMetadata(md); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.Close(); err != nil {
+		t.Fatal(err)
+	}
+	inconsistencies, err := r.HealthInconsistencies(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inconsistencies) != 2 {
+		t.Fatal("expected two inconsistencies", inconsistencies)
+	}
+	for i, name := range []string{"a/f1", "a/f2"} {
+		hi := inconsistencies[i]
+		if !hi.TurtleDexPath.Equals(newTurtleDexPath(name)) || !hi.Dir.Equals(a) || hi.Reason != InconsistencyUnhealthyFile {
+			t.Fatal("unexpected inconsistency", hi)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package renter
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/crypto"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// direttag.go contains the ETags of directories. The ETag of a directory is a
+// hash over the paths of all the directories and files within its subtree
+// together with the size and the modification time of every file. The
+// in-memory UIDs of the files are not included, since they change on every
+// restart. That way the same contents always result in the same ETag.
+//
+// ETags are cached until the next bubble of the directory. Changes that
+// weren't bubbled yet are therefore not reflected by the ETag.
+
+const (
+	// dirETagLen is the number of bytes of the hash that are used for an
+	// ETag.
+	dirETagLen = 16
+)
+
+type (
+	// dirETagCache caches the ETags of directories.
+	dirETagCache struct {
+		etags map[modules.TurtleDexPath]string
+
+		// generation is incremented on every invalidation. An ETag is only
+		// cached if no invalidation happened while it was computed.
+		generation uint64
+
+		mu sync.Mutex
+	}
+)
+
+// callGet returns the cached ETag of sp and the current generation.
+func (c *dirETagCache) callGet(sp modules.TurtleDexPath) (string, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	etag, ok := c.etags[sp]
+	return etag, ok, c.generation
+}
+
+// callInvalidate removes the cached ETag of sp.
+func (c *dirETagCache) callInvalidate(sp modules.TurtleDexPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	delete(c.etags, sp)
+}
+
+// callSet caches the ETag of sp unless the cache was invalidated since
+// generation.
+func (c *dirETagCache) callSet(sp modules.TurtleDexPath, etag string, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	if c.etags == nil {
+		c.etags = make(map[modules.TurtleDexPath]string)
+	}
+	c.etags[sp] = etag
+}
+
+// DirETag returns a short token which identifies the contents of the subtree
+// of siaPath. The token only changes if a file or directory is added, removed
+// or renamed or if a file's size or modification time changes. The token
+// consists of hex characters and can be used as an HTTP ETag once quoted.
+func (r *Renter) DirETag(siaPath modules.TurtleDexPath) (string, error) {
+	if err := r.tg.Add(); err != nil {
+		return "", err
+	}
+	defer r.tg.Done()
+	etag, ok, generation := r.staticDirETags.callGet(siaPath)
+	if ok {
+		return etag, nil
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var entries []string
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		entry := fmt.Sprintf("f %v %v %v", fi.TurtleDexPath, fi.Filesize, fi.ModificationTime.UnixNano())
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+	}
+	dlf := func(di modules.DirectoryInfo) {
+		entry := fmt.Sprintf("d %v", di.TurtleDexPath)
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, dlf)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to list directory")
+	}
+	sort.Strings(entries)
+	h := crypto.NewHash()
+	for _, entry := range entries {
+		h.Write([]byte(entry))
+		h.Write([]byte{'\n'})
+	}
+	etag = hex.EncodeToString(h.Sum(nil)[:dirETagLen])
+	r.staticDirETags.callSet(siaPath, etag, generation)
+	return etag, nil
+}
@@ -0,0 +1,77 @@
+package renter
+
+import (
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestDirETag probes DirETag.
+func TestDirETag(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	dir := newTurtleDexPath("dir")
+	for _, name := range []string{"dir/a", "dir/sub/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	etag, err := r.DirETag(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(etag) != 2*dirETagLen {
+		t.Fatal("wrong etag length", etag)
+	}
+	if _, cached, _ := r.staticDirETags.callGet(dir); !cached {
+		t.Fatal("etag should be cached")
+	}
+
+	// A bubble without changes should result in the same ETag.
+	if err := r.managedBubbleMetadata(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached, _ := r.staticDirETags.callGet(dir); cached {
+		t.Fatal("etag should be invalidated by the bubble")
+	}
+	etag2, err := r.DirETag(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag2 != etag {
+		t.Fatalf("etag changed without changes: %v != %v", etag, etag2)
+	}
+
+	// Renaming a file should change the ETag after the next bubble.
+	if err := r.RenameFile(newTurtleDexPath("dir/a"), newTurtleDexPath("dir/c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedBubbleMetadata(dir); err != nil {
+		t.Fatal(err)
+	}
+	etag3, err := r.DirETag(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag3 == etag {
+		t.Fatal("etag should change after a rename")
+	}
+}
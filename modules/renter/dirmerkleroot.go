@@ -0,0 +1,98 @@
+package renter
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/crypto"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// dirmerkleroot.go contains the code for computing a Merkle root over the
+// contents of a directory. The root can be used to prove that two renters hold
+// the same logical directory contents without transferring the full listing.
+//
+// The root is only as strong as the content identifiers of the files. A
+// skylink commits to the contents of a file, but the renter doesn't store a
+// renter independent hash of the plaintext of a regular file. The sector
+// roots can't be used either since they depend on the renter's encryption
+// key. Two files without skylinks are therefore considered equal if they have
+// the same path and size, even if their contents differ. The root must not be
+// relied on to detect changed contents of such files.
+
+// fileContentID returns the identifier of a file which is used as a leaf of
+// the directory's Merkle tree. It only depends on the logical contents of the
+// file, which are its path relative to the directory, its size and its
+// skylinks. Renter specific data like the encryption key or the hosts storing
+// the file are not taken into account. Only the skylinks identify the actual
+// contents, see the top of this file.
+func fileContentID(fi modules.FileInfo, dir modules.TurtleDexPath) (crypto.Hash, error) {
+	relPath, err := fi.TurtleDexPath.Rebase(dir, modules.RootTurtleDexPath())
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	skylinks := append([]string(nil), fi.Skylinks...)
+	sort.Strings(skylinks)
+	return crypto.HashAll(relPath.String(), fi.Filesize, skylinks), nil
+}
+
+// DirMerkleRoot computes the Merkle root over the content identifiers of all
+// the files within a directory and its subdirectories. The leaves are sorted,
+// which means that two directories with identical logical contents result in
+// the same root. Changes to the contents of files without skylinks which
+// don't change their size don't change the root.
+func (r *Renter) DirMerkleRoot(siaPath modules.TurtleDexPath) ([]byte, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+
+	// Make sure the path isn't a file.
+	isFile, err := r.staticFileSystem.FileExists(siaPath)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		return nil, errors.AddContext(errNotADirectory, siaPath.String())
+	}
+
+	// Get the content identifiers of all the files.
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	var ids []crypto.Hash
+	var idErr error
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		id, err := fileContentID(fi, siaPath)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			idErr = errors.Compose(idErr, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, func(modules.DirectoryInfo) {})
+	release()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directory")
+	}
+	if idErr != nil {
+		return nil, errors.AddContext(idErr, "failed to compute file content ids")
+	}
+
+	// Build the tree.
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	tree := crypto.NewTree()
+	for _, id := range ids {
+		tree.PushObject(id)
+	}
+	root := tree.Root()
+	return root[:], nil
+}
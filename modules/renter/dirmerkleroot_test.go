@@ -0,0 +1,82 @@
+package renter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestDirMerkleRoot probes DirMerkleRoot.
+func TestDirMerkleRoot(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// createFiles is a helper to create files relative to a dir.
+	createFiles := func(dir string, names ...string) {
+		for _, name := range names {
+			f, err := r.createRenterTestFile(newTurtleDexPath(dir + "/" + name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Create two dirs with the same logical contents. The files are created in
+	// a different order and with different keys.
+	createFiles("x", "a", "sub/b", "sub/c")
+	createFiles("y", "sub/c", "a", "sub/b")
+	rootX, err := r.DirMerkleRoot(newTurtleDexPath("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootY, err := r.DirMerkleRoot(newTurtleDexPath("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rootX, rootY) {
+		t.Fatal("roots of dirs with identical contents should match")
+	}
+
+	// Adding a file should change the root.
+	createFiles("y", "d")
+	rootY, err = r.DirMerkleRoot(newTurtleDexPath("y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rootX, rootY) {
+		t.Fatal("roots of dirs with different contents shouldn't match")
+	}
+
+	// A file with the same name in a different subdir results in a different
+	// root as well.
+	createFiles("z", "a", "sub/b", "c")
+	rootZ, err := r.DirMerkleRoot(newTurtleDexPath("z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rootX, rootZ) {
+		t.Fatal("roots of dirs with different layouts shouldn't match")
+	}
+
+	// Files aren't supported.
+	if _, err := r.DirMerkleRoot(newTurtleDexPath("x/a")); err == nil {
+		t.Fatal("expected error for file")
+	}
+}
@@ -1,14 +1,66 @@
 package renter
 
 import (
+	"fmt"
+	"math"
 	"os"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/turtledex/TurtleDexCore/modules"
 	"github.com/turtledex/errors"
 )
 
+var (
+	// errNotADirectory is returned if a directory operation is called on a
+	// file.
+	errNotADirectory = errors.New("path is a file, not a directory")
+
+	// errMatchAllPattern is returned by RefreshMatching if the pattern would
+	// match every directory.
+	errMatchAllPattern = errors.New("pattern matches the entire tree")
+)
+
+// Types of children returned by ListChildren.
+const (
+	// ChildTypeDir indicates that a child is a directory.
+	ChildTypeDir ChildType = iota
+	// ChildTypeFile indicates that a child is a file.
+	ChildTypeFile
+)
+
+type (
+	// ChildType is the type of a child returned by ListChildren.
+	ChildType int
+
+	// ChildInfo contains information about an immediate child of a directory.
+	// Depending on the Type either DirInfo or FileInfo is set.
+	ChildInfo struct {
+		Type          ChildType
+		TurtleDexPath modules.TurtleDexPath
+		Size          uint64
+		ModTime       time.Time
+
+		DirInfo  modules.DirectoryInfo
+		FileInfo modules.FileInfo
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (ct ChildType) String() string {
+	switch ct {
+	case ChildTypeDir:
+		return "dir"
+	case ChildTypeFile:
+		return "file"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(ct))
+	}
+}
+
 // CreateDir creates a directory for the renter
 func (r *Renter) CreateDir(siaPath modules.TurtleDexPath, mode os.FileMode) error {
 	err := r.tg.Add()
@@ -16,6 +68,10 @@ func (r *Renter) CreateDir(siaPath modules.TurtleDexPath, mode os.FileMode) erro
 		return err
 	}
 	defer r.tg.Done()
+	// Make sure the directory doesn't exceed the maximum depth.
+	if err := siaPath.ValidateDepth(); err != nil {
+		return err
+	}
 	return r.staticFileSystem.NewTurtleDexDir(siaPath, mode)
 }
 
@@ -26,7 +82,17 @@ func (r *Renter) DeleteDir(siaPath modules.TurtleDexPath) error {
 		return err
 	}
 	defer r.tg.Done()
-	return r.staticFileSystem.DeleteDir(siaPath)
+	files, err := r.managedAuditedFiles(siaPath)
+	if err != nil {
+		return err
+	}
+	if err := r.staticFileSystem.DeleteDir(siaPath); err != nil {
+		return err
+	}
+	for _, sp := range files {
+		r.callRecordFileOp(fileOpDeleted, sp, modules.TurtleDexPath{})
+	}
+	return nil
 }
 
 // DirList lists the directories in a ttdxdir
@@ -35,6 +101,11 @@ func (r *Renter) DirList(siaPath modules.TurtleDexPath) (dis []modules.Directory
 		return nil, err
 	}
 	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return r.managedDirList(siaPath)
 }
 
@@ -56,6 +127,322 @@ func (r *Renter) managedDirList(siaPath modules.TurtleDexPath) (dis []modules.Di
 	return dis, nil
 }
 
+// ListChildren returns the immediate children of a directory, both files and
+// directories, using the cached metadata. Directories are listed before files
+// and both are sorted by their path.
+func (r *Renter) ListChildren(siaPath modules.TurtleDexPath) ([]ChildInfo, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Make sure the path isn't a file.
+	isFile, err := r.staticFileSystem.FileExists(siaPath)
+	if err != nil {
+		return nil, err
+	}
+	if isFile {
+		return nil, errors.AddContext(errNotADirectory, siaPath.String())
+	}
+
+	// List the children.
+	var children []ChildInfo
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		children = append(children, ChildInfo{
+			Type:          ChildTypeFile,
+			TurtleDexPath: fi.TurtleDexPath,
+			Size:          fi.Filesize,
+			ModTime:       fi.ModificationTime,
+			FileInfo:      fi,
+		})
+	}
+	dlf := func(di modules.DirectoryInfo) {
+		// Ignore the directory itself.
+		if di.TurtleDexPath.Equals(siaPath) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		children = append(children, ChildInfo{
+			Type:          ChildTypeDir,
+			TurtleDexPath: di.TurtleDexPath,
+			Size:          di.AggregateSize,
+			ModTime:       di.AggregateMostRecentModTime,
+			DirInfo:       di,
+		})
+	}
+	err = r.staticFileSystem.CachedList(siaPath, false, flf, dlf)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Type != children[j].Type {
+			return children[i].Type < children[j].Type
+		}
+		return children[i].TurtleDexPath.String() < children[j].TurtleDexPath.String()
+	})
+	return children, nil
+}
+
+// DirInfoCached returns the info of a directory as it was computed by the
+// last bubble without recomputing anything. The returned bool indicates
+// whether the info is stale, which is the case if the directory's
+// LastHealthCheckTime is older than the staleness threshold. If refresh is
+// true, a bubble is queued for a stale directory in the background.
+func (r *Renter) DirInfoCached(siaPath modules.TurtleDexPath, refresh bool) (_ modules.DirectoryInfo, stale bool, err error) {
+	if err := r.tg.Add(); err != nil {
+		return modules.DirectoryInfo{}, false, err
+	}
+	defer r.tg.Done()
+
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(siaPath)
+	if err != nil {
+		return modules.DirectoryInfo{}, false, err
+	}
+	defer func() {
+		err = errors.Compose(err, dir.Close())
+	}()
+	di, err := r.staticFileSystem.DirNodeInfo(dir)
+	if err != nil {
+		return modules.DirectoryInfo{}, false, err
+	}
+	threshold := time.Duration(atomic.LoadInt64(&r.atomicDirStalenessThreshold))
+	stale = time.Since(di.LastHealthCheckTime) > threshold
+	if stale && refresh {
+		go r.callThreadedBubbleMetadata(siaPath)
+	}
+	return di, stale, nil
+}
+
+// SetDirStalenessThreshold sets the age of a directory's LastHealthCheckTime
+// after which DirInfoCached reports the directory's info as stale.
+func (r *Renter) SetDirStalenessThreshold(threshold time.Duration) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if threshold <= 0 {
+		return fmt.Errorf("staleness threshold must be positive but was %v", threshold)
+	}
+	atomic.StoreInt64(&r.atomicDirStalenessThreshold, int64(threshold))
+	return nil
+}
+
+// LastActivity returns the most recent modification time of any file within
+// the subtree of siaPath as it was computed by the last bubble. Unlike the
+// AggregateMostRecentModTime of the directory's info, it doesn't depend on when
+// the directory was last bubbled. The zero time is returned if the subtree
+// contains no files.
+func (r *Renter) LastActivity(siaPath modules.TurtleDexPath) (time.Time, error) {
+	if err := r.tg.Add(); err != nil {
+		return time.Time{}, err
+	}
+	defer r.tg.Done()
+	di, err := r.staticFileSystem.DirInfo(siaPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return di.AggregateLastActivity, nil
+}
+
+// EmptyDirs returns the sorted paths of all directories within prefix,
+// including prefix itself, which contain neither files nor subdirectories.
+// The counts are taken from the cached directory metadata. The root and
+// pinned directories are never returned.
+func (r *Renter) EmptyDirs(prefix modules.TurtleDexPath) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var empty []modules.TurtleDexPath
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		if di.TurtleDexPath.IsRoot() || di.Pinned {
+			return
+		}
+		if di.AggregateNumFiles != 0 || di.AggregateNumSubDirs != 0 {
+			return
+		}
+		mu.Lock()
+		empty = append(empty, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(prefix, true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(empty, func(i, j int) bool {
+		return empty[i].String() < empty[j].String()
+	})
+	return empty, nil
+}
+
+// ExpensiveDirs returns the paths of all directories which directly contain
+// more than maxFiles files. Bubbling these directories is expensive, which
+// makes them candidates for RebalanceDir. The paths are sorted by the number
+// of files in descending order. The counts are taken from the cached
+// directory metadata.
+func (r *Renter) ExpensiveDirs(maxFiles int) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	if maxFiles < 0 {
+		return nil, fmt.Errorf("maxFiles can't be negative but was %v", maxFiles)
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var dirs []modules.DirectoryInfo
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		if di.NumFiles <= uint64(maxFiles) {
+			return
+		}
+		mu.Lock()
+		dirs = append(dirs, di)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].NumFiles != dirs[j].NumFiles {
+			return dirs[i].NumFiles > dirs[j].NumFiles
+		}
+		return dirs[i].TurtleDexPath.String() < dirs[j].TurtleDexPath.String()
+	})
+	paths := make([]modules.TurtleDexPath, 0, len(dirs))
+	for _, di := range dirs {
+		paths = append(paths, di.TurtleDexPath)
+	}
+	return paths, nil
+}
+
+// FutureDatedDirs returns the sorted paths of all directories whose
+// LastHealthCheckTime or AggregateLastHealthCheckTime is in the future. This
+// happens if the system clock jumped backwards. These directories are
+// corrected by the next bubble of their parent.
+func (r *Renter) FutureDatedDirs() ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	now := time.Now()
+	var dirs []modules.TurtleDexPath
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		if !di.LastHealthCheckTime.After(now) && !di.AggregateLastHealthCheckTime.After(now) {
+			return
+		}
+		mu.Lock()
+		dirs = append(dirs, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].String() < dirs[j].String()
+	})
+	return dirs, nil
+}
+
+// Reconcile updates the metadata of the directory at sp after files or
+// directories were added to or removed from it on disk without going through
+// the renter. The directory's metadata is recalculated from its actual contents
+// and bubbled up to the root. The differences between the old and the new
+// metadata are logged. If the directory is being bubbled already, the
+// recalculation is queued after that bubble and the logged metadata might not
+// reflect it yet.
+func (r *Renter) Reconcile(sp modules.TurtleDexPath) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	before, err := r.managedDirectoryMetadata(sp)
+	if err != nil {
+		return errors.AddContext(err, "unable to read the directory metadata")
+	}
+	if err := r.managedBubbleMetadata(sp); err != nil {
+		return errors.AddContext(err, "unable to bubble the directory")
+	}
+	after, err := r.managedDirectoryMetadata(sp)
+	if err != nil {
+		return errors.AddContext(err, "unable to read the reconciled directory metadata")
+	}
+	if before.NumFiles == after.NumFiles && before.NumSubDirs == after.NumSubDirs && before.Size == after.Size {
+		r.log.Printf("Reconciled directory '%v': no changes", sp)
+		return nil
+	}
+	r.log.Printf("Reconciled directory '%v': files %v -> %v, subdirs %v -> %v, size %v -> %v", sp, before.NumFiles, after.NumFiles, before.NumSubDirs, after.NumSubDirs, before.Size, after.Size)
+	return nil
+}
+
+// StorageEfficiency returns the logical size of the files within siaPath and
+// its subdirectories, the physical size they take up on the network and the
+// ratio of physical to logical size. The physical size of a file is its size
+// multiplied by its redundancy. The values are based on the cached redundancy
+// of the files and are therefore only as recent as the last health check. The
+// ratio of a directory without data is 0.
+func (r *Renter) StorageEfficiency(siaPath modules.TurtleDexPath) (logical uint64, physical uint64, ratio float64, err error) {
+	if err := r.tg.Add(); err != nil {
+		return 0, 0, 0, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer release()
+
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		var fp uint64
+		if fi.Redundancy > 0 && !math.IsInf(fi.Redundancy, 0) {
+			fp = uint64(float64(fi.Filesize) * fi.Redundancy)
+		}
+		mu.Lock()
+		logical += fi.Filesize
+		physical += fp
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, func(modules.DirectoryInfo) {})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if logical > 0 {
+		ratio = float64(physical) / float64(logical)
+	}
+	return logical, physical, ratio, nil
+}
+
 // RenameDir takes an existing directory and changes the path. The original
 // directory must exist, and there must not be any directory that already has
 // the replacement path.  All sia files within directory will also be renamed
@@ -69,5 +456,282 @@ func (r *Renter) RenameDir(oldPath, newPath modules.TurtleDexPath) error {
 	if newPath.IsRoot() {
 		return errors.New("cannot rename a file to the root directory")
 	}
-	return r.staticFileSystem.RenameDir(oldPath, newPath)
+	// Make sure the new path doesn't exceed the maximum depth.
+	if err := newPath.ValidateDepth(); err != nil {
+		return err
+	}
+	files, err := r.managedAuditedFiles(oldPath)
+	if err != nil {
+		return err
+	}
+	if err := r.staticFileSystem.RenameDir(oldPath, newPath); err != nil {
+		return err
+	}
+	for _, sp := range files {
+		newFile, err := sp.Rebase(oldPath, newPath)
+		if err != nil {
+			r.log.Printf("WARN: failed to record rename of '%v' in the audit log: %v", sp, err)
+			continue
+		}
+		r.callRecordFileOp(fileOpModified, newFile, sp)
+	}
+	return nil
+}
+
+// RebalanceDir distributes the files directly within parent across numbered
+// sub directories so that no sub directory holds more than maxPerDir files.
+// Files are moved one at a time so an interrupted rebalance never loses a file
+// and calling RebalanceDir again will continue filling the existing numbered
+// directories before creating new ones.
+func (r *Renter) RebalanceDir(parent modules.TurtleDexPath, maxPerDir int) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if maxPerDir < 1 {
+		return errors.New("maxPerDir must be at least 1")
+	}
+
+	// Grab the files that need to be moved.
+	files, err := r.managedDirFiles(parent)
+	if err != nil {
+		return errors.AddContext(err, "unable to list files of parent directory")
+	}
+	if len(files) <= maxPerDir {
+		return nil
+	}
+
+	// Make sure the numbered directories and the files moved into them don't
+	// exceed the maximum depth. All of them have the same depth.
+	subDir, err := parent.Join(rebalanceDirPrefix + "0")
+	if err != nil {
+		return err
+	}
+	newPath, err := subDir.Join(files[0].Name())
+	if err != nil {
+		return err
+	}
+	if err := newPath.ValidateDepth(); err != nil {
+		return err
+	}
+
+	// Make sure the affected directories are bubbled once we are done, even if
+	// the rebalance fails half way through.
+	urp := r.newUniqueRefreshPaths()
+	defer urp.callRefreshAll()
+	if err := urp.callAdd(parent); err != nil {
+		return err
+	}
+
+	for i := 0; len(files) > 0; i++ {
+		subDir, err := parent.Join(fmt.Sprintf("%v%v", rebalanceDirPrefix, i))
+		if err != nil {
+			return err
+		}
+		// Check how many files the numbered directory already contains from a
+		// previous, possibly interrupted, rebalance.
+		exists, err := r.staticFileSystem.DirExists(subDir)
+		if err != nil {
+			return err
+		}
+		numFiles := 0
+		if exists {
+			subDirFiles, err := r.managedDirFiles(subDir)
+			if err != nil {
+				return errors.AddContext(err, "unable to list files of numbered directory")
+			}
+			numFiles = len(subDirFiles)
+		}
+		if numFiles >= maxPerDir {
+			continue
+		}
+		// Move files until the directory is full.
+		for ; numFiles < maxPerDir && len(files) > 0; numFiles++ {
+			newPath, err := subDir.Join(files[0].Name())
+			if err != nil {
+				return err
+			}
+			err = r.managedRenameFile(files[0], newPath)
+			if err != nil {
+				return errors.AddContext(err, fmt.Sprintf("unable to move '%v' to '%v'", files[0], newPath))
+			}
+			files = files[1:]
+		}
+		if err := urp.callAdd(subDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CoveringDirs returns the sorted minimal set of directories whose bubbles
+// cover the directories of the provided files. Like uniqueRefreshPaths, a
+// directory is left out if a descendant is in the set, since the bubble of the
+// descendant updates it as well. CoveringDirs doesn't access the filesystem, so
+// the files don't need to exist.
+func (r *Renter) CoveringDirs(files []modules.TurtleDexPath) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	urp := r.newUniqueRefreshPaths()
+	for _, sp := range files {
+		if err := sp.Validate(false); err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("invalid file path '%v'", sp))
+		}
+		dir, err := sp.Dir()
+		if err != nil {
+			return nil, err
+		}
+		if err := urp.callAdd(dir); err != nil {
+			return nil, err
+		}
+	}
+	dirs := urp.callChildDirs()
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].String() < dirs[j].String()
+	})
+	return dirs, nil
+}
+
+// RefreshMatching refreshes all directories whose path matches pattern and
+// returns their sorted paths. See modules.TurtleDexPath.Match for the pattern
+// syntax. If blocking is true, RefreshMatching only returns once the matching
+// directories were bubbled. A pattern like "*" or "*/*" which consists only of
+// wildcards is rejected since it matches every directory at its depths. Use
+// ForceRefreshMatching to refresh those.
+func (r *Renter) RefreshMatching(pattern string, blocking bool) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	return r.managedRefreshMatching(pattern, blocking, false)
+}
+
+// ForceRefreshMatching is like RefreshMatching but also accepts patterns
+// which match the entire tree.
+func (r *Renter) ForceRefreshMatching(pattern string, blocking bool) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	return r.managedRefreshMatching(pattern, blocking, true)
+}
+
+// managedRefreshMatching refreshes all directories matching pattern. If force
+// is false, patterns matching the entire tree are rejected.
+func (r *Renter) managedRefreshMatching(pattern string, blocking, force bool) ([]modules.TurtleDexPath, error) {
+	if _, err := modules.RootTurtleDexPath().Match(pattern); err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("invalid pattern '%v'", pattern))
+	}
+	if !force && isMatchAllPattern(pattern) {
+		return nil, errors.AddContext(errMatchAllPattern, pattern)
+	}
+	dirs, err := r.managedMatchingDirs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	urp := r.newUniqueRefreshPaths()
+	if err := urp.callAddMulti(dirs); err != nil {
+		return nil, err
+	}
+	if !blocking {
+		urp.callRefreshAll()
+		return dirs, nil
+	}
+	if err := urp.callRefreshAllBlocking(); err != nil {
+		return nil, errors.AddContext(err, "failed to refresh matching directories")
+	}
+	return dirs, nil
+}
+
+// managedMatchingDirs returns the sorted paths of all directories matching
+// pattern.
+func (r *Renter) managedMatchingDirs(pattern string) ([]modules.TurtleDexPath, error) {
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var dirs []modules.TurtleDexPath
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		// The pattern was validated before, so there is no error.
+		if match, _ := di.TurtleDexPath.Match(pattern); !match {
+			return
+		}
+		mu.Lock()
+		dirs = append(dirs, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directories")
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].String() < dirs[j].String()
+	})
+	return dirs, nil
+}
+
+// managedSubDirs returns the siapaths of siaPath and all directories within it,
+// sorted by their path.
+func (r *Renter) managedSubDirs(siaPath modules.TurtleDexPath) ([]modules.TurtleDexPath, error) {
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var dirs []modules.TurtleDexPath
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		dirs = append(dirs, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directories")
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].String() < dirs[j].String()
+	})
+	return dirs, nil
+}
+
+// isMatchAllPattern returns true if every element of pattern consists only of
+// '*', which means that the pattern matches every directory at its depth.
+func isMatchAllPattern(pattern string) bool {
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "" || strings.Trim(elem, "*") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// managedDirFiles returns the sorted siapaths of the files directly within the
+// provided directory.
+func (r *Renter) managedDirFiles(siaPath modules.TurtleDexPath) (files []modules.TurtleDexPath, _ error) {
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		files = append(files, fi.TurtleDexPath)
+		mu.Unlock()
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	err = r.staticFileSystem.CachedList(siaPath, false, flf, func(modules.DirectoryInfo) {})
+	release()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].String() < files[j].String()
+	})
+	return files, nil
 }
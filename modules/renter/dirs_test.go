@@ -89,6 +89,111 @@ func TestRenterCreateDirectories(t *testing.T) {
 	}
 }
 
+// TestRenterCreateDirMaxDepth checks that CreateDir respects the maximum
+// TurtleDexPath depth.
+func TestRenterCreateDirMaxDepth(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create a directory before the limit is set.
+	deep := modules.TurtleDexPath{Path: "deep/b/c/d"}
+	err = rt.renter.CreateDir(deep, modules.DefaultDirPerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Set a limit of 3 and reset it at the end of the test.
+	modules.SetMaxTurtleDexPathDepth(3)
+	defer modules.SetMaxTurtleDexPathDepth(0)
+
+	// The existing directory past the limit is still accessible.
+	if _, err := rt.renter.DirList(deep); err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating a directory at the limit should work.
+	err = rt.renter.CreateDir(modules.TurtleDexPath{Path: "a/b/c"}, modules.DefaultDirPerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Creating a directory just past the limit should fail.
+	err = rt.renter.CreateDir(modules.TurtleDexPath{Path: "a/b/c/d"}, modules.DefaultDirPerm)
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	// Renaming a directory past the limit should fail too.
+	err = rt.renter.RenameDir(modules.TurtleDexPath{Path: "a/b/c"}, modules.TurtleDexPath{Path: "x/y/z/w"})
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	// So should a batch which creates a directory past the limit.
+	err = rt.renter.ApplyBatch([]FileOp{{Type: FileOpCreateDir, TurtleDexPath: modules.TurtleDexPath{Path: "a/b/c/e"}}})
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	if exists, err := rt.renter.staticFileSystem.DirExists(modules.TurtleDexPath{Path: "a/b/c/e"}); err != nil || exists {
+		t.Fatal("the batch shouldn't have created the directory", exists, err)
+	}
+	// Moving a file past the limit should fail as well.
+	file := modules.TurtleDexPath{Path: "a/file"}
+	sf, err := rt.renter.createRenterTestFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = rt.renter.MoveFileWithTags(file, modules.TurtleDexPath{Path: "a/b/c/file"}, nil)
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	// Publishing a file past the limit should fail before anything is
+	// staged.
+	err = rt.renter.PublishDir(modules.TurtleDexPath{Path: "a/pub"}, map[modules.TurtleDexPath]SourceRef{
+		{Path: "b/file"}: {TurtleDexPath: file},
+	})
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	if exists, err := rt.renter.staticFileSystem.FileExists(file); err != nil || !exists {
+		t.Fatal("the file shouldn't have been moved", exists, err)
+	}
+
+	// Rebalancing a directory whose numbered directories would hold files
+	// past the limit should fail without moving anything.
+	var rebalanced []modules.TurtleDexPath
+	for _, name := range []string{"a/b/f1", "a/b/f2"} {
+		sp := modules.TurtleDexPath{Path: name}
+		sf, err := rt.renter.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sf.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rebalanced = append(rebalanced, sp)
+	}
+	err = rt.renter.RebalanceDir(modules.TurtleDexPath{Path: "a/b"}, 1)
+	if !errors.Contains(err, modules.ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep but got", err)
+	}
+	for _, sp := range rebalanced {
+		if exists, err := rt.renter.staticFileSystem.FileExists(sp); err != nil || !exists {
+			t.Fatal("the file shouldn't have been moved", sp, exists, err)
+		}
+	}
+}
+
 // checkDirInitialized is a helper function that checks that the directory was
 // initialized correctly and the metadata file exist and contain the correct
 // information
@@ -203,6 +308,458 @@ func TestDirInfo(t *testing.T) {
 	}
 }
 
+// TestDirInfoCached probes DirInfoCached and SetDirStalenessThreshold.
+func TestDirInfoCached(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	sp := newTurtleDexPath("dir")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetDirStalenessThreshold(0); err == nil {
+		t.Fatal("expected zero threshold to be rejected")
+	}
+
+	// With a large threshold the dir is fresh.
+	if err := r.SetDirStalenessThreshold(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	di, stale, err := r.DirInfoCached(sp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale {
+		t.Fatal("dir shouldn't be stale")
+	}
+	if !di.TurtleDexPath.Equals(sp) {
+		t.Fatal("wrong path", di.TurtleDexPath)
+	}
+
+	// With a tiny threshold the dir is stale. Requesting a refresh bubbles
+	// the dir which updates its LastHealthCheckTime.
+	if err := r.SetDirStalenessThreshold(time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	lhct := di.LastHealthCheckTime
+	time.Sleep(10 * time.Millisecond)
+	_, stale, err = r.DirInfoCached(sp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stale {
+		t.Fatal("dir should be stale")
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		di, _, err := r.DirInfoCached(sp, false)
+		if err != nil {
+			return err
+		}
+		if !di.LastHealthCheckTime.After(lhct) {
+			return errors.New("dir wasn't bubbled")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing dirs return an error.
+	if _, _, err := r.DirInfoCached(newTurtleDexPath("missing"), false); err == nil {
+		t.Fatal("expected error for missing dir")
+	}
+}
+
+// TestEmptyDirs probes EmptyDirs.
+func TestEmptyDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create the dirs a/b, a/c, d and e and add a file to a/c. Then pin e.
+	for _, dir := range []string{"a/b", "a/c", "d", "e"} {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := r.createRenterTestFile(newTurtleDexPath("a/c/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pinned := true
+	if _, err := r.SetFlagsByPattern("e", &pinned, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bubble the tree to update the cached counts.
+	urp := r.newUniqueRefreshPaths()
+	for _, dir := range []string{"a/b", "a/c", "d", "e"} {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := urp.callRefreshAllBlocking(); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		di, err := r.staticFileSystem.DirInfo(newTurtleDexPath("a"))
+		if err != nil {
+			return err
+		}
+		if di.AggregateNumFiles != 1 {
+			return errors.New("a wasn't bubbled yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	empty, err := r.EmptyDirs(modules.RootTurtleDexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []modules.TurtleDexPath{newTurtleDexPath("a/b"), newTurtleDexPath("d")}
+	if fmt.Sprint(empty) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v but got %v", expected, empty)
+	}
+	empty, err = r.EmptyDirs(newTurtleDexPath("a/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != 0 {
+		t.Fatal("expected no empty dirs", empty)
+	}
+}
+
+// TestExpensiveDirs probes ExpensiveDirs.
+func TestExpensiveDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create 3 dirs with a different number of files and bubble them.
+	numFiles := map[string]int{"a": 3, "b": 1, "c/d": 4}
+	for dir, n := range numFiles {
+		for i := 0; i < n; i++ {
+			f, err := r.createRenterTestFile(newTurtleDexPath(fmt.Sprintf("%v/file%v", dir, i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.managedBubbleMetadata(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs, err := r.ExpensiveDirs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []modules.TurtleDexPath{newTurtleDexPath("c/d"), newTurtleDexPath("a")}
+	if fmt.Sprint(dirs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v but got %v", expected, dirs)
+	}
+	if _, err := r.ExpensiveDirs(-1); err == nil {
+		t.Fatal("expected error for negative maxFiles")
+	}
+}
+
+// TestFutureDatedDirs probes FutureDatedDirs and the correction of future
+// LastHealthCheckTimes during a bubble.
+func TestFutureDatedDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create a dir and set its LastHealthCheckTime to the future.
+	sp := newTurtleDexPath("a/b")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := r.staticFileSystem.OpenTurtleDexDir(sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	err = errors.Compose(entry.UpdateLastHealthCheckTime(future, future), entry.Close())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := r.FutureDatedDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || !dirs[0].Equals(sp) {
+		t.Fatal("expected a/b to be future dated", dirs)
+	}
+
+	// Bubbling the parent should correct the dir.
+	if err := r.managedBubbleMetadata(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		dirs, err := r.FutureDatedDirs()
+		if err != nil {
+			return err
+		}
+		if len(dirs) != 0 {
+			return fmt.Errorf("dirs are still future dated: %v", dirs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLastActivity probes LastActivity.
+func TestLastActivity(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create a file in a subdir and an empty dir next to it.
+	fileSP := newTurtleDexPath("act/sub/file")
+	f, err := r.createRenterTestFile(fileSP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	emptySP := newTurtleDexPath("act/empty")
+	if err := r.CreateDir(emptySP, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := r.File(fileSP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bubble both dirs. The last activity of the parent should be the mod
+	// time of the file, not the time of the bubble of the empty dir.
+	if err := r.managedBubbleMetadata(emptySP); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedBubbleMetadata(newTurtleDexPath("act/sub")); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		la, err := r.LastActivity(newTurtleDexPath("act"))
+		if err != nil {
+			return err
+		}
+		if !la.Equal(fi.ModificationTime) {
+			return fmt.Errorf("last activity should be %v but was %v", fi.ModificationTime, la)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The empty dir has no activity.
+	la, err := r.LastActivity(emptySP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !la.IsZero() {
+		t.Fatal("empty dir shouldn't have any activity", la)
+	}
+
+	// Missing dirs return an error.
+	if _, err := r.LastActivity(newTurtleDexPath("missing")); err == nil {
+		t.Fatal("expected error for missing dir")
+	}
+}
+
+// TestReconcile probes Reconcile.
+func TestReconcile(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create a dir with 2 files and bubble it.
+	dir := newTurtleDexPath("dir")
+	for _, name := range []string{"dir/a", "dir/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.managedBubbleMetadata(dir); err != nil {
+		t.Fatal(err)
+	}
+	md, err := r.managedDirectoryMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.NumFiles != 2 {
+		t.Fatal("expected 2 files", md.NumFiles)
+	}
+
+	// Delete a file on disk without going through the renter. The metadata
+	// should still report 2 files.
+	if err := os.Remove(r.staticFileSystem.FilePath(newTurtleDexPath("dir/a"))); err != nil {
+		t.Fatal(err)
+	}
+	md, err = r.managedDirectoryMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.NumFiles != 2 {
+		t.Fatal("expected 2 files", md.NumFiles)
+	}
+
+	// Reconcile the dir.
+	if err := r.Reconcile(dir); err != nil {
+		t.Fatal(err)
+	}
+	md, err = r.managedDirectoryMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.NumFiles != 1 {
+		t.Fatal("expected 1 file", md.NumFiles)
+	}
+}
+
+// TestStorageEfficiency probes StorageEfficiency.
+func TestStorageEfficiency(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// An empty dir has no data and a ratio of 0.
+	sp := newTurtleDexPath("dir")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	logical, physical, ratio, err := r.StorageEfficiency(sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logical != 0 || physical != 0 || ratio != 0 {
+		t.Fatal("expected no data", logical, physical, ratio)
+	}
+
+	// Add files to the dir and a subdir. Since there are no hosts, nothing
+	// is uploaded yet.
+	var size uint64
+	for _, name := range []string{"dir/a", "dir/sub/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += f.Size()
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logical, physical, ratio, err = r.StorageEfficiency(sp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logical != size || physical != 0 || ratio != 0 {
+		t.Fatalf("expected logical size %v without physical data but got %v, %v, %v", size, logical, physical, ratio)
+	}
+}
+
 // TestRenterListDirectory verifies that the renter properly lists the contents
 // of a directory
 func TestRenterListDirectory(t *testing.T) {
@@ -439,3 +996,262 @@ func compareDirectoryInfoAndMetadataCustom(di modules.DirectoryInfo, siaDir *fil
 	}
 	return nil
 }
+
+// TestRenterRebalanceDir probes RebalanceDir.
+func TestRenterRebalanceDir(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create a parent with 7 files.
+	parent := newTurtleDexPath("parent")
+	numFiles := 7
+	for i := 0; i < numFiles; i++ {
+		sp, err := parent.Join(fmt.Sprintf("file%v", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := rt.renter.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Invalid maxPerDir should be rejected.
+	if err := rt.renter.RebalanceDir(parent, 0); err == nil {
+		t.Fatal("expected error for maxPerDir of 0")
+	}
+
+	// Simulate an interrupted rebalance by moving a file into the first
+	// numbered directory manually.
+	subDir0, err := parent.Join(rebalanceDirPrefix + "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rt.renter.staticFileSystem.RenameFile(newTurtleDexPath("parent/file0"), newTurtleDexPath(subDir0.String()+"/file0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebalance with 3 files per dir.
+	maxPerDir := 3
+	if err := rt.renter.RebalanceDir(parent, maxPerDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The parent shouldn't contain any files anymore and the numbered dirs
+	// should be filled up in order.
+	files, err := rt.renter.managedDirFiles(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected 0 files in parent but got %v", len(files))
+	}
+	expected := []int{3, 3, 1}
+	total := 0
+	for i, n := range expected {
+		subDir, err := parent.Join(fmt.Sprintf("%v%v", rebalanceDirPrefix, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := rt.renter.managedDirFiles(subDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != n {
+			t.Fatalf("expected %v files in %v but got %v", n, subDir, len(files))
+		}
+		total += len(files)
+	}
+	if total != numFiles {
+		t.Fatalf("expected %v files in total but got %v", numFiles, total)
+	}
+}
+
+// TestRenterListChildren probes ListChildren.
+func TestRenterListChildren(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create a dir with 2 files and 2 sub dirs.
+	for _, sp := range []string{"parent/b", "parent/a"} {
+		f, err := rt.renter.createRenterTestFile(newTurtleDexPath(sp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sp := range []string{"parent/d", "parent/c"} {
+		err = rt.renter.CreateDir(newTurtleDexPath(sp), modules.DefaultDirPerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Directories should come first.
+	children, err := rt.renter.ListChildren(newTurtleDexPath("parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		path string
+		ct   ChildType
+	}{
+		{"parent/c", ChildTypeDir},
+		{"parent/d", ChildTypeDir},
+		{"parent/a", ChildTypeFile},
+		{"parent/b", ChildTypeFile},
+	}
+	if len(children) != len(expected) {
+		t.Fatalf("expected %v children but got %v", len(expected), len(children))
+	}
+	for i, child := range children {
+		if child.TurtleDexPath.String() != expected[i].path || child.Type != expected[i].ct {
+			t.Fatalf("child %v: expected %v (%v) but got %v (%v)", i, expected[i].path, expected[i].ct, child.TurtleDexPath, child.Type)
+		}
+	}
+
+	// Listing a file should fail.
+	_, err = rt.renter.ListChildren(newTurtleDexPath("parent/a"))
+	if !errors.Contains(err, errNotADirectory) {
+		t.Fatal("expected errNotADirectory but got", err)
+	}
+}
+
+// TestCoveringDirs probes CoveringDirs.
+func TestCoveringDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	var files []modules.TurtleDexPath
+	for _, name := range []string{"a/f1", "a/f2", "a/b/f3", "c/f4", "f5"} {
+		files = append(files, newTurtleDexPath(name))
+	}
+	dirs, err := r.CoveringDirs(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dirs) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("a/b"), newTurtleDexPath("c")}) {
+		t.Fatal("wrong covering dirs", dirs)
+	}
+
+	// Invalid paths should be rejected.
+	if _, err := r.CoveringDirs([]modules.TurtleDexPath{modules.RootTurtleDexPath()}); err == nil {
+		t.Fatal("expected error for root")
+	}
+	if _, err := r.CoveringDirs([]modules.TurtleDexPath{{Path: "a/../b"}}); err == nil {
+		t.Fatal("expected error for invalid path")
+	}
+}
+
+// TestRefreshMatching probes RefreshMatching and ForceRefreshMatching.
+func TestRefreshMatching(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	for _, dir := range []string{"logs/a", "logs/b/c", "data/a"} {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A blocking refresh should bubble the matching dirs before returning.
+	_, numBubbles, _, _ := r.staticRefreshMetrics.callCounters()
+	dirs, err := r.RefreshMatching("logs/*", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dirs) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("logs/a"), newTurtleDexPath("logs/b")}) {
+		t.Fatal("wrong matching dirs", dirs)
+	}
+	if _, n, _, _ := r.staticRefreshMetrics.callCounters(); n < numBubbles+2 {
+		t.Fatalf("expected at least %v bubbles but got %v", numBubbles+2, n)
+	}
+
+	// A non-blocking refresh returns the same dirs.
+	dirs, err = r.RefreshMatching("*/a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(dirs) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("data/a"), newTurtleDexPath("logs/a")}) {
+		t.Fatal("wrong matching dirs", dirs)
+	}
+
+	// Patterns matching the entire tree require force.
+	for _, pattern := range []string{"*", "*/*", "**/*"} {
+		if _, err := r.RefreshMatching(pattern, false); !errors.Contains(err, errMatchAllPattern) {
+			t.Fatalf("expected %v for pattern %v but got %v", errMatchAllPattern, pattern, err)
+		}
+	}
+	dirs, err = r.ForceRefreshMatching("*", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, dir := range dirs {
+		found = found || dir.Equals(newTurtleDexPath("logs"))
+	}
+	if !found {
+		t.Fatal("logs should match", dirs)
+	}
+
+	// Invalid patterns are rejected.
+	if _, err := r.ForceRefreshMatching("[a-", false); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+}
@@ -0,0 +1,155 @@
+package renter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// eventqueue.go contains the delivery queue for refresh events. Registered
+// handlers, such as an integration that forwards the events to a webhook,
+// receive every event recorded in the refresh event log. Events are queued
+// without blocking the bubble that recorded them and delivered by a single
+// background thread which waits at least the delivery interval between two
+// events. If the queue is full, new events are dropped and counted.
+
+const (
+	// eventQueueMaxSize is the maximum number of undelivered events.
+	eventQueueMaxSize = 1000
+
+	// defaultEventDeliveryInterval is the default minimum time between the
+	// delivery of two events.
+	defaultEventDeliveryInterval = 10 * time.Millisecond
+)
+
+type (
+	// RefreshEventHandler handles a refresh event delivered by the renter.
+	RefreshEventHandler func(RefreshDiagEvent)
+
+	// eventQueue is a bounded queue of refresh events that are delivered to
+	// the registered handlers at a limited rate.
+	eventQueue struct {
+		dropped  uint64
+		events   []RefreshDiagEvent
+		handlers []RefreshEventHandler
+		interval time.Duration
+		maxSize  int
+
+		// wakeChan is signaled whenever an event is queued.
+		wakeChan chan struct{}
+		mu       sync.Mutex
+	}
+)
+
+// newEventQueue creates a new eventQueue.
+func newEventQueue(maxSize int, interval time.Duration) *eventQueue {
+	return &eventQueue{
+		interval: interval,
+		maxSize:  maxSize,
+		wakeChan: make(chan struct{}, 1),
+	}
+}
+
+// callAddHandler registers a handler that receives all future events.
+func (eq *eventQueue) callAddHandler(h RefreshEventHandler) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	eq.handlers = append(eq.handlers, h)
+}
+
+// callEnqueue queues an event for delivery. Events are ignored if no handler
+// is registered and dropped if the queue is full. It never blocks.
+func (eq *eventQueue) callEnqueue(e RefreshDiagEvent) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	if len(eq.handlers) == 0 {
+		return
+	}
+	if len(eq.events) >= eq.maxSize {
+		eq.dropped++
+		return
+	}
+	eq.events = append(eq.events, e)
+	select {
+	case eq.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// callPop removes the oldest event from the queue and returns it together
+// with the handlers it needs to be delivered to and the delivery interval.
+func (eq *eventQueue) callPop() (e RefreshDiagEvent, handlers []RefreshEventHandler, interval time.Duration, ok bool) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	if len(eq.events) == 0 {
+		return RefreshDiagEvent{}, nil, 0, false
+	}
+	e = eq.events[0]
+	eq.events = eq.events[1:]
+	handlers = append([]RefreshEventHandler(nil), eq.handlers...)
+	return e, handlers, eq.interval, true
+}
+
+// callSetInterval sets the minimum time between the delivery of two events.
+func (eq *eventQueue) callSetInterval(interval time.Duration) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	eq.interval = interval
+}
+
+// callStatus returns the number of queued events and the number of dropped
+// events.
+func (eq *eventQueue) callStatus() (depth int, dropped uint64) {
+	eq.mu.Lock()
+	defer eq.mu.Unlock()
+	return len(eq.events), eq.dropped
+}
+
+// threadedDeliver delivers the queued events until stopChan is closed.
+func (eq *eventQueue) threadedDeliver(stopChan <-chan struct{}) {
+	for {
+		e, handlers, interval, ok := eq.callPop()
+		if !ok {
+			select {
+			case <-stopChan:
+				return
+			case <-eq.wakeChan:
+			}
+			continue
+		}
+		for _, h := range handlers {
+			h(e)
+		}
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RegisterRefreshEventHandler registers a handler that receives every refresh
+// event recorded from now on. Handlers are called one after another from a
+// single thread and should return quickly.
+func (r *Renter) RegisterRefreshEventHandler(h RefreshEventHandler) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	r.staticEventQueue.callAddHandler(h)
+	return nil
+}
+
+// SetEventDeliveryInterval sets the minimum time between the delivery of two
+// refresh events to the registered handlers.
+func (r *Renter) SetEventDeliveryInterval(interval time.Duration) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if interval < 0 {
+		return fmt.Errorf("event delivery interval can't be negative but was %v", interval)
+	}
+	r.staticEventQueue.callSetInterval(interval)
+	return nil
+}
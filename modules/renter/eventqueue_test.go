@@ -0,0 +1,75 @@
+package renter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/errors"
+)
+
+// TestEventQueue probes the eventQueue.
+func TestEventQueue(t *testing.T) {
+	t.Parallel()
+
+	eq := newEventQueue(2, 0)
+
+	// Without handlers events are ignored.
+	sp := newTurtleDexPath("dir")
+	eq.callEnqueue(RefreshDiagEvent{Type: string(refreshEventQueued), TurtleDexPath: sp})
+	if depth, dropped := eq.callStatus(); depth != 0 || dropped != 0 {
+		t.Fatal("expected event to be ignored", depth, dropped)
+	}
+
+	// Register a handler and fill the queue. The third event is dropped.
+	var mu sync.Mutex
+	var delivered []RefreshDiagEvent
+	eq.callAddHandler(func(e RefreshDiagEvent) {
+		mu.Lock()
+		delivered = append(delivered, e)
+		mu.Unlock()
+	})
+	for _, typ := range []refreshEventType{refreshEventQueued, refreshEventBubbled, refreshEventQueued} {
+		eq.callEnqueue(RefreshDiagEvent{Type: string(typ), TurtleDexPath: sp})
+	}
+	if depth, dropped := eq.callStatus(); depth != 2 || dropped != 1 {
+		t.Fatal("expected 2 queued and 1 dropped event", depth, dropped)
+	}
+
+	// Deliver the events.
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go eq.threadedDeliver(stopChan)
+	err := build.Retry(100, 10*time.Millisecond, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(delivered) != 2 {
+			return errors.New("events not delivered yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered[0].Type != string(refreshEventQueued) || delivered[1].Type != string(refreshEventBubbled) {
+		t.Fatal("events delivered out of order", delivered)
+	}
+	if depth, _ := eq.callStatus(); depth != 0 {
+		t.Fatal("expected empty queue", depth)
+	}
+
+	// Events queued later are delivered as well.
+	eq.callEnqueue(RefreshDiagEvent{Type: string(refreshEventQueued), TurtleDexPath: sp})
+	err = build.Retry(100, 10*time.Millisecond, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(delivered) != 3 {
+			return errors.New("event not delivered yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
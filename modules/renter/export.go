@@ -0,0 +1,61 @@
+package renter
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/turtledex/errors"
+)
+
+// export.go contains helpers shared by methods which export renter data as a
+// stream, like RefreshMetricsProm, ExportRefreshDiagnostics and
+// ExportRefreshLog. All of them write uncompressed output by default and gzip
+// it on the fly if requested.
+
+type (
+	// exportWriter wraps the io.Writer an export is written to. If compression
+	// is enabled, the data is gzipped on the fly. Close must be called once the
+	// export is done to flush any buffered data.
+	exportWriter struct {
+		gz  *gzip.Writer
+		w   io.Writer
+		err error
+	}
+)
+
+// newExportWriter creates a new exportWriter for w. If compress is true, the
+// written data is gzipped.
+func newExportWriter(w io.Writer, compress bool) *exportWriter {
+	ew := &exportWriter{w: w}
+	if compress {
+		ew.gz = gzip.NewWriter(w)
+		ew.w = ew.gz
+	}
+	return ew
+}
+
+// Write implements io.Writer. Once a write fails, all subsequent writes will
+// return the same error.
+func (ew *exportWriter) Write(b []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(b)
+	if err != nil {
+		ew.err = errors.AddContext(err, "failed to write export")
+	}
+	return n, ew.err
+}
+
+// Close flushes any buffered data and writes the gzip footer if compression
+// is enabled. It doesn't close the underlying writer. Close returns the first
+// error encountered while writing the export.
+func (ew *exportWriter) Close() error {
+	if ew.gz == nil || ew.err != nil {
+		return ew.err
+	}
+	if err := ew.gz.Close(); err != nil {
+		ew.err = errors.AddContext(err, "failed to flush compressed export")
+	}
+	return ew.err
+}
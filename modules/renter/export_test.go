@@ -0,0 +1,156 @@
+package renter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// failingWriter is a writer which always fails.
+type failingWriter struct{}
+
+// Write implements io.Writer.
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// TestExportWriter probes the exportWriter.
+func TestExportWriter(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte("siapath,size\n"), 100)
+
+	// Uncompressed.
+	var buf bytes.Buffer
+	ew := newExportWriter(&buf, false)
+	if _, err := ew.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("uncompressed data doesn't match")
+	}
+
+	// Compressed.
+	buf.Reset()
+	ew = newExportWriter(&buf, true)
+	if _, err := ew.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(uncompressed, data) {
+		t.Fatal("decompressed data doesn't match")
+	}
+
+	// Write errors should be reported by Write and Close.
+	ew = newExportWriter(failingWriter{}, false)
+	if _, err := ew.Write(data); err == nil {
+		t.Fatal("expected write to fail")
+	}
+	if err := ew.Close(); err == nil {
+		t.Fatal("expected close to fail")
+	}
+	ew = newExportWriter(failingWriter{}, true)
+	_, err = ew.Write(data)
+	if err == nil {
+		err = ew.Close()
+	}
+	if err == nil {
+		t.Fatal("expected compressed export to fail")
+	}
+}
+
+// gunzip is a helper to decompress the gzipped data in b.
+func gunzip(t *testing.T, b *bytes.Buffer) []byte {
+	gz, err := gzip.NewReader(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestRefreshExports probes the compressed output of the refresh exports.
+func TestRefreshExports(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	if err := r.SetPendingRefreshPersistence(true); err != nil {
+		t.Fatal(err)
+	}
+	sp := newTurtleDexPath("a")
+	r.callRecordPendingRefresh(sp)
+
+	// The refresh log contains the queued event.
+	var b bytes.Buffer
+	if err := r.ExportRefreshLog(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	dec := json.NewDecoder(bytes.NewReader(gunzip(t, &b)))
+	for dec.More() {
+		var e refreshEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		found = found || (e.Type == refreshEventQueued && e.TurtleDexPath.Equals(sp))
+	}
+	if !found {
+		t.Fatal("queued event missing from the exported log")
+	}
+
+	// The diagnostics contain the pending refresh.
+	b.Reset()
+	if err := r.ExportRefreshDiagnostics(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	var diag RefreshDiag
+	if err := json.Unmarshal(gunzip(t, &b), &diag); err != nil {
+		t.Fatal(err)
+	}
+	if diag.NumPendingRefreshes == 0 {
+		t.Fatal("expected a pending refresh in the diagnostics")
+	}
+
+	// The metrics are the same as without compression.
+	b.Reset()
+	if err := r.RefreshMetricsProm(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	if out := string(gunzip(t, &b)); !strings.Contains(out, "# TYPE turtledex_renter_refresh_pending gauge") {
+		t.Fatal("unexpected metrics", out)
+	}
+}
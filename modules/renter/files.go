@@ -1,7 +1,13 @@
 package renter
 
 import (
+	"fmt"
+	"sort"
+	"sync"
+
 	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
 
 	"github.com/turtledex/errors"
 )
@@ -16,7 +22,7 @@ func (r *Renter) DeleteFile(siaPath modules.TurtleDexPath) error {
 	defer r.tg.Done()
 
 	// Perform the delete operation.
-	err = r.staticFileSystem.DeleteFile(siaPath)
+	err = r.managedDeleteFile(siaPath)
 	if err != nil {
 		return errors.AddContext(err, "unable to delete siafile from filesystem")
 	}
@@ -37,6 +43,18 @@ func (r *Renter) DeleteFile(siaPath modules.TurtleDexPath) error {
 	return nil
 }
 
+// managedDeleteFile deletes a file and records the deletion in the audit log.
+// Every deletion of a single file goes through this method, so it is the place
+// for hooks which need to see them all. Unlike DeleteFile, it doesn't bubble
+// the parent directory.
+func (r *Renter) managedDeleteFile(siaPath modules.TurtleDexPath) error {
+	if err := r.staticFileSystem.DeleteFile(siaPath); err != nil {
+		return err
+	}
+	r.callRecordFileOp(fileOpDeleted, siaPath, modules.TurtleDexPath{})
+	return nil
+}
+
 // FileList loops over all the files within the directory specified by siaPath
 // and will then call the provided listing function on the file.
 func (r *Renter) FileList(siaPath modules.TurtleDexPath, recursive, cached bool, flf modules.FileListFunc) error {
@@ -44,7 +62,11 @@ func (r *Renter) FileList(siaPath modules.TurtleDexPath, recursive, cached bool,
 		return err
 	}
 	defer r.tg.Done()
-	var err error
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return err
+	}
+	defer release()
 	if cached {
 		err = r.staticFileSystem.CachedList(siaPath, recursive, flf, func(modules.DirectoryInfo) {})
 	} else {
@@ -82,6 +104,63 @@ func (r *Renter) FileCached(siaPath modules.TurtleDexPath) (modules.FileInfo, er
 	return r.staticFileSystem.CachedFileInfo(siaPath)
 }
 
+// FilesBySize returns the paths of all files within prefix and its
+// subdirectories whose size is exactly size. The paths are sorted. Files of the
+// same size don't necessarily have the same content, so the result is only a
+// cheap list of candidates for a more thorough comparison.
+func (r *Renter) FilesBySize(prefix modules.TurtleDexPath, size uint64) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var matches []modules.TurtleDexPath
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		if fi.Filesize != size {
+			return
+		}
+		mu.Lock()
+		matches = append(matches, fi.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(prefix, true, flf, func(modules.DirectoryInfo) {})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].String() < matches[j].String()
+	})
+	return matches, nil
+}
+
+// MissingFrom returns the paths of expected which don't exist as files in the
+// renter. Every path is checked on its own instead of walking the filesystem,
+// which makes this cheap for short lists. The missing paths are returned in the
+// order of expected, including duplicates.
+func (r *Renter) MissingFrom(expected []modules.TurtleDexPath) ([]modules.TurtleDexPath, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	var missing []modules.TurtleDexPath
+	for _, sp := range expected {
+		exists, err := r.staticFileSystem.FileExists(sp)
+		if err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("failed to check if '%v' exists", sp))
+		}
+		if !exists {
+			missing = append(missing, sp)
+		}
+	}
+	return missing, nil
+}
+
 // RenameFile takes an existing file and changes the nickname. The original
 // file must exist, and there must not be any file that already has the
 // replacement nickname.
@@ -91,8 +170,16 @@ func (r *Renter) RenameFile(currentName, newName modules.TurtleDexPath) error {
 	}
 	defer r.tg.Done()
 
+	// Make sure the new path doesn't exceed the maximum depth or any quota.
+	if err := newName.ValidateDepth(); err != nil {
+		return err
+	}
+	if err := r.managedCheckQuotaMove(currentName, newName); err != nil {
+		return err
+	}
+
 	// Rename file.
-	err := r.staticFileSystem.RenameFile(currentName, newName)
+	err := r.managedRenameFile(currentName, newName)
 	if err != nil {
 		return err
 	}
@@ -120,6 +207,71 @@ func (r *Renter) RenameFile(currentName, newName modules.TurtleDexPath) error {
 	return nil
 }
 
+// managedRenameFile renames a file and records the rename in the audit log.
+// Like managedDeleteFile, it is used for every rename of a single file and
+// doesn't bubble the affected directories.
+func (r *Renter) managedRenameFile(currentName, newName modules.TurtleDexPath) error {
+	if err := r.staticFileSystem.RenameFile(currentName, newName); err != nil {
+		return err
+	}
+	r.callRecordFileOp(fileOpModified, newName, currentName)
+	return nil
+}
+
+// MoveFileWithTags renames src to dst and replaces the tags of the file in a
+// single step. Passing an empty map removes all of the file's tags. The tags
+// are validated and dst is checked against the maximum depth and existing
+// files before anything is changed. Both parent directories are bubbled
+// afterwards, or one if they are the same.
+func (r *Renter) MoveFileWithTags(src, dst modules.TurtleDexPath, tags map[string]string) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if err := siafile.ValidateTags(tags); err != nil {
+		return errors.AddContext(err, "invalid tags")
+	}
+	if err := dst.ValidateDepth(); err != nil {
+		return err
+	}
+	exists, err := r.staticFileSystem.FileExists(dst)
+	if err != nil {
+		return errors.AddContext(err, "failed to check destination")
+	}
+	if exists {
+		return filesystem.ErrExists
+	}
+	if err := r.managedCheckQuotaMove(src, dst); err != nil {
+		return err
+	}
+
+	// Rename the file and update the tags.
+	err = r.staticFileSystem.RenameFileWithTags(src, dst, tags)
+	if err != nil {
+		return err
+	}
+	r.callRecordFileOp(fileOpModified, dst, src)
+
+	// Bubble the old and new directories.
+	srcDir, err := src.Dir()
+	if err != nil {
+		return err
+	}
+	dstDir, err := dst.Dir()
+	if err != nil {
+		return err
+	}
+	urp := r.newUniqueRefreshPaths()
+	if err := urp.callAdd(srcDir); err != nil {
+		r.log.Printf("failed to add old directory '%v' to bubble paths: %v", srcDir, err)
+	}
+	if err := urp.callAdd(dstDir); err != nil {
+		r.log.Printf("failed to add new directory '%v' to bubble paths: %v", dstDir, err)
+	}
+	urp.callRefreshAll()
+	return nil
+}
+
 // SetFileStuck sets the Stuck field of the whole siafile to stuck.
 func (r *Renter) SetFileStuck(siaPath modules.TurtleDexPath, stuck bool) (err error) {
 	if err := r.tg.Add(); err != nil {
@@ -15,7 +15,9 @@ import (
 	"github.com/turtledex/TurtleDexCore/crypto"
 	"github.com/turtledex/TurtleDexCore/modules"
 	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
 	"github.com/turtledex/TurtleDexCore/persist"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
 )
 
 // newTurtleDexPath returns a new TurtleDexPath for testing and panics on error
@@ -328,6 +330,116 @@ func TestRenterFileList(t *testing.T) {
 	}
 }
 
+// TestRenterFilesBySize probes FilesBySize.
+func TestRenterFilesBySize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create files with different sizes.
+	_, rsc := testingFileParams()
+	files := map[string]uint64{
+		"a":       1000,
+		"dir/b":   1000,
+		"dir/c":   2000,
+		"other/d": 1000,
+	}
+	for path, size := range files {
+		sp := newTurtleDexPath(path)
+		dir, _ := filepath.Split(r.staticFileSystem.FilePath(sp))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		err := r.staticFileSystem.NewTurtleDexFile(sp, "", rsc, crypto.GenerateTurtleDexKey(crypto.RandomCipherType()), size, persist.DefaultDiskPermissionsTest, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Check the matches for different prefixes and sizes.
+	tests := []struct {
+		prefix modules.TurtleDexPath
+		size   uint64
+		want   []string
+	}{
+		{modules.RootTurtleDexPath(), 1000, []string{"a", "dir/b", "other/d"}},
+		{modules.RootTurtleDexPath(), 2000, []string{"dir/c"}},
+		{modules.RootTurtleDexPath(), 3000, nil},
+		{newTurtleDexPath("dir"), 1000, []string{"dir/b"}},
+	}
+	for _, test := range tests {
+		matches, err := r.FilesBySize(test.prefix, test.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, sp := range matches {
+			got = append(got, sp.String())
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%v, %v: expected %v but got %v", test.prefix, test.size, test.want, got)
+		}
+	}
+}
+
+// TestRenterMissingFrom probes MissingFrom.
+func TestRenterMissingFrom(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	for _, name := range []string{"a", "dir/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Directories don't count as files.
+	var expected []modules.TurtleDexPath
+	for _, name := range []string{"x", "a", "dir", "dir/b", "dir/y"} {
+		expected = append(expected, newTurtleDexPath(name))
+	}
+	missing, err := r.MissingFrom(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sp := range missing {
+		got = append(got, sp.String())
+	}
+	if strings.Join(got, ",") != "x,dir,dir/y" {
+		t.Fatal("wrong missing files", got)
+	}
+}
+
 // TestRenterRenameFile probes the rename method of the renter.
 func TestRenterRenameFile(t *testing.T) {
 	if testing.Short() {
@@ -449,6 +561,93 @@ func TestRenterRenameFile(t *testing.T) {
 	}
 }
 
+// TestRenterMoveFileWithTags probes MoveFileWithTags.
+func TestRenterMoveFileWithTags(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	src, dst, other := newTurtleDexPath("a/src"), newTurtleDexPath("b/dst"), newTurtleDexPath("other")
+	for _, sp := range []modules.TurtleDexPath{src, other} {
+		f, err := r.createRenterTestFile(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Invalid tags and an existing destination should be rejected without
+	// changing anything.
+	tooLarge := map[string]string{"key": strings.Repeat("v", siafile.MaxTagEntrySize)}
+	if err := r.MoveFileWithTags(src, dst, tooLarge); !errors.Contains(err, siafile.ErrTagTooLarge) {
+		t.Fatal("expected ErrTagTooLarge, got", err)
+	}
+	if err := r.MoveFileWithTags(src, other, map[string]string{"k": "v"}); !errors.Contains(err, filesystem.ErrExists) {
+		t.Fatal("expected ErrExists, got", err)
+	}
+	fi, err := r.staticFileSystem.CachedFileInfo(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fi.Tags) != 0 {
+		t.Fatal("tags shouldn't have changed", fi.Tags)
+	}
+
+	// Move the file and set its tags.
+	tags := map[string]string{"k1": "v1", "k2": "v2"}
+	if err := r.MoveFileWithTags(src, dst, tags); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := r.staticFileSystem.FileExists(src); err != nil || exists {
+		t.Fatal("src should be gone", exists, err)
+	}
+	fi, err = r.staticFileSystem.CachedFileInfo(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fi.Tags) != fmt.Sprint(tags) {
+		t.Fatalf("expected tags %v but got %v", tags, fi.Tags)
+	}
+
+	// The tags should survive reopening the file.
+	f, err := r.staticFileSystem.OpenTurtleDexFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(f.Tags()) != fmt.Sprint(tags) {
+		t.Fatalf("expected tags %v but got %v", tags, f.Tags())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving with an empty map removes the tags.
+	if err := r.MoveFileWithTags(dst, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = r.staticFileSystem.CachedFileInfo(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fi.Tags) != 0 {
+		t.Fatal("tags should be removed", fi.Tags)
+	}
+}
+
 // TestRenterFileDir tests that the renter files are uploaded to the files
 // directory and not the root directory of the renter.
 func TestRenterFileDir(t *testing.T) {
@@ -2,7 +2,6 @@ package filesystem
 
 import (
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
@@ -170,7 +169,7 @@ func (n *DirNode) UpdateMetadata(md ttdxdir.Metadata) error {
 // managedList returns the files and dirs within the TurtleDexDir specified by siaPath.
 // offlineMap, goodForRenewMap and contractMap don't need to be provided if
 // 'cached' is set to 'true'.
-func (n *DirNode) managedList(fsRoot string, recursive, cached bool, offlineMap map[string]bool, goodForRenewMap map[string]bool, contractsMap map[string]modules.RenterContract, flf modules.FileListFunc, dlf modules.DirListFunc) error {
+func (n *DirNode) managedList(fsRoot string, symlinkPolicy SymlinkPolicy, recursive, cached bool, offlineMap map[string]bool, goodForRenewMap map[string]bool, contractsMap map[string]modules.RenterContract, flf modules.FileListFunc, dlf modules.DirListFunc) error {
 	// Prepare a pool of workers.
 	numThreads := 40
 	dirLoadChan := make(chan *DirNode, numThreads)
@@ -232,7 +231,7 @@ func (n *DirNode) managedList(fsRoot string, recursive, cached bool, offlineMap
 			wg.Done()
 		}()
 	}
-	err := n.managedRecursiveList(recursive, cached, fileLoadChan, dirLoadChan)
+	err := n.managedRecursiveList(fsRoot, symlinkPolicy, recursive, cached, fileLoadChan, dirLoadChan)
 	// Signal the workers that we are done adding work and wait for them to
 	// finish any pending work.
 	close(dirLoadChan)
@@ -242,11 +241,11 @@ func (n *DirNode) managedList(fsRoot string, recursive, cached bool, offlineMap
 }
 
 // managedRecursiveList returns the files and dirs within the TurtleDexDir.
-func (n *DirNode) managedRecursiveList(recursive, cached bool, fileLoadChan chan func() (*FileNode, error), dirLoadChan chan *DirNode) error {
+func (n *DirNode) managedRecursiveList(fsRoot string, symlinkPolicy SymlinkPolicy, recursive, cached bool, fileLoadChan chan func() (*FileNode, error), dirLoadChan chan *DirNode) error {
 	// Get DirectoryInfo of dir itself.
 	dirLoadChan <- n.managedCopy()
 	// Read dir.
-	fis, err := ioutil.ReadDir(n.managedAbsPath())
+	fis, err := readDir(n.managedAbsPath(), fsRoot, symlinkPolicy)
 	if err != nil {
 		return err
 	}
@@ -277,7 +276,7 @@ func (n *DirNode) managedRecursiveList(recursive, cached bool, fileLoadChan chan
 		}
 		if recursive {
 			// Call managedList on the child if 'recursive' was specified.
-			err = dir.managedRecursiveList(recursive, cached, fileLoadChan, dirLoadChan)
+			err = dir.managedRecursiveList(fsRoot, symlinkPolicy, recursive, cached, fileLoadChan, dirLoadChan)
 		} else {
 			// If not recursive, hand a copy to the worker. It will handle closing it.
 			dirLoadChan <- dir.managedCopy()
@@ -577,6 +576,7 @@ func (n *DirNode) managedInfo(siaPath modules.TurtleDexPath) (modules.DirectoryI
 	return modules.DirectoryInfo{
 		// Aggregate Fields
 		AggregateHealth:              metadata.AggregateHealth,
+		AggregateLastActivity:        metadata.AggregateLastActivity,
 		AggregateLastHealthCheckTime: metadata.AggregateLastHealthCheckTime,
 		AggregateMaxHealth:           aggregateMaxHealth,
 		AggregateMaxHealthPercentage: modules.HealthPercentage(aggregateMaxHealth),
@@ -615,6 +615,15 @@ func (n *DirNode) managedInfo(siaPath modules.TurtleDexPath) (modules.DirectoryI
 		// Skynet Fields
 		SkynetFiles: metadata.SkynetFiles,
 		SkynetSize:  metadata.SkynetSize,
+
+		// Bubble Fields
+		BubbleDuration:  metadata.BubbleDuration,
+		AggregateCustom: metadata.AggregateCustom,
+
+		// User Fields
+		Tags:     metadata.Tags,
+		Pinned:   metadata.Pinned,
+		Excluded: metadata.Excluded,
 	}, nil
 }
 
@@ -148,6 +148,20 @@ func (n *FileNode) managedFileInfo(siaPath modules.TurtleDexPath, offline map[st
 
 // managedRename renames the fNode's underlying file.
 func (n *FileNode) managedRename(newName string, oldParent, newParent *DirNode) error {
+	return n.managedRenameFunc(newName, oldParent, newParent, n.TurtleDexFile.Rename)
+}
+
+// managedRenameWithTags renames the file like managedRename and replaces its
+// tags within the same transaction.
+func (n *FileNode) managedRenameWithTags(newName string, oldParent, newParent *DirNode, tags map[string]string) error {
+	return n.managedRenameFunc(newName, oldParent, newParent, func(newPath string) error {
+		return n.TurtleDexFile.RenameWithTags(newPath, tags)
+	})
+}
+
+// managedRenameFunc moves the node to newParent using rename to move the
+// siafile on disk.
+func (n *FileNode) managedRenameFunc(newName string, oldParent, newParent *DirNode, rename func(newPath string) error) error {
 	// Lock the parents. If they are the same, only lock one.
 	if oldParent.staticUID == newParent.staticUID {
 		oldParent.node.mu.Lock()
@@ -167,7 +181,7 @@ func (n *FileNode) managedRename(newName string, oldParent, newParent *DirNode)
 	}
 	newPath := filepath.Join(newParent.absPath(), newName) + modules.TurtleDexFileExtension
 	// Rename the file.
-	err := n.TurtleDexFile.Rename(newPath)
+	err := rename(newPath)
 	if errors.Contains(err, siafile.ErrPathOverload) {
 		return ErrExists
 	}
@@ -219,6 +233,7 @@ func (n *FileNode) staticCachedInfo(siaPath modules.TurtleDexPath) (modules.File
 		Redundancy:       md.CachedUserRedundancy,
 		Renewing:         true,
 		Skylinks:         md.Skylinks,
+		Tags:             md.Tags,
 		TurtleDexPath:          siaPath,
 		Stuck:            md.NumStuckChunks > 0,
 		StuckHealth:      md.CachedStuckHealth,
@@ -40,6 +40,10 @@ type (
 	// future.
 	FileSystem struct {
 		DirNode
+
+		// symlinkPolicy is the SymlinkPolicy of the filesystem. It is accessed
+		// atomically.
+		symlinkPolicy uint32
 	}
 
 	// node is a struct that contains the common fields of every node.
@@ -222,7 +226,7 @@ func (fs *FileSystem) CachedListOnNode(d *DirNode) (fis []modules.FileInfo, dis
 		dis = append(dis, di)
 		dmu.Unlock()
 	}
-	err = d.managedList(fs.managedAbsPath(), false, true, nil, nil, nil, flf, dlf)
+	err = d.managedList(fs.managedAbsPath(), fs.SymlinkPolicy(), false, true, nil, nil, nil, flf, dlf)
 
 	// Sort slices by TurtleDexPath.
 	sort.Slice(dis, func(i, j int) bool {
@@ -329,19 +333,11 @@ func (fs *FileSystem) NewTurtleDexFile(siaPath modules.TurtleDexPath, source str
 	return fs.managedNewTurtleDexFile(siaPath.String(), source, ec, mk, fileSize, fileMode, disablePartialUpload)
 }
 
-// ReadDir reads all the fileinfos of the specified dir.
+// ReadDir reads all the fileinfos of the specified dir. Symlinks are handled
+// according to the filesystem's SymlinkPolicy.
 func (fs *FileSystem) ReadDir(siaPath modules.TurtleDexPath) ([]os.FileInfo, error) {
-	// Open dir.
-	dirPath := siaPath.TurtleDexDirSysPath(fs.managedAbsPath())
-	f, err := os.Open(dirPath)
-	if err != nil {
-		return nil, err
-	}
-	// Read it and close it.
-	fis, err1 := f.Readdir(-1)
-	err2 := f.Close()
-	err = errors.Compose(err1, err2)
-	return fis, err
+	root := fs.managedAbsPath()
+	return readDir(siaPath.TurtleDexDirSysPath(root), root, fs.SymlinkPolicy())
 }
 
 // DirExists checks to see if a dir with the provided siaPath already exists in
@@ -475,7 +471,24 @@ func (fs *FileSystem) OpenTurtleDexFile(siaPath modules.TurtleDexPath) (*FileNod
 }
 
 // RenameFile renames the file with oldTurtleDexPath to newTurtleDexPath.
-func (fs *FileSystem) RenameFile(oldTurtleDexPath, newTurtleDexPath modules.TurtleDexPath) (err error) {
+func (fs *FileSystem) RenameFile(oldTurtleDexPath, newTurtleDexPath modules.TurtleDexPath) error {
+	return fs.managedRenameFile(oldTurtleDexPath, newTurtleDexPath, func(sf *FileNode, oldDir, newDir *DirNode) error {
+		return sf.managedRename(newTurtleDexPath.Name(), oldDir, newDir)
+	})
+}
+
+// RenameFileWithTags renames a file like RenameFile and replaces its tags
+// within the same transaction. Passing an empty map removes all of the file's
+// tags.
+func (fs *FileSystem) RenameFileWithTags(oldTurtleDexPath, newTurtleDexPath modules.TurtleDexPath, tags map[string]string) error {
+	return fs.managedRenameFile(oldTurtleDexPath, newTurtleDexPath, func(sf *FileNode, oldDir, newDir *DirNode) error {
+		return sf.managedRenameWithTags(newTurtleDexPath.Name(), oldDir, newDir, tags)
+	})
+}
+
+// managedRenameFile opens the file at oldTurtleDexPath and the parent
+// directories and calls rename to move the file to newTurtleDexPath.
+func (fs *FileSystem) managedRenameFile(oldTurtleDexPath, newTurtleDexPath modules.TurtleDexPath, rename func(sf *FileNode, oldDir, newDir *DirNode) error) (err error) {
 	// Open TurtleDexDir for file at old location.
 	oldDirTurtleDexPath, err := oldTurtleDexPath.Dir()
 	if err != nil {
@@ -516,7 +529,7 @@ func (fs *FileSystem) RenameFile(oldTurtleDexPath, newTurtleDexPath modules.Turt
 		err = errors.Compose(err, newDir.Close())
 	}()
 	// Rename the file.
-	return sf.managedRename(newTurtleDexPath.Name(), oldDir, newDir)
+	return rename(sf, oldDir, newDir)
 }
 
 // RenameDir takes an existing directory and changes the path. The original
@@ -638,7 +651,7 @@ func (fs *FileSystem) managedList(siaPath modules.TurtleDexPath, recursive, cach
 	defer func() {
 		err = errors.Compose(err, dir.Close())
 	}()
-	return dir.managedList(fs.managedAbsPath(), recursive, cached, offlineMap, goodForRenewMap, contractsMap, flf, dlf)
+	return dir.managedList(fs.managedAbsPath(), fs.SymlinkPolicy(), recursive, cached, offlineMap, goodForRenewMap, contractsMap, flf, dlf)
 }
 
 // managedNewTurtleDexDir creates the folder at the specified siaPath.
@@ -0,0 +1,185 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/turtledex/errors"
+)
+
+// symlink.go contains the policy for handling symlinks within the on-disk
+// layout of the filesystem. The policy is applied whenever the filesystem
+// reads a directory from disk, which includes the walk used by the bubble and
+// the listing functions.
+//
+// Security implications of SymlinkPolicyFollowWithinRoot:
+//
+// Following symlinks means that the content of a link's target is treated as if
+// it was part of the renter's directory. Anyone who is able to create a symlink
+// within the renter's directory can therefore make the renter pick up
+// siafiles and directory metadata from elsewhere within the root. To prevent
+// the renter from reading arbitrary files on the host, a link is only followed
+// if its fully resolved target lies within the root of the filesystem. Links
+// which would create a cycle by pointing to one of the directories that is
+// currently being walked are never followed either. Both checks resolve the
+// paths at the time the directory is read, so a link that is swapped out
+// between the check and the use can't be fully guarded against. Operators
+// should only enable the follow mode if they trust everyone with write access
+// to the renter's directory.
+
+var (
+	// ErrSymlink is returned when a symlink is encountered while reading a
+	// directory and the policy is SymlinkPolicyError.
+	ErrSymlink = errors.New("symlink found in renter directory")
+)
+
+// Policies for handling symlinks.
+const (
+	// SymlinkPolicyIgnore skips symlinks as if they didn't exist. This is the
+	// default.
+	SymlinkPolicyIgnore SymlinkPolicy = iota
+	// SymlinkPolicyFollowWithinRoot follows symlinks as long as their target
+	// is within the root of the filesystem and doesn't create a cycle. Links
+	// that don't satisfy these conditions are ignored.
+	SymlinkPolicyFollowWithinRoot
+	// SymlinkPolicyError causes reading a directory which contains a symlink
+	// to fail with ErrSymlink.
+	SymlinkPolicyError
+)
+
+type (
+	// SymlinkPolicy defines how symlinks within the filesystem are handled.
+	SymlinkPolicy uint32
+
+	// symlinkFileInfo is the os.FileInfo of a symlink's target but with the
+	// name of the symlink.
+	symlinkFileInfo struct {
+		os.FileInfo
+		name string
+	}
+)
+
+// Name returns the name of the symlink.
+func (fi symlinkFileInfo) Name() string {
+	return fi.name
+}
+
+// String implements the fmt.Stringer interface.
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinkPolicyIgnore:
+		return "ignore"
+	case SymlinkPolicyFollowWithinRoot:
+		return "follow-within-root"
+	case SymlinkPolicyError:
+		return "error"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(p))
+	}
+}
+
+// SymlinkPolicy returns the policy the filesystem uses for symlinks.
+func (fs *FileSystem) SymlinkPolicy() SymlinkPolicy {
+	return SymlinkPolicy(atomic.LoadUint32(&fs.symlinkPolicy))
+}
+
+// SetSymlinkPolicy sets the policy the filesystem uses for symlinks.
+func (fs *FileSystem) SetSymlinkPolicy(p SymlinkPolicy) error {
+	if p > SymlinkPolicyError {
+		return fmt.Errorf("invalid symlink policy %v", p)
+	}
+	atomic.StoreUint32(&fs.symlinkPolicy, uint32(p))
+	return nil
+}
+
+// readDir reads the directory at dirPath and applies the symlink policy to
+// its entries. root is the root of the filesystem.
+func readDir(dirPath, root string, policy SymlinkPolicy) ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := fis[:0]
+	for _, fi := range fis {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			entries = append(entries, fi)
+			continue
+		}
+		linkPath := filepath.Join(dirPath, fi.Name())
+		switch policy {
+		case SymlinkPolicyIgnore:
+			continue
+		case SymlinkPolicyError:
+			return nil, errors.AddContext(ErrSymlink, linkPath)
+		case SymlinkPolicyFollowWithinRoot:
+			target, err := followableSymlink(linkPath, dirPath, root)
+			if err != nil {
+				return nil, err
+			}
+			if target != nil {
+				entries = append(entries, symlinkFileInfo{FileInfo: target, name: fi.Name()})
+			}
+		default:
+			return nil, fmt.Errorf("invalid symlink policy %v", policy)
+		}
+	}
+	return entries, nil
+}
+
+// followableSymlink returns the os.FileInfo of the target of the symlink at
+// linkPath if it may be followed. A nil os.FileInfo is returned for links that
+// are dangling, point outside of root or would create a cycle.
+func followableSymlink(linkPath, dirPath, root string) (os.FileInfo, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to resolve filesystem root")
+	}
+	target, err := filepath.EvalSymlinks(linkPath)
+	if os.IsNotExist(err) {
+		return nil, nil // dangling link
+	} else if err != nil {
+		return nil, err
+	}
+	// Make sure the target doesn't escape the root.
+	if !withinDir(target, realRoot) {
+		return nil, nil
+	}
+	fi, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return fi, nil
+	}
+	// Make sure the target isn't one of the directories that are currently
+	// being walked. Those are the directory containing the link and all of its
+	// ancestors up to the root.
+	for p := dirPath; withinDir(p, root); p = filepath.Dir(p) {
+		realP, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return nil, err
+		}
+		if realP == target {
+			return nil, nil
+		}
+		if p == root {
+			break
+		}
+	}
+	return fi, nil
+}
+
+// withinDir returns true if path is dir or a descendant of dir.
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
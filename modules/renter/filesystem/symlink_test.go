@@ -0,0 +1,88 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/persist"
+	"github.com/turtledex/errors"
+)
+
+// TestReadDirSymlinkPolicy probes readDir with all the symlink policies.
+func TestReadDirSymlinkPolicy(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	// Prepare the following layout.
+	//
+	// outside/
+	// root/a/      -> regular dir
+	// root/a/up    -> link to root/a (cycle)
+	// root/a/tob   -> link to root/b
+	// root/a/out   -> link to outside
+	// root/a/gone  -> dangling link
+	// root/b/
+	testRoot := testDir(t.Name())
+	root := filepath.Join(testRoot, "root")
+	outside := filepath.Join(testRoot, "outside")
+	a := filepath.Join(root, "a")
+	b := filepath.Join(root, "b")
+	for _, dir := range []string{outside, a, b} {
+		if err := os.MkdirAll(dir, persist.DefaultDiskPermissionsTest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"up":   a,
+		"tob":  b,
+		"out":  outside,
+		"gone": filepath.Join(root, "doesntexist"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(a, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// names is a helper to get the sorted names of the entries of a.
+	names := func(policy SymlinkPolicy) ([]string, error) {
+		fis, err := readDir(a, root, policy)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	// Ignore should skip all links.
+	n, err := names(SymlinkPolicyIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n) != 0 {
+		t.Fatal("expected no entries", n)
+	}
+
+	// Error should fail.
+	_, err = names(SymlinkPolicyError)
+	if !errors.Contains(err, ErrSymlink) {
+		t.Fatal("expected ErrSymlink but got", err)
+	}
+
+	// Follow should only follow the link to b.
+	fis, err := readDir(a, root, SymlinkPolicyFollowWithinRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "tob" || !fis[0].IsDir() {
+		t.Fatal("expected only 'tob' to be followed", fis)
+	}
+}
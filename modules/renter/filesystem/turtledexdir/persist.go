@@ -318,6 +318,9 @@ func (sd *TurtleDexDir) UpdateBubbledMetadata(metadata Metadata) error {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 	metadata.Mode = sd.metadata.Mode
+	metadata.Tags = sd.metadata.Tags
+	metadata.Pinned = sd.metadata.Pinned
+	metadata.Excluded = sd.metadata.Excluded
 	metadata.Version = sd.metadata.Version
 	return sd.updateMetadata(metadata)
 }
@@ -349,6 +352,7 @@ func (sd *TurtleDexDir) updateMetadata(metadata Metadata) error {
 
 	// Update metadata
 	sd.metadata.AggregateHealth = metadata.AggregateHealth
+	sd.metadata.AggregateLastActivity = metadata.AggregateLastActivity
 	sd.metadata.AggregateLastHealthCheckTime = metadata.AggregateLastHealthCheckTime
 	sd.metadata.AggregateMinRedundancy = metadata.AggregateMinRedundancy
 	sd.metadata.AggregateModTime = metadata.AggregateModTime
@@ -381,6 +385,12 @@ func (sd *TurtleDexDir) updateMetadata(metadata Metadata) error {
 	sd.metadata.SkynetFiles = metadata.SkynetFiles
 	sd.metadata.SkynetSize = metadata.SkynetSize
 
+	sd.metadata.BubbleDuration = metadata.BubbleDuration
+	sd.metadata.AggregateCustom = metadata.AggregateCustom
+	sd.metadata.Tags = metadata.Tags
+	sd.metadata.Pinned = metadata.Pinned
+	sd.metadata.Excluded = metadata.Excluded
+
 	sd.metadata.Version = metadata.Version
 
 	// Testing check to ensure new fields aren't missed
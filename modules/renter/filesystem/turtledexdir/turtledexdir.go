@@ -1,8 +1,11 @@
 package ttdxdir
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,6 +14,11 @@ import (
 	"github.com/turtledex/TurtleDexCore/modules"
 )
 
+const (
+	// MetadataVersion is the latest version of the layout of the metadata.
+	MetadataVersion = 1
+)
+
 type (
 	// TurtleDexDir contains the metadata information about a renter directory
 	TurtleDexDir struct {
@@ -36,6 +44,11 @@ type (
 		//
 		// Health is the health of the most in need siafile that is not stuck
 		//
+		// LastActivity is the most recent ModTime of any of the siafiles. Unlike
+		// ModTime it is zero if there are no siafiles and is therefore not
+		// affected by the time of the last bubble. Only the aggregate value
+		// exists.
+		//
 		// LastHealthCheckTime is the oldest LastHealthCheckTime of any of the
 		// siafiles in the ttdxdir and is the last time the health was calculated
 		// by the health loop
@@ -62,6 +75,7 @@ type (
 		// the totals of the ttdxdir and any sub ttdxdirs, or are calculated based on
 		// all the values in the subtree
 		AggregateHealth              float64   `json:"aggregatehealth"`
+		AggregateLastActivity        time.Time `json:"aggregatelastactivity"`
 		AggregateLastHealthCheckTime time.Time `json:"aggregatelasthealthchecktime"`
 		AggregateMinRedundancy       float64   `json:"aggregateminredundancy"`
 		AggregateModTime             time.Time `json:"aggregatemodtime"`
@@ -98,6 +112,25 @@ type (
 		SkynetFiles uint64 `json:"skynetfiles"`
 		SkynetSize  uint64 `json:"skynetsize"`
 
+		// BubbleDuration is an exponential moving average of the time it took
+		// to bubble the ttdxdir.
+		BubbleDuration time.Duration `json:"bubbleduration"`
+
+		// AggregateCustom contains the custom aggregates registered with the
+		// renter. Every value is a sum over all the siafiles in the sub tree.
+		AggregateCustom map[string]uint64 `json:"aggregatecustom,omitempty"`
+
+		// Tags are user defined key value pairs attached to the ttdxdir. They
+		// are not changed by a bubble.
+		Tags map[string]string `json:"tags,omitempty"`
+
+		// Pinned and Excluded are user defined flags of the ttdxdir. Like the
+		// tags they are not changed by a bubble. A pinned dir is refreshed
+		// whenever the health loop checks its subtree and never reported as
+		// empty. An excluded dir is skipped by the health loop.
+		Pinned   bool `json:"pinned,omitempty"`
+		Excluded bool `json:"excluded,omitempty"`
+
 		// Version is the used version of the header file.
 		Version string `json:"version"`
 	}
@@ -135,3 +168,23 @@ func (sd *TurtleDexDir) MDPath() string {
 	defer sd.mu.Unlock()
 	return sd.mdPath()
 }
+
+// FormatMetadataVersion returns the Version of the metadata for the given
+// layout version.
+func FormatMetadataVersion(version int) string {
+	return fmt.Sprintf("%d.0", version)
+}
+
+// ParseMetadataVersion returns the layout version of the metadata with the
+// given Version. Metadata without a Version uses the first layout.
+func ParseMetadataVersion(version string) (int, error) {
+	if version == "" {
+		return 1, nil
+	}
+	major := strings.SplitN(version, ".", 2)[0]
+	v, err := strconv.Atoi(major)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid metadata version '%v'", version)
+	}
+	return v, nil
+}
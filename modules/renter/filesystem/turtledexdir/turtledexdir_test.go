@@ -315,3 +315,30 @@ func TestTurtleDexDirDelete(t *testing.T) {
 	}
 	siaDir.mu.Unlock()
 }
+
+// TestParseMetadataVersion probes ParseMetadataVersion and
+// FormatMetadataVersion.
+func TestParseMetadataVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    int
+		valid   bool
+	}{
+		{"", 1, true},
+		{"1.0", 1, true},
+		{"2.1", 2, true},
+		{FormatMetadataVersion(MetadataVersion), MetadataVersion, true},
+		{"0.9", 0, false},
+		{"abc", 0, false},
+	}
+	for _, test := range tests {
+		v, err := ParseMetadataVersion(test.version)
+		if test.valid && err != nil {
+			t.Errorf("%q: unexpected error %v", test.version, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%q: expected error", test.version)
+		} else if v != test.want {
+			t.Errorf("%q: expected %v but got %v", test.version, test.want, v)
+		}
+	}
+}
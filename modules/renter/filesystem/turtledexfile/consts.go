@@ -40,6 +40,16 @@ const (
 	pubKeyTablePruneThreshold = 50
 )
 
+const (
+	// MaxTags is the maximum number of tags of a single TurtleDexFile.
+	MaxTags = 32
+
+	// MaxTagEntrySize is the maximum combined size of the key and the value
+	// of a single tag in bytes. Together with MaxTags it makes sure that the
+	// tags don't grow the metadata by more than a couple of pages.
+	MaxTagEntrySize = 256
+)
+
 // Constants to indicate which part of the partial upload the combined chunk is
 // currently at.
 const (
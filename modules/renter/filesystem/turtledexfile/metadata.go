@@ -168,6 +168,9 @@ type (
 		// skyfiles, those skyfiles will be listed here. It should be noted that
 		// a single siafile can be responsible for tracking many skyfiles.
 		Skylinks []string `json:"skylinks"`
+
+		// Tags are user defined key value pairs attached to the siafile.
+		Tags map[string]string `json:"tags,omitempty"`
 	}
 
 	// BubbledMetadata is the metadata of a siafile that gets bubbled
@@ -374,6 +377,7 @@ func (md Metadata) backup() (b Metadata) {
 		b.Skylinks = make([]string, len(md.Skylinks), cap(md.Skylinks))
 		copy(b.Skylinks, md.Skylinks)
 	}
+	b.Tags = copyTags(md.Tags)
 	// If the backup was successful it should match the original.
 	if build.Release == "testing" && !md.equals(b) {
 		fmt.Println("md:\n", md)
@@ -413,6 +417,7 @@ func (md *Metadata) restore(b Metadata) {
 	md.ChunkOffset = b.ChunkOffset
 	md.PubKeyTableOffset = b.PubKeyTableOffset
 	md.Skylinks = b.Skylinks
+	md.Tags = b.Tags
 	// If the backup was successful it should match the backup.
 	if build.Release == "testing" && !md.equals(b) {
 		fmt.Println("md:\n", md)
@@ -498,6 +503,62 @@ func (sf *TurtleDexFile) rename(newTurtleDexFilePath string) (err error) {
 	return createAndApplyTransaction(sf.wal, updates...)
 }
 
+// RenameWithTags changes the name of the file to a new one and replaces its
+// tags. Both changes are applied within the same transaction. Passing an empty
+// map removes all of the file's tags.
+func (sf *TurtleDexFile) RenameWithTags(newTurtleDexFilePath string, tags map[string]string) (err error) {
+	if err := ValidateTags(tags); err != nil {
+		return err
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	// backup the changed metadata before changing it. Revert the change on
+	// error.
+	defer func(backup Metadata) {
+		if err != nil {
+			sf.staticMetadata.restore(backup)
+		}
+	}(sf.staticMetadata.backup())
+	sf.staticMetadata.Tags = copyTags(tags)
+	return sf.rename(newTurtleDexFilePath)
+}
+
+// Tags returns a copy of the tags of the file.
+func (sf *TurtleDexFile) Tags() map[string]string {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return copyTags(sf.staticMetadata.Tags)
+}
+
+// ValidateTags checks that tags doesn't exceed the maximum number of tags and
+// that no tag exceeds the maximum size of a single entry.
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxTags {
+		return fmt.Errorf("%v tags exceed the maximum of %v tags", len(tags), MaxTags)
+	}
+	for key, value := range tags {
+		if key == "" {
+			return errors.New("tag key can't be empty")
+		}
+		if size := len(key) + len(value); size > MaxTagEntrySize {
+			return errors.AddContext(ErrTagTooLarge, fmt.Sprintf("tag '%v' has %v bytes but only %v are allowed", key, size, MaxTagEntrySize))
+		}
+	}
+	return nil
+}
+
+// copyTags returns a deep copy of tags. An empty map is copied as nil.
+func copyTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(tags))
+	for key, value := range tags {
+		c[key] = value
+	}
+	return c
+}
+
 // SetMode sets the filemode of the sia file.
 func (sf *TurtleDexFile) SetMode(mode os.FileMode) (err error) {
 	sf.mu.Lock()
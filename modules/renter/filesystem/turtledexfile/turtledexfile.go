@@ -29,6 +29,9 @@ var (
 	// ErrDeleted is returned when an operation failed due to the siafile being
 	// deleted already.
 	ErrDeleted = errors.New("files was deleted")
+	// ErrTagTooLarge is returned if the key and value of a tag exceed the
+	// MaxTagEntrySize.
+	ErrTagTooLarge = errors.New("tag exceeds the maximum entry size")
 )
 
 type (
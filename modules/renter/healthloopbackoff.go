@@ -0,0 +1,108 @@
+package renter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// healthloopbackoff.go contains the adaptive backoff of the health loop. A
+// cycle of the health loop is idle if the least recently checked directory was
+// checked within the effective interval, which makes the loop sleep. Every
+// idle cycle multiplies the effective interval by the backoff factor, up to
+// the max interval, which is capped at a small multiple of the
+// healthCheckInterval. As soon as the loop finds a directory that is stale
+// without having slept first, the effective interval is reset to the
+// healthCheckInterval.
+
+type (
+	// healthLoopBackoff tracks the effective interval of the health loop.
+	healthLoopBackoff struct {
+		factor      float64
+		idleCycles  int
+		maxInterval time.Duration
+		mu          sync.Mutex
+	}
+)
+
+// newHealthLoopBackoff creates a new healthLoopBackoff with the default
+// settings.
+func newHealthLoopBackoff() *healthLoopBackoff {
+	return &healthLoopBackoff{
+		factor:      defaultHealthLoopBackoffFactor,
+		maxInterval: defaultHealthLoopMaxInterval,
+	}
+}
+
+// interval returns the effective interval.
+func (b *healthLoopBackoff) interval() time.Duration {
+	interval := healthCheckInterval
+	for i := 0; i < b.idleCycles && interval < b.maxInterval; i++ {
+		interval = time.Duration(float64(interval) * b.factor)
+	}
+	if interval > b.maxInterval {
+		interval = b.maxInterval
+	}
+	return interval
+}
+
+// callIdle records an idle cycle.
+func (b *healthLoopBackoff) callIdle() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.interval() < b.maxInterval {
+		b.idleCycles++
+	}
+}
+
+// callInterval returns the effective interval.
+func (b *healthLoopBackoff) callInterval() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.interval()
+}
+
+// callReset resets the effective interval to the healthCheckInterval.
+func (b *healthLoopBackoff) callReset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.idleCycles = 0
+}
+
+// callSettings returns the backoff factor and the max interval.
+func (b *healthLoopBackoff) callSettings() (float64, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.factor, b.maxInterval
+}
+
+// callSetSettings updates the backoff factor and the max interval.
+func (b *healthLoopBackoff) callSetSettings(factor float64, maxInterval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.factor = factor
+	b.maxInterval = maxInterval
+}
+
+// SetHealthLoopBackoff sets the factor by which the interval of the health
+// loop grows with every idle cycle and the max interval it can grow to. A
+// factor of 1 or a max interval equal to the health check interval disables
+// the backoff. The max interval can be at most healthLoopMaxIntervalMultiple
+// times the health check interval.
+func (r *Renter) SetHealthLoopBackoff(factor float64, maxInterval time.Duration) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if factor < 1 {
+		return fmt.Errorf("backoff factor must be at least 1 but was %v", factor)
+	}
+	if maxInterval < healthCheckInterval {
+		return fmt.Errorf("max interval must be at least the health check interval %v but was %v", healthCheckInterval, maxInterval)
+	}
+	if limit := healthLoopMaxIntervalMultiple * healthCheckInterval; maxInterval > limit {
+		return fmt.Errorf("max interval must be at most %v but was %v", limit, maxInterval)
+	}
+	r.staticHealthLoopBackoff.callSetSettings(factor, maxInterval)
+	return nil
+}
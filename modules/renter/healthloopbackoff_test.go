@@ -0,0 +1,78 @@
+package renter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestHealthLoopBackoff probes the healthLoopBackoff.
+func TestHealthLoopBackoff(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	b := &healthLoopBackoff{
+		factor:      2,
+		maxInterval: 5 * healthCheckInterval,
+	}
+	if interval := b.callInterval(); interval != healthCheckInterval {
+		t.Fatal("wrong initial interval", interval)
+	}
+
+	// Idle cycles should grow the interval up to the max.
+	expected := []time.Duration{2 * healthCheckInterval, 4 * healthCheckInterval, 5 * healthCheckInterval, 5 * healthCheckInterval}
+	for i, e := range expected {
+		b.callIdle()
+		if interval := b.callInterval(); interval != e {
+			t.Fatalf("%v: expected %v but got %v", i, e, interval)
+		}
+	}
+
+	// A reset should shrink it back to the base interval.
+	b.callReset()
+	if interval := b.callInterval(); interval != healthCheckInterval {
+		t.Fatal("interval wasn't reset", interval)
+	}
+
+	// A factor of 1 disables the backoff.
+	b.callSetSettings(1, 5*healthCheckInterval)
+	b.callIdle()
+	if interval := b.callInterval(); interval != healthCheckInterval {
+		t.Fatal("interval shouldn't grow", interval)
+	}
+
+	// Check the renter's settings.
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	if err := r.SetHealthLoopBackoff(0.5, time.Hour); err == nil {
+		t.Fatal("expected error for factor < 1")
+	}
+	if err := r.SetHealthLoopBackoff(2, healthCheckInterval/2); err == nil {
+		t.Fatal("expected error for max interval < health check interval")
+	}
+	if err := r.SetHealthLoopBackoff(2, 3*healthCheckInterval); err == nil {
+		t.Fatal("expected error for max interval > 2x health check interval")
+	}
+	maxInterval := healthLoopMaxIntervalMultiple * healthCheckInterval
+	if err := r.SetHealthLoopBackoff(3, maxInterval); err != nil {
+		t.Fatal(err)
+	}
+	diag := r.RefreshDiagnostics()
+	if diag.HealthLoopBackoffFactor != 3 || diag.HealthLoopMaxInterval != maxInterval {
+		t.Fatal("wrong backoff settings", diag.HealthLoopBackoffFactor, diag.HealthLoopMaxInterval)
+	}
+	if diag.EffectiveHealthCheckInterval != healthCheckInterval {
+		t.Fatal("wrong effective interval", diag.EffectiveHealthCheckInterval)
+	}
+}
@@ -0,0 +1,127 @@
+package renter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/turtledex/errors"
+)
+
+// listinglimiter.go contains the limiter for the number of directory listings
+// that are allowed to walk the filesystem at the same time. It bounds the disk
+// load caused by listings independently of the load caused by bubbles.
+
+var (
+	// errListingTimeout is returned if a listing had to wait too long for the
+	// limiter.
+	errListingTimeout = errors.New("timed out waiting for other directory listings to finish")
+)
+
+type (
+	// listingLimiter is a semaphore with a configurable limit. A limit of 0
+	// means that the number of concurrent listings is unlimited.
+	listingLimiter struct {
+		active  int
+		limit   int
+		waiting int
+
+		// wakeChan is closed and replaced whenever a slot is freed up or the
+		// limit changes to wake up all waiting listings.
+		wakeChan chan struct{}
+		mu       sync.Mutex
+	}
+)
+
+// newListingLimiter creates a new listingLimiter.
+func newListingLimiter(limit int) *listingLimiter {
+	return &listingLimiter{
+		limit:    limit,
+		wakeChan: make(chan struct{}),
+	}
+}
+
+// managedAcquire blocks until a listing is allowed to run or until the context
+// is closed.
+func (ll *listingLimiter) managedAcquire(ctx context.Context) error {
+	ll.mu.Lock()
+	ll.waiting++
+	defer func() {
+		ll.waiting--
+		ll.mu.Unlock()
+	}()
+	for ll.limit > 0 && ll.active >= ll.limit {
+		wakeChan := ll.wakeChan
+		ll.mu.Unlock()
+		select {
+		case <-wakeChan:
+		case <-ctx.Done():
+			ll.mu.Lock()
+			return errListingTimeout
+		}
+		ll.mu.Lock()
+	}
+	ll.active++
+	return nil
+}
+
+// managedRelease frees up the slot of a listing.
+func (ll *listingLimiter) managedRelease() {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.active--
+	ll.wake()
+}
+
+// managedSetLimit updates the limit of the limiter.
+func (ll *listingLimiter) managedSetLimit(limit int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.limit = limit
+	ll.wake()
+}
+
+// managedWaiting returns the number of listings that are waiting.
+func (ll *listingLimiter) managedWaiting() int {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	return ll.waiting
+}
+
+// wake wakes up all waiting listings.
+func (ll *listingLimiter) wake() {
+	close(ll.wakeChan)
+	ll.wakeChan = make(chan struct{})
+}
+
+// managedAcquireListing acquires a slot from the renter's listing limiter. The
+// returned function needs to be called to release the slot once the listing
+// is done.
+func (r *Renter) managedAcquireListing() (func(), error) {
+	ctx, cancel := context.WithTimeout(r.tg.StopCtx(), listingTimeout)
+	defer cancel()
+	if err := r.staticListingLimiter.managedAcquire(ctx); err != nil {
+		return nil, err
+	}
+	return r.staticListingLimiter.managedRelease, nil
+}
+
+// NumWaitingListings returns the number of directory listings which are
+// currently waiting for other listings to finish.
+func (r *Renter) NumWaitingListings() int {
+	return r.staticListingLimiter.managedWaiting()
+}
+
+// SetMaxConcurrentListings sets the number of directory listings that are
+// allowed to run at the same time. A limit of 0 disables the limit.
+func (r *Renter) SetMaxConcurrentListings(limit int) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if limit < 0 {
+		return fmt.Errorf("invalid listing limit %v", limit)
+	}
+	r.staticListingLimiter.managedSetLimit(limit)
+	return nil
+}
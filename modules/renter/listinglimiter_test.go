@@ -0,0 +1,69 @@
+package renter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/errors"
+)
+
+// TestListingLimiter probes the listingLimiter.
+func TestListingLimiter(t *testing.T) {
+	t.Parallel()
+
+	ll := newListingLimiter(1)
+
+	// The first listing can run right away.
+	if err := ll.managedAcquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second one should time out.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ll.managedAcquire(ctx); !errors.Contains(err, errListingTimeout) {
+		t.Fatal("expected timeout but got", err)
+	}
+	if ll.managedWaiting() != 0 {
+		t.Fatal("no listing should be waiting")
+	}
+
+	// Start another one in the background and wait for it to be waiting.
+	done := make(chan error)
+	go func() {
+		done <- ll.managedAcquire(context.Background())
+	}()
+	err := build.Retry(100, 10*time.Millisecond, func() error {
+		if n := ll.managedWaiting(); n != 1 {
+			return errors.New("listing isn't waiting")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Releasing the first listing should unblock it.
+	ll.managedRelease()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listing wasn't unblocked")
+	}
+
+	// Disabling the limit allows for more listings.
+	ll.managedSetLimit(0)
+	for i := 0; i < 3; i++ {
+		if err := ll.managedAcquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ll.managedWaiting() != 0 {
+		t.Fatal("no listing should be waiting")
+	}
+}
@@ -1,18 +1,22 @@
 package renter
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/turtledex/errors"
+	"github.com/turtledex/threadgroup"
 
 	"github.com/turtledex/TurtleDexCore/build"
 	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
 	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
 	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
 )
@@ -97,41 +101,10 @@ func (r *Renter) managedPrepareBubble(siaPath modules.TurtleDexPath) bool {
 // directory's metadata and tracks the value, either worst or best, for each to
 // be bubbled up
 func (r *Renter) managedCalculateDirectoryMetadata(siaPath modules.TurtleDexPath) (ttdxdir.Metadata, error) {
-	// Set default metadata values to start
+	// Remember the start time which is used as the default for the
+	// LastHealthCheckTime fields.
 	now := time.Now()
-	metadata := ttdxdir.Metadata{
-		AggregateHealth:              ttdxdir.DefaultDirHealth,
-		AggregateLastHealthCheckTime: now,
-		AggregateMinRedundancy:       math.MaxFloat64,
-		AggregateModTime:             time.Time{},
-		AggregateNumFiles:            uint64(0),
-		AggregateNumStuckChunks:      uint64(0),
-		AggregateNumSubDirs:          uint64(0),
-		AggregateRemoteHealth:        ttdxdir.DefaultDirHealth,
-		AggregateRepairSize:          uint64(0),
-		AggregateSize:                uint64(0),
-		AggregateStuckHealth:         ttdxdir.DefaultDirHealth,
-		AggregateStuckSize:           uint64(0),
-
-		AggregateSkynetFiles: uint64(0),
-		AggregateSkynetSize:  uint64(0),
-
-		Health:              ttdxdir.DefaultDirHealth,
-		LastHealthCheckTime: now,
-		MinRedundancy:       math.MaxFloat64,
-		ModTime:             time.Time{},
-		NumFiles:            uint64(0),
-		NumStuckChunks:      uint64(0),
-		NumSubDirs:          uint64(0),
-		RemoteHealth:        ttdxdir.DefaultDirHealth,
-		RepairSize:          uint64(0),
-		Size:                uint64(0),
-		StuckHealth:         ttdxdir.DefaultDirHealth,
-		StuckSize:           uint64(0),
 
-		SkynetFiles: uint64(0),
-		SkynetSize:  uint64(0),
-	}
 	// Read directory
 	fileinfos, err := r.staticFileSystem.ReadDir(siaPath)
 	if err != nil {
@@ -173,7 +146,8 @@ func (r *Renter) managedCalculateDirectoryMetadata(siaPath modules.TurtleDexPath
 	// Calculate the Files' bubbleMetadata first.
 	// Note: We don't need to abort on error. It's likely that only one or a few
 	// files failed and that the remaining metadatas are good to use.
-	bubbledMetadatas, err := r.managedCalculateFileMetadatas(fileTurtleDexPaths)
+	aggregates := r.staticBubbleAggregates.callAggregates()
+	metadata, err := r.managedCalculateFilesBubbleMetadata(siaPath, fileTurtleDexPaths, aggregates, now)
 	if err != nil {
 		r.log.Printf("failed to calculate file metadata: %v", err)
 	}
@@ -186,171 +160,87 @@ func (r *Renter) managedCalculateDirectoryMetadata(siaPath modules.TurtleDexPath
 		r.log.Printf("failed to calculate file metadata: %v", err)
 	}
 
-	for len(bubbledMetadatas)+len(dirMetadatas) > 0 {
+	for _, dirMetadata := range dirMetadatas {
 		// Aggregate Fields
 		var aggregateHealth, aggregateRemoteHealth, aggregateStuckHealth, aggregateMinRedundancy float64
-		var aggregateLastHealthCheckTime, aggregateModTime time.Time
-		if len(bubbledMetadatas) > 0 {
-			// Get next file's metadata.
-			bubbledMetadata := bubbledMetadatas[0]
-			bubbledMetadatas = bubbledMetadatas[1:]
-			fileTurtleDexPath := bubbledMetadata.sp
-			fileMetadata := bubbledMetadata.bm
-			// If 75% or more of the redundancy is missing, register an alert
-			// for the file.
-			uid := string(fileMetadata.UID)
-			if maxHealth := math.Max(fileMetadata.Health, fileMetadata.StuckHealth); maxHealth >= AlertTurtleDexfileLowRedundancyThreshold {
-				r.staticAlerter.RegisterAlert(modules.AlertIDTurtleDexfileLowRedundancy(uid), AlertMSGTurtleDexfileLowRedundancy,
-					AlertCauseTurtleDexfileLowRedundancy(fileTurtleDexPath, maxHealth, fileMetadata.Redundancy),
-					modules.SeverityWarning)
-			} else {
-				r.staticAlerter.UnregisterAlert(modules.AlertIDTurtleDexfileLowRedundancy(uid))
+		var aggregateLastActivity, aggregateLastHealthCheckTime, aggregateModTime time.Time
+
+		// Check if the directory's AggregateLastHealthCheckTime is Zero. If so
+		// set the time to now and call bubble on that directory to try and fix
+		// the directories metadata.
+		//
+		// The LastHealthCheckTime is not a field that is initialized when
+		// a directory is created, so we can reach this point if a directory is
+		// created and gets a bubble called on it outside of the health loop
+		// before the health loop has been able to set the LastHealthCheckTime.
+		if dirMetadata.AggregateLastHealthCheckTime.IsZero() {
+			dirMetadata.AggregateLastHealthCheckTime = time.Now()
+			// Check for the dependency to disable the LastHealthCheckTime
+			// correction, (LHCT = LastHealthCheckTime).
+			if !r.deps.Disrupt("DisableLHCTCorrection") {
+				dirTurtleDexPath := dirMetadata.sp
+				err = r.tg.Launch(func() {
+					r.callThreadedBubbleMetadata(dirTurtleDexPath)
+				})
+				if err != nil {
+					r.log.Printf("WARN: unable to launch bubble for '%v'", dirTurtleDexPath)
+				}
 			}
+		}
 
-			// If the file's LastHealthCheckTime is still zero, set it as now since it
-			// it currently being checked.
-			//
-			// The LastHealthCheckTime is not a field that is initialized when a file
-			// is created, so we can reach this point by one of two ways. If a file is
-			// created in the directory after the health loop has decided it needs to
-			// be bubbled, or a file is created in a directory that gets a bubble
-			// called on it outside of the health loop before the health loop as been
-			// able to set the LastHealthCheckTime.
-			if fileMetadata.LastHealthCheckTime.IsZero() {
-				fileMetadata.LastHealthCheckTime = time.Now()
+		// Check if the directory's AggregateLastHealthCheckTime is in the
+		// future. This happens if the system clock jumped backwards and would
+		// prevent the health loop from ever picking the directory. Correct
+		// the time and call bubble on that directory to fix its metadata.
+		if current := time.Now(); dirMetadata.AggregateLastHealthCheckTime.After(current) {
+			r.log.Printf("WARN: AggregateLastHealthCheckTime of directory '%v' is in the future (%v > %v), the system clock might have been set back", dirMetadata.sp, dirMetadata.AggregateLastHealthCheckTime, current)
+			dirMetadata.AggregateLastHealthCheckTime = current
+			if !r.deps.Disrupt("DisableLHCTCorrection") {
+				dirTurtleDexPath := dirMetadata.sp
+				err = r.tg.Launch(func() {
+					r.callThreadedBubbleMetadata(dirTurtleDexPath)
+				})
+				if err != nil {
+					r.log.Printf("WARN: unable to launch bubble for '%v'", dirTurtleDexPath)
+				}
 			}
+		}
 
-			// Update repair fields
-			metadata.AggregateRepairSize += fileMetadata.RepairBytes
-			metadata.AggregateStuckSize += fileMetadata.StuckBytes
-			metadata.RepairSize += fileMetadata.RepairBytes
-			metadata.StuckSize += fileMetadata.StuckBytes
-
-			// Record Values that compare against sub directories
-			aggregateHealth = fileMetadata.Health
-			aggregateStuckHealth = fileMetadata.StuckHealth
-			aggregateMinRedundancy = fileMetadata.Redundancy
-			aggregateLastHealthCheckTime = fileMetadata.LastHealthCheckTime
-			aggregateModTime = fileMetadata.ModTime
-			if !fileMetadata.OnDisk {
-				aggregateRemoteHealth = fileMetadata.Health
-			}
+		// Record Values that compare against files
+		aggregateHealth = dirMetadata.AggregateHealth
+		aggregateStuckHealth = dirMetadata.AggregateStuckHealth
+		aggregateMinRedundancy = dirMetadata.AggregateMinRedundancy
+		aggregateLastHealthCheckTime = dirMetadata.AggregateLastHealthCheckTime
+		aggregateModTime = dirMetadata.AggregateModTime
+		aggregateLastActivity = dirMetadata.AggregateLastActivity
+		aggregateRemoteHealth = dirMetadata.AggregateRemoteHealth
+
+		// Update aggregate fields.
+		metadata.AggregateNumFiles += dirMetadata.AggregateNumFiles
+		metadata.AggregateNumStuckChunks += dirMetadata.AggregateNumStuckChunks
+		metadata.AggregateNumSubDirs += dirMetadata.AggregateNumSubDirs
+		metadata.AggregateRepairSize += dirMetadata.AggregateRepairSize
+		metadata.AggregateSize += dirMetadata.AggregateSize
+		metadata.AggregateStuckSize += dirMetadata.AggregateStuckSize
+
+		// Update aggregate Skynet fields
+		metadata.AggregateSkynetFiles += dirMetadata.AggregateSkynetFiles
+		metadata.AggregateSkynetSize += dirMetadata.AggregateSkynetSize
+
+		// Update the custom aggregates. Values of aggregates that are no
+		// longer registered are dropped.
+		for name := range aggregates {
+			addBubbleAggregate(&metadata, name, dirMetadata.AggregateCustom[name])
+		}
 
-			// Update aggregate fields.
-			metadata.AggregateNumFiles++
-			metadata.AggregateNumStuckChunks += fileMetadata.NumStuckChunks
-			metadata.AggregateSize += fileMetadata.Size
+		// Add 1 to the AggregateNumSubDirs to account for this subdirectory.
+		metadata.AggregateNumSubDirs++
 
-			// Update ttdxdir fields.
-			metadata.Health = math.Max(metadata.Health, fileMetadata.Health)
-			if fileMetadata.LastHealthCheckTime.Before(metadata.LastHealthCheckTime) {
-				metadata.LastHealthCheckTime = fileMetadata.LastHealthCheckTime
-			}
-			if fileMetadata.Redundancy != -1 {
-				metadata.MinRedundancy = math.Min(metadata.MinRedundancy, fileMetadata.Redundancy)
-			}
-			if fileMetadata.ModTime.After(metadata.ModTime) {
-				metadata.ModTime = fileMetadata.ModTime
-			}
-			metadata.NumFiles++
-			metadata.NumStuckChunks += fileMetadata.NumStuckChunks
-			if !fileMetadata.OnDisk {
-				metadata.RemoteHealth = math.Max(metadata.RemoteHealth, fileMetadata.Health)
-			}
-			metadata.Size += fileMetadata.Size
-			metadata.StuckHealth = math.Max(metadata.StuckHealth, fileMetadata.StuckHealth)
-
-			// Update Skynet Fields
-			//
-			// If the current directory is under the Skynet Folder, or the siafile
-			// contains a skylink in the metadata, then we count the file towards the
-			// Skynet Stats.
-			//
-			// For all cases we count the size.
-			//
-			// We only count the file towards the number of files if it is in the
-			// skynet folder and is not extended. We do not count files outside of the
-			// skynet folder because they should be treated as an extended file.
-			isSkynetDir := strings.Contains(siaPath.String(), modules.SkynetFolder.String())
-			isExtended := strings.Contains(fileTurtleDexPath.String(), modules.ExtendedSuffix)
-			hasSkylinks := fileMetadata.NumSkylinks > 0
-			if isSkynetDir || hasSkylinks {
-				metadata.AggregateSkynetSize += fileMetadata.Size
-				metadata.SkynetSize += fileMetadata.Size
-			}
-			if isSkynetDir && !isExtended {
-				metadata.AggregateSkynetFiles++
-				metadata.SkynetFiles++
-			}
-		} else if len(dirMetadatas) > 0 {
-			// Get next dir's metadata.
-			dirMetadata := dirMetadatas[0]
-			dirMetadatas = dirMetadatas[1:]
-
-			// Check if the directory's AggregateLastHealthCheckTime is Zero. If so
-			// set the time to now and call bubble on that directory to try and fix
-			// the directories metadata.
-			//
-			// The LastHealthCheckTime is not a field that is initialized when
-			// a directory is created, so we can reach this point if a directory is
-			// created and gets a bubble called on it outside of the health loop
-			// before the health loop has been able to set the LastHealthCheckTime.
-			if dirMetadata.AggregateLastHealthCheckTime.IsZero() {
-				dirMetadata.AggregateLastHealthCheckTime = time.Now()
-				// Check for the dependency to disable the LastHealthCheckTime
-				// correction, (LHCT = LastHealthCheckTime).
-				if !r.deps.Disrupt("DisableLHCTCorrection") {
-					err = r.tg.Launch(func() {
-						r.callThreadedBubbleMetadata(dirMetadata.sp)
-					})
-					if err != nil {
-						r.log.Printf("WARN: unable to launch bubble for '%v'", dirMetadata.sp)
-					}
-				}
-			}
+		// Update ttdxdir fields
+		metadata.NumSubDirs++
 
-			// Record Values that compare against files
-			aggregateHealth = dirMetadata.AggregateHealth
-			aggregateStuckHealth = dirMetadata.AggregateStuckHealth
-			aggregateMinRedundancy = dirMetadata.AggregateMinRedundancy
-			aggregateLastHealthCheckTime = dirMetadata.AggregateLastHealthCheckTime
-			aggregateModTime = dirMetadata.AggregateModTime
-			aggregateRemoteHealth = dirMetadata.AggregateRemoteHealth
-
-			// Update aggregate fields.
-			metadata.AggregateNumFiles += dirMetadata.AggregateNumFiles
-			metadata.AggregateNumStuckChunks += dirMetadata.AggregateNumStuckChunks
-			metadata.AggregateNumSubDirs += dirMetadata.AggregateNumSubDirs
-			metadata.AggregateRepairSize += dirMetadata.AggregateRepairSize
-			metadata.AggregateSize += dirMetadata.AggregateSize
-			metadata.AggregateStuckSize += dirMetadata.AggregateStuckSize
-
-			// Update aggregate Skynet fields
-			metadata.AggregateSkynetFiles += dirMetadata.AggregateSkynetFiles
-			metadata.AggregateSkynetSize += dirMetadata.AggregateSkynetSize
-
-			// Add 1 to the AggregateNumSubDirs to account for this subdirectory.
-			metadata.AggregateNumSubDirs++
-
-			// Update ttdxdir fields
-			metadata.NumSubDirs++
-		}
-		// Track the max value of aggregate health values
-		metadata.AggregateHealth = math.Max(metadata.AggregateHealth, aggregateHealth)
-		metadata.AggregateRemoteHealth = math.Max(metadata.AggregateRemoteHealth, aggregateRemoteHealth)
-		metadata.AggregateStuckHealth = math.Max(metadata.AggregateStuckHealth, aggregateStuckHealth)
-		// Track the min value for AggregateMinRedundancy
-		if aggregateMinRedundancy != -1 {
-			metadata.AggregateMinRedundancy = math.Min(metadata.AggregateMinRedundancy, aggregateMinRedundancy)
-		}
-		// Update LastHealthCheckTime
-		if aggregateLastHealthCheckTime.Before(metadata.AggregateLastHealthCheckTime) {
-			metadata.AggregateLastHealthCheckTime = aggregateLastHealthCheckTime
-		}
-		// Update ModTime
-		if aggregateModTime.After(metadata.AggregateModTime) {
-			metadata.AggregateModTime = aggregateModTime
-		}
+		// Update the aggregate fields.
+		updateBubbleAggregates(&metadata, aggregateHealth, aggregateRemoteHealth, aggregateStuckHealth, aggregateMinRedundancy, aggregateLastHealthCheckTime, aggregateModTime, aggregateLastActivity)
 	}
 
 	// Sanity check on ModTime. If mod time is still zero it means there were no
@@ -374,6 +264,297 @@ func (r *Renter) managedCalculateDirectoryMetadata(siaPath modules.TurtleDexPath
 	return metadata, nil
 }
 
+// callAddFileToBubbleMetadata adds the metadata of a file to the metadata of
+// the directory at siaPath which contains the file.
+func (r *Renter) callAddFileToBubbleMetadata(metadata *ttdxdir.Metadata, siaPath modules.TurtleDexPath, bubbledMetadata bubbledTurtleDexFileMetadata, aggregates map[string]BubbleAggregateFunc) {
+	// Aggregate Fields
+	var aggregateHealth, aggregateRemoteHealth, aggregateStuckHealth, aggregateMinRedundancy float64
+	var aggregateLastActivity, aggregateLastHealthCheckTime, aggregateModTime time.Time
+
+	fileTurtleDexPath := bubbledMetadata.sp
+	fileMetadata := bubbledMetadata.bm
+	// If 75% or more of the redundancy is missing, register an alert
+	// for the file.
+	uid := string(fileMetadata.UID)
+	if maxHealth := math.Max(fileMetadata.Health, fileMetadata.StuckHealth); maxHealth >= AlertTurtleDexfileLowRedundancyThreshold {
+		r.staticAlerter.RegisterAlert(modules.AlertIDTurtleDexfileLowRedundancy(uid), AlertMSGTurtleDexfileLowRedundancy,
+			AlertCauseTurtleDexfileLowRedundancy(fileTurtleDexPath, maxHealth, fileMetadata.Redundancy),
+			modules.SeverityWarning)
+	} else {
+		r.staticAlerter.UnregisterAlert(modules.AlertIDTurtleDexfileLowRedundancy(uid))
+	}
+
+	// If the file's LastHealthCheckTime is still zero, set it as now since it
+	// it currently being checked.
+	//
+	// The LastHealthCheckTime is not a field that is initialized when a file
+	// is created, so we can reach this point by one of two ways. If a file is
+	// created in the directory after the health loop has decided it needs to
+	// be bubbled, or a file is created in a directory that gets a bubble
+	// called on it outside of the health loop before the health loop as been
+	// able to set the LastHealthCheckTime.
+	if fileMetadata.LastHealthCheckTime.IsZero() {
+		fileMetadata.LastHealthCheckTime = time.Now()
+	}
+
+	// If the file's LastHealthCheckTime is in the future, the system clock
+	// jumped backwards. Use the current time instead to prevent the directory
+	// from never being picked by the health loop.
+	if current := time.Now(); fileMetadata.LastHealthCheckTime.After(current) {
+		r.log.Printf("WARN: LastHealthCheckTime of file '%v' is in the future (%v > %v), the system clock might have been set back", fileTurtleDexPath, fileMetadata.LastHealthCheckTime, current)
+		fileMetadata.LastHealthCheckTime = current
+	}
+
+	// Update repair fields
+	metadata.AggregateRepairSize += fileMetadata.RepairBytes
+	metadata.AggregateStuckSize += fileMetadata.StuckBytes
+	metadata.RepairSize += fileMetadata.RepairBytes
+	metadata.StuckSize += fileMetadata.StuckBytes
+
+	// Record Values that compare against sub directories
+	aggregateHealth = fileMetadata.Health
+	aggregateStuckHealth = fileMetadata.StuckHealth
+	aggregateMinRedundancy = fileMetadata.Redundancy
+	aggregateLastHealthCheckTime = fileMetadata.LastHealthCheckTime
+	aggregateModTime = fileMetadata.ModTime
+	aggregateLastActivity = fileMetadata.ModTime
+	if !fileMetadata.OnDisk {
+		aggregateRemoteHealth = fileMetadata.Health
+	}
+
+	// Update aggregate fields.
+	metadata.AggregateNumFiles++
+	metadata.AggregateNumStuckChunks += fileMetadata.NumStuckChunks
+	metadata.AggregateSize += fileMetadata.Size
+
+	// Update ttdxdir fields.
+	metadata.Health = math.Max(metadata.Health, fileMetadata.Health)
+	if fileMetadata.LastHealthCheckTime.Before(metadata.LastHealthCheckTime) {
+		metadata.LastHealthCheckTime = fileMetadata.LastHealthCheckTime
+	}
+	if fileMetadata.Redundancy != -1 {
+		metadata.MinRedundancy = math.Min(metadata.MinRedundancy, fileMetadata.Redundancy)
+	}
+	if fileMetadata.ModTime.After(metadata.ModTime) {
+		metadata.ModTime = fileMetadata.ModTime
+	}
+	metadata.NumFiles++
+	metadata.NumStuckChunks += fileMetadata.NumStuckChunks
+	if !fileMetadata.OnDisk {
+		metadata.RemoteHealth = math.Max(metadata.RemoteHealth, fileMetadata.Health)
+	}
+	metadata.Size += fileMetadata.Size
+	metadata.StuckHealth = math.Max(metadata.StuckHealth, fileMetadata.StuckHealth)
+
+	// Update Skynet Fields
+	//
+	// If the current directory is under the Skynet Folder, or the siafile
+	// contains a skylink in the metadata, then we count the file towards the
+	// Skynet Stats.
+	//
+	// For all cases we count the size.
+	//
+	// We only count the file towards the number of files if it is in the
+	// skynet folder and is not extended. We do not count files outside of the
+	// skynet folder because they should be treated as an extended file.
+	isSkynetDir := strings.Contains(siaPath.String(), modules.SkynetFolder.String())
+	isExtended := strings.Contains(fileTurtleDexPath.String(), modules.ExtendedSuffix)
+	hasSkylinks := fileMetadata.NumSkylinks > 0
+	if isSkynetDir || hasSkylinks {
+		metadata.AggregateSkynetSize += fileMetadata.Size
+		metadata.SkynetSize += fileMetadata.Size
+	}
+	if isSkynetDir && !isExtended {
+		metadata.AggregateSkynetFiles++
+		metadata.SkynetFiles++
+	}
+
+	// Update the custom aggregates.
+	for name, fn := range aggregates {
+		addBubbleAggregate(metadata, name, fn(fileTurtleDexPath, fileMetadata))
+	}
+
+	// Update the aggregate fields.
+	updateBubbleAggregates(metadata, aggregateHealth, aggregateRemoteHealth, aggregateStuckHealth, aggregateMinRedundancy, aggregateLastHealthCheckTime, aggregateModTime, aggregateLastActivity)
+}
+
+// updateBubbleAggregates updates the aggregate fields of a directory's
+// metadata which track the worst or best value of all its files and
+// subdirectories.
+func updateBubbleAggregates(metadata *ttdxdir.Metadata, health, remoteHealth, stuckHealth, minRedundancy float64, lastHealthCheckTime, modTime, lastActivity time.Time) {
+	// Track the max value of aggregate health values
+	metadata.AggregateHealth = math.Max(metadata.AggregateHealth, health)
+	metadata.AggregateRemoteHealth = math.Max(metadata.AggregateRemoteHealth, remoteHealth)
+	metadata.AggregateStuckHealth = math.Max(metadata.AggregateStuckHealth, stuckHealth)
+	// Track the min value for AggregateMinRedundancy
+	if minRedundancy != -1 {
+		metadata.AggregateMinRedundancy = math.Min(metadata.AggregateMinRedundancy, minRedundancy)
+	}
+	// Update LastHealthCheckTime
+	if lastHealthCheckTime.Before(metadata.AggregateLastHealthCheckTime) {
+		metadata.AggregateLastHealthCheckTime = lastHealthCheckTime
+	}
+	// Update ModTime
+	if modTime.After(metadata.AggregateModTime) {
+		metadata.AggregateModTime = modTime
+	}
+	// Update LastActivity. Unlike the ModTime it stays zero for empty
+	// directories.
+	if lastActivity.After(metadata.AggregateLastActivity) {
+		metadata.AggregateLastActivity = lastActivity
+	}
+}
+
+// newBubbleMetadata returns the metadata a bubble starts with before the
+// metadata of the directory's files and subdirectories is added.
+func newBubbleMetadata(now time.Time) ttdxdir.Metadata {
+	return ttdxdir.Metadata{
+		AggregateHealth:              ttdxdir.DefaultDirHealth,
+		AggregateLastActivity:        time.Time{},
+		AggregateLastHealthCheckTime: now,
+		AggregateMinRedundancy:       math.MaxFloat64,
+		AggregateModTime:             time.Time{},
+		AggregateNumFiles:            uint64(0),
+		AggregateNumStuckChunks:      uint64(0),
+		AggregateNumSubDirs:          uint64(0),
+		AggregateRemoteHealth:        ttdxdir.DefaultDirHealth,
+		AggregateRepairSize:          uint64(0),
+		AggregateSize:                uint64(0),
+		AggregateStuckHealth:         ttdxdir.DefaultDirHealth,
+		AggregateStuckSize:           uint64(0),
+
+		AggregateSkynetFiles: uint64(0),
+		AggregateSkynetSize:  uint64(0),
+
+		Health:              ttdxdir.DefaultDirHealth,
+		LastHealthCheckTime: now,
+		MinRedundancy:       math.MaxFloat64,
+		ModTime:             time.Time{},
+		NumFiles:            uint64(0),
+		NumStuckChunks:      uint64(0),
+		NumSubDirs:          uint64(0),
+		RemoteHealth:        ttdxdir.DefaultDirHealth,
+		RepairSize:          uint64(0),
+		Size:                uint64(0),
+		StuckHealth:         ttdxdir.DefaultDirHealth,
+		StuckSize:           uint64(0),
+
+		SkynetFiles: uint64(0),
+		SkynetSize:  uint64(0),
+	}
+}
+
+// managedCalculateFilesBubbleMetadata calculates the metadata of the files
+// within the directory at siaPath and returns the directory's metadata
+// containing only those files. The files are split across a bounded pool of
+// workers which each aggregate their files into a partial metadata. The partial
+// metadatas are combined at the end. Since every field is either a sum, a
+// minimum or a maximum, the result doesn't depend on which worker handled which
+// file. Like managedCalculateFileMetadatas, the returned metadata contains all
+// the files that didn't fail even if an error is returned.
+func (r *Renter) managedCalculateFilesBubbleMetadata(siaPath modules.TurtleDexPath, siaPaths []modules.TurtleDexPath, aggregates map[string]BubbleAggregateFunc, now time.Time) (ttdxdir.Metadata, error) {
+	// Get cached offline and goodforrenew maps.
+	hostOfflineMap, hostGoodForRenewMap, _, _ := r.managedRenterContractsAndUtilities()
+
+	// Launch the workers.
+	numWorkers := r.managedBubbleFileWorkers()
+	partials := make([]ttdxdir.Metadata, numWorkers)
+	errs := make([]error, numWorkers)
+	siaPathChan := make(chan modules.TurtleDexPath, numWorkers)
+	var wg sync.WaitGroup
+	for i := range partials {
+		partials[i] = newBubbleMetadata(now)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for fileTurtleDexPath := range siaPathChan {
+				md, err := r.managedCalculateFileMetadata(fileTurtleDexPath, hostOfflineMap, hostGoodForRenewMap)
+				if errors.Contains(err, ErrSkylinkBlocked) {
+					// If the fileNode is blocked we ignore the error and continue.
+					continue
+				}
+				if err != nil {
+					errs[i] = errors.Compose(errs[i], err)
+					continue
+				}
+				r.callAddFileToBubbleMetadata(&partials[i], siaPath, md, aggregates)
+			}
+		}(i)
+	}
+	for _, fileTurtleDexPath := range siaPaths {
+		siaPathChan <- fileTurtleDexPath
+	}
+	close(siaPathChan)
+	wg.Wait()
+
+	// Combine the partial metadatas.
+	metadata := newBubbleMetadata(now)
+	for _, partial := range partials {
+		combineBubbleMetadata(&metadata, partial)
+	}
+	return metadata, errors.Compose(errs...)
+}
+
+// managedBubbleFileWorkers returns the number of workers used to calculate the
+// metadata of the files within a directory during a bubble.
+func (r *Renter) managedBubbleFileWorkers() int {
+	return int(atomic.LoadUint64(&r.atomicBubbleFileWorkers))
+}
+
+// SetBubbleFileWorkers sets the number of workers used to calculate the
+// metadata of the files within a single directory during a bubble.
+func (r *Renter) SetBubbleFileWorkers(workers int) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if workers < 1 {
+		return fmt.Errorf("number of bubble file workers must be at least 1 but was %v", workers)
+	}
+	atomic.StoreUint64(&r.atomicBubbleFileWorkers, uint64(workers))
+	return nil
+}
+
+// combineBubbleMetadata adds the partial metadata of a bubble to the metadata
+// of a directory.
+func combineBubbleMetadata(metadata *ttdxdir.Metadata, partial ttdxdir.Metadata) {
+	// Update aggregate fields.
+	metadata.AggregateNumFiles += partial.AggregateNumFiles
+	metadata.AggregateNumStuckChunks += partial.AggregateNumStuckChunks
+	metadata.AggregateNumSubDirs += partial.AggregateNumSubDirs
+	metadata.AggregateRepairSize += partial.AggregateRepairSize
+	metadata.AggregateSize += partial.AggregateSize
+	metadata.AggregateStuckSize += partial.AggregateStuckSize
+	metadata.AggregateSkynetFiles += partial.AggregateSkynetFiles
+	metadata.AggregateSkynetSize += partial.AggregateSkynetSize
+	updateBubbleAggregates(metadata, partial.AggregateHealth, partial.AggregateRemoteHealth, partial.AggregateStuckHealth, partial.AggregateMinRedundancy, partial.AggregateLastHealthCheckTime, partial.AggregateModTime, partial.AggregateLastActivity)
+
+	// Update ttdxdir fields.
+	metadata.Health = math.Max(metadata.Health, partial.Health)
+	if partial.LastHealthCheckTime.Before(metadata.LastHealthCheckTime) {
+		metadata.LastHealthCheckTime = partial.LastHealthCheckTime
+	}
+	metadata.MinRedundancy = math.Min(metadata.MinRedundancy, partial.MinRedundancy)
+	if partial.ModTime.After(metadata.ModTime) {
+		metadata.ModTime = partial.ModTime
+	}
+	metadata.NumFiles += partial.NumFiles
+	metadata.NumStuckChunks += partial.NumStuckChunks
+	metadata.NumSubDirs += partial.NumSubDirs
+	metadata.RemoteHealth = math.Max(metadata.RemoteHealth, partial.RemoteHealth)
+	metadata.RepairSize += partial.RepairSize
+	metadata.Size += partial.Size
+	metadata.StuckHealth = math.Max(metadata.StuckHealth, partial.StuckHealth)
+	metadata.StuckSize += partial.StuckSize
+	metadata.SkynetFiles += partial.SkynetFiles
+	metadata.SkynetSize += partial.SkynetSize
+
+	// Update the custom aggregates.
+	for name, value := range partial.AggregateCustom {
+		addBubbleAggregate(metadata, name, value)
+	}
+}
+
 // managedCalculateFileMetadata calculates and returns the necessary metadata
 // information of a siafiles that needs to be bubbled.
 func (r *Renter) managedCalculateFileMetadata(siaPath modules.TurtleDexPath, hostOfflineMap, hostGoodForRenewMap map[string]bool) (bubbledTurtleDexFileMetadata, error) {
@@ -498,9 +679,11 @@ func (r *Renter) managedCompleteBubbleUpdate(siaPath modules.TurtleDexPath) {
 	siaPathStr := siaPath.String()
 	status, exists := r.bubbleUpdates[siaPathStr]
 
-	// If the status is 'bubbleActive', delete the status and return.
+	// If the status is 'bubbleActive', delete the status, release the
+	// waiters and return.
 	if status == bubbleActive {
 		delete(r.bubbleUpdates, siaPathStr)
+		r.closeBubbleWaiters(siaPathStr)
 		return
 	}
 	// If the status is not 'bubbleActive', and the status is also not
@@ -509,6 +692,7 @@ func (r *Renter) managedCompleteBubbleUpdate(siaPath modules.TurtleDexPath) {
 	if status != bubblePending {
 		build.Critical("invalid bubble status", status, exists)
 		delete(r.bubbleUpdates, siaPathStr) // Attempt to reset the corrupted state.
+		r.closeBubbleWaiters(siaPathStr)
 		return
 	}
 	// The status is bubblePending, switch the status to bubbleActive.
@@ -526,6 +710,33 @@ func (r *Renter) managedCompleteBubbleUpdate(siaPath modules.TurtleDexPath) {
 	}()
 }
 
+// managedBubbleDone returns a channel which is closed once siaPath has no
+// active or pending bubble anymore. That includes the bubbles which were
+// coalesced into the pending bubble of an active one. If siaPath isn't being
+// bubbled, the returned channel is already closed.
+func (r *Renter) managedBubbleDone(siaPath modules.TurtleDexPath) <-chan struct{} {
+	r.bubbleUpdatesMu.Lock()
+	defer r.bubbleUpdatesMu.Unlock()
+	done := make(chan struct{})
+	siaPathStr := siaPath.String()
+	if _, exists := r.bubbleUpdates[siaPathStr]; !exists {
+		close(done)
+		return done
+	}
+	r.bubbleWaiters[siaPathStr] = append(r.bubbleWaiters[siaPathStr], done)
+	return done
+}
+
+// closeBubbleWaiters closes and removes the channels returned by
+// managedBubbleDone for siaPathStr. It must be called while holding
+// bubbleUpdatesMu.
+func (r *Renter) closeBubbleWaiters(siaPathStr string) {
+	for _, done := range r.bubbleWaiters[siaPathStr] {
+		close(done)
+	}
+	delete(r.bubbleWaiters, siaPathStr)
+}
+
 // managedDirectoryMetadatas returns all the metadatas of the TurtleDexDirs for the
 // provided siaPaths
 func (r *Renter) managedDirectoryMetadatas(siaPaths []modules.TurtleDexPath) ([]bubbledTurtleDexDirMetadata, error) {
@@ -647,6 +858,16 @@ func (r *Renter) managedUpdateLastHealthCheckTime(siaPath modules.TurtleDexPath)
 	return errors.Compose(err, entry.Close())
 }
 
+// bubbleDurationEMA returns the updated exponential moving average of a
+// directory's bubble duration after adding a new sample.
+func bubbleDurationEMA(avg, sample time.Duration) time.Duration {
+	// The first sample initializes the average.
+	if avg == 0 {
+		return sample
+	}
+	return time.Duration(bubbleDurationDecay*float64(avg) + (1-bubbleDurationDecay)*float64(sample))
+}
+
 // callThreadedBubbleMetadata is the thread safe method used to call
 // managedBubbleMetadata when the call does not need to be blocking
 func (r *Renter) callThreadedBubbleMetadata(siaPath modules.TurtleDexPath) {
@@ -656,12 +877,22 @@ func (r *Renter) callThreadedBubbleMetadata(siaPath modules.TurtleDexPath) {
 	defer r.tg.Done()
 	if err := r.managedBubbleMetadata(siaPath); err != nil {
 		r.log.Debugln("WARN: error with bubbling metadata:", err)
+		r.staticStrictBubbles.callRecord(siaPath, err)
+		if r.deps.Disrupt("PanicOnBubbleError") {
+			panic(fmt.Sprintf("bubble of '%v' failed: %v", siaPath, err))
+		}
 	}
 }
 
 // managedPerformBubbleMetadata will bubble the metadata without checking the
 // bubble preparation.
 func (r *Renter) managedPerformBubbleMetadata(siaPath modules.TurtleDexPath) (err error) {
+	start := time.Now()
+	defer func() {
+		r.staticRefreshMetrics.callRecordBubble(time.Since(start), err)
+	}()
+	r.staticDirETags.callInvalidate(siaPath)
+
 	// Make sure we call callThreadedBubbleMetadata on the parent once we are
 	// done.
 	defer func() error {
@@ -697,7 +928,16 @@ func (r *Renter) managedPerformBubbleMetadata(siaPath modules.TurtleDexPath) (er
 		defer func() {
 			err = errors.Compose(err, siaDir.Close())
 		}()
-		err = siaDir.UpdateBubbledMetadata(metadata)
+		// Update the moving average of the bubble duration.
+		var oldMetadata ttdxdir.Metadata
+		oldMetadata, err = siaDir.Metadata()
+		if err == nil {
+			metadata.BubbleDuration = bubbleDurationEMA(oldMetadata.BubbleDuration, time.Since(start))
+			err = siaDir.UpdateBubbledMetadata(metadata)
+		}
+		if err == nil {
+			r.callRecordRefreshEvent(refreshEventBubbled, siaPath)
+		}
 		if err != nil {
 			e := fmt.Sprintf("could not update the metadata of the directory %v", siaPath.String())
 			err = errors.AddContext(err, e)
@@ -730,6 +970,11 @@ func (r *Renter) managedPerformBubbleMetadata(siaPath modules.TurtleDexPath) (er
 // and updates the ttdxdir metadata on disk then calls callThreadedBubbleMetadata
 // on the parent directory so that it is only blocking for the current directory
 func (r *Renter) managedBubbleMetadata(siaPath modules.TurtleDexPath) error {
+	// Defer the bubble if the directory is within a suppressed subtree.
+	if r.staticRefreshSuppressor.callDefer(siaPath) {
+		return nil
+	}
+
 	// Check if bubble is needed
 	proceedWithBubble := r.managedPrepareBubble(siaPath)
 	if !proceedWithBubble {
@@ -739,3 +984,40 @@ func (r *Renter) managedBubbleMetadata(siaPath modules.TurtleDexPath) error {
 	}
 	return r.managedPerformBubbleMetadata(siaPath)
 }
+
+// managedBubbleMetadataRetry calls managedBubbleMetadata and retries it up to
+// maxRetries times if it fails with a transient error. The backoff doubles
+// after every attempt. Permanent errors are returned right away.
+func (r *Renter) managedBubbleMetadataRetry(siaPath modules.TurtleDexPath, maxRetries int, backoff time.Duration) error {
+	return r.managedRetryBubble(context.Background(), r.managedBubbleMetadata, siaPath, maxRetries, backoff)
+}
+
+// managedRetryBubble calls bubble for siaPath and retries it like
+// managedBubbleMetadataRetry. It stops waiting for the next attempt once ctx
+// is cancelled or the renter is stopped.
+func (r *Renter) managedRetryBubble(ctx context.Context, bubble func(modules.TurtleDexPath) error, siaPath modules.TurtleDexPath, maxRetries int, backoff time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		err := bubble(siaPath)
+		if err == nil || attempt >= maxRetries || isPermanentBubbleError(err) {
+			return err
+		}
+		select {
+		case <-time.After(backoff << uint(attempt)):
+		case <-ctx.Done():
+			return errors.Compose(err, ctx.Err())
+		case <-r.tg.StopChan():
+			return errors.Compose(err, threadgroup.ErrStopped)
+		}
+	}
+}
+
+// isPermanentBubbleError returns true if a bubble which failed with err won't
+// succeed when retried, e.g. because the directory doesn't exist. All other
+// errors are considered transient since they are usually caused by disk IO.
+func isPermanentBubbleError(err error) bool {
+	return os.IsNotExist(err) ||
+		errors.Contains(err, filesystem.ErrNotExist) ||
+		errors.Contains(err, errNotADirectory) ||
+		errors.Contains(err, modules.ErrInvalidTurtleDexPath) ||
+		errors.Contains(err, threadgroup.ErrStopped)
+}
@@ -7,10 +7,12 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/turtledex/TurtleDexCore/build"
 	"github.com/turtledex/TurtleDexCore/crypto"
 	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
 	"github.com/turtledex/TurtleDexCore/persist"
 	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
 )
@@ -74,6 +76,56 @@ func BenchmarkBubbleMetadata(b *testing.B) {
 	}
 }
 
+// BenchmarkBubbleMetadataWideDir runs a benchmark on the bubble metadata
+// method for a directory with many files using different numbers of workers.
+func BenchmarkBubbleMetadataWideDir(b *testing.B) {
+	r, err := newBenchmarkRenterWithDependency(b.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	// Create a directory with many files.
+	dirTurtleDexPath, err := modules.NewTurtleDexPath("wide")
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = r.CreateDir(dirTurtleDexPath, modules.DefaultDirPerm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	rsc, _ := modules.NewRSCode(1, 1)
+	for i := 0; i < 1000; i++ {
+		fileTurtleDexPath, err := dirTurtleDexPath.Join(fmt.Sprintf("file%v", i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = r.staticFileSystem.NewTurtleDexFile(fileTurtleDexPath, "", rsc, crypto.GenerateTurtleDexKey(crypto.RandomCipherType()), 100, persist.DefaultDiskPermissionsTest, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, workers := range []int{1, 4, numBubbleWorkerThreads} {
+		b.Run(fmt.Sprintf("Workers%v", workers), func(b *testing.B) {
+			if err := r.SetBubbleFileWorkers(workers); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				err := r.managedBubbleMetadata(dirTurtleDexPath)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // newBenchmarkRenterWithDependency creates a renter to be used for benchmarks
 // on renter methods
 func newBenchmarkRenterWithDependency(name string, deps modules.Dependencies) (*Renter, error) {
@@ -208,3 +260,93 @@ func TestDirectoryMetadatas(t *testing.T) {
 		t.Fatal("different metadatas")
 	}
 }
+
+// TestBubbleDurationEMA probes bubbleDurationEMA.
+func TestBubbleDurationEMA(t *testing.T) {
+	t.Parallel()
+
+	// The first sample should initialize the average.
+	avg := bubbleDurationEMA(0, time.Second)
+	if avg != time.Second {
+		t.Fatalf("expected %v but got %v", time.Second, avg)
+	}
+	// The same sample shouldn't change the average.
+	avg = bubbleDurationEMA(avg, time.Second)
+	if avg != time.Second {
+		t.Fatalf("expected %v but got %v", time.Second, avg)
+	}
+	// A slower bubble should increase the average, but not all the way.
+	avg = bubbleDurationEMA(avg, 11*time.Second)
+	expected := time.Duration(bubbleDurationDecay*float64(time.Second) + (1-bubbleDurationDecay)*float64(11*time.Second))
+	if avg != expected {
+		t.Fatalf("expected %v but got %v", expected, avg)
+	}
+	if avg <= time.Second || avg >= 11*time.Second {
+		t.Fatal("average should be between the samples", avg)
+	}
+}
+
+// TestBubbleFileWorkers makes sure that the metadata calculated for a directory
+// doesn't depend on the number of workers used for its files.
+func TestBubbleFileWorkers(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create a directory with some files and a subdirectory.
+	dir := newTurtleDexPath("workers")
+	for i := 0; i < 25; i++ {
+		f, err := r.createRenterTestFile(newTurtleDexPath(fmt.Sprintf("workers/file%v", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.CreateDir(newTurtleDexPath("workers/sub"), modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid number of workers should be rejected.
+	if err := r.SetBubbleFileWorkers(0); err == nil {
+		t.Fatal("expected error for 0 workers")
+	}
+
+	// Calculate the metadata with different numbers of workers and compare
+	// the fields that don't depend on the current time.
+	var expected ttdxdir.Metadata
+	for i, workers := range []int{1, 3, 25, 40} {
+		if err := r.SetBubbleFileWorkers(workers); err != nil {
+			t.Fatal(err)
+		}
+		md, err := r.managedCalculateDirectoryMetadata(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		md.AggregateLastHealthCheckTime = time.Time{}
+		md.LastHealthCheckTime = time.Time{}
+		md.AggregateModTime = time.Time{}
+		md.ModTime = time.Time{}
+		if md.NumFiles != 25 || md.NumSubDirs != 1 {
+			t.Fatalf("wrong counts with %v workers: %v files %v dirs", workers, md.NumFiles, md.NumSubDirs)
+		}
+		if i == 0 {
+			expected = md
+		} else if !reflect.DeepEqual(md, expected) {
+			t.Fatalf("metadata with %v workers doesn't match\n%+v\n%+v", workers, md, expected)
+		}
+	}
+}
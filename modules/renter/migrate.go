@@ -0,0 +1,151 @@
+package renter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
+	"github.com/turtledex/TurtleDexCore/persist"
+	"github.com/turtledex/errors"
+)
+
+// migrate.go contains the migration of the metadata of all directories to a
+// different layout version. Every directory is rewritten through the
+// writeaheadlog, so each individual rewrite is atomic. The layout version file
+// is only updated once every directory was migrated. If the migration is
+// interrupted, the version file still contains the old version and running the
+// migration again picks up where it left off since directories which are
+// already at the target version are skipped.
+
+const (
+	// metadataLayoutFile is the name of the file that contains the layout
+	// version of the directory metadata.
+	metadataLayoutFile = "metadatalayout.json"
+
+	// migrateMetadataLogInterval is the number of directories after which the
+	// progress of a migration is logged.
+	migrateMetadataLogInterval = 1000
+)
+
+var (
+	// metadataLayoutMetadata is the header of the metadata layout file.
+	metadataLayoutMetadata = persist.Metadata{
+		Header:  "Renter Metadata Layout",
+		Version: "1.0",
+	}
+)
+
+type (
+	// metadataLayout is the content of the metadata layout file.
+	metadataLayout struct {
+		Version int `json:"version"`
+	}
+)
+
+// managedMetadataLayoutVersion returns the layout version from the metadata
+// layout file. Renters without a layout file use the first layout.
+func (r *Renter) managedMetadataLayoutVersion() (int, error) {
+	var layout metadataLayout
+	err := persist.LoadJSON(metadataLayoutMetadata, &layout, filepath.Join(r.persistDir, metadataLayoutFile))
+	if os.IsNotExist(err) {
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return layout.Version, nil
+}
+
+// MigrateMetadata rewrites the metadata of all directories using the layout
+// of targetVersion. Directories that already use the target version are
+// skipped. The layout version file is updated once all directories are
+// migrated. Cancelling ctx stops the migration without updating the layout
+// version file.
+func (r *Renter) MigrateMetadata(targetVersion int, ctx context.Context) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	if targetVersion < 1 || targetVersion > ttdxdir.MetadataVersion {
+		return fmt.Errorf("unsupported metadata version %v, supported versions are 1 to %v", targetVersion, ttdxdir.MetadataVersion)
+	}
+
+	// Get all the directories.
+	var dirs []modules.TurtleDexPath
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		dirs = append(dirs, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return err
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	release()
+	if err != nil {
+		return errors.AddContext(err, "failed to list directories")
+	}
+
+	// Migrate them.
+	r.log.Printf("Migrating the metadata of %v directories to version %v", len(dirs), targetVersion)
+	var migrated int
+	for i, siaPath := range dirs {
+		select {
+		case <-ctx.Done():
+			return errors.AddContext(ctx.Err(), fmt.Sprintf("migration interrupted after %v of %v directories", i, len(dirs)))
+		case <-r.tg.StopChan():
+			return errors.New("renter shutdown before the migration finished")
+		default:
+		}
+		changed, err := r.managedMigrateDirMetadata(siaPath, targetVersion)
+		if err != nil {
+			return errors.AddContext(err, fmt.Sprintf("failed to migrate '%v'", siaPath))
+		}
+		if changed {
+			migrated++
+		}
+		if (i+1)%migrateMetadataLogInterval == 0 {
+			r.log.Printf("Migrated %v of %v directories", i+1, len(dirs))
+		}
+	}
+	r.log.Printf("Migrated the metadata of %v directories, %v were already at version %v", migrated, len(dirs)-migrated, targetVersion)
+
+	// Update the layout version.
+	layout := metadataLayout{Version: targetVersion}
+	err = persist.SaveJSON(metadataLayoutMetadata, layout, filepath.Join(r.persistDir, metadataLayoutFile))
+	if err != nil {
+		return errors.AddContext(err, "failed to update metadata layout version")
+	}
+	return nil
+}
+
+// managedMigrateDirMetadata rewrites the metadata of a single directory using
+// the layout of targetVersion. It returns false if the directory already uses
+// the target version.
+func (r *Renter) managedMigrateDirMetadata(siaPath modules.TurtleDexPath, targetVersion int) (_ bool, err error) {
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(siaPath)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err = errors.Compose(err, dir.Close())
+	}()
+	md, err := dir.Metadata()
+	if err != nil {
+		return false, err
+	}
+	if md.Version == ttdxdir.FormatMetadataVersion(targetVersion) {
+		return false, nil
+	}
+	if _, err := ttdxdir.ParseMetadataVersion(md.Version); err != nil {
+		return false, err
+	}
+	md.Version = ttdxdir.FormatMetadataVersion(targetVersion)
+	return true, dir.UpdateMetadata(md)
+}
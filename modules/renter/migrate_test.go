@@ -0,0 +1,81 @@
+package renter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/ttdxdir"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestMigrateMetadata probes MigrateMetadata.
+func TestMigrateMetadata(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	dirs := []string{"a", "a/b", "c"}
+	for _, dir := range dirs {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layoutPath := filepath.Join(r.persistDir, metadataLayoutFile)
+
+	// Unsupported versions are rejected.
+	if err := r.MigrateMetadata(ttdxdir.MetadataVersion+1, context.Background()); err == nil {
+		t.Fatal("expected unsupported version to fail")
+	}
+
+	// A cancelled migration doesn't update the layout version file.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.MigrateMetadata(ttdxdir.MetadataVersion, ctx); err == nil {
+		t.Fatal("expected cancelled migration to fail")
+	}
+	if _, err := os.Stat(layoutPath); !os.IsNotExist(err) {
+		t.Fatal("layout file shouldn't exist", err)
+	}
+
+	// Migrate all dirs. Migrating twice is fine.
+	for i := 0; i < 2; i++ {
+		if err := r.MigrateMetadata(ttdxdir.MetadataVersion, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range append(dirs, "") {
+		sp := modules.RootTurtleDexPath()
+		if dir != "" {
+			sp = newTurtleDexPath(dir)
+		}
+		md, err := r.managedDirectoryMetadata(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Version != ttdxdir.FormatMetadataVersion(ttdxdir.MetadataVersion) {
+			t.Fatalf("'%v' has version '%v'", sp, md.Version)
+		}
+	}
+	v, err := r.managedMetadataLayoutVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != ttdxdir.MetadataVersion {
+		t.Fatal("wrong layout version", v)
+	}
+}
@@ -3,6 +3,7 @@ package renter
 import (
 	"os"
 	"path/filepath"
+	"sync/atomic"
 
 	"github.com/turtledex/errors"
 	"github.com/turtledex/writeaheadlog"
@@ -61,6 +62,11 @@ type (
 		MaxUploadSpeed   int64
 		UploadedBackups  []modules.UploadedBackup
 		SyncedContracts  []types.FileContractID
+
+		// PersistPendingRefreshes indicates whether the paths added to a
+		// persistent uniqueRefreshPaths are recorded in the refresh event
+		// log and refreshed on startup. It is disabled by default.
+		PersistPendingRefreshes bool
 	}
 )
 
@@ -109,6 +115,12 @@ func (r *Renter) managedLoadSettings() error {
 		return err
 	}
 
+	// The setting is read on every add to a uniqueRefreshPaths, so it is
+	// mirrored in an atomic.
+	if r.persist.PersistPendingRefreshes {
+		atomic.StoreUint32(&r.atomicPersistPendingRefreshes, 1)
+	}
+
 	// Set the bandwidth limits on the contractor, which was already initialized
 	// without bandwidth limits.
 	return r.setBandwidthLimits(r.persist.MaxDownloadSpeed, r.persist.MaxUploadSpeed)
@@ -0,0 +1,243 @@
+package renter
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
+	"github.com/turtledex/errors"
+	"github.com/turtledex/fastrand"
+)
+
+// publish.go contains the code for replacing the contents of a directory with
+// a new set of files in a single swap.
+//
+// The new contents are staged within a temporary sibling of the directory
+// first. Once all files are in place, the old directory is renamed to a
+// temporary backup sibling, the staging directory is renamed to the path of
+// the old directory and the backup is deleted. Since a directory rename moves
+// the whole subtree at once, readers see either the old or the new contents
+// but never a mix of both. While the two renames are in progress the directory
+// briefly doesn't exist at all.
+
+const (
+	// publishStagingDirSuffix is the suffix of the temporary directory the new
+	// contents of a directory are staged in by PublishDir.
+	publishStagingDirSuffix = "_publish_"
+
+	// publishBackupDirSuffix is the suffix of the temporary directory the old
+	// contents of a directory are moved to by PublishDir.
+	publishBackupDirSuffix = "_unpublished_"
+)
+
+type (
+	// SourceRef references the source of a file published by PublishDir.
+	SourceRef struct {
+		// TurtleDexPath is the path of an existing file which is moved to its
+		// new location. The file may be part of the directory that is being
+		// replaced.
+		TurtleDexPath modules.TurtleDexPath
+	}
+)
+
+// PublishDir replaces the contents of a directory with newContents. The keys
+// of newContents are the paths of the new files relative to siaPath and the
+// values reference the files that are moved there. If the directory doesn't
+// exist yet, it is created. None of the new files may exceed the maximum
+// depth. The parent of the directory is bubbled once the swap is complete.
+func (r *Renter) PublishDir(siaPath modules.TurtleDexPath, newContents map[modules.TurtleDexPath]SourceRef) (err error) {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	if siaPath.IsRoot() {
+		return errors.New("cannot publish to the root directory")
+	}
+	if err := siaPath.Validate(false); err != nil {
+		return err
+	}
+	if err := siaPath.ValidateDepth(); err != nil {
+		return err
+	}
+	parent, err := siaPath.Dir()
+	if err != nil {
+		return err
+	}
+	for relPath, src := range newContents {
+		if relPath.IsRoot() {
+			return errors.New("published files need a non-empty relative path")
+		}
+		// The staging dir has the same depth as siaPath, so checking the
+		// final destination covers the staged file as well.
+		dst, err := relPath.Rebase(modules.RootTurtleDexPath(), siaPath)
+		if err != nil {
+			return err
+		}
+		if err := dst.ValidateDepth(); err != nil {
+			return err
+		}
+		exists, err := r.staticFileSystem.FileExists(src.TurtleDexPath)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errors.AddContext(filesystem.ErrNotExist, fmt.Sprintf("source '%v' of '%v'", src.TurtleDexPath, relPath))
+		}
+	}
+	exists, err := r.staticFileSystem.DirExists(siaPath)
+	if err != nil {
+		return err
+	}
+	if err := r.managedCheckPublishQuota(siaPath, newContents, exists); err != nil {
+		return err
+	}
+
+	// Bubble the directory and the directories the sources are moved from.
+	urp := r.newUniqueRefreshPaths()
+	defer urp.callRefreshAll()
+
+	// Stage the new contents. If staging fails, the files are moved back.
+	suffix := hex.EncodeToString(fastrand.Bytes(8))
+	staging, err := parent.Join(siaPath.Name() + publishStagingDirSuffix + suffix)
+	if err != nil {
+		return err
+	}
+	if err := r.staticFileSystem.NewTurtleDexDir(staging, modules.DefaultDirPerm); err != nil {
+		return errors.AddContext(err, "failed to create staging dir")
+	}
+	var undo []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			if rbErr := undo[i](); rbErr != nil {
+				err = errors.Compose(err, errors.AddContext(rbErr, "failed to roll back publish"))
+			}
+		}
+		err = errors.Compose(err, r.staticFileSystem.DeleteDir(staging))
+	}()
+	for relPath, src := range newContents {
+		dst, err := relPath.Rebase(modules.RootTurtleDexPath(), staging)
+		if err != nil {
+			return err
+		}
+		if err := r.staticFileSystem.RenameFile(src.TurtleDexPath, dst); err != nil {
+			return errors.AddContext(err, fmt.Sprintf("failed to stage '%v'", src.TurtleDexPath))
+		}
+		oldPath := src.TurtleDexPath
+		undo = append(undo, func() error {
+			return r.staticFileSystem.RenameFile(dst, oldPath)
+		})
+		// Sources within the replaced directory are covered by bubbling the
+		// directory itself.
+		if strings.HasPrefix(oldPath.String(), siaPath.String()+"/") {
+			continue
+		}
+		if err := r.callAddParentDir(urp, oldPath); err != nil {
+			return err
+		}
+	}
+
+	// Swap the staging dir into place.
+	var backup modules.TurtleDexPath
+	if exists {
+		backup, err = parent.Join(siaPath.Name() + publishBackupDirSuffix + suffix)
+		if err != nil {
+			return err
+		}
+		if err := r.staticFileSystem.RenameDir(siaPath, backup); err != nil {
+			return errors.AddContext(err, "failed to move old contents out of the way")
+		}
+	}
+	if err := r.staticFileSystem.RenameDir(staging, siaPath); err != nil {
+		err = errors.AddContext(err, "failed to move new contents into place")
+		if exists {
+			err = errors.Compose(err, r.staticFileSystem.RenameDir(backup, siaPath))
+		}
+		return err
+	}
+
+	// The new contents are published and can't be rolled back anymore. Any
+	// errors from here on are only logged since they don't affect the result.
+	if err := urp.callAdd(siaPath); err != nil {
+		r.log.Printf("WARN: failed to queue bubble for '%v': %v", siaPath, err)
+	}
+	for relPath, src := range newContents {
+		dst, err := relPath.Rebase(modules.RootTurtleDexPath(), siaPath)
+		if err != nil {
+			r.log.Printf("WARN: failed to record publish of '%v' in the audit log: %v", src.TurtleDexPath, err)
+			continue
+		}
+		r.callRecordFileOp(fileOpModified, dst, src.TurtleDexPath)
+	}
+	if exists {
+		// The old files which weren't republished are gone from siaPath.
+		unpublished, err := r.managedAuditedFiles(backup)
+		if err != nil {
+			r.log.Printf("WARN: failed to record unpublished contents in the audit log: %v", err)
+		}
+		for _, sp := range unpublished {
+			if oldPath, err := sp.Rebase(backup, siaPath); err == nil {
+				r.callRecordFileOp(fileOpDeleted, oldPath, modules.TurtleDexPath{})
+			}
+		}
+		if err := r.staticFileSystem.DeleteDir(backup); err != nil {
+			r.log.Printf("WARN: failed to delete unpublished contents at '%v': %v", backup, err)
+		}
+	}
+	return nil
+}
+
+// managedCheckPublishQuota checks whether publishing newContents at siaPath
+// would exceed any quota. The sources are moved into siaPath and the files
+// that were within siaPath before and aren't republished are removed.
+func (r *Renter) managedCheckPublishQuota(siaPath modules.TurtleDexPath, newContents map[modules.TurtleDexPath]SourceRef, exists bool) error {
+	if len(r.staticQuotaPolicy.callRules()) == 0 {
+		return nil
+	}
+	qc := make(quotaChanges)
+	sources := make(map[modules.TurtleDexPath]struct{}, len(newContents))
+	for relPath, src := range newContents {
+		dst, err := relPath.Rebase(modules.RootTurtleDexPath(), siaPath)
+		if err != nil {
+			return err
+		}
+		if err := r.managedQuotaMove(qc, src.TurtleDexPath, dst); err != nil {
+			return err
+		}
+		sources[src.TurtleDexPath] = struct{}{}
+	}
+	if !exists {
+		return r.managedCheckQuotaChanges(qc)
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return err
+	}
+	var old []modules.FileInfo
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		mu.Lock()
+		old = append(old, fi)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, func(modules.DirectoryInfo) {})
+	release()
+	if err != nil {
+		return errors.AddContext(err, "failed to list the files to unpublish")
+	}
+	for _, fi := range old {
+		if _, isSource := sources[fi.TurtleDexPath]; isSource {
+			continue
+		}
+		if err := qc.remove(fi.TurtleDexPath, fi.Filesize); err != nil {
+			return err
+		}
+	}
+	return r.managedCheckQuotaChanges(qc)
+}
@@ -0,0 +1,91 @@
+package renter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestPublishDir probes PublishDir.
+func TestPublishDir(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create the old contents and the files to publish.
+	for _, sp := range []string{"site/old", "site/keep", "upload/x", "upload/y"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(sp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileExists := func(sp string) bool {
+		exists, err := r.staticFileSystem.FileExists(newTurtleDexPath(sp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+	site := newTurtleDexPath("site")
+
+	// Publishing a missing source should fail without changing anything.
+	err = r.PublishDir(site, map[modules.TurtleDexPath]SourceRef{
+		newTurtleDexPath("x"):       {TurtleDexPath: newTurtleDexPath("upload/x")},
+		newTurtleDexPath("missing"): {TurtleDexPath: newTurtleDexPath("upload/missing")},
+	})
+	if err == nil {
+		t.Fatal("expected publish to fail")
+	}
+	if !fileExists("site/old") || !fileExists("upload/x") {
+		t.Fatal("failed publish shouldn't change anything")
+	}
+
+	// Publish the new contents. One of the old files is kept.
+	err = r.PublishDir(site, map[modules.TurtleDexPath]SourceRef{
+		newTurtleDexPath("x"):     {TurtleDexPath: newTurtleDexPath("upload/x")},
+		newTurtleDexPath("sub/y"): {TurtleDexPath: newTurtleDexPath("upload/y")},
+		newTurtleDexPath("keep"):  {TurtleDexPath: newTurtleDexPath("site/keep")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sp := range []string{"site/x", "site/sub/y", "site/keep"} {
+		if !fileExists(sp) {
+			t.Fatal("missing published file", sp)
+		}
+	}
+	for _, sp := range []string{"site/old", "upload/x", "upload/y"} {
+		if fileExists(sp) {
+			t.Fatal("file should have been moved or removed", sp)
+		}
+	}
+
+	// No temporary dirs should be left behind.
+	dis, err := r.DirList(modules.RootTurtleDexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, di := range dis {
+		name := di.TurtleDexPath.Name()
+		if strings.Contains(name, publishStagingDirSuffix) || strings.Contains(name, publishBackupDirSuffix) {
+			t.Fatal("temporary dir left behind", di.TurtleDexPath)
+		}
+	}
+}
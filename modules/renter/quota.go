@@ -0,0 +1,276 @@
+package renter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// quota.go contains the directory quotas. The quotas are declared in a policy
+// file within the renter's persist directory which contains a JSON array of
+// rules. Every rule applies to all directories whose path matches its pattern,
+// using the syntax of path.Match. The root directory has the empty path. An
+// upload, rename or move is rejected if it would push the size or the number
+// of files of any of the affected directories beyond the limit of a matching
+// rule. A file that is replaced or moved away is subtracted from its
+// ancestors first. Directories whose usage doesn't grow are never rejected,
+// even if they already exceed their quota. The check uses the aggregate values
+// of the directories' metadata, so it's only as accurate as the last bubble.
+//
+// Example policy:
+//
+//   [
+//     {"pattern": "backups/*", "maxbytes": 1099511627776},
+//     {"pattern": "", "maxfiles": 1000000}
+//   ]
+
+const (
+	// quotaPolicyFile is the name of the file that contains the quota policy.
+	quotaPolicyFile = "quotas.json"
+)
+
+var (
+	// ErrQuotaExceeded is returned if an upload, rename or move would exceed
+	// a quota.
+	ErrQuotaExceeded = errors.New("operation exceeds directory quota")
+)
+
+type (
+	// QuotaRule limits the size and the number of files of all directories
+	// that match its pattern. A limit of 0 means unlimited.
+	QuotaRule struct {
+		Pattern  string `json:"pattern"`
+		MaxBytes uint64 `json:"maxbytes"`
+		MaxFiles uint64 `json:"maxfiles"`
+	}
+
+	// quotaChange is the change of the size and the number of files of a
+	// directory.
+	quotaChange struct {
+		bytes int64
+		files int64
+	}
+
+	// quotaChanges contains the quotaChanges of all the directories affected
+	// by an operation.
+	quotaChanges map[modules.TurtleDexPath]quotaChange
+
+	// quotaPolicy contains the currently loaded quota rules.
+	quotaPolicy struct {
+		rules []QuotaRule
+		mu    sync.Mutex
+	}
+)
+
+// String implements the fmt.Stringer interface.
+func (qr QuotaRule) String() string {
+	return fmt.Sprintf("pattern '%v' (max %v bytes, max %v files)", qr.Pattern, qr.MaxBytes, qr.MaxFiles)
+}
+
+// loadQuotaRules reads the quota rules from the policy file at filename. A
+// missing file is treated as an empty policy.
+func loadQuotaRules(filename string) ([]QuotaRule, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rules []QuotaRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, errors.AddContext(err, "unable to parse quota policy")
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("invalid quota pattern '%v'", rule.Pattern))
+		}
+	}
+	return rules, nil
+}
+
+// callRules returns a copy of the loaded rules.
+func (qp *quotaPolicy) callRules() []QuotaRule {
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+	return append([]QuotaRule(nil), qp.rules...)
+}
+
+// callSetRules replaces the loaded rules.
+func (qp *quotaPolicy) callSetRules(rules []QuotaRule) {
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+	qp.rules = rules
+}
+
+// managedLoadQuotaPolicy loads the quota policy from the renter's persist
+// directory.
+func (r *Renter) managedLoadQuotaPolicy() error {
+	rules, err := loadQuotaRules(filepath.Join(r.persistDir, quotaPolicyFile))
+	if err != nil {
+		return err
+	}
+	r.staticQuotaPolicy.callSetRules(rules)
+	return nil
+}
+
+// add records a file of the given size being added at siaPath.
+func (qc quotaChanges) add(siaPath modules.TurtleDexPath, size uint64) error {
+	return qc.update(siaPath, int64(size), 1)
+}
+
+// remove records a file of the given size being removed from siaPath.
+func (qc quotaChanges) remove(siaPath modules.TurtleDexPath, size uint64) error {
+	return qc.update(siaPath, -int64(size), -1)
+}
+
+// update applies a change to all the ancestors of siaPath.
+func (qc quotaChanges) update(siaPath modules.TurtleDexPath, bytes, files int64) error {
+	dir := siaPath
+	for !dir.IsRoot() {
+		var err error
+		dir, err = dir.Dir()
+		if err != nil {
+			return err
+		}
+		c := qc[dir]
+		c.bytes += bytes
+		c.files += files
+		qc[dir] = c
+	}
+	return nil
+}
+
+// managedCheckQuota checks whether adding a file of the given size at siaPath
+// would exceed the quota of any of its ancestors. If a file already exists at
+// siaPath, it is replaced, so its size and slot are subtracted. The returned
+// error names the violated rule.
+func (r *Renter) managedCheckQuota(siaPath modules.TurtleDexPath, size uint64) error {
+	if len(r.staticQuotaPolicy.callRules()) == 0 {
+		return nil
+	}
+	qc := make(quotaChanges)
+	if err := r.managedQuotaRemoveExisting(qc, siaPath); err != nil {
+		return err
+	}
+	if err := qc.add(siaPath, size); err != nil {
+		return err
+	}
+	return r.managedCheckQuotaChanges(qc)
+}
+
+// managedCheckQuotaMove checks whether moving the file at src to dst would
+// exceed the quota of any of the ancestors of dst.
+func (r *Renter) managedCheckQuotaMove(src, dst modules.TurtleDexPath) error {
+	if len(r.staticQuotaPolicy.callRules()) == 0 {
+		return nil
+	}
+	qc := make(quotaChanges)
+	if err := r.managedQuotaMove(qc, src, dst); err != nil {
+		return err
+	}
+	return r.managedCheckQuotaChanges(qc)
+}
+
+// managedQuotaMove records the file at src being moved to dst.
+func (r *Renter) managedQuotaMove(qc quotaChanges, src, dst modules.TurtleDexPath) error {
+	fi, err := r.staticFileSystem.CachedFileInfo(src)
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("unable to get the size of '%v'", src))
+	}
+	return errors.Compose(qc.remove(src, fi.Filesize), qc.add(dst, fi.Filesize))
+}
+
+// managedQuotaRemoveExisting records the file at siaPath being removed if it
+// exists.
+func (r *Renter) managedQuotaRemoveExisting(qc quotaChanges, siaPath modules.TurtleDexPath) error {
+	exists, err := r.staticFileSystem.FileExists(siaPath)
+	if err != nil || !exists {
+		return err
+	}
+	fi, err := r.staticFileSystem.CachedFileInfo(siaPath)
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("unable to get the size of '%v'", siaPath))
+	}
+	return qc.remove(siaPath, fi.Filesize)
+}
+
+// managedCheckQuotaChanges checks whether any of the changes would exceed the
+// quota of its directory. Only growing values are checked. The directories
+// are checked in order to return a deterministic error.
+func (r *Renter) managedCheckQuotaChanges(qc quotaChanges) error {
+	rules := r.staticQuotaPolicy.callRules()
+	if len(rules) == 0 {
+		return nil
+	}
+	dirs := make([]modules.TurtleDexPath, 0, len(qc))
+	for dir := range qc {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].String() < dirs[j].String()
+	})
+	for _, dir := range dirs {
+		c := qc[dir]
+		if c.bytes <= 0 && c.files <= 0 {
+			continue
+		}
+		var matching []QuotaRule
+		for _, rule := range rules {
+			if match, _ := path.Match(rule.Pattern, dir.String()); match {
+				matching = append(matching, rule)
+			}
+		}
+		if len(matching) == 0 {
+			continue
+		}
+		exists, err := r.staticFileSystem.DirExists(dir)
+		if err != nil {
+			return err
+		}
+		var numFiles, dirSize uint64
+		if exists {
+			md, err := r.managedDirectoryMetadata(dir)
+			if err != nil {
+				return err
+			}
+			numFiles, dirSize = md.AggregateNumFiles, md.AggregateSize
+		}
+		newSize, newFiles := dirSize+uint64(c.bytes), numFiles+uint64(c.files)
+		for _, rule := range matching {
+			if c.bytes > 0 && rule.MaxBytes > 0 && newSize > rule.MaxBytes {
+				return errors.AddContext(ErrQuotaExceeded, fmt.Sprintf("'%v' would contain %v bytes which exceeds the quota of %v", dir, newSize, rule))
+			}
+			if c.files > 0 && rule.MaxFiles > 0 && newFiles > rule.MaxFiles {
+				return errors.AddContext(ErrQuotaExceeded, fmt.Sprintf("'%v' would contain %v files which exceeds the quota of %v", dir, newFiles, rule))
+			}
+		}
+	}
+	return nil
+}
+
+// QuotaPolicy returns the currently loaded quota rules.
+func (r *Renter) QuotaPolicy() ([]QuotaRule, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	return r.staticQuotaPolicy.callRules(), nil
+}
+
+// ReloadQuotaPolicy reloads the quota policy from the policy file. If the
+// file is invalid, the previously loaded policy stays in effect.
+func (r *Renter) ReloadQuotaPolicy() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.managedLoadQuotaPolicy()
+}
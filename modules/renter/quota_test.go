@@ -0,0 +1,138 @@
+package renter
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestQuotaPolicy probes loading the quota policy and checking uploads
+// against it.
+func TestQuotaPolicy(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Without a policy file there are no rules.
+	rules, err := r.QuotaPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Fatal("expected no rules", rules)
+	}
+
+	// Add two files to a.
+	var size uint64
+	for _, name := range []string{"a/f1", "a/f2"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += f.Size()
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.managedBubbleMetadata(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid policy is rejected and the old policy stays in effect.
+	policyPath := filepath.Join(r.persistDir, quotaPolicyFile)
+	if err := ioutil.WriteFile(policyPath, []byte(`[{"pattern": "a/["}]`), modules.DefaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReloadQuotaPolicy(); err == nil {
+		t.Fatal("expected invalid policy to be rejected")
+	}
+
+	// Limit the number of files.
+	if err := ioutil.WriteFile(policyPath, []byte(`[{"pattern": "a", "maxfiles": 2}]`), modules.DefaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReloadQuotaPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedCheckQuota(newTurtleDexPath("a/f3"), 0); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+	if err := r.managedCheckQuota(newTurtleDexPath("b/f3"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Limit the size of all directories.
+	r.staticQuotaPolicy.callSetRules([]QuotaRule{{Pattern: "*", MaxBytes: size + 10}})
+	if err := r.managedCheckQuota(newTurtleDexPath("a/f3"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedCheckQuota(newTurtleDexPath("a/f3"), 11); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+
+	// Overwriting a file only counts the difference in size and no new file.
+	r.staticQuotaPolicy.callSetRules([]QuotaRule{{Pattern: "a", MaxFiles: 2, MaxBytes: size + 10}})
+	fi, err := r.staticFileSystem.CachedFileInfo(newTurtleDexPath("a/f1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedCheckQuota(newTurtleDexPath("a/f1"), fi.Filesize+10); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedCheckQuota(newTurtleDexPath("a/f1"), fi.Filesize+11); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+
+	// Moving a file into a exceeds the quota while moving it within a or
+	// out of it doesn't.
+	f, err := r.createRenterTestFile(newTurtleDexPath("b/f3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RenameFile(newTurtleDexPath("b/f3"), newTurtleDexPath("a/f3")); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+	if err := r.MoveFileWithTags(newTurtleDexPath("b/f3"), newTurtleDexPath("a/f3"), nil); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+	if err := r.managedCheckQuotaMove(newTurtleDexPath("a/f1"), newTurtleDexPath("a/sub/f1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.managedCheckQuotaMove(newTurtleDexPath("a/f1"), newTurtleDexPath("b/f1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch which deletes a file in a makes room for a rename into a.
+	rename := FileOp{Type: FileOpRename, TurtleDexPath: newTurtleDexPath("b/f3"), NewTurtleDexPath: newTurtleDexPath("a/f3")}
+	if _, err := r.ValidateBatch([]FileOp{rename}); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+	ops := []FileOp{rename, {Type: FileOpDelete, TurtleDexPath: newTurtleDexPath("a/f2")}}
+	if _, err := r.ValidateBatch(ops); err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishing a file into a counts against its quota.
+	if err := r.PublishDir(newTurtleDexPath("a/pub"), map[modules.TurtleDexPath]SourceRef{newTurtleDexPath("f3"): {TurtleDexPath: newTurtleDexPath("b/f3")}}); !errors.Contains(err, ErrQuotaExceeded) {
+		t.Fatal("expected ErrQuotaExceeded but got", err)
+	}
+}
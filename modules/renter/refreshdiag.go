@@ -0,0 +1,160 @@
+package renter
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+)
+
+// refreshdiag.go contains a snapshot of the refresh subsystem for support
+// bundles. Every part of the snapshot is read under the lock that protects it,
+// which makes it safe to take a snapshot while refreshes are active. The
+// parts are read one after another though, so the counters of different parts
+// might be off by the refreshes that happened in between.
+
+const (
+	// refreshDiagNumRecentEvents is the number of most recent refresh events
+	// that are included in a RefreshDiag.
+	refreshDiagNumRecentEvents = 50
+)
+
+type (
+	// RefreshDiag is a snapshot of the settings and the state of the refresh
+	// subsystem.
+	RefreshDiag struct {
+		// Settings.
+		HealthCheckInterval          time.Duration `json:"healthcheckinterval"`
+		HealthLoopErrorSleepDuration time.Duration `json:"healthlooperrorsleepduration"`
+		HealthLoopNumBatchFiles      uint64        `json:"healthloopnumbatchfiles"`
+		HealthLoopNumBatchSubDirs    uint64        `json:"healthloopnumbatchsubdirs"`
+		HealthLoopBackoffFactor      float64       `json:"healthloopbackofffactor"`
+		HealthLoopMaxInterval        time.Duration `json:"healthloopmaxinterval"`
+		BubbleFileWorkers            int           `json:"bubblefileworkers"`
+		MaxConcurrentListings        int           `json:"maxconcurrentlistings"`
+
+		// Bubbles. Active bubbles are in flight and pending bubbles are
+		// queued to run again once the active bubble of the same directory
+		// is done.
+		NumActiveBubbles  int `json:"numactivebubbles"`
+		NumPendingBubbles int `json:"numpendingbubbles"`
+
+		// Listings.
+		NumActiveListings  int `json:"numactivelistings"`
+		NumWaitingListings int `json:"numwaitinglistings"`
+
+		// Refresh event log. Pending refreshes were queued but haven't
+		// completed a bubble yet.
+		NumPendingRefreshes  int                `json:"numpendingrefreshes"`
+		NumRefreshLogEntries int                `json:"numrefreshlogentries"`
+		RecentEvents         []RefreshDiagEvent `json:"recentevents"`
+
+		// Event delivery.
+		EventQueueDepth  int    `json:"eventqueuedepth"`
+		NumDroppedEvents uint64 `json:"numdroppedevents"`
+
+		// Bubble subscriptions. Dropped events are summed over all
+		// subscribers.
+		NumBubbleSubscribers   int    `json:"numbubblesubscribers"`
+		MaxBubbleSubscribers   int    `json:"maxbubblesubscribers"`
+		NumDroppedBubbleEvents uint64 `json:"numdroppedbubbleevents"`
+
+		// EffectiveHealthCheckInterval is the current interval of the health
+		// loop including its backoff.
+		EffectiveHealthCheckInterval time.Duration `json:"effectivehealthcheckinterval"`
+	}
+
+	// RefreshDiagEvent is an entry of the refresh event log.
+	RefreshDiagEvent struct {
+		Time          time.Time             `json:"time"`
+		Type          string                `json:"type"`
+		TurtleDexPath modules.TurtleDexPath `json:"siapath"`
+	}
+)
+
+// callStatus returns the number of pending refreshes and the number of
+// entries in the log.
+func (rel *refreshEventLog) callStatus() (numPending, numEntries int) {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return len(rel.pending), rel.numEntries
+}
+
+// managedStatus returns the number of active and waiting listings and the
+// limit of active listings.
+func (ll *listingLimiter) managedStatus() (active, waiting, limit int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	return ll.active, ll.waiting, ll.limit
+}
+
+// managedBubbleStatus returns the number of active and pending bubbles. A
+// directory with a pending bubble always has an active bubble as well.
+func (r *Renter) managedBubbleStatus() (active, pending int) {
+	r.bubbleUpdatesMu.Lock()
+	defer r.bubbleUpdatesMu.Unlock()
+	for _, status := range r.bubbleUpdates {
+		switch status {
+		case bubbleActive:
+			active++
+		case bubblePending:
+			active++
+			pending++
+		}
+	}
+	return active, pending
+}
+
+// RefreshDiagnostics returns a snapshot of the settings and the state of the
+// refresh subsystem. The recent events are read from the refresh event log, so
+// there are none unless pending refreshes are persisted. Failing to read them
+// is logged and results in a snapshot without events.
+func (r *Renter) RefreshDiagnostics() RefreshDiag {
+	diag := RefreshDiag{
+		HealthCheckInterval:          healthCheckInterval,
+		HealthLoopErrorSleepDuration: healthLoopErrorSleepDuration,
+		HealthLoopNumBatchFiles:      healthLoopNumBatchFiles,
+		HealthLoopNumBatchSubDirs:    healthLoopNumBatchSubDirs,
+		BubbleFileWorkers:            r.managedBubbleFileWorkers(),
+	}
+	diag.NumActiveBubbles, diag.NumPendingBubbles = r.managedBubbleStatus()
+	diag.NumActiveListings, diag.NumWaitingListings, diag.MaxConcurrentListings = r.staticListingLimiter.managedStatus()
+	diag.NumPendingRefreshes, diag.NumRefreshLogEntries = r.staticRefreshEventLog.callStatus()
+	diag.EventQueueDepth, diag.NumDroppedEvents = r.staticEventQueue.callStatus()
+	diag.NumBubbleSubscribers, diag.MaxBubbleSubscribers, diag.NumDroppedBubbleEvents = r.staticBubbleSubscribers.callStatus()
+	diag.HealthLoopBackoffFactor, diag.HealthLoopMaxInterval = r.staticHealthLoopBackoff.callSettings()
+	diag.EffectiveHealthCheckInterval = r.staticHealthLoopBackoff.callInterval()
+
+	events, err := r.staticRefreshEventLog.callEvents()
+	if err != nil {
+		r.log.Printf("WARN: unable to read refresh event log for diagnostics: %v", err)
+		return diag
+	}
+	if len(events) > refreshDiagNumRecentEvents {
+		events = events[len(events)-refreshDiagNumRecentEvents:]
+	}
+	diag.RecentEvents = make([]RefreshDiagEvent, 0, len(events))
+	for _, e := range events {
+		diag.RecentEvents = append(diag.RecentEvents, RefreshDiagEvent{
+			Time:          e.Time,
+			Type:          string(e.Type),
+			TurtleDexPath: e.TurtleDexPath,
+		})
+	}
+	return diag
+}
+
+// ExportRefreshDiagnostics writes the snapshot returned by RefreshDiagnostics
+// to w as JSON. If compress is true, the output is gzipped.
+func (r *Renter) ExportRefreshDiagnostics(w io.Writer, compress bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	ew := newExportWriter(w, compress)
+	if err := json.NewEncoder(ew).Encode(r.RefreshDiagnostics()); err != nil {
+		return err
+	}
+	return ew.Close()
+}
@@ -0,0 +1,84 @@
+package renter
+
+import (
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+)
+
+// TestRefreshDiagnostics probes RefreshDiagnostics.
+func TestRefreshDiagnostics(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Check the settings. Pending refreshes are persisted to record the
+	// events in the refresh event log.
+	if err := r.SetPendingRefreshPersistence(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetMaxConcurrentListings(3); err != nil {
+		t.Fatal(err)
+	}
+	diag := r.RefreshDiagnostics()
+	if diag.HealthCheckInterval != healthCheckInterval {
+		t.Fatal("wrong health check interval", diag.HealthCheckInterval)
+	}
+	if diag.BubbleFileWorkers != defaultBubbleFileWorkers {
+		t.Fatal("wrong number of bubble file workers", diag.BubbleFileWorkers)
+	}
+	if diag.MaxConcurrentListings != 3 {
+		t.Fatal("wrong max concurrent listings", diag.MaxConcurrentListings)
+	}
+	if diag.NumActiveBubbles != 0 || diag.NumActiveListings != 0 {
+		t.Fatal("expected an idle refresh subsystem", diag)
+	}
+
+	// Hold a listing and refresh a directory.
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := newTurtleDexPath("dir")
+	if err := r.staticFileSystem.NewTurtleDexDir(sp, 0700); err != nil {
+		t.Fatal(err)
+	}
+	urp := r.newUniqueRefreshPaths()
+	if err := urp.callAdd(sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := urp.callRefreshAllBlocking(); err != nil {
+		t.Fatal(err)
+	}
+	diag = r.RefreshDiagnostics()
+	release()
+	if diag.NumActiveListings != 1 {
+		t.Fatal("expected one active listing", diag.NumActiveListings)
+	}
+	if diag.NumPendingRefreshes != 0 {
+		t.Fatal("expected no pending refreshes", diag.NumPendingRefreshes)
+	}
+	n := len(diag.RecentEvents)
+	if n < 2 || diag.NumRefreshLogEntries < n {
+		t.Fatalf("expected at least 2 recent events out of %v but got %v", diag.NumRefreshLogEntries, n)
+	}
+	queued, bubbled := diag.RecentEvents[n-2], diag.RecentEvents[n-1]
+	if queued.Type != string(refreshEventQueued) || !queued.TurtleDexPath.Equals(sp) {
+		t.Fatal("unexpected event", queued)
+	}
+	if bubbled.Type != string(refreshEventBubbled) || !bubbled.TurtleDexPath.Equals(sp) {
+		t.Fatal("unexpected event", bubbled)
+	}
+}
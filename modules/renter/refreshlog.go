@@ -0,0 +1,552 @@
+package renter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// refreshlog.go contains the refresh event log. The log records every
+// directory that is queued for a bubble by uniqueRefreshPaths and when the
+// bubble of such a directory completes. After a crash the log can be replayed
+// to queue the directories again whose bubbles never completed. Since that is
+// only done if pending refreshes are persisted, see
+// SetPendingRefreshPersistence, nothing is recorded otherwise.
+//
+// By default, events are buffered in memory and persisted periodically, so a
+// crash loses the events of the last persist interval. A shorter interval
+// loses less but writes more often. In the persist on enqueue mode, every
+// event is written and synced to disk before it is acknowledged, which loses
+// nothing but costs a write and a sync per event. Closing the log always
+// persists the buffered events.
+
+const (
+	// refreshEventLogFile is the name of the file that contains the refresh
+	// event log.
+	refreshEventLogFile = "refreshevents.log"
+
+	// refreshEventLogMaxEntries is the number of entries after which the
+	// refresh event log is compacted to only contain the pending refreshes.
+	refreshEventLogMaxEntries = 10000
+
+	// defaultRefreshEventLogPersistInterval is the default interval at which
+	// the buffered events of the refresh event log are persisted.
+	defaultRefreshEventLogPersistInterval = 5 * time.Second
+)
+
+const (
+	// refreshEventQueued indicates that a directory was queued for a bubble.
+	refreshEventQueued refreshEventType = "queued"
+	// refreshEventBubbled indicates that the bubble of a directory completed.
+	refreshEventBubbled refreshEventType = "bubbled"
+	// refreshEventDropped indicates that a pending directory was dropped
+	// without a bubble since its bubble can never succeed, e.g. because the
+	// directory was deleted.
+	refreshEventDropped refreshEventType = "dropped"
+)
+
+type (
+	// refreshEventType is the type of a refreshEvent.
+	refreshEventType string
+
+	// refreshEvent is a single entry of the refresh event log.
+	refreshEvent struct {
+		Time          time.Time             `json:"time"`
+		Type          refreshEventType      `json:"type"`
+		TurtleDexPath modules.TurtleDexPath `json:"siapath"`
+	}
+
+	// refreshEventLog is an append-only log of refreshEvents on disk.
+	refreshEventLog struct {
+		// pending contains the directories which were queued but haven't
+		// completed a bubble yet, mapped to the time they were queued.
+		pending    map[modules.TurtleDexPath]time.Time
+		numEntries int
+
+		// buffered contains the events which weren't persisted yet.
+		buffered []refreshEvent
+
+		// persistInterval is the interval at which the buffered events are
+		// persisted. If persistOnEnqueue is set, events are persisted right
+		// away instead.
+		persistInterval  time.Duration
+		persistOnEnqueue bool
+
+		f          *os.File
+		staticPath string
+		mu         sync.Mutex
+	}
+)
+
+// newRefreshEventLog opens the refresh event log at the given path, creating
+// it if necessary. Entries which can't be decoded are skipped and their number
+// is returned. A partially written last entry, e.g. from a crash in the middle
+// of a write, is truncated so new events start on a fresh line.
+func newRefreshEventLog(path string) (_ *refreshEventLog, skipped int, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, modules.DefaultFilePerm)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "unable to open refresh event log")
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Compose(err, f.Close())
+		}
+	}()
+	events, skipped, complete, err := decodeRefreshEvents(f)
+	if err != nil {
+		return nil, 0, errors.AddContext(err, "unable to read refresh event log")
+	}
+	if err := f.Truncate(complete); err != nil {
+		return nil, 0, errors.AddContext(err, "unable to truncate partially written entry")
+	}
+	return &refreshEventLog{
+		pending:         pendingRefreshes(events, time.Time{}),
+		numEntries:      len(events),
+		persistInterval: defaultRefreshEventLogPersistInterval,
+		f:               f,
+		staticPath:      path,
+	}, skipped, nil
+}
+
+// pendingRefreshes returns the directories which were queued at or after
+// since and didn't complete a bubble afterwards.
+func pendingRefreshes(events []refreshEvent, since time.Time) map[modules.TurtleDexPath]time.Time {
+	pending := make(map[modules.TurtleDexPath]time.Time)
+	for _, e := range events {
+		switch e.Type {
+		case refreshEventQueued:
+			if !e.Time.Before(since) {
+				pending[e.TurtleDexPath] = e.Time
+			}
+		case refreshEventBubbled, refreshEventDropped:
+			delete(pending, e.TurtleDexPath)
+		}
+	}
+	return pending
+}
+
+// readRefreshEvents reads all the events from the refresh event log at path.
+// A missing log is treated as an empty one and entries which can't be decoded
+// are skipped.
+func readRefreshEvents(path string) (_ []refreshEvent, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = errors.Compose(err, f.Close())
+	}()
+	events, _, _, err := decodeRefreshEvents(f)
+	return events, err
+}
+
+// decodeRefreshEvents decodes the events of a refresh event log. Every event
+// is on its own line. Lines which can't be decoded are skipped and counted,
+// since a crash in the middle of a write can leave a partial entry behind
+// which the next entry is appended to. A last line without a newline is
+// partial as well. complete is the length of the log up to the end of the
+// last line which ends with a newline.
+func decodeRefreshEvents(r io.Reader) (events []refreshEvent, skipped int, complete int64, err error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Contains(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				skipped++
+			}
+			return events, skipped, complete, nil
+		} else if err != nil {
+			return nil, 0, 0, errors.AddContext(err, fmt.Sprintf("failed to read entry %v", len(events)+skipped))
+		}
+		complete += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e refreshEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			skipped++
+			continue
+		}
+		events = append(events, e)
+	}
+}
+
+// callClose persists the buffered events and closes the refresh event log.
+func (rel *refreshEventLog) callClose() error {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return errors.Compose(rel.persist(), rel.f.Close())
+}
+
+// callClear removes all the events from the log, including the buffered ones.
+func (rel *refreshEventLog) callClear() error {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	rel.pending = make(map[modules.TurtleDexPath]time.Time)
+	rel.buffered = rel.buffered[:0]
+	rel.numEntries = 0
+	return rel.f.Truncate(0)
+}
+
+// callEvents returns all the events of the log.
+func (rel *refreshEventLog) callEvents() ([]refreshEvent, error) {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	if err := rel.persist(); err != nil {
+		return nil, errors.AddContext(err, "failed to persist buffered events")
+	}
+	return readRefreshEvents(rel.staticPath)
+}
+
+// callPersist persists the buffered events.
+func (rel *refreshEventLog) callPersist() error {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return rel.persist()
+}
+
+// callPersistSettings returns the persist interval and whether events are
+// persisted on enqueue.
+func (rel *refreshEventLog) callPersistSettings() (time.Duration, bool) {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return rel.persistInterval, rel.persistOnEnqueue
+}
+
+// callSetPersistSettings updates the persist interval and whether events are
+// persisted on enqueue. Switching to persisting on enqueue persists the
+// buffered events right away.
+func (rel *refreshEventLog) callSetPersistSettings(interval time.Duration, onEnqueue bool) error {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	rel.persistInterval = interval
+	rel.persistOnEnqueue = onEnqueue
+	if onEnqueue {
+		return rel.persist()
+	}
+	return nil
+}
+
+// callRecord appends an event for the given directory to the log. Completed
+// bubbles and dropped directories are only recorded for directories that are
+// pending.
+func (rel *refreshEventLog) callRecord(t refreshEventType, sp modules.TurtleDexPath) error {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	now := time.Now()
+	switch t {
+	case refreshEventQueued:
+		rel.pending[sp] = now
+	case refreshEventBubbled, refreshEventDropped:
+		if _, ok := rel.pending[sp]; !ok {
+			return nil
+		}
+		delete(rel.pending, sp)
+	}
+	rel.buffered = append(rel.buffered, refreshEvent{
+		Time:          now,
+		Type:          t,
+		TurtleDexPath: sp,
+	})
+	if rel.persistOnEnqueue {
+		return rel.persist()
+	}
+	return nil
+}
+
+// persist writes the buffered events to the log. In the persist on enqueue
+// mode the log is also synced.
+func (rel *refreshEventLog) persist() error {
+	if len(rel.buffered) == 0 {
+		return nil
+	}
+	if rel.numEntries+len(rel.buffered) > refreshEventLogMaxEntries {
+		// The pending refreshes already include the buffered events.
+		if err := rel.compact(); err != nil {
+			return errors.AddContext(err, "failed to compact refresh event log")
+		}
+	} else {
+		for _, e := range rel.buffered {
+			if err := rel.write(e); err != nil {
+				return err
+			}
+		}
+	}
+	rel.buffered = rel.buffered[:0]
+	if rel.persistOnEnqueue {
+		return rel.f.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the log to only contain the pending refreshes. The pending
+// refreshes are written to a temporary file which then replaces the log, so a
+// crash during the compaction leaves either the old or the new log behind. The
+// temporary file is created in the temp dir configured with
+// build.SetAtomicTempDir, like the files of other atomic writes.
+func (rel *refreshEventLog) compact() error {
+	tmpPath, err := rel.writePending()
+	if err != nil {
+		return errors.AddContext(err, "unable to write compacted log")
+	}
+	if err := build.RenameAtomic(tmpPath, rel.staticPath); err != nil {
+		return errors.AddContext(err, "unable to replace the log")
+	}
+	// The log was replaced, possibly by a copy of the temporary file, so it
+	// is opened again and the old file only needs to be closed.
+	f, err := os.OpenFile(rel.staticPath, os.O_RDWR|os.O_APPEND, modules.DefaultFilePerm)
+	if err != nil {
+		return errors.AddContext(err, "unable to open the compacted log")
+	}
+	old := rel.f
+	rel.f = f
+	rel.numEntries = len(rel.pending)
+	return errors.AddContext(old.Close(), "unable to close the old log")
+}
+
+// writePending writes the pending refreshes to a new temporary log for
+// replacing the log, syncs it and returns its path. If writing fails, the
+// temporary log is removed again.
+func (rel *refreshEventLog) writePending() (_ string, err error) {
+	f, err := build.CreateAtomicTempFile(rel.staticPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Compose(err, os.Remove(f.Name()))
+		}
+	}()
+	enc := json.NewEncoder(f)
+	for sp, queued := range rel.pending {
+		err := enc.Encode(refreshEvent{
+			Time:          queued,
+			Type:          refreshEventQueued,
+			TurtleDexPath: sp,
+		})
+		if err != nil {
+			return "", errors.Compose(err, f.Close())
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return "", errors.Compose(err, f.Close())
+	}
+	return f.Name(), f.Close()
+}
+
+// write appends a single event to the log.
+func (rel *refreshEventLog) write(e refreshEvent) error {
+	if err := json.NewEncoder(rel.f).Encode(e); err != nil {
+		return err
+	}
+	rel.numEntries++
+	return nil
+}
+
+// callRecordInRefreshLog records an event in the renter's refresh event log.
+// Since the log is only replayed if pending refreshes are persisted, nothing is
+// recorded otherwise.
+func (r *Renter) callRecordInRefreshLog(t refreshEventType, sp modules.TurtleDexPath) error {
+	if !r.staticPersistsPendingRefreshes() {
+		return nil
+	}
+	return r.staticRefreshEventLog.callRecord(t, sp)
+}
+
+// callRecordRefreshEvent records an event in the renter's refresh event log.
+// Failing to record an event only results in a warning since the log is not
+// required for the bubble to work.
+func (r *Renter) callRecordRefreshEvent(t refreshEventType, sp modules.TurtleDexPath) {
+	if err := r.callRecordInRefreshLog(t, sp); err != nil {
+		r.log.Printf("WARN: unable to record refresh event '%v' for '%v': %v", t, sp, err)
+	}
+	if t == refreshEventQueued {
+		r.staticRefreshMetrics.callRecordQueued()
+	}
+	e := RefreshDiagEvent{
+		Time:          time.Now(),
+		Type:          string(t),
+		TurtleDexPath: sp,
+	}
+	r.staticEventQueue.callEnqueue(e)
+	if t == refreshEventBubbled {
+		r.staticBubbleSubscribers.callPublish(e)
+	}
+}
+
+// callRecordPendingRefresh records a directory as queued in the refresh event
+// log without counting it as queued in the metrics, since it isn't dispatched
+// yet. Failing to record it only results in a warning.
+func (r *Renter) callRecordPendingRefresh(sp modules.TurtleDexPath) {
+	if err := r.callRecordInRefreshLog(refreshEventQueued, sp); err != nil {
+		r.log.Printf("WARN: unable to record pending refresh of '%v': %v", sp, err)
+	}
+}
+
+// callDropPendingRefresh records a pending directory as dropped in the
+// refresh event log, so it isn't replayed again. Failing to record it only
+// results in a warning.
+func (r *Renter) callDropPendingRefresh(sp modules.TurtleDexPath) {
+	if err := r.callRecordInRefreshLog(refreshEventDropped, sp); err != nil {
+		r.log.Printf("WARN: unable to drop pending refresh of '%v': %v", sp, err)
+	}
+}
+
+// staticPersistsPendingRefreshes returns whether pending refreshes are
+// persisted.
+func (r *Renter) staticPersistsPendingRefreshes() bool {
+	return atomic.LoadUint32(&r.atomicPersistPendingRefreshes) == 1
+}
+
+// threadedReplayRefreshLog replays the refresh event log on startup to
+// refresh the directories whose refreshes were pending when the renter was
+// stopped or crashed. It only does so if pending refreshes are persisted.
+// Otherwise the entries are never replayed and the log is cleared instead.
+func (r *Renter) threadedReplayRefreshLog() {
+	if !r.staticPersistsPendingRefreshes() {
+		if err := r.staticRefreshEventLog.callClear(); err != nil {
+			r.log.Printf("WARN: unable to clear refresh event log: %v", err)
+		}
+		return
+	}
+	if err := r.ReplayRefreshLog(time.Time{}); err != nil {
+		r.log.Printf("WARN: unable to replay refresh event log: %v", err)
+	}
+}
+
+// threadedPersistRefreshEventLog periodically persists the buffered events of
+// the refresh event log. A changed interval takes effect after the current
+// one has passed.
+func (r *Renter) threadedPersistRefreshEventLog() {
+	for {
+		interval, _ := r.staticRefreshEventLog.callPersistSettings()
+		select {
+		case <-r.tg.StopChan():
+			return
+		case <-time.After(interval):
+		}
+		if err := r.staticRefreshEventLog.callPersist(); err != nil {
+			r.log.Printf("WARN: unable to persist refresh event log: %v", err)
+		}
+	}
+}
+
+// SetRefreshLogPersistence sets how the refresh event log is persisted. If
+// persistOnEnqueue is set, every event is written and synced to disk right
+// away. Otherwise the events are buffered and persisted every interval. See
+// refreshlog.go for the tradeoff between the two.
+func (r *Renter) SetRefreshLogPersistence(interval time.Duration, persistOnEnqueue bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if interval <= 0 {
+		return fmt.Errorf("persist interval must be positive but was %v", interval)
+	}
+	return r.staticRefreshEventLog.callSetPersistSettings(interval, persistOnEnqueue)
+}
+
+// SetPendingRefreshPersistence enables or disables persisting pending
+// refreshes. If enabled, the refresh events and the paths added to a
+// persistent uniqueRefreshPaths are recorded in the refresh event log right
+// away and the log is replayed on startup. Disabling it clears the log. The
+// setting is persisted and disabled by default.
+func (r *Renter) SetPendingRefreshPersistence(enabled bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	id := r.mu.Lock()
+	defer r.mu.Unlock(id)
+	r.persist.PersistPendingRefreshes = enabled
+	if err := r.saveSync(); err != nil {
+		return errors.AddContext(err, "unable to save pending refresh persistence")
+	}
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&r.atomicPersistPendingRefreshes, v)
+	if !enabled {
+		return errors.AddContext(r.staticRefreshEventLog.callClear(), "unable to clear refresh event log")
+	}
+	return nil
+}
+
+// ExportRefreshLog writes all the events of the refresh event log to w, one
+// JSON encoded event per line like in the log itself. Entries of the log
+// which can't be decoded are left out. If compress is true, the output is
+// gzipped.
+func (r *Renter) ExportRefreshLog(w io.Writer, compress bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	events, err := r.staticRefreshEventLog.callEvents()
+	if err != nil {
+		return errors.AddContext(err, "unable to read refresh event log")
+	}
+	ew := newExportWriter(w, compress)
+	enc := json.NewEncoder(ew)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return ew.Close()
+}
+
+// ReplayRefreshLog reads the refresh event log and queues a bubble for all
+// the directories that were queued at or after since but never finished
+// bubbling. Replaying the log multiple times is safe since completed bubbles
+// are recorded in the log as well. Directories which no longer exist or fail
+// with a permanent error are dropped from the pending refreshes. A directory
+// which fails doesn't stop the replay of the others, the errors of all of them
+// are returned together.
+func (r *Renter) ReplayRefreshLog(since time.Time) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	events, err := r.staticRefreshEventLog.callEvents()
+	if err != nil {
+		return errors.AddContext(err, "unable to read refresh event log")
+	}
+	urp := r.newUniqueRefreshPaths()
+	failed := make(map[modules.TurtleDexPath]error)
+	for sp := range pendingRefreshes(events, since) {
+		exists, err := r.staticFileSystem.DirExists(sp)
+		if err == nil && !exists {
+			r.callDropPendingRefresh(sp)
+			continue
+		}
+		if err := urp.callAdd(sp); err != nil {
+			failed[sp] = errors.AddContext(err, fmt.Sprintf("unable to add '%v' to the refresh paths", sp))
+		}
+	}
+	for sp, err := range urp.callRefreshAllBlockingDetailed() {
+		failed[sp] = err
+	}
+	var replayErr error
+	for sp, err := range failed {
+		if isPermanentBubbleError(err) {
+			r.callDropPendingRefresh(sp)
+			continue
+		}
+		replayErr = errors.Compose(replayErr, err)
+	}
+	return replayErr
+}
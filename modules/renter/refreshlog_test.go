@@ -0,0 +1,485 @@
+package renter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestRefreshEventLog probes the refreshEventLog.
+func TestRefreshEventLog(t *testing.T) {
+	t.Parallel()
+
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, refreshEventLogFile)
+	rel, _, err := newRefreshEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue a and b and complete the bubble of a.
+	a, b, c := newTurtleDexPath("a"), newTurtleDexPath("b"), newTurtleDexPath("c")
+	if err := rel.callRecord(refreshEventQueued, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callRecord(refreshEventQueued, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callRecord(refreshEventBubbled, a); err != nil {
+		t.Fatal(err)
+	}
+	// Bubbles of directories that weren't queued aren't recorded.
+	if err := rel.callRecord(refreshEventBubbled, c); err != nil {
+		t.Fatal(err)
+	}
+	events, err := rel.callEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events but got %v", len(events))
+	}
+	if err := rel.callClose(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the log. Only b should be pending.
+	rel, _, err = newRefreshEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rel.callClose(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if len(rel.pending) != 1 {
+		t.Fatalf("expected 1 pending refresh but got %v", len(rel.pending))
+	}
+	if _, ok := rel.pending[b]; !ok {
+		t.Fatal("b should be pending")
+	}
+
+	// Refreshes queued before since should be ignored.
+	events, err = rel.callEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingRefreshes(events, time.Now())) != 0 {
+		t.Fatal("no refresh should be pending after now")
+	}
+
+	// Compacting the log should only leave the pending refresh.
+	rel.mu.Lock()
+	err = rel.compact()
+	rel.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err = rel.callEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !events[0].TurtleDexPath.Equals(b) || events[0].Type != refreshEventQueued {
+		t.Fatal("unexpected events after compaction", events)
+	}
+	// The temporary file replaced the log and new events are appended to it.
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != refreshEventLogFile {
+		t.Fatal("expected only the log to be left", fis)
+	}
+	if err := rel.callRecord(refreshEventBubbled, b); err != nil {
+		t.Fatal(err)
+	}
+	events, err = rel.callEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != refreshEventBubbled {
+		t.Fatal("unexpected events after appending to the compacted log", events)
+	}
+}
+
+// TestRefreshEventLogPersistence probes the persist settings of the
+// refreshEventLog.
+func TestRefreshEventLogPersistence(t *testing.T) {
+	t.Parallel()
+
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, refreshEventLogFile)
+	rel, _, err := newRefreshEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default events are buffered until they are persisted.
+	if err := rel.callRecord(refreshEventQueued, newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	events, err := readRefreshEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected 0 persisted events but got %v", len(events))
+	}
+	if err := rel.callPersist(); err != nil {
+		t.Fatal(err)
+	}
+	events, err = readRefreshEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 persisted event but got %v", len(events))
+	}
+
+	// In the persist on enqueue mode events are persisted right away.
+	if err := rel.callSetPersistSettings(time.Minute, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callRecord(refreshEventQueued, newTurtleDexPath("b")); err != nil {
+		t.Fatal(err)
+	}
+	events, err = readRefreshEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 persisted events but got %v", len(events))
+	}
+
+	// Closing the log persists the buffered events.
+	if err := rel.callSetPersistSettings(time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callRecord(refreshEventQueued, newTurtleDexPath("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callClose(); err != nil {
+		t.Fatal(err)
+	}
+	events, err = readRefreshEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 persisted events but got %v", len(events))
+	}
+}
+
+// TestRefreshEventLogTornEntries probes that the refreshEventLog can be opened
+// after a crash left partially written entries behind.
+func TestRefreshEventLogTornEntries(t *testing.T) {
+	t.Parallel()
+
+	dir := build.TempDir("renter", t.Name())
+	if err := os.MkdirAll(dir, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, refreshEventLogFile)
+
+	// Write a log with a torn entry that the next complete entry was appended
+	// to, followed by a complete entry and a torn last entry.
+	a, b, c, d := newTurtleDexPath("a"), newTurtleDexPath("b"), newTurtleDexPath("c"), newTurtleDexPath("d")
+	entry := func(sp modules.TurtleDexPath) []byte {
+		e, err := json.Marshal(refreshEvent{Time: time.Now(), Type: refreshEventQueued, TurtleDexPath: sp})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	var log []byte
+	log = append(log, entry(a)...)
+	log = append(log, '\n')
+	log = append(log, entry(b)[:10]...)
+	log = append(log, entry(b)...)
+	log = append(log, '\n')
+	log = append(log, entry(c)...)
+	log = append(log, '\n')
+	log = append(log, entry(d)[:10]...)
+	if err := ioutil.WriteFile(path, log, modules.DefaultFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the log skips both torn entries.
+	rel, skipped, err := newRefreshEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 {
+		t.Fatalf("expected 2 skipped entries but got %v", skipped)
+	}
+	if len(rel.pending) != 2 {
+		t.Fatalf("expected 2 pending refreshes but got %v", len(rel.pending))
+	}
+	if _, ok := rel.pending[a]; !ok {
+		t.Fatal("a should be pending")
+	}
+	if _, ok := rel.pending[c]; !ok {
+		t.Fatal("c should be pending")
+	}
+
+	// The torn last entry was truncated, so a new entry can be read again
+	// after reopening the log.
+	if err := rel.callRecord(refreshEventQueued, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := rel.callClose(); err != nil {
+		t.Fatal(err)
+	}
+	rel, skipped, err = newRefreshEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rel.callClose(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if skipped != 1 {
+		t.Fatalf("expected 1 skipped entry but got %v", skipped)
+	}
+	if _, ok := rel.pending[d]; !ok || len(rel.pending) != 3 {
+		t.Fatal("a, c and d should be pending", rel.pending)
+	}
+}
+
+// TestPersistentRefreshPaths probes that the paths added to a persistent
+// uniqueRefreshPaths survive a restart of the renter and are refreshed on
+// startup.
+func TestPersistentRefreshPaths(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	sp := newTurtleDexPath("a/b")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Persisting pending refreshes is disabled by default, so even a
+	// persistent uniqueRefreshPaths doesn't record anything.
+	if err := r.newPersistentUniqueRefreshPaths().callAdd(sp); err != nil {
+		t.Fatal(err)
+	}
+	r.staticRefreshEventLog.mu.Lock()
+	_, pending := r.staticRefreshEventLog.pending[sp]
+	r.staticRefreshEventLog.mu.Unlock()
+	if pending {
+		t.Fatal("a/b shouldn't be pending")
+	}
+	if err := r.SetPendingRefreshPersistence(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding a path to a regular uniqueRefreshPaths doesn't record it.
+	if err := r.newUniqueRefreshPaths().callAdd(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	// Adding a path to a persistent one records it as pending, even with an
+	// equivalent representation.
+	urp := r.newPersistentUniqueRefreshPaths()
+	if err := urp.callAdd(modules.TurtleDexPath{Path: "a/b/"}); err != nil {
+		t.Fatal(err)
+	}
+	r.staticRefreshEventLog.mu.Lock()
+	_, pendingA := r.staticRefreshEventLog.pending[newTurtleDexPath("a")]
+	_, pendingB := r.staticRefreshEventLog.pending[sp]
+	r.staticRefreshEventLog.mu.Unlock()
+	if pendingA || !pendingB {
+		t.Fatal("only a/b should be pending", pendingA, pendingB)
+	}
+
+	// Closing the renter persists the log. Reading it again restores the
+	// pending path.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := readRefreshEvents(filepath.Join(r.persistDir, refreshEventLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pendingRefreshes(events, time.Time{})[sp]; !ok {
+		t.Fatal("a/b should be pending after a restart")
+	}
+
+	// Restarting the renter refreshes the pending path.
+	r, err = newRenterWithDependency(rt.gateway, rt.cs, rt.wallet, rt.tpool, rt.mux, filepath.Join(rt.dir, modules.RenterDir), r.deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.addRenter(r); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		r.staticRefreshEventLog.mu.Lock()
+		defer r.staticRefreshEventLog.mu.Unlock()
+		if _, ok := r.staticRefreshEventLog.pending[sp]; ok {
+			return errors.New("a/b is still pending")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReplayRefreshLogDropsMissingDirs probes that ReplayRefreshLog drops
+// pending refreshes of directories which no longer exist.
+func TestReplayRefreshLogDropsMissingDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	if err := r.SetPendingRefreshPersistence(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue a directory and delete it before it is refreshed.
+	sp := newTurtleDexPath("deleted")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.newPersistentUniqueRefreshPaths().callAdd(sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteDir(sp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the log drops the directory without an error.
+	if err := r.ReplayRefreshLog(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := r.staticRefreshEventLog.callEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pendingRefreshes(events, time.Time{})[sp]; ok {
+		t.Fatal("the deleted directory is still pending")
+	}
+	var dropped bool
+	for _, e := range events {
+		dropped = dropped || (e.Type == refreshEventDropped && e.TurtleDexPath == sp)
+	}
+	if !dropped {
+		t.Fatal("expected the deleted directory to be dropped")
+	}
+}
+
+// TestRefreshEventLogDisabled probes that nothing is recorded in the refresh
+// event log unless pending refreshes are persisted and that disabling it
+// clears the log.
+func TestRefreshEventLogDisabled(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	sp := newTurtleDexPath("dir")
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	refresh := func() {
+		urp := r.newUniqueRefreshPaths()
+		if err := urp.callAdd(sp); err != nil {
+			t.Fatal(err)
+		}
+		if err := urp.callRefreshAllBlocking(); err != nil {
+			t.Fatal(err)
+		}
+		r.callRecordRefreshEvent(refreshEventQueued, newTurtleDexPath("failed"))
+	}
+	numEvents := func() int {
+		events, err := r.staticRefreshEventLog.callEvents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(events)
+	}
+
+	// Persisting pending refreshes is disabled by default, so refreshing
+	// doesn't record anything.
+	refresh()
+	if n := numEvents(); n != 0 {
+		t.Fatalf("expected no events but got %v", n)
+	}
+
+	// Once it is enabled, the events are recorded.
+	if err := r.SetPendingRefreshPersistence(true); err != nil {
+		t.Fatal(err)
+	}
+	refresh()
+	if n := numEvents(); n != 3 {
+		t.Fatalf("expected 3 events but got %v", n)
+	}
+
+	// Disabling it clears the log, including the pending refresh which would
+	// never be replayed.
+	if err := r.SetPendingRefreshPersistence(false); err != nil {
+		t.Fatal(err)
+	}
+	if n := numEvents(); n != 0 {
+		t.Fatalf("expected no events but got %v", n)
+	}
+	if numPending, numEntries := r.staticRefreshEventLog.callStatus(); numPending != 0 || numEntries != 0 {
+		t.Fatalf("expected an empty log but got %v pending of %v entries", numPending, numEntries)
+	}
+	fi, err := os.Stat(filepath.Join(r.persistDir, refreshEventLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("expected an empty log file but got %v bytes", fi.Size())
+	}
+}
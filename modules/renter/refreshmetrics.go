@@ -0,0 +1,157 @@
+package renter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// refreshmetrics.go exports the state of the refresh subsystem in the
+// Prometheus text exposition format. The metric names are part of the API and
+// must not be changed, since dashboards and alerts depend on them. New metrics
+// can be added at any time. No timestamps are written, so the scraper assigns
+// the time of the scrape.
+
+const (
+	// refreshMetricsNumSamples is the number of most recent bubble durations
+	// that are used to compute the quantiles of the bubble duration summary.
+	refreshMetricsNumSamples = 1000
+
+	// refreshMetricsPrefix is the prefix of all the refresh metrics.
+	refreshMetricsPrefix = "turtledex_renter_refresh_"
+)
+
+var (
+	// refreshMetricsQuantiles are the quantiles of the bubble duration
+	// summary.
+	refreshMetricsQuantiles = []float64{0.5, 0.9, 0.99}
+)
+
+type (
+	// refreshMetrics contains the counters and the bubble durations of the
+	// refresh subsystem.
+	refreshMetrics struct {
+		numQueued       uint64
+		numBubbles      uint64
+		numBubbleErrors uint64
+
+		// durations is a ring buffer of the most recent bubble durations.
+		// durationSum is the sum of all bubble durations.
+		durations   []time.Duration
+		next        int
+		durationSum time.Duration
+
+		mu sync.Mutex
+	}
+)
+
+// callRecordQueued records a directory that was queued for a bubble.
+func (rm *refreshMetrics) callRecordQueued() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.numQueued++
+}
+
+// callRecordBubble records a completed bubble and its duration.
+func (rm *refreshMetrics) callRecordBubble(d time.Duration, err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.numBubbles++
+	if err != nil {
+		rm.numBubbleErrors++
+	}
+	rm.durationSum += d
+	if len(rm.durations) < refreshMetricsNumSamples {
+		rm.durations = append(rm.durations, d)
+		return
+	}
+	rm.durations[rm.next] = d
+	rm.next = (rm.next + 1) % refreshMetricsNumSamples
+}
+
+// callQuantiles returns the refreshMetricsQuantiles of the recent bubble
+// durations. If no bubble was recorded yet, nil is returned.
+func (rm *refreshMetrics) callQuantiles() []time.Duration {
+	rm.mu.Lock()
+	durations := append([]time.Duration{}, rm.durations...)
+	rm.mu.Unlock()
+	if len(durations) == 0 {
+		return nil
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	quantiles := make([]time.Duration, 0, len(refreshMetricsQuantiles))
+	for _, q := range refreshMetricsQuantiles {
+		quantiles = append(quantiles, durations[int(q*float64(len(durations)-1))])
+	}
+	return quantiles
+}
+
+// callCounters returns the counters and the sum of the bubble durations.
+func (rm *refreshMetrics) callCounters() (numQueued, numBubbles, numBubbleErrors uint64, durationSum time.Duration) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.numQueued, rm.numBubbles, rm.numBubbleErrors, rm.durationSum
+}
+
+// writePromMetric writes a metric without labels including its help and type
+// lines.
+func writePromMetric(b *bytes.Buffer, name, typ, help string, value interface{}) {
+	fmt.Fprintf(b, "# HELP %s%s %s\n", refreshMetricsPrefix, name, help)
+	fmt.Fprintf(b, "# TYPE %s%s %s\n", refreshMetricsPrefix, name, typ)
+	fmt.Fprintf(b, "%s%s %v\n", refreshMetricsPrefix, name, value)
+}
+
+// RefreshMetricsProm writes the counters, the gauges and the bubble duration
+// summary of the refresh subsystem to w in the Prometheus text exposition
+// format. The quantiles of the summary are computed over the most recent
+// bubbles. If compress is true, the output is gzipped. Nothing is written to w
+// if an error occurs while collecting the metrics.
+func (r *Renter) RefreshMetricsProm(w io.Writer, compress bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+
+	numQueued, numBubbles, numBubbleErrors, durationSum := r.staticRefreshMetrics.callCounters()
+	quantiles := r.staticRefreshMetrics.callQuantiles()
+	activeBubbles, pendingBubbles := r.managedBubbleStatus()
+	activeListings, waitingListings, _ := r.staticListingLimiter.managedStatus()
+	pendingRefreshes, _ := r.staticRefreshEventLog.callStatus()
+	queueDepth, droppedEvents := r.staticEventQueue.callStatus()
+
+	var b bytes.Buffer
+	writePromMetric(&b, "queued_total", "counter", "Number of directories queued for a bubble.", numQueued)
+	writePromMetric(&b, "bubbles_total", "counter", "Number of completed bubbles.", numBubbles)
+	writePromMetric(&b, "bubble_errors_total", "counter", "Number of bubbles that failed.", numBubbleErrors)
+	writePromMetric(&b, "events_dropped_total", "counter", "Number of refresh events dropped by the event queue.", droppedEvents)
+	writePromMetric(&b, "bubbles_active", "gauge", "Number of bubbles in flight.", activeBubbles)
+	writePromMetric(&b, "bubbles_pending", "gauge", "Number of bubbles queued to run once the active bubble of the same directory is done.", pendingBubbles)
+	writePromMetric(&b, "pending", "gauge", "Number of queued directories whose bubble didn't complete yet.", pendingRefreshes)
+	writePromMetric(&b, "event_queue_depth", "gauge", "Number of refresh events waiting to be delivered.", queueDepth)
+	writePromMetric(&b, "listings_active", "gauge", "Number of active directory listings.", activeListings)
+	writePromMetric(&b, "listings_waiting", "gauge", "Number of directory listings waiting for a slot.", waitingListings)
+
+	name := refreshMetricsPrefix + "bubble_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Duration of the bubbles of single directories.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s summary\n", name)
+	for i, q := range refreshMetricsQuantiles {
+		value := "NaN"
+		if quantiles != nil {
+			value = fmt.Sprint(quantiles[i].Seconds())
+		}
+		fmt.Fprintf(&b, "%s{quantile=\"%v\"} %s\n", name, q, value)
+	}
+	fmt.Fprintf(&b, "%s_sum %v\n", name, durationSum.Seconds())
+	fmt.Fprintf(&b, "%s_count %v\n", name, numBubbles)
+
+	ew := newExportWriter(w, compress)
+	if _, err := ew.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return ew.Close()
+}
@@ -0,0 +1,91 @@
+package renter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestRefreshMetrics probes the refreshMetrics.
+func TestRefreshMetrics(t *testing.T) {
+	t.Parallel()
+
+	var rm refreshMetrics
+	if rm.callQuantiles() != nil {
+		t.Fatal("expected no quantiles without bubbles")
+	}
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = errors.New("bubble failed")
+		}
+		rm.callRecordBubble(time.Duration(i)*time.Millisecond, err)
+	}
+	rm.callRecordQueued()
+	numQueued, numBubbles, numBubbleErrors, durationSum := rm.callCounters()
+	if numQueued != 1 || numBubbles != 100 || numBubbleErrors != 10 {
+		t.Fatal("wrong counters", numQueued, numBubbles, numBubbleErrors)
+	}
+	if durationSum != 5050*time.Millisecond {
+		t.Fatal("wrong duration sum", durationSum)
+	}
+	quantiles := rm.callQuantiles()
+	expected := []time.Duration{50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond}
+	for i := range expected {
+		if quantiles[i] != expected[i] {
+			t.Fatalf("quantile %v: expected %v but got %v", refreshMetricsQuantiles[i], expected[i], quantiles[i])
+		}
+	}
+
+	// Only the most recent durations should be kept.
+	for i := 0; i < refreshMetricsNumSamples; i++ {
+		rm.callRecordBubble(time.Second, nil)
+	}
+	if len(rm.durations) != refreshMetricsNumSamples {
+		t.Fatal("wrong number of samples", len(rm.durations))
+	}
+	if q := rm.callQuantiles(); q[0] != time.Second {
+		t.Fatal("old durations should be gone", q)
+	}
+}
+
+// TestRefreshMetricsProm probes RefreshMetricsProm.
+func TestRefreshMetricsProm(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	var b bytes.Buffer
+	if err := r.RefreshMetricsProm(&b, false); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE turtledex_renter_refresh_bubbles_total counter",
+		"# TYPE turtledex_renter_refresh_bubbles_active gauge",
+		"# TYPE turtledex_renter_refresh_bubble_duration_seconds summary",
+		"# HELP turtledex_renter_refresh_pending ",
+		"turtledex_renter_refresh_bubble_duration_seconds_sum ",
+		`turtledex_renter_refresh_bubble_duration_seconds{quantile="0.5"} `,
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("missing %q in\n%v", line, out)
+		}
+	}
+}
@@ -1,22 +1,117 @@
 package renter
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/turtledex/TurtleDexCore/build"
 	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
 	"github.com/turtledex/errors"
 )
 
+var (
+	// defaultMaxConcurrentRefreshes is the maximum number of bubbles which
+	// callRefreshAll runs at the same time. Bubbles are mostly waiting for
+	// disk IO, so it's a multiple of the number of CPUs.
+	defaultMaxConcurrentRefreshes = 2 * runtime.NumCPU()
+
+	// defaultBubbleRetries is the number of times callRefreshAllBlocking
+	// retries a bubble which failed with a transient error.
+	defaultBubbleRetries = 3
+
+	// defaultBubbleRetryBackoff is the backoff before the first retry of a
+	// bubble. It doubles with every retry.
+	defaultBubbleRetryBackoff = build.Select(build.Var{
+		Dev:      100 * time.Millisecond,
+		Standard: time.Second,
+		Testing:  10 * time.Millisecond,
+	}).(time.Duration)
+)
+
+// RefreshStats contains the counters of the directories which were refreshed
+// through uniqueRefreshPaths since the renter was started.
+type RefreshStats struct {
+	// DirsAdded is the number of directories added, including duplicates and
+	// directories which are updated by the bubble of a descendant. Only
+	// directories which are refreshed are counted, adds which are used for
+	// planning only are not.
+	DirsAdded uint64
+
+	// BubblesDispatched is the number of bubbles started for the minimal set
+	// of directories.
+	BubblesDispatched uint64
+
+	// RefreshErrors is the number of bubbles of blocking refreshes which
+	// failed. The errors of background bubbles are only logged.
+	RefreshErrors uint64
+}
+
+// refreshPathsSnapshot is a consistent view of the directories tracked by a
+// uniqueRefreshPaths. It is what uniqueRefreshPaths is marshaled to.
+type refreshPathsSnapshot struct {
+	ChildDirs  []string `json:"childdirs"`
+	ParentDirs []string `json:"parentdirs"`
+}
+
+// refreshPathsAddStats contains the number of paths added to a
+// uniqueRefreshPaths since it was created. It helps to find callers which add
+// the same paths over and over again.
+type refreshPathsAddStats struct {
+	// New is the number of adds which changed the tracked directories.
+	New uint64
+
+	// Redundant is the number of adds of a path which was already tracked as
+	// a child or parent dir and therefore didn't change anything.
+	Redundant uint64
+}
+
 // uniqueRefreshPaths is a helper struct for determining the minimum number of
 // directories that will need to have callThreadedBubbleMetadata called on in
 // order to properly update the affected directory tree. Since bubble calls
 // itself on the parent directory when it finishes with a directory, only a call
 // to the lowest level child directory is needed to properly update the entire
 // directory tree.
+//
+// The de-duplication is done by the embedded modules.MinimalDirSet. Its
+// methods must only be called while holding the lock.
 type uniqueRefreshPaths struct {
-	childDirs  map[modules.TurtleDexPath]struct{}
-	parentDirs map[modules.TurtleDexPath]struct{}
+	modules.MinimalDirSet
+
+	// staticThreadedBubble is called by callRefreshAll for every directory.
+	// It defaults to the renter's callThreadedBubbleMetadata.
+	staticThreadedBubble func(modules.TurtleDexPath)
+
+	// staticBubbleDone is called by callRefreshAll after staticThreadedBubble
+	// returned to wait for bubbles which were coalesced into an active one.
+	// It defaults to the renter's managedBubbleDone.
+	staticBubbleDone func(modules.TurtleDexPath) <-chan struct{}
+
+	// staticBubble is called by callRefreshAllBlocking for every directory.
+	// It defaults to the renter's managedBubbleMetadata.
+	staticBubble func(modules.TurtleDexPath) error
+
+	// staticPersistPending indicates whether added paths are recorded as
+	// queued in the renter's refresh event log right away if the renter
+	// persists pending refreshes. That way they are refreshed on the next
+	// startup if the renter crashes before they are refreshed.
+	staticPersistPending bool
+
+	// addStats counts the new and redundant adds. It is not cleared by
+	// callReset.
+	addStats refreshPathsAddStats
+
+	// unreportedAdds is the number of adds which haven't been added to the
+	// renter's RefreshStats yet. They are reported once urp is refreshed, so
+	// a uniqueRefreshPaths which is only used for planning doesn't inflate
+	// the stats.
+	unreportedAdds uint64
 
 	r  *Renter
 	mu sync.Mutex
@@ -25,51 +120,162 @@ type uniqueRefreshPaths struct {
 // newUniqueRefreshPaths returns an initialized uniqueRefreshPaths struct
 func (r *Renter) newUniqueRefreshPaths() *uniqueRefreshPaths {
 	return &uniqueRefreshPaths{
-		childDirs:  make(map[modules.TurtleDexPath]struct{}),
-		parentDirs: make(map[modules.TurtleDexPath]struct{}),
+		staticThreadedBubble: r.callThreadedBubbleMetadata,
+		staticBubbleDone:     r.managedBubbleDone,
+		staticBubble:         r.managedBubbleMetadata,
 
 		r: r,
 	}
 }
 
-// callAdd adds a path to uniqueRefreshPaths.
+// newPersistentUniqueRefreshPaths returns an initialized uniqueRefreshPaths
+// struct which records the added paths in the refresh event log, so pending
+// refreshes survive a crash. The paths are only recorded if persisting pending
+// refreshes was enabled with SetPendingRefreshPersistence.
+func (r *Renter) newPersistentUniqueRefreshPaths() *uniqueRefreshPaths {
+	urp := r.newUniqueRefreshPaths()
+	urp.staticPersistPending = true
+	return urp
+}
+
+// callAdd adds a path to uniqueRefreshPaths. Invalid paths are rejected, but
+// the empty path is accepted as the root dir. See modules.MinimalDirSet.Add.
 func (urp *uniqueRefreshPaths) callAdd(path modules.TurtleDexPath) error {
 	urp.mu.Lock()
 	defer urp.mu.Unlock()
+	return urp.add(path)
+}
 
-	// Check if the path is in the parent directory map
-	if _, ok := urp.parentDirs[path]; ok {
-		return nil
+// callAddMulti adds multiple paths to uniqueRefreshPaths while holding the
+// lock only once. The result is the same as calling callAdd for every path.
+func (urp *uniqueRefreshPaths) callAddMulti(paths []modules.TurtleDexPath) error {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	for _, path := range paths {
+		if err := urp.add(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds a path to uniqueRefreshPaths and records it in the refresh event
+// log if pending paths are persisted. The log buffers the event, so this
+// doesn't wait for disk IO unless the log persists on enqueue.
+func (urp *uniqueRefreshPaths) add(path modules.TurtleDexPath) error {
+	redundant := urp.IsChildDir(path) || urp.IsParentDir(path)
+	if err := urp.Add(path); err != nil {
+		return err
+	}
+	if redundant {
+		urp.addStats.Redundant++
+	} else {
+		urp.addStats.New++
+	}
+	urp.unreportedAdds++
+	if urp.staticPersistPending && urp.r.staticPersistsPendingRefreshes() {
+		urp.r.callRecordPendingRefresh(path.Clean())
 	}
+	return nil
+}
 
-	// Check if the path is in the child directory map
-	if _, ok := urp.childDirs[path]; ok {
+// reportAdds adds the unreported adds to the renter's RefreshStats. It is
+// called when urp is refreshed and must be called while holding the lock.
+func (urp *uniqueRefreshPaths) reportAdds() {
+	atomic.AddUint64(&urp.r.atomicRefreshDirsAdded, urp.unreportedAdds)
+	urp.unreportedAdds = 0
+}
+
+// callAddRecursive adds path and every directory within it to
+// uniqueRefreshPaths. That way the leaf directories under path become child
+// dirs instead of path itself. A path which doesn't exist is skipped without an
+// error.
+func (urp *uniqueRefreshPaths) callAddRecursive(path modules.TurtleDexPath) error {
+	path = path.Clean()
+	if err := path.Validate(true); err != nil {
+		return errors.AddContext(err, fmt.Sprintf("can't add invalid path '%v'", path))
+	}
+	// List the directories without holding the lock since that might take a
+	// while.
+	dirs, err := urp.r.managedSubDirs(path)
+	if errors.Contains(err, filesystem.ErrNotExist) {
 		return nil
 	}
+	if err != nil {
+		return errors.AddContext(err, fmt.Sprintf("failed to list directories within '%v'", path))
+	}
+	return urp.callAddMulti(dirs)
+}
 
-	// Add path to the childDir map
-	urp.childDirs[path] = struct{}{}
+// callRemove removes path from the child dirs of uniqueRefreshPaths, e.g. if
+// the operation which added it was rolled back. Removing a path which isn't
+// a child dir is a no-op. The ancestors of path are not turned back into
+// child dirs, so they are only refreshed by the bubbles of their remaining
+// descendants.
+func (urp *uniqueRefreshPaths) callRemove(path modules.TurtleDexPath) {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	urp.Remove(path)
+}
 
-	// Check all path elements to make sure any parent directories are removed
-	// from the child directory map and added to the parent directory map
-	for !path.IsRoot() {
-		// Get the parentDir of the path
-		parentDir, err := path.Dir()
-		if err != nil {
-			contextStr := fmt.Sprintf("unable to get parent directory of %v", path)
-			return errors.AddContext(err, contextStr)
-		}
-		// Check if the parentDir is in the childDirs map
-		if _, ok := urp.childDirs[parentDir]; ok {
-			// Remove from childDir map and add to parentDir map
-			delete(urp.childDirs, parentDir)
-		}
-		// Make sure the parentDir is in the parentDirs map
-		urp.parentDirs[parentDir] = struct{}{}
-		// Set path equal to the parentDir
-		path = parentDir
+// callReset removes all paths from uniqueRefreshPaths so that it can be
+// reused for the next batch of refreshes. Adds which weren't refreshed yet are
+// not reported to the renter's RefreshStats.
+func (urp *uniqueRefreshPaths) callReset() {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	urp.Reset()
+	urp.unreportedAdds = 0
+}
+
+// callChildDirs returns the child directories currently being tracked.
+func (urp *uniqueRefreshPaths) callChildDirs() []modules.TurtleDexPath {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	return urp.ChildDirs()
+}
+
+// callSnapshot returns the sorted child and parent dirs which are currently
+// being tracked. Both are read while holding the lock, so the snapshot is
+// consistent.
+func (urp *uniqueRefreshPaths) callSnapshot() refreshPathsSnapshot {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	snapshot := refreshPathsSnapshot{
+		ChildDirs:  make([]string, 0, urp.NumChildDirs()),
+		ParentDirs: make([]string, 0, urp.NumParentDirs()),
 	}
-	return nil
+	for _, sp := range urp.ChildDirs() {
+		snapshot.ChildDirs = append(snapshot.ChildDirs, sp.String())
+	}
+	for _, sp := range urp.ParentDirs() {
+		snapshot.ParentDirs = append(snapshot.ParentDirs, sp.String())
+	}
+	return snapshot
+}
+
+// MarshalJSON implements json.Marshaler by marshaling a snapshot of the
+// tracked directories.
+func (urp *uniqueRefreshPaths) MarshalJSON() ([]byte, error) {
+	return json.Marshal(urp.callSnapshot())
+}
+
+// callRefreshPlan returns the directories which callRefreshAll and
+// callRefreshAllBlocking would bubble, sorted by their path. Their ancestors
+// are updated by the bubbles of the returned directories. Nothing is
+// refreshed.
+func (urp *uniqueRefreshPaths) callRefreshPlan() []modules.TurtleDexPath {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	return urp.ChildDirs()
+}
+
+// callAddStats returns the number of new and redundant adds since urp was
+// created.
+func (urp *uniqueRefreshPaths) callAddStats() refreshPathsAddStats {
+	urp.mu.Lock()
+	defer urp.mu.Unlock()
+	return urp.addStats
 }
 
 // callNumChildDirs returns the number of child directories currently being
@@ -77,7 +283,7 @@ func (urp *uniqueRefreshPaths) callAdd(path modules.TurtleDexPath) error {
 func (urp *uniqueRefreshPaths) callNumChildDirs() int {
 	urp.mu.Lock()
 	defer urp.mu.Unlock()
-	return len(urp.childDirs)
+	return urp.NumChildDirs()
 }
 
 // callNumParentDirs returns the number of parent directories currently being
@@ -85,26 +291,154 @@ func (urp *uniqueRefreshPaths) callNumChildDirs() int {
 func (urp *uniqueRefreshPaths) callNumParentDirs() int {
 	urp.mu.Lock()
 	defer urp.mu.Unlock()
-	return len(urp.parentDirs)
+	return urp.NumParentDirs()
 }
 
 // callRefreshAll uses the uniqueRefreshPaths's Renter to call
-// callThreadedBubbleMetadata on all the directories in the childDir map
+// callThreadedBubbleMetadata on all the directories in the childDir map. At
+// most defaultMaxConcurrentRefreshes bubbles are run at the same time.
+//
+// Overlapping refreshes don't bubble the same directory concurrently. If a
+// directory is already being bubbled, managedPrepareBubble marks it as pending
+// instead and the active bubble runs once more when it's done, no matter how
+// many requests were coalesced.
 func (urp *uniqueRefreshPaths) callRefreshAll() {
+	urp.callRefreshAllAsync()
+}
+
+// callRefreshAllAsync is like callRefreshAll but returns a channel which is
+// closed once all the dispatched bubbles have finished. If a directory was
+// already being bubbled, its dispatched bubble is only coalesced into the
+// pending one, so the channel isn't closed before the directory has no active
+// or pending bubble left. The bubbles of the parent directories which they
+// trigger are not waited for.
+func (urp *uniqueRefreshPaths) callRefreshAllAsync() <-chan struct{} {
+	return urp.callRefreshAllWithLimit(defaultMaxConcurrentRefreshes)
+}
+
+// callRefreshAllWithLimit is like callRefreshAllAsync but runs at most
+// maxConcurrent bubbles at the same time. The dispatch and every bubble are
+// part of the renter's thread group, so nothing is started once the renter is
+// stopping. In that case the returned channel is closed once the bubbles which
+// were already started have finished.
+func (urp *uniqueRefreshPaths) callRefreshAllWithLimit(maxConcurrent int) <-chan struct{} {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	done := make(chan struct{})
+	tg := &urp.r.tg
+	if err := tg.Add(); err != nil {
+		close(done)
+		return done
+	}
 	urp.mu.Lock()
-	defer urp.mu.Unlock()
-	for sp := range urp.childDirs {
-		go urp.r.callThreadedBubbleMetadata(sp)
+	urp.reportAdds()
+	dirs := urp.ChildDirs()
+	for _, sp := range dirs {
+		urp.r.callRecordRefreshEvent(refreshEventQueued, sp)
 	}
+	urp.mu.Unlock()
+
+	go func() {
+		defer tg.Done()
+		var wg sync.WaitGroup
+		defer close(done)
+		defer wg.Wait()
+		sem := make(chan struct{}, maxConcurrent)
+		for _, sp := range dirs {
+			select {
+			case sem <- struct{}{}:
+			case <-tg.StopChan():
+				return
+			}
+			if err := tg.Add(); err != nil {
+				return
+			}
+			atomic.AddUint64(&urp.r.atomicRefreshBubblesDispatched, 1)
+			wg.Add(1)
+			go func(sp modules.TurtleDexPath) {
+				defer tg.Done()
+				defer wg.Done()
+				defer func() { <-sem }()
+				urp.staticThreadedBubble(sp)
+				select {
+				case <-urp.staticBubbleDone(sp):
+				case <-tg.StopChan():
+				}
+			}(sp)
+		}
+	}()
+	return done
 }
 
 // callRefreshAllBlocking uses the uniqueRefreshPaths's Renter to call
 // managedBubbleMetadata on all the directories in the childDir map
-func (urp *uniqueRefreshPaths) callRefreshAllBlocking() (err error) {
+func (urp *uniqueRefreshPaths) callRefreshAllBlocking() error {
+	return urp.callRefreshAllBlockingCtx(context.Background())
+}
+
+// callRefreshAllBlockingCtx is like callRefreshAllBlocking but stops before
+// the next directory once ctx is cancelled. In that case the errors of the
+// directories bubbled so far are returned together with ctx.Err(). Bubbles
+// which fail with a transient error are retried with backoff.
+func (urp *uniqueRefreshPaths) callRefreshAllBlockingCtx(ctx context.Context) error {
+	failed, ctxErr := urp.callRefreshAllBlockingDetailedCtx(ctx)
+	dirs := make([]modules.TurtleDexPath, 0, len(failed))
+	for sp := range failed {
+		dirs = append(dirs, sp)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Path < dirs[j].Path
+	})
+	var err error
+	for _, sp := range dirs {
+		err = errors.Compose(err, failed[sp])
+	}
+	return errors.Compose(err, ctxErr)
+}
+
+// callRefreshAllBlockingDetailed is like callRefreshAllBlocking but returns
+// the error of every directory which failed to bubble by its path. An empty
+// map means that all directories were bubbled successfully.
+func (urp *uniqueRefreshPaths) callRefreshAllBlockingDetailed() map[modules.TurtleDexPath]error {
+	failed, _ := urp.callRefreshAllBlockingDetailedCtx(context.Background())
+	return failed
+}
+
+// callRefreshAllBlockingDetailedCtx is like callRefreshAllBlockingDetailed but
+// stops before the next directory once ctx is cancelled. In that case
+// ctx.Err() is returned in addition to the errors of the directories bubbled
+// so far.
+func (urp *uniqueRefreshPaths) callRefreshAllBlockingDetailedCtx(ctx context.Context) (map[modules.TurtleDexPath]error, error) {
 	urp.mu.Lock()
 	defer urp.mu.Unlock()
-	for sp := range urp.childDirs {
-		err = errors.Compose(err, urp.r.managedBubbleMetadata(sp))
+	urp.reportAdds()
+	failed := make(map[modules.TurtleDexPath]error)
+	for _, sp := range urp.ChildDirs() {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return failed, ctxErr
+		}
+		urp.r.callRecordRefreshEvent(refreshEventQueued, sp)
+		atomic.AddUint64(&urp.r.atomicRefreshBubblesDispatched, 1)
+		err := urp.r.managedRetryBubble(ctx, urp.staticBubble, sp, defaultBubbleRetries, defaultBubbleRetryBackoff)
+		if err != nil {
+			atomic.AddUint64(&urp.r.atomicRefreshErrors, 1)
+			failed[sp] = err
+		}
+	}
+	return failed, nil
+}
+
+// RefreshStats returns the counters of the directories which were refreshed
+// since the renter was started.
+func (r *Renter) RefreshStats() (RefreshStats, error) {
+	if err := r.tg.Add(); err != nil {
+		return RefreshStats{}, err
 	}
-	return
+	defer r.tg.Done()
+	return RefreshStats{
+		DirsAdded:         atomic.LoadUint64(&r.atomicRefreshDirsAdded),
+		BubblesDispatched: atomic.LoadUint64(&r.atomicRefreshBubblesDispatched),
+		RefreshErrors:     atomic.LoadUint64(&r.atomicRefreshErrors),
+	}, nil
 }
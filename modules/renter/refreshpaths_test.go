@@ -1,13 +1,17 @@
 package renter
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/turtledex/TurtleDexCore/build"
 	"github.com/turtledex/TurtleDexCore/crypto"
 	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem"
 	"github.com/turtledex/TurtleDexCore/persist"
 	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
 	"github.com/turtledex/errors"
@@ -63,11 +67,11 @@ func TestRefreshPaths(t *testing.T) {
 		t.Fatal(err)
 	}
 	dirsToRefresh.mu.Lock()
-	if _, ok := dirsToRefresh.childDirs[child]; !ok {
+	if !dirsToRefresh.IsChildDir(child) {
 		t.Fatal("Did not find path in map", child)
 	}
 	for _, parent := range parents {
-		if _, ok := dirsToRefresh.parentDirs[parent]; !ok {
+		if !dirsToRefresh.IsParentDir(parent) {
 			t.Fatal("Did not find path in map", parent)
 		}
 	}
@@ -105,7 +109,7 @@ func TestRefreshPaths(t *testing.T) {
 	}
 	dirsToRefresh.mu.Lock()
 	for _, path := range uniquePaths {
-		if _, ok := dirsToRefresh.childDirs[path]; !ok {
+		if !dirsToRefresh.IsChildDir(path) {
 			t.Fatal("Did not find path in map", path)
 		}
 	}
@@ -124,7 +128,7 @@ func TestRefreshPaths(t *testing.T) {
 	}
 	dirsToRefresh.mu.Lock()
 	for _, path := range parentPaths {
-		if _, ok := dirsToRefresh.parentDirs[path]; !ok {
+		if !dirsToRefresh.IsParentDir(path) {
 			t.Fatal("Did not find path in map", path)
 		}
 	}
@@ -184,7 +188,7 @@ func TestRefreshPaths(t *testing.T) {
 	}
 
 	// Wait for root directory to show proper number of files and subdirs.
-	numSubDirs := len(dirsToRefresh.parentDirs) + len(dirsToRefresh.childDirs) - 1
+	numSubDirs := dirsToRefresh.NumParentDirs() + dirsToRefresh.NumChildDirs() - 1
 	err = build.Retry(100, 100*time.Millisecond, func() error {
 		di, err = rt.renter.DirList(modules.RootTurtleDexPath())
 		if err != nil {
@@ -202,3 +206,913 @@ func TestRefreshPaths(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestRefreshPathsAddMulti probes callAddMulti.
+func TestRefreshPathsAddMulti(t *testing.T) {
+	t.Parallel()
+
+	// Adding a dir together with its parent only keeps the deepest dir.
+	urp := new(Renter).newUniqueRefreshPaths()
+	err := urp.callAddMulti([]modules.TurtleDexPath{{Path: "a/b/c"}, {Path: "a/b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	childDirs := urp.callChildDirs()
+	if len(childDirs) != 1 || !childDirs[0].Equals(modules.TurtleDexPath{Path: "a/b/c"}) {
+		t.Fatal("expected only a/b/c as child dir", childDirs)
+	}
+	if urp.callNumParentDirs() != 3 {
+		t.Fatal("expected 3 parent dirs but got", urp.callNumParentDirs())
+	}
+
+	// The result should be the same as adding the paths one by one in any
+	// order.
+	paths := []modules.TurtleDexPath{
+		{Path: "a"},
+		{Path: "a/b"},
+		{Path: "a/b/c"},
+		{Path: "a/d"},
+		{Path: "e/f"},
+		{Path: "e"},
+	}
+	for i := 0; i < 10; i++ {
+		var shuffled []modules.TurtleDexPath
+		for _, j := range fastrand.Perm(len(paths)) {
+			shuffled = append(shuffled, paths[j])
+		}
+		multi := new(Renter).newUniqueRefreshPaths()
+		if err := multi.callAddMulti(shuffled); err != nil {
+			t.Fatal(err)
+		}
+		single := new(Renter).newUniqueRefreshPaths()
+		for _, path := range shuffled {
+			if err := single.callAdd(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if fmt.Sprint(multi.ChildDirs()) != fmt.Sprint(single.ChildDirs()) || fmt.Sprint(multi.ParentDirs()) != fmt.Sprint(single.ParentDirs()) {
+			t.Fatalf("callAddMulti and callAdd differ: %v %v, %v %v", multi.ChildDirs(), multi.ParentDirs(), single.ChildDirs(), single.ParentDirs())
+		}
+		if multi.callNumChildDirs() != 3 {
+			t.Fatal("expected 3 child dirs but got", multi.callChildDirs())
+		}
+	}
+}
+
+// TestRefreshAllWithLimit probes that callRefreshAllWithLimit doesn't block
+// and runs no more than the limit of bubbles at the same time.
+func TestRefreshAllWithLimit(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Add some dirs and replace the bubble with a counting hook which blocks
+	// until it is released.
+	urp := rt.renter.newUniqueRefreshPaths()
+	numDirs := 20
+	for i := 0; i < numDirs; i++ {
+		if err := urp.callAdd(modules.TurtleDexPath{Path: fmt.Sprintf("dir%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limit := 3
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var active, maxActive, done int
+	urp.staticThreadedBubble = func(modules.TurtleDexPath) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		<-release
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		active--
+		done++
+		mu.Unlock()
+	}
+
+	// The call should return even though no bubble can finish yet.
+	urp.callRefreshAllWithLimit(limit)
+	close(release)
+
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if done != numDirs {
+			return fmt.Errorf("expected %v bubbles but got %v", numDirs, done)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive > limit {
+		t.Fatalf("expected at most %v concurrent bubbles but got %v", limit, maxActive)
+	}
+}
+
+// TestRefreshAllBlockingCtx probes that callRefreshAllBlockingCtx stops once
+// its context is cancelled.
+func TestRefreshAllBlockingCtx(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	urp := rt.renter.newUniqueRefreshPaths()
+	for i := 0; i < 10; i++ {
+		if err := urp.callAdd(modules.TurtleDexPath{Path: fmt.Sprintf("dir%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Cancel the context after the first bubble.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errBubble := errors.New("bubble failed")
+	var bubbled int
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		bubbled++
+		cancel()
+		return errBubble
+	}
+	err = urp.callRefreshAllBlockingCtx(ctx)
+	if !errors.Contains(err, context.Canceled) || !errors.Contains(err, errBubble) {
+		t.Fatal("expected both the bubble error and the cancellation but got", err)
+	}
+	if bubbled != 1 {
+		t.Fatalf("expected the remaining dirs to be skipped but %v were bubbled", bubbled)
+	}
+
+	// Without cancellation all dirs are bubbled.
+	bubbled = 0
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		bubbled++
+		return nil
+	}
+	if err := urp.callRefreshAllBlocking(); err != nil {
+		t.Fatal(err)
+	}
+	if bubbled != 10 {
+		t.Fatalf("expected 10 bubbles but got %v", bubbled)
+	}
+}
+
+// TestRefreshAllBlockingRetry probes that callRefreshAllBlocking retries
+// bubbles which fail with a transient error.
+func TestRefreshAllBlockingRetry(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	urp := rt.renter.newUniqueRefreshPaths()
+	if err := urp.callAdd(modules.TurtleDexPath{Path: "dir"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bubble which fails fewer times than the number of retries succeeds.
+	errTransient := errors.New("transient error")
+	var attempts int
+	failures := defaultBubbleRetries
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		attempts++
+		if attempts <= failures {
+			return errTransient
+		}
+		return nil
+	}
+	if err := urp.callRefreshAllBlocking(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != failures+1 {
+		t.Fatalf("expected %v attempts but got %v", failures+1, attempts)
+	}
+
+	// A bubble which keeps failing gives up after the retries.
+	attempts = 0
+	failures = defaultBubbleRetries + 1
+	if err := urp.callRefreshAllBlocking(); !errors.Contains(err, errTransient) {
+		t.Fatalf("expected %v but got %v", errTransient, err)
+	}
+	if attempts != defaultBubbleRetries+1 {
+		t.Fatalf("expected %v attempts but got %v", defaultBubbleRetries+1, attempts)
+	}
+
+	// Permanent errors aren't retried.
+	attempts = 0
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		attempts++
+		return filesystem.ErrNotExist
+	}
+	if err := urp.callRefreshAllBlocking(); !errors.Contains(err, filesystem.ErrNotExist) {
+		t.Fatalf("expected %v but got %v", filesystem.ErrNotExist, err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected 1 attempt but got %v", attempts)
+	}
+}
+
+// TestRefreshAllBlockingDetailed probes that callRefreshAllBlockingDetailed
+// reports the error of every failed directory.
+func TestRefreshAllBlockingDetailed(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	urp := rt.renter.newUniqueRefreshPaths()
+	for _, dir := range []string{"a", "b", "c"} {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without failures the map is empty.
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		return nil
+	}
+	if failed := urp.callRefreshAllBlockingDetailed(); len(failed) != 0 {
+		t.Fatal("expected no failures", failed)
+	}
+
+	// Only the failing dir is reported. The error is permanent to avoid the
+	// retries.
+	failing := newTurtleDexPath("b")
+	urp.staticBubble = func(sp modules.TurtleDexPath) error {
+		if sp.Equals(failing) {
+			return filesystem.ErrNotExist
+		}
+		return nil
+	}
+	failed := urp.callRefreshAllBlockingDetailed()
+	if len(failed) != 1 || !errors.Contains(failed[failing], filesystem.ErrNotExist) {
+		t.Fatal("expected only b to fail", failed)
+	}
+}
+
+// TestRefreshAllAsync probes that the channel returned by callRefreshAllAsync
+// is closed once all bubbles have finished.
+func TestRefreshAllAsync(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	urp := rt.renter.newUniqueRefreshPaths()
+	numDirs := 10
+	for i := 0; i < numDirs; i++ {
+		if err := urp.callAdd(modules.TurtleDexPath{Path: fmt.Sprintf("dir%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	bubbled := make(map[modules.TurtleDexPath]struct{})
+	urp.staticThreadedBubble = func(sp modules.TurtleDexPath) {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		bubbled[sp] = struct{}{}
+		mu.Unlock()
+	}
+	select {
+	case <-urp.callRefreshAllAsync():
+	case <-time.After(time.Minute):
+		t.Fatal("bubbles didn't finish")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bubbled) != numDirs {
+		t.Fatalf("expected %v bubbles but got %v", numDirs, len(bubbled))
+	}
+}
+
+// TestRefreshAllAsyncInProgress probes that the channel returned by
+// callRefreshAllAsync isn't closed before the bubble of a directory which was
+// already being bubbled has finished.
+func TestRefreshAllAsyncInProgress(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create a dir and mark it as being bubbled.
+	siaPath, err := modules.NewTurtleDexPath("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.renter.CreateDir(siaPath, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if !rt.renter.managedPrepareBubble(siaPath) {
+		t.Fatal("expected bubble to be prepared")
+	}
+
+	// Refresh the dir. Its bubble is coalesced into a pending one.
+	urp := rt.renter.newUniqueRefreshPaths()
+	if err := urp.callAdd(siaPath); err != nil {
+		t.Fatal(err)
+	}
+	done := urp.callRefreshAllAsync()
+	err = build.Retry(100, 10*time.Millisecond, func() error {
+		rt.renter.bubbleUpdatesMu.Lock()
+		defer rt.renter.bubbleUpdatesMu.Unlock()
+		if status := rt.renter.bubbleUpdates[siaPath.String()]; status != bubblePending {
+			return fmt.Errorf("expected status %v but got %v", bubblePending, status)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		t.Fatal("refresh finished while the bubble is still pending")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Complete the active bubble. That runs the pending one, after which the
+	// refresh is done.
+	rt.renter.managedCompleteBubbleUpdate(siaPath)
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("refresh didn't finish")
+	}
+	rt.renter.bubbleUpdatesMu.Lock()
+	defer rt.renter.bubbleUpdatesMu.Unlock()
+	if status, exists := rt.renter.bubbleUpdates[siaPath.String()]; exists {
+		t.Fatalf("expected no bubble but got status %v", status)
+	}
+}
+
+// TestRefreshAllAfterClose probes that callRefreshAll doesn't start any
+// bubbles once the renter is closed.
+func TestRefreshAllAfterClose(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urp := rt.renter.newUniqueRefreshPaths()
+	for i := 0; i < 10; i++ {
+		if err := urp.callAdd(modules.TurtleDexPath{Path: fmt.Sprintf("dir%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var mu sync.Mutex
+	var bubbled int
+	urp.staticThreadedBubble = func(modules.TurtleDexPath) {
+		mu.Lock()
+		bubbled++
+		mu.Unlock()
+	}
+
+	// Close the renter and refresh afterwards.
+	if err := rt.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-urp.callRefreshAllAsync()
+	mu.Lock()
+	defer mu.Unlock()
+	if bubbled != 0 {
+		t.Fatalf("expected no bubbles after close but got %v", bubbled)
+	}
+}
+
+// TestRefreshPlan probes callRefreshPlan.
+func TestRefreshPlan(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+	bubbled := false
+	urp.staticThreadedBubble = func(modules.TurtleDexPath) { bubbled = true }
+	urp.staticBubble = func(modules.TurtleDexPath) error {
+		bubbled = true
+		return nil
+	}
+	paths := []modules.TurtleDexPath{
+		{Path: "b/c"},
+		{Path: "a"},
+		{Path: "b"},
+		{Path: "a/z/y"},
+		{Path: "a/x"},
+		{Path: "b/c"},
+	}
+	if err := urp.callAddMulti(paths); err != nil {
+		t.Fatal(err)
+	}
+	expected := []modules.TurtleDexPath{{Path: "a/x"}, {Path: "a/z/y"}, {Path: "b/c"}}
+	for i := 0; i < 3; i++ {
+		plan := urp.callRefreshPlan()
+		if fmt.Sprint(plan) != fmt.Sprint(expected) {
+			t.Fatal("wrong plan", plan)
+		}
+	}
+	if bubbled {
+		t.Fatal("plan shouldn't refresh anything")
+	}
+}
+
+// TestRefreshPathsAddInvalid probes that callAdd rejects invalid paths
+// without changing the tracked directories.
+func TestRefreshPathsAddInvalid(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+
+	// The empty path is the root dir.
+	if err := urp.callAdd(modules.TurtleDexPath{}); err != nil {
+		t.Fatal(err)
+	}
+	if !urp.IsChildDir(modules.RootTurtleDexPath()) {
+		t.Fatal("root should be a child dir")
+	}
+
+	// A path whose parent can't be computed by Dir is rejected as well as
+	// other malformed paths.
+	invalidPath := modules.TurtleDexPath{Path: "\xff/a"}
+	if _, err := invalidPath.Dir(); err == nil {
+		t.Fatal("expected Dir to fail")
+	}
+	for _, sp := range []modules.TurtleDexPath{invalidPath, {Path: "a//b"}, {Path: "a/../b"}, {Path: "."}} {
+		if err := urp.callAdd(sp); !errors.Contains(err, modules.ErrInvalidTurtleDexPath) {
+			t.Fatalf("expected %v for %q but got %v", modules.ErrInvalidTurtleDexPath, sp.Path, err)
+		}
+	}
+	if urp.callNumChildDirs() != 1 || urp.callNumParentDirs() != 0 {
+		t.Fatal("invalid paths shouldn't be tracked", urp.callChildDirs())
+	}
+}
+
+// TestRefreshPathsRemove probes callRemove.
+func TestRefreshPathsRemove(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+	for _, dir := range []string{"a/b", "a/c"} {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Remove a leaf.
+	urp.callRemove(newTurtleDexPath("a/b"))
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("a/c")}) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+
+	// Removing a path which isn't a child dir is a no-op.
+	urp.callRemove(newTurtleDexPath("x"))
+	urp.callRemove(newTurtleDexPath("a"))
+	if urp.callNumChildDirs() != 1 || urp.callNumParentDirs() != 2 {
+		t.Fatal("unexpected dirs", urp.callChildDirs(), urp.callNumParentDirs())
+	}
+
+	// Removing the last descendant doesn't turn the parent back into a
+	// child dir.
+	urp.callRemove(newTurtleDexPath("a/c"))
+	if urp.callNumChildDirs() != 0 || !urp.IsParentDir(newTurtleDexPath("a")) {
+		t.Fatal("parent dirs should stay parent dirs", urp.callChildDirs())
+	}
+
+	// A removed path can be added again.
+	if err := urp.callAdd(newTurtleDexPath("a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("a/b")}) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+}
+
+// TestRefreshPathsReset probes that a reset uniqueRefreshPaths is empty and
+// can be reused.
+func TestRefreshPathsReset(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+
+	// Resetting an empty instance is fine.
+	urp.callReset()
+
+	for _, dir := range []string{"a/b", "a/c", "d"} {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	urp.callReset()
+	if urp.callNumChildDirs() != 0 || urp.callNumParentDirs() != 0 {
+		t.Fatal("expected an empty set", urp.callChildDirs())
+	}
+
+	// The instance is still usable.
+	if err := urp.callAdd(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint([]modules.TurtleDexPath{newTurtleDexPath("a")}) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+	if urp.callNumParentDirs() != 1 {
+		t.Fatal("expected only the root as parent dir", urp.callNumParentDirs())
+	}
+}
+
+// TestRefreshPathsAddStats probes that callAddStats distinguishes new adds
+// from adds of paths which are already tracked.
+func TestRefreshPathsAddStats(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+	add := func(dir string) {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertStats := func(newAdds, redundant uint64) {
+		t.Helper()
+		stats := urp.callAddStats()
+		if stats.New != newAdds || stats.Redundant != redundant {
+			t.Fatalf("expected %v new and %v redundant adds but got %+v", newAdds, redundant, stats)
+		}
+	}
+
+	add("a/b/c")
+	assertStats(1, 0)
+
+	// "a/b" is already a queued parent of "a/b/c".
+	add("a/b")
+	assertStats(1, 1)
+
+	// Re-adding the child is redundant as well.
+	add("a/b/c")
+	assertStats(1, 2)
+
+	// A sibling and a descendant of the child are new.
+	add("a/d")
+	add("a/b/c/e")
+	assertStats(3, 2)
+
+	// Invalid paths aren't counted.
+	if err := urp.callAdd(modules.TurtleDexPath{Path: "a//b"}); err == nil {
+		t.Fatal("expected an error for an invalid path")
+	}
+	assertStats(3, 2)
+
+	// The tracked dirs are the same as without the redundant adds.
+	expected := []modules.TurtleDexPath{newTurtleDexPath("a/b/c/e"), newTurtleDexPath("a/d")}
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint(expected) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+}
+
+// TestRefreshPathsAddRecursive probes that callAddRecursive tracks the leaf
+// directories of a siadir tree.
+func TestRefreshPathsAddRecursive(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	for _, dir := range []string{"a/b/c", "a/b/d", "a/e", "f"} {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	urp := r.newUniqueRefreshPaths()
+	if err := urp.callAddRecursive(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []modules.TurtleDexPath{newTurtleDexPath("a/b/c"), newTurtleDexPath("a/b/d"), newTurtleDexPath("a/e")}
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint(expected) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+
+	// A leaf dir is added as is.
+	if err := urp.callAddRecursive(newTurtleDexPath("f")); err != nil {
+		t.Fatal(err)
+	}
+	if !urp.IsChildDir(newTurtleDexPath("f")) {
+		t.Fatal("f should be a child dir")
+	}
+
+	// Equivalent representations of a path are the same dir.
+	if err := urp.callAddRecursive(modules.TurtleDexPath{Path: "/f/"}); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(urp.callChildDirs()) != fmt.Sprint(append(expected, newTurtleDexPath("f"))) {
+		t.Fatal("wrong child dirs", urp.callChildDirs())
+	}
+
+	// A missing subtree is skipped.
+	numChildDirs := urp.callNumChildDirs()
+	if err := urp.callAddRecursive(newTurtleDexPath("a/missing")); err != nil {
+		t.Fatal(err)
+	}
+	if urp.callNumChildDirs() != numChildDirs {
+		t.Fatal("missing dir shouldn't be tracked", urp.callChildDirs())
+	}
+
+	// Invalid paths are rejected.
+	if err := urp.callAddRecursive(modules.TurtleDexPath{Path: "a//b"}); !errors.Contains(err, modules.ErrInvalidTurtleDexPath) {
+		t.Fatalf("expected %v but got %v", modules.ErrInvalidTurtleDexPath, err)
+	}
+}
+
+// TestRefreshPathsMarshalJSON probes that the refresh paths are marshaled as
+// sorted and deduplicated arrays.
+func TestRefreshPathsMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	urp := new(Renter).newUniqueRefreshPaths()
+	b, err := json.Marshal(urp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"childdirs":[],"parentdirs":[]}` {
+		t.Fatal("unexpected json for empty set", string(b))
+	}
+
+	for _, dir := range []string{"b/c", "a", "b/c", "b/a/d", "a"} {
+		if err := urp.callAdd(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err = json.Marshal(urp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot refreshPathsSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(snapshot.ChildDirs) != fmt.Sprint([]string{"a", "b/a/d", "b/c"}) {
+		t.Fatal("wrong child dirs", snapshot.ChildDirs)
+	}
+	if fmt.Sprint(snapshot.ParentDirs) != fmt.Sprint([]string{"", "b", "b/a"}) {
+		t.Fatal("wrong parent dirs", snapshot.ParentDirs)
+	}
+}
+
+// TestRefreshStats probes that the refresh counters reflect the minimal set
+// of dispatched bubbles.
+func TestRefreshStats(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+	before, err := r.RefreshStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add 5 paths of which 2 need a bubble. One of the bubbles fails.
+	urp := r.newUniqueRefreshPaths()
+	paths := []modules.TurtleDexPath{{Path: "a"}, {Path: "a/b"}, {Path: "a/b"}, {Path: "c"}, {Path: ""}}
+	for _, path := range paths {
+		if err := urp.callAdd(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The adds are only counted once the paths are refreshed.
+	stats, err := r.RefreshStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DirsAdded != before.DirsAdded {
+		t.Fatal("adds shouldn't be counted before a refresh", stats.DirsAdded-before.DirsAdded)
+	}
+	errBubble := errors.New("bubble failed")
+	urp.staticBubble = func(sp modules.TurtleDexPath) error {
+		if sp.Equals(modules.TurtleDexPath{Path: "c"}) {
+			return errBubble
+		}
+		return nil
+	}
+	if err := urp.callRefreshAllBlocking(); !errors.Contains(err, errBubble) {
+		t.Fatal("expected bubble error but got", err)
+	}
+	after, err := r.RefreshStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added := after.DirsAdded - before.DirsAdded; added != uint64(len(paths)) {
+		t.Fatalf("expected %v added dirs but got %v", len(paths), added)
+	}
+	if dispatched := after.BubblesDispatched - before.BubblesDispatched; dispatched != 2 {
+		t.Fatalf("expected 2 dispatched bubbles but got %v", dispatched)
+	}
+	if errs := after.RefreshErrors - before.RefreshErrors; errs != 1 {
+		t.Fatalf("expected 1 refresh error but got %v", errs)
+	}
+
+	// A non-blocking refresh dispatches the same bubbles.
+	urp.staticThreadedBubble = func(modules.TurtleDexPath) {}
+	urp.callRefreshAll()
+	err = build.Retry(100, 10*time.Millisecond, func() error {
+		stats, err := r.RefreshStats()
+		if err != nil {
+			return err
+		}
+		if dispatched := stats.BubblesDispatched - before.BubblesDispatched; dispatched != 4 {
+			return fmt.Errorf("expected 4 dispatched bubbles but got %v", dispatched)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Refreshing the same paths again doesn't count their adds twice.
+	stats, err = r.RefreshStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added := stats.DirsAdded - before.DirsAdded; added != uint64(len(paths)) {
+		t.Fatalf("expected %v added dirs but got %v", len(paths), added)
+	}
+
+	// Planning helpers don't count their adds.
+	if _, err := r.CoveringDirs([]modules.TurtleDexPath{{Path: "x/file"}, {Path: "y/file"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RefreshFrontierSize(modules.RootTurtleDexPath(), 0); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = r.RefreshStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added := stats.DirsAdded - before.DirsAdded; added != uint64(len(paths)) {
+		t.Fatalf("expected %v added dirs after planning but got %v", len(paths), added)
+	}
+}
+
+// TestRefreshAllOverlapping probes that overlapping refreshes of a directory
+// which is already being bubbled are coalesced into a single bubble.
+func TestRefreshAllOverlapping(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	sp := modules.TurtleDexPath{Path: "overlap/dir"}
+	if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe, err := r.SubscribeBubbles(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// Simulate an active bubble of the dir.
+	if !r.managedPrepareBubble(sp) {
+		t.Fatal("dir shouldn't be bubbling yet")
+	}
+
+	// Refresh the dir a few times with overlapping trees while it is
+	// bubbling. None of the refreshes may start another bubble.
+	for i := 0; i < 3; i++ {
+		urp := r.newUniqueRefreshPaths()
+		if err := urp.callAddMulti([]modules.TurtleDexPath{sp, {Path: "overlap"}}); err != nil {
+			t.Fatal(err)
+		}
+		urp.callRefreshAll()
+		if err := urp.callRefreshAllBlocking(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = build.Retry(100, 10*time.Millisecond, func() error {
+		if active, pending := r.managedBubbleStatus(); active != 1 || pending != 1 {
+			return fmt.Errorf("expected 1 active and 1 pending bubble but got %v and %v", active, pending)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Completing the simulated bubble runs the coalesced bubble once.
+	r.managedCompleteBubbleUpdate(sp)
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if active, _ := r.managedBubbleStatus(); active != 0 {
+			return fmt.Errorf("%v bubbles are still active", active)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var bubbled int
+	for len(events) > 0 {
+		if e := <-events; e.TurtleDexPath.Equals(sp) {
+			bubbled++
+		}
+	}
+	if bubbled != 1 {
+		t.Fatalf("expected the dir to be bubbled once but it was bubbled %v times", bubbled)
+	}
+}
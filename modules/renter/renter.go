@@ -217,12 +217,82 @@ type Renter struct {
 	// moving on to its parent directory so that any changes in metadata are
 	// properly reflected throughout the filesystem.
 	//
+	// bubbleWaiters are the channels returned by managedBubbleDone. They are
+	// closed once the directory has no active or pending bubble left.
+	//
 	// cachedUtilities contain contract information used when bubbling. These
 	// values are cached to prevent recomputing them too often.
 	bubbleUpdates   map[string]bubbleStatus
+	bubbleWaiters   map[string][]chan struct{}
 	bubbleUpdatesMu sync.Mutex
 	cachedUtilities cachedUtilities
 
+	// staticRefreshEventLog records the directories queued for a bubble and
+	// the completion of their bubbles so that they can be queued again after
+	// a crash.
+	staticRefreshEventLog *refreshEventLog
+
+	// staticQuotaPolicy contains the directory quotas.
+	staticQuotaPolicy *quotaPolicy
+
+	// staticHealthLoopBackoff tracks the effective interval of the health
+	// loop.
+	staticHealthLoopBackoff *healthLoopBackoff
+
+	// staticRefreshSuppressor tracks the subtrees whose refreshes are
+	// suppressed.
+	staticRefreshSuppressor *refreshSuppressor
+
+	// staticBubbleAggregates contains the custom aggregates computed during
+	// a bubble.
+	staticBubbleAggregates *bubbleAggregates
+
+	// staticAuditLog records the file operations of the renter if it is
+	// enabled.
+	staticAuditLog *auditLog
+
+	// staticEventQueue delivers the refresh events to the registered
+	// handlers.
+	staticEventQueue *eventQueue
+
+	// staticListingLimiter limits the number of concurrent directory
+	// listings.
+	staticListingLimiter *listingLimiter
+
+	// staticRefreshMetrics contains the counters and the bubble durations
+	// exported by RefreshMetricsProm.
+	staticRefreshMetrics *refreshMetrics
+
+	// staticDirETags caches the ETags of directories until their next bubble.
+	staticDirETags *dirETagCache
+
+	// staticBubbleSubscribers contains the subscribers to completed bubbles.
+	staticBubbleSubscribers *bubbleSubscribers
+
+	// staticStrictBubbles collects the errors of background bubbles in
+	// strict mode.
+	staticStrictBubbles *strictBubbles
+
+	// atomicBubbleFileWorkers is the number of workers used to calculate the
+	// metadata of the files within a single directory during a bubble.
+	atomicBubbleFileWorkers uint64
+
+	// atomicDirStalenessThreshold is the age of a directory's
+	// LastHealthCheckTime in nanoseconds after which DirInfoCached reports the
+	// directory's info as stale.
+	atomicDirStalenessThreshold int64
+
+	// The following counters track the directories added to
+	// uniqueRefreshPaths, the bubbles dispatched by them and the errors of
+	// blocking refreshes. They are reported by RefreshStats.
+	atomicRefreshDirsAdded         uint64
+	atomicRefreshBubblesDispatched uint64
+	atomicRefreshErrors            uint64
+
+	// atomicPersistPendingRefreshes is 1 if pending refreshes are persisted.
+	// It mirrors the PersistPendingRefreshes field of the persistence.
+	atomicPersistPendingRefreshes uint32
+
 	// The renter's bandwidth ratelimit.
 	rl *ratelimit.RateLimit
 
@@ -996,8 +1066,23 @@ func renterBlockingStartup(g modules.Gateway, cs modules.ConsensusSet, tpool mod
 		},
 
 		bubbleUpdates:   make(map[string]bubbleStatus),
+		bubbleWaiters:   make(map[string][]chan struct{}),
 		downloadHistory: make(map[modules.DownloadID]*download),
 
+		staticAuditLog:              newAuditLog(),
+		staticBubbleAggregates:      &bubbleAggregates{},
+		staticBubbleSubscribers:     newBubbleSubscribers(defaultMaxBubbleSubscribers),
+		staticDirETags:              &dirETagCache{},
+		staticQuotaPolicy:           &quotaPolicy{},
+		staticRefreshSuppressor:     &refreshSuppressor{},
+		staticStrictBubbles:         &strictBubbles{},
+		staticRefreshMetrics:        &refreshMetrics{},
+		staticEventQueue:            newEventQueue(eventQueueMaxSize, defaultEventDeliveryInterval),
+		staticHealthLoopBackoff:     newHealthLoopBackoff(),
+		staticListingLimiter:        newListingLimiter(defaultMaxConcurrentListings),
+		atomicBubbleFileWorkers:     defaultBubbleFileWorkers,
+		atomicDirStalenessThreshold: int64(defaultDirStalenessThreshold),
+
 		cs:             cs,
 		deps:           deps,
 		g:              g,
@@ -1037,6 +1122,23 @@ func renterBlockingStartup(g modules.Gateway, cs modules.ConsensusSet, tpool mod
 	if err := r.tg.AfterStop(r.repairLog.Close); err != nil {
 		return nil, err
 	}
+	if err := r.managedLoadQuotaPolicy(); err != nil {
+		return nil, errors.AddContext(err, "unable to load quota policy")
+	}
+	var skipped int
+	r.staticRefreshEventLog, skipped, err = newRefreshEventLog(filepath.Join(r.persistDir, refreshEventLogFile))
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		r.log.Printf("WARN: skipped %v refresh event log entries which couldn't be decoded", skipped)
+	}
+	if err := r.tg.AfterStop(r.staticBubbleSubscribers.callClose); err != nil {
+		return nil, err
+	}
+	if err := r.tg.AfterStop(r.staticRefreshEventLog.callClose); err != nil {
+		return nil, err
+	}
 
 	// Initialize some of the components.
 	err = r.newAccountManager()
@@ -1094,6 +1196,12 @@ func renterBlockingStartup(g modules.Gateway, cs modules.ConsensusSet, tpool mod
 	r.managedUpdateRenterContractsAndUtilities()
 	go r.threadedUpdateRenterContractsAndUtilities()
 
+	// Deliver refresh events to the registered handlers, persist the refresh
+	// event log and replay the refreshes which were pending on shutdown.
+	go r.staticEventQueue.threadedDeliver(r.tg.StopChan())
+	go r.threadedPersistRefreshEventLog()
+	go r.threadedReplayRefreshLog()
+
 	// Spin up background threads which are not depending on the renter being
 	// up-to-date with consensus.
 	if !r.deps.Disrupt("DisableRepairAndHealthLoops") {
@@ -555,7 +555,8 @@ func (r *Renter) threadedStuckFileLoop() {
 }
 
 // threadedUpdateRenterHealth reads all the siafiles in the renter, calculates
-// the health of each file and updates the folder metadata
+// the health of each file and updates the folder metadata. Excluded
+// directories and their descendants are skipped, see managedPrepareForBubble.
 func (r *Renter) threadedUpdateRenterHealth() {
 	err := r.tg.Add()
 	if err != nil {
@@ -589,13 +590,15 @@ func (r *Renter) threadedUpdateRenterHealth() {
 		}
 
 		// Check if the time since the last check on the least recently checked
-		// folder is inside the health check interval. If so, the whole
-		// filesystem has been checked recently, and we can sleep until the
-		// least recent check is outside the check interval.
+		// folder is inside the effective health check interval. If so, the
+		// whole filesystem has been checked recently, and we can sleep until
+		// the least recent check is outside the check interval. Every cycle
+		// that sleeps increases the effective interval for the next cycle.
+		interval := r.staticHealthLoopBackoff.callInterval()
 		timeSinceLastCheck := time.Since(lastHealthCheckTime)
-		if timeSinceLastCheck < healthCheckInterval {
+		if timeSinceLastCheck < interval {
 			// Sleep until the least recent check is outside the check interval.
-			sleepDuration := healthCheckInterval - timeSinceLastCheck
+			sleepDuration := interval - timeSinceLastCheck
 			r.log.Printf("Health loop sleeping for %v, lastHealthCheckTime %v, directory %v", sleepDuration, lastHealthCheckTime, siaPath)
 			wakeSignal := time.After(sleepDuration)
 			select {
@@ -603,6 +606,9 @@ func (r *Renter) threadedUpdateRenterHealth() {
 				return
 			case <-wakeSignal:
 			}
+			r.staticHealthLoopBackoff.callIdle()
+		} else {
+			r.staticHealthLoopBackoff.callReset()
 		}
 
 		// Prepare the subtree for being bubbled
@@ -636,6 +642,84 @@ func (r *Renter) threadedUpdateRenterHealth() {
 	}
 }
 
+// nextRefreshTime returns the time at which the health loop picks up a
+// directory with the given LastHealthCheckTime if it uses the given effective
+// interval. Directories whose check is overdue are picked up right away.
+func nextRefreshTime(lastHealthCheckTime, now time.Time, interval time.Duration) time.Time {
+	next := lastHealthCheckTime.Add(interval)
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// NextRefreshTime predicts when the health loop will next check the health of
+// the directory at siaPath. The prediction is based on the directory's
+// LastHealthCheckTime and the health loop's current effective interval. The health loop
+// might check the directory earlier as part of a batch with one of its
+// ancestors, and later if it is busy with other directories. A zero time is
+// returned if the health loop is disabled or the directory is excluded from
+// it.
+func (r *Renter) NextRefreshTime(siaPath modules.TurtleDexPath) (time.Time, error) {
+	if err := r.tg.Add(); err != nil {
+		return time.Time{}, err
+	}
+	defer r.tg.Done()
+	if r.deps.Disrupt("DisableRepairAndHealthLoops") {
+		return time.Time{}, nil
+	}
+	metadata, err := r.managedDirectoryMetadata(siaPath)
+	if err != nil {
+		return time.Time{}, errors.AddContext(err, "unable to get directory metadata")
+	}
+	if metadata.Excluded {
+		return time.Time{}, nil
+	}
+	interval := r.staticHealthLoopBackoff.callInterval()
+	return nextRefreshTime(metadata.LastHealthCheckTime, time.Now(), interval), nil
+}
+
+// RefreshFrontierSize estimates the number of directories within the subtree
+// at siaPath that would need to be bubbled to refresh all the directories whose
+// LastHealthCheckTime is older than olderThan. Since a bubble continues with
+// the parent directory, the stale directories are collapsed into the deepest
+// ones just like uniqueRefreshPaths does before a refresh. The estimate is
+// based on the cached metadata.
+func (r *Renter) RefreshFrontierSize(siaPath modules.TurtleDexPath, olderThan time.Duration) (int, error) {
+	if err := r.tg.Add(); err != nil {
+		return 0, err
+	}
+	defer r.tg.Done()
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	cutoff := time.Now().Add(-olderThan)
+	urp := r.newUniqueRefreshPaths()
+	var errs error
+	var errMu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		if !di.LastHealthCheckTime.Before(cutoff) {
+			return
+		}
+		if err := urp.callAdd(di.TurtleDexPath); err != nil {
+			errMu.Lock()
+			errs = errors.Compose(errs, err)
+			errMu.Unlock()
+		}
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return 0, err
+	}
+	if errs != nil {
+		return 0, errors.AddContext(errs, "unable to collapse the stale directories")
+	}
+	return urp.callNumChildDirs(), nil
+}
+
 // managedPrepareForBubble prepares a directory for the Health Loop to call
 // bubble on and returns a uniqueRefreshPaths including all the paths of the
 // directories in the subtree that need to be updated. This includes updating
@@ -646,7 +730,9 @@ func (r *Renter) threadedUpdateRenterHealth() {
 // added.
 //
 // If the force boolean is supplied, the LastHealthCheckTime of the directories
-// will be ignored so all directories will be considered.
+// will be ignored so all directories will be considered. Otherwise excluded
+// directories and their descendants are skipped and pinned directories are
+// always considered.
 func (r *Renter) managedPrepareForBubble(rootDir modules.TurtleDexPath, force bool) (*uniqueRefreshPaths, error) {
 	// Initiate helpers
 	urp := r.newUniqueRefreshPaths()
@@ -659,26 +745,53 @@ func (r *Renter) managedPrepareForBubble(rootDir modules.TurtleDexPath, force bo
 		return nil, errors.AddContext(err, "unable to add initial rootDir to uniqueRefreshPaths")
 	}
 
-	// Define DirectoryInfo function
+	// Define DirectoryInfo function. The directories are collected first
+	// since whether a directory is skipped depends on its ancestors.
 	var mu sync.Mutex
+	var dis []modules.DirectoryInfo
+	excluded := make(map[modules.TurtleDexPath]struct{})
 	dlf := func(di modules.DirectoryInfo) {
 		mu.Lock()
 		defer mu.Unlock()
+		dis = append(dis, di)
+		if !force && di.Excluded {
+			excluded[di.TurtleDexPath] = struct{}{}
+		}
+	}
 
-		// Skip any directories that have been updated recently
-		if !force && time.Since(di.LastHealthCheckTime) < healthCheckInterval {
+	// Execute the function on the FileSystem
+	errList := r.staticFileSystem.CachedList(rootDir, true, func(modules.FileInfo) {}, dlf)
+	if errList != nil {
+		err = errors.Compose(err, errList)
+		return nil, errors.AddContext(err, "unable to get cached list of sub directories")
+	}
+
+	for _, di := range dis {
+		// Skip excluded directories and their descendants. They are marked
+		// as checked, so they don't keep their subtree stale and the health
+		// loop doesn't pick them up over and over again.
+		if isExcludedDir(di.TurtleDexPath, excluded) {
+			if skipErr := r.managedSkipExcludedDir(di.TurtleDexPath); skipErr != nil {
+				r.log.Printf("WARN: unable to skip excluded dir `%v`; err: %v", di.TurtleDexPath, skipErr)
+				err = errors.Compose(err, skipErr)
+			}
+			continue
+		}
+		// Skip any directories that have been updated recently unless they
+		// are pinned
+		if !force && !di.Pinned && time.Since(di.LastHealthCheckTime) < healthCheckInterval {
 			// Track the LastHealthCheckTime of the skipped directory
 			if di.LastHealthCheckTime.Before(aggregateLastHealthCheckTime) {
 				aggregateLastHealthCheckTime = di.LastHealthCheckTime
 			}
-			return
+			continue
 		}
 		// Add the directory to uniqueRefreshPaths
 		addErr := urp.callAdd(di.TurtleDexPath)
 		if addErr != nil {
 			r.log.Printf("WARN: unable to add siapath `%v` to uniqueRefreshPaths; err: %v", di.TurtleDexPath, addErr)
 			err = errors.Compose(err, addErr)
-			return
+			continue
 		}
 		// Update files in the directory.
 		updateErr := r.managedUpdateFileMetadatasParams(di.TurtleDexPath, offlineMap, goodForRenewMap, contracts, used)
@@ -688,13 +801,6 @@ func (r *Renter) managedPrepareForBubble(rootDir modules.TurtleDexPath, force bo
 		}
 	}
 
-	// Execute the function on the FileSystem
-	errList := r.staticFileSystem.CachedList(rootDir, true, func(modules.FileInfo) {}, dlf)
-	if errList != nil {
-		err = errors.Compose(err, errList)
-		return nil, errors.AddContext(err, "unable to get cached list of sub directories")
-	}
-
 	// Update the root directory's LastHealthCheckTime to signal that this sub
 	// tree has been updated
 	entry, openErr := r.staticFileSystem.OpenTurtleDexDir(rootDir)
@@ -704,6 +810,38 @@ func (r *Renter) managedPrepareForBubble(rootDir modules.TurtleDexPath, force bo
 	return urp, errors.Compose(err, entry.UpdateLastHealthCheckTime(aggregateLastHealthCheckTime, time.Now()), entry.Close())
 }
 
+// isExcludedDir returns whether siaPath or one of its ancestors is in the
+// excluded set.
+func isExcludedDir(siaPath modules.TurtleDexPath, excluded map[modules.TurtleDexPath]struct{}) bool {
+	if len(excluded) == 0 {
+		return false
+	}
+	for {
+		if _, ok := excluded[siaPath]; ok {
+			return true
+		}
+		if siaPath.IsRoot() {
+			return false
+		}
+		parent, err := siaPath.Dir()
+		if err != nil {
+			return false
+		}
+		siaPath = parent
+	}
+}
+
+// managedSkipExcludedDir marks an excluded directory or one of its descendants
+// as checked by the health loop without updating its files.
+func (r *Renter) managedSkipExcludedDir(siaPath modules.TurtleDexPath) error {
+	entry, err := r.staticFileSystem.OpenTurtleDexDir(siaPath)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return errors.Compose(entry.UpdateLastHealthCheckTime(now, now), entry.Close())
+}
+
 // managedUpdateFileMetadata updates the metadata of all siafiles within a dir.
 // This can be very expensive for large directories and should therefore only
 // happen sparingly.
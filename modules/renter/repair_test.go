@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -1735,7 +1736,7 @@ func TestPrepareForBubble(t *testing.T) {
 		t.Errorf("expected %v got %v", 1, urp.callNumChildDirs())
 	}
 	urp.mu.Lock()
-	_, ok := urp.childDirs[emptyDir]
+	ok := urp.IsChildDir(emptyDir)
 	urp.mu.Unlock()
 	if !ok {
 		t.Error("unexpected")
@@ -1744,7 +1745,7 @@ func TestPrepareForBubble(t *testing.T) {
 		t.Errorf("expected %v got %v", 1, urp.callNumParentDirs())
 	}
 	urp.mu.Lock()
-	_, ok = urp.parentDirs[modules.RootTurtleDexPath()]
+	ok = urp.IsParentDir(modules.RootTurtleDexPath())
 	urp.mu.Unlock()
 	if !ok {
 		t.Error("unexpected")
@@ -1800,13 +1801,13 @@ func TestPrepareForBubble(t *testing.T) {
 
 	// Validate that the directories with future LastHealthCheckTimes are ignored
 	if urp.callNumChildDirs() != 3 {
-		t.Log(urp.childDirs)
+		t.Log(urp.ChildDirs())
 		t.Errorf("expected %v got %v", 3, urp.callNumChildDirs())
 	}
 	urp.mu.Lock()
-	_, okHome := urp.childDirs[modules.HomeFolder]
-	_, okBackup := urp.childDirs[modules.BackupFolder]
-	_, okVar := urp.childDirs[modules.VarFolder]
+	okHome := urp.IsChildDir(modules.HomeFolder)
+	okBackup := urp.IsChildDir(modules.BackupFolder)
+	okVar := urp.IsChildDir(modules.VarFolder)
 	urp.mu.Unlock()
 	if !okHome || !okBackup || !okVar {
 		t.Error("unexpected", okHome, okBackup, okVar)
@@ -1815,7 +1816,7 @@ func TestPrepareForBubble(t *testing.T) {
 		t.Errorf("expected %v got %v", 1, urp.callNumParentDirs())
 	}
 	urp.mu.Lock()
-	_, ok = urp.parentDirs[modules.RootTurtleDexPath()]
+	ok = urp.IsParentDir(modules.RootTurtleDexPath())
 	urp.mu.Unlock()
 	if !ok {
 		t.Error("unexpected")
@@ -1832,14 +1833,14 @@ func TestPrepareForBubble(t *testing.T) {
 
 	// Validate that the directories with future LastHealthCheckTimes are not ignored
 	if urp.callNumChildDirs() != 4 {
-		t.Log(urp.childDirs)
+		t.Log(urp.ChildDirs())
 		t.Errorf("expected %v got %v", 4, urp.callNumChildDirs())
 	}
 	urp.mu.Lock()
-	_, okEmpty := urp.childDirs[emptyDir]
-	_, okUser := urp.childDirs[modules.UserFolder]
-	_, okBackup = urp.childDirs[modules.BackupFolder]
-	_, okSkynet := urp.childDirs[modules.SkynetFolder]
+	okEmpty := urp.IsChildDir(emptyDir)
+	okUser := urp.IsChildDir(modules.UserFolder)
+	okBackup = urp.IsChildDir(modules.BackupFolder)
+	okSkynet := urp.IsChildDir(modules.SkynetFolder)
 	urp.mu.Unlock()
 	if !okEmpty || !okUser || !okBackup || !okSkynet {
 		t.Error("unexpected", okEmpty, okUser, okBackup, okSkynet)
@@ -1848,11 +1849,214 @@ func TestPrepareForBubble(t *testing.T) {
 		t.Errorf("expected %v got %v", 3, urp.callNumParentDirs())
 	}
 	urp.mu.Lock()
-	_, ok = urp.parentDirs[modules.RootTurtleDexPath()]
-	_, okHome = urp.parentDirs[modules.HomeFolder]
-	_, okVar = urp.parentDirs[modules.VarFolder]
+	ok = urp.IsParentDir(modules.RootTurtleDexPath())
+	okHome = urp.IsParentDir(modules.HomeFolder)
+	okVar = urp.IsParentDir(modules.VarFolder)
 	urp.mu.Unlock()
 	if !ok || !okHome || !okVar {
 		t.Error("unexpected", ok, okHome, okVar)
 	}
 }
+
+// TestPrepareForBubbleFlags probes that managedPrepareForBubble skips excluded
+// directories and their descendants and always considers pinned ones.
+func TestPrepareForBubbleFlags(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create a stale excluded dir and two recently checked dirs, one of
+	// which is pinned.
+	excluded, pinned, recent := newTurtleDexPath("excluded"), newTurtleDexPath("pinned"), newTurtleDexPath("recent")
+	old := time.Now().AddDate(-1, 0, 0)
+	future := time.Now().AddDate(1, 0, 0)
+	dirs := map[modules.TurtleDexPath]ttdxdir.Metadata{
+		excluded: {AggregateLastHealthCheckTime: old, LastHealthCheckTime: old, Excluded: true},
+		pinned:   {AggregateLastHealthCheckTime: future, LastHealthCheckTime: future, Pinned: true},
+		recent:   {AggregateLastHealthCheckTime: future, LastHealthCheckTime: future},
+	}
+	for sp, md := range dirs {
+		if err := rt.renter.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.openAndUpdateDir(sp, md); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Create a stale dir within the excluded dir which isn't excluded itself.
+	child := newTurtleDexPath("excluded/child")
+	if err := rt.renter.CreateDir(child, modules.DefaultDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.openAndUpdateDir(child, ttdxdir.Metadata{AggregateLastHealthCheckTime: old, LastHealthCheckTime: old}); err != nil {
+		t.Fatal(err)
+	}
+
+	urp, err := rt.renter.managedPrepareForBubble(modules.RootTurtleDexPath(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urp.mu.Lock()
+	okExcluded := urp.IsChildDir(excluded)
+	okChild := urp.IsChildDir(child)
+	okPinned := urp.IsChildDir(pinned)
+	okRecent := urp.IsChildDir(recent)
+	urp.mu.Unlock()
+	if okExcluded || okChild || !okPinned || okRecent {
+		t.Fatal("unexpected", okExcluded, okChild, okPinned, okRecent)
+	}
+
+	// The excluded dir and its descendant are marked as checked.
+	for _, sp := range []modules.TurtleDexPath{excluded, child} {
+		md, err := rt.renter.managedDirectoryMetadata(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !md.LastHealthCheckTime.After(old) || !md.AggregateLastHealthCheckTime.After(old) {
+			t.Fatal("excluded dir wasn't marked as checked", sp, md.LastHealthCheckTime, md.AggregateLastHealthCheckTime)
+		}
+	}
+
+	// Forcing the preparation considers the excluded dir as well.
+	urp, err = rt.renter.managedPrepareForBubble(modules.RootTurtleDexPath(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urp.mu.Lock()
+	okChild = urp.IsChildDir(child)
+	urp.mu.Unlock()
+	if !okChild {
+		t.Fatal("forced preparation should include the excluded dir's subtree")
+	}
+}
+
+// TestNextRefreshTime probes nextRefreshTime and NextRefreshTime.
+func TestNextRefreshTime(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	// A recently checked directory is picked up once the interval passed.
+	now := time.Now()
+	last := now.Add(-healthCheckInterval / 2)
+	if next := nextRefreshTime(last, now, healthCheckInterval); !next.Equal(last.Add(healthCheckInterval)) {
+		t.Fatal("wrong next refresh time", next)
+	}
+	// A longer effective interval delays the refresh.
+	if next := nextRefreshTime(last, now, 2*healthCheckInterval); !next.Equal(last.Add(2 * healthCheckInterval)) {
+		t.Fatal("wrong next refresh time with backoff", next)
+	}
+	// An overdue directory is picked up right away.
+	if next := nextRefreshTime(now.Add(-2*healthCheckInterval), now, healthCheckInterval); !next.Equal(now) {
+		t.Fatal("overdue directory should be refreshed now", next)
+	}
+	if next := nextRefreshTime(time.Time{}, now, healthCheckInterval); !next.Equal(now) {
+		t.Fatal("unchecked directory should be refreshed now", next)
+	}
+
+	// The renter reports a zero time while the health loop is disabled.
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	next, err := rt.renter.NextRefreshTime(modules.RootTurtleDexPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.IsZero() {
+		t.Fatal("expected zero time", next)
+	}
+}
+
+// TestRefreshFrontierSize probes RefreshFrontierSize.
+func TestRefreshFrontierSize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create some dirs and mark a/b, a/b/c, a and d as stale.
+	for _, dir := range []string{"a/b/c", "a/e", "d", "f"} {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setLHCT := func(sp modules.TurtleDexPath, lhct time.Time) {
+		entry, err := r.staticFileSystem.OpenTurtleDexDir(sp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = errors.Compose(entry.UpdateLastHealthCheckTime(lhct, lhct), entry.Close())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var dirs []modules.TurtleDexPath
+	var mu sync.Mutex
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, func(di modules.DirectoryInfo) {
+		mu.Lock()
+		dirs = append(dirs, di.TurtleDexPath)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, dir := range dirs {
+		setLHCT(dir, now)
+	}
+	for _, dir := range []string{"a", "a/b", "a/b/c", "d"} {
+		setLHCT(newTurtleDexPath(dir), now.Add(-time.Hour))
+	}
+
+	// a, a/b and a/b/c collapse into a/b/c.
+	n, err := r.RefreshFrontierSize(modules.RootTurtleDexPath(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatal("expected frontier of 2 but got", n)
+	}
+	n, err = r.RefreshFrontierSize(newTurtleDexPath("a"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatal("expected frontier of 1 but got", n)
+	}
+	n, err = r.RefreshFrontierSize(modules.RootTurtleDexPath(), 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("expected empty frontier but got", n)
+	}
+}
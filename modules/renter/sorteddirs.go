@@ -0,0 +1,135 @@
+package renter
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+)
+
+// Fields by which SortedDirs can sort the directories. All fields use the
+// aggregate values of the directories. By default the directories are sorted
+// in ascending order. Combine a field with SortDescending to sort in
+// descending order, e.g. SortBySize|SortDescending for the largest
+// directories.
+const (
+	// SortBySize sorts by the AggregateSize.
+	SortBySize SortField = iota
+	// SortByHealth sorts by the AggregateHealth.
+	SortByHealth
+	// SortByNumFiles sorts by the AggregateNumFiles.
+	SortByNumFiles
+	// SortByLastHealthCheckTime sorts by the AggregateLastHealthCheckTime.
+	SortByLastHealthCheckTime
+
+	// SortDescending reverses the order of a field.
+	SortDescending SortField = 1 << 8
+)
+
+type (
+	// SortField selects the field and the order used by SortedDirs.
+	SortField int
+
+	// sortedDirsHeap is a heap of directories whose root is the directory
+	// that comes last in the sort order. It is used to keep only the first
+	// directories of a walk in memory.
+	sortedDirsHeap struct {
+		dirs   []modules.DirectoryInfo
+		before func(a, b modules.DirectoryInfo) bool
+	}
+)
+
+// Implementation of heap.Interface for sortedDirsHeap.
+func (h *sortedDirsHeap) Len() int           { return len(h.dirs) }
+func (h *sortedDirsHeap) Less(i, j int) bool { return h.before(h.dirs[j], h.dirs[i]) }
+func (h *sortedDirsHeap) Swap(i, j int)      { h.dirs[i], h.dirs[j] = h.dirs[j], h.dirs[i] }
+func (h *sortedDirsHeap) Push(x interface{}) {
+	h.dirs = append(h.dirs, x.(modules.DirectoryInfo))
+}
+func (h *sortedDirsHeap) Pop() interface{} {
+	old := h.dirs
+	n := len(old)
+	x := old[n-1]
+	h.dirs = old[0 : n-1]
+	return x
+}
+
+// sortFieldLess returns a func which reports whether directory a comes before
+// directory b in the order selected by field. Directories with equal values
+// are sorted by their path.
+func sortFieldLess(field SortField) (func(a, b modules.DirectoryInfo) bool, error) {
+	descending := field&SortDescending != 0
+	var less func(a, b modules.DirectoryInfo) (less bool, equal bool)
+	switch field &^ SortDescending {
+	case SortBySize:
+		less = func(a, b modules.DirectoryInfo) (bool, bool) {
+			return a.AggregateSize < b.AggregateSize, a.AggregateSize == b.AggregateSize
+		}
+	case SortByHealth:
+		less = func(a, b modules.DirectoryInfo) (bool, bool) {
+			return a.AggregateHealth < b.AggregateHealth, a.AggregateHealth == b.AggregateHealth
+		}
+	case SortByNumFiles:
+		less = func(a, b modules.DirectoryInfo) (bool, bool) {
+			return a.AggregateNumFiles < b.AggregateNumFiles, a.AggregateNumFiles == b.AggregateNumFiles
+		}
+	case SortByLastHealthCheckTime:
+		less = func(a, b modules.DirectoryInfo) (bool, bool) {
+			return a.AggregateLastHealthCheckTime.Before(b.AggregateLastHealthCheckTime), a.AggregateLastHealthCheckTime.Equal(b.AggregateLastHealthCheckTime)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sort field %v", int(field))
+	}
+	return func(a, b modules.DirectoryInfo) bool {
+		l, equal := less(a, b)
+		if equal {
+			return a.TurtleDexPath.String() < b.TurtleDexPath.String()
+		}
+		return l != descending
+	}, nil
+}
+
+// SortedDirs returns the first limit directories within prefix, including
+// prefix itself, in the order selected by field. Only limit directories are
+// kept in memory while walking the subtree. The values are taken from the
+// cached metadata, so they are only as recent as the last bubble of each
+// directory.
+func (r *Renter) SortedDirs(prefix modules.TurtleDexPath, field SortField, limit int) ([]modules.DirectoryInfo, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+	if limit < 1 {
+		return nil, fmt.Errorf("limit must be at least 1 but was %v", limit)
+	}
+	before, err := sortFieldLess(field)
+	if err != nil {
+		return nil, err
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	h := &sortedDirsHeap{before: before}
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		heap.Push(h, di)
+		if h.Len() > limit {
+			heap.Pop(h)
+		}
+	}
+	err = r.staticFileSystem.CachedList(prefix, true, func(modules.FileInfo) {}, dlf)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]modules.DirectoryInfo, h.Len())
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dirs[i] = heap.Pop(h).(modules.DirectoryInfo)
+	}
+	return dirs, nil
+}
@@ -0,0 +1,92 @@
+package renter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestSortedDirs probes SortedDirs.
+func TestSortedDirs(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create 3 dirs with a different number of files.
+	numFiles := map[string]int{"s/a": 3, "s/b": 1, "s/c": 2}
+	for dir, n := range numFiles {
+		for i := 0; i < n; i++ {
+			f, err := r.createRenterTestFile(newTurtleDexPath(fmt.Sprintf("%v/file%v", dir, i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.managedBubbleMetadata(newTurtleDexPath(dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		di, err := r.staticFileSystem.DirInfo(newTurtleDexPath("s"))
+		if err != nil {
+			return err
+		}
+		if di.AggregateNumFiles != 6 {
+			return errors.New("s wasn't bubbled yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		field    SortField
+		limit    int
+		expected []string
+	}{
+		{SortByNumFiles | SortDescending, 2, []string{"s", "s/a"}},
+		{SortByNumFiles, 2, []string{"s/b", "s/c"}},
+		{SortBySize | SortDescending, 10, []string{"s", "s/a", "s/c", "s/b"}},
+	}
+	for _, test := range tests {
+		dirs, err := r.SortedDirs(newTurtleDexPath("s"), test.field, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, di := range dirs {
+			paths = append(paths, di.TurtleDexPath.String())
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(test.expected) {
+			t.Errorf("field %v: expected %v but got %v", test.field, test.expected, paths)
+		}
+	}
+
+	// Invalid arguments should be rejected.
+	if _, err := r.SortedDirs(newTurtleDexPath("s"), SortBySize, 0); err == nil {
+		t.Fatal("expected error for limit 0")
+	}
+	if _, err := r.SortedDirs(newTurtleDexPath("s"), SortField(42), 1); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
@@ -0,0 +1,94 @@
+package renter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// strictbubble.go contains the strict bubble mode. Bubbles that run in the
+// background only log their errors, which makes failing bubbles easy to miss
+// in tests. In strict mode these errors are also collected so that a test can
+// check them. Test builds can additionally use a dependency to panic on the
+// first error. Strict mode is meant for tests and CI only: the collected errors
+// are only freed when strict mode is enabled again.
+
+const (
+	// maxStrictBubbleErrors is the maximum number of errors collected in
+	// strict mode. Further errors are only counted.
+	maxStrictBubbleErrors = 1000
+)
+
+type (
+	// strictBubbles collects the errors of background bubbles in strict
+	// mode.
+	strictBubbles struct {
+		enabled    bool
+		errs       []error
+		numDropped int
+		mu         sync.Mutex
+	}
+)
+
+// callEnable enables or disables strict mode and clears the collected
+// errors.
+func (sb *strictBubbles) callEnable(enabled bool) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.enabled = enabled
+	sb.errs = nil
+	sb.numDropped = 0
+}
+
+// callErr returns the collected errors composed into a single error or nil if
+// there are none.
+func (sb *strictBubbles) callErr() error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	err := errors.Compose(sb.errs...)
+	if sb.numDropped > 0 {
+		err = errors.AddContext(err, fmt.Sprintf("%v more bubble errors were dropped", sb.numDropped))
+	}
+	return err
+}
+
+// callRecord collects the error of the bubble of siaPath if strict mode is
+// enabled.
+func (sb *strictBubbles) callRecord(siaPath modules.TurtleDexPath, err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if !sb.enabled {
+		return
+	}
+	if len(sb.errs) >= maxStrictBubbleErrors {
+		sb.numDropped++
+		return
+	}
+	sb.errs = append(sb.errs, errors.AddContext(err, fmt.Sprintf("bubble of '%v' failed", siaPath)))
+}
+
+// BubbleErrors returns the errors of the bubbles that failed in the background
+// since strict mode was enabled. It returns nil if strict mode is disabled or
+// no bubble failed.
+func (r *Renter) BubbleErrors() error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	return r.staticStrictBubbles.callErr()
+}
+
+// SetStrictBubbles enables or disables strict mode and clears the errors that
+// were collected so far. In strict mode the errors of bubbles that run in the
+// background are collected and returned by BubbleErrors. Strict mode is
+// intended for tests and must not be enabled in production.
+func (r *Renter) SetStrictBubbles(enabled bool) error {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	r.staticStrictBubbles.callEnable(enabled)
+	return nil
+}
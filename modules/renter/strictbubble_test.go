@@ -0,0 +1,61 @@
+package renter
+
+import (
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestStrictBubbles probes the strict bubble mode.
+func TestStrictBubbles(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Without strict mode errors aren't collected.
+	missing := newTurtleDexPath("missing")
+	r.callThreadedBubbleMetadata(missing)
+	if err := r.BubbleErrors(); err != nil {
+		t.Fatal("unexpected bubble errors", err)
+	}
+
+	// In strict mode they are.
+	if err := r.SetStrictBubbles(true); err != nil {
+		t.Fatal(err)
+	}
+	r.callThreadedBubbleMetadata(missing)
+	if err := r.BubbleErrors(); err == nil {
+		t.Fatal("expected bubble error")
+	}
+
+	// Enabling strict mode again clears the errors.
+	if err := r.SetStrictBubbles(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.BubbleErrors(); err != nil {
+		t.Fatal("errors should be cleared", err)
+	}
+
+	// The number of collected errors is limited.
+	var sb strictBubbles
+	sb.callEnable(true)
+	for i := 0; i < maxStrictBubbleErrors+1; i++ {
+		sb.callRecord(missing, errors.New("bubble failed"))
+	}
+	if len(sb.errs) != maxStrictBubbleErrors || sb.numDropped != 1 {
+		t.Fatal("wrong number of errors", len(sb.errs), sb.numDropped)
+	}
+}
@@ -0,0 +1,101 @@
+package renter
+
+import (
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+var (
+	// errRefreshAlreadyResumed is returned if the resume func of a
+	// suppression is called more than once.
+	errRefreshAlreadyResumed = errors.New("refreshes of the subtree were already resumed")
+)
+
+type (
+	// refreshSuppressor tracks the subtrees whose refreshes are currently
+	// suppressed.
+	refreshSuppressor struct {
+		nextID       uint64
+		suppressions map[uint64]*refreshSuppression
+		mu           sync.Mutex
+	}
+
+	// refreshSuppression is a single suppressed subtree. The refreshes that
+	// are deferred while it is active are collected in staticDeferred.
+	refreshSuppression struct {
+		staticDeferred *uniqueRefreshPaths
+		staticRoot     modules.TurtleDexPath
+	}
+)
+
+// callDefer checks whether sp is within a suppressed subtree. If it is, sp is
+// remembered to be refreshed once the suppression ends and true is returned.
+func (rs *refreshSuppressor) callDefer(sp modules.TurtleDexPath) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, s := range rs.suppressions {
+		if _, err := sp.RelativeDepth(s.staticRoot); err != nil {
+			continue
+		}
+		if err := s.staticDeferred.callAdd(sp); err != nil {
+			// Don't defer the refresh if we can't remember it.
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// callRemove removes the suppression with the given id and returns it.
+func (rs *refreshSuppressor) callRemove(id uint64) *refreshSuppression {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	s := rs.suppressions[id]
+	delete(rs.suppressions, id)
+	return s
+}
+
+// callSuppress starts a new suppression for the subtree at sp and returns its
+// id.
+func (rs *refreshSuppressor) callSuppress(r *Renter, sp modules.TurtleDexPath) uint64 {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.suppressions == nil {
+		rs.suppressions = make(map[uint64]*refreshSuppression)
+	}
+	id := rs.nextID
+	rs.nextID++
+	rs.suppressions[id] = &refreshSuppression{
+		staticDeferred: r.newUniqueRefreshPaths(),
+		staticRoot:     sp,
+	}
+	return id
+}
+
+// SuppressRefresh suppresses the refreshes of the directories within the
+// subtree at sp, e.g. during a bulk upload into the subtree. Refreshes of
+// other directories continue normally. The returned resume func ends the
+// suppression and refreshes all the directories whose refreshes were
+// suppressed in a single collapsed refresh. It blocks until that refresh is
+// done and may only be called once.
+func (r *Renter) SuppressRefresh(sp modules.TurtleDexPath) (resume func() error) {
+	id := r.staticRefreshSuppressor.callSuppress(r, sp)
+	var mu sync.Mutex
+	resumed := false
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if resumed {
+			return errRefreshAlreadyResumed
+		}
+		resumed = true
+		s := r.staticRefreshSuppressor.callRemove(id)
+		if err := r.tg.Add(); err != nil {
+			return err
+		}
+		defer r.tg.Done()
+		return errors.AddContext(s.staticDeferred.callRefreshAllBlocking(), "failed to refresh the suppressed subtree")
+	}
+}
@@ -0,0 +1,85 @@
+package renter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turtledex/TurtleDexCore/build"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestSuppressRefresh probes SuppressRefresh.
+func TestSuppressRefresh(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create files in a/b and c.
+	for _, file := range []string{"a/b/file", "c/file"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	numFiles := func(dir string) uint64 {
+		di, err := r.staticFileSystem.DirInfo(newTurtleDexPath(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return di.AggregateNumFiles
+	}
+
+	// Suppress the refreshes of a. Bubbling a/b shouldn't do anything while
+	// c can still be bubbled.
+	resume := r.SuppressRefresh(newTurtleDexPath("a"))
+	if err := r.managedBubbleMetadata(newTurtleDexPath("a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if n := numFiles("a/b"); n != 0 {
+		t.Fatal("a/b was bubbled while suppressed", n)
+	}
+	if err := r.managedBubbleMetadata(newTurtleDexPath("c")); err != nil {
+		t.Fatal(err)
+	}
+	if n := numFiles("c"); n != 1 {
+		t.Fatal("c wasn't bubbled", n)
+	}
+
+	// Resuming should bubble a/b and eventually a.
+	if err := resume(); err != nil {
+		t.Fatal(err)
+	}
+	if n := numFiles("a/b"); n != 1 {
+		t.Fatal("a/b wasn't bubbled on resume", n)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if numFiles("a") != 1 {
+			return errors.New("a wasn't bubbled yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resuming a second time should fail.
+	if err := resume(); !errors.Contains(err, errRefreshAlreadyResumed) {
+		t.Fatalf("expected %v but got %v", errRefreshAlreadyResumed, err)
+	}
+}
@@ -0,0 +1,183 @@
+package renter
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
+	"github.com/turtledex/errors"
+)
+
+// tags.go contains the code for managing the user defined tags and flags of
+// directories.
+
+const (
+	// maxTagValuesPerKey is the maximum number of distinct values AllTags
+	// returns for a single tag key.
+	maxTagValuesPerKey = 1000
+)
+
+// AllTags returns every tag key in use by any directory mapped to the sorted
+// distinct values of that key. At most maxTagValuesPerKey values are returned
+// per key. The tags are read from the cached directory metadata.
+func (r *Renter) AllTags() (map[string][]string, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+
+	seen := make(map[string]map[string]struct{})
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		for key, value := range di.Tags {
+			values, exists := seen[key]
+			if !exists {
+				values = make(map[string]struct{})
+				seen[key] = values
+			}
+			if len(values) < maxTagValuesPerKey {
+				values[value] = struct{}{}
+			}
+		}
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	release()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directories")
+	}
+
+	tags := make(map[string][]string, len(seen))
+	for key, values := range seen {
+		sorted := make([]string, 0, len(values))
+		for value := range values {
+			sorted = append(sorted, value)
+		}
+		sort.Strings(sorted)
+		tags[key] = sorted
+	}
+	return tags, nil
+}
+
+// SetDirTags replaces the tags of a directory. Passing an empty map removes
+// all of the directory's tags. The tags are subject to the same limits as the
+// tags of files.
+func (r *Renter) SetDirTags(siaPath modules.TurtleDexPath, tags map[string]string) (err error) {
+	if err := r.tg.Add(); err != nil {
+		return err
+	}
+	defer r.tg.Done()
+	if err := siafile.ValidateTags(tags); err != nil {
+		return errors.AddContext(err, "invalid tags")
+	}
+
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(siaPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Compose(err, dir.Close())
+	}()
+	md, err := dir.Metadata()
+	if err != nil {
+		return err
+	}
+	md.Tags = nil
+	if len(tags) > 0 {
+		md.Tags = make(map[string]string, len(tags))
+		for key, value := range tags {
+			md.Tags[key] = value
+		}
+	}
+	return dir.UpdateMetadata(md)
+}
+
+// SetFlagsByPattern sets the pinned and excluded flags of all directories
+// whose path matches pattern. See modules.DirectoryInfo for how the flags
+// affect the health loop. The pattern uses the syntax of path.Match and is
+// matched against the full path of a directory. A nil flag is left unchanged.
+// The returned paths are the sorted paths of the directories whose flags
+// changed.
+func (r *Renter) SetFlagsByPattern(pattern string, pinned, excluded *bool) (affected []modules.TurtleDexPath, err error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.AddContext(err, fmt.Sprintf("invalid pattern '%v'", pattern))
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the matching directories that need to change.
+	var mu sync.Mutex
+	dlf := func(di modules.DirectoryInfo) {
+		if di.TurtleDexPath.IsRoot() {
+			return
+		}
+		if match, _ := path.Match(pattern, di.TurtleDexPath.String()); !match {
+			return
+		}
+		if (pinned == nil || *pinned == di.Pinned) && (excluded == nil || *excluded == di.Excluded) {
+			return
+		}
+		mu.Lock()
+		affected = append(affected, di.TurtleDexPath)
+		mu.Unlock()
+	}
+	err = r.staticFileSystem.CachedList(modules.RootTurtleDexPath(), true, func(modules.FileInfo) {}, dlf)
+	release()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directories")
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		return affected[i].String() < affected[j].String()
+	})
+
+	// Update the flags and refresh the directories afterwards.
+	urp := r.newUniqueRefreshPaths()
+	defer urp.callRefreshAll()
+	for _, siaPath := range affected {
+		if err := r.managedSetDirFlags(siaPath, pinned, excluded); err != nil {
+			return nil, errors.AddContext(err, fmt.Sprintf("failed to set flags of '%v'", siaPath))
+		}
+		if err := urp.callAdd(siaPath); err != nil {
+			return nil, err
+		}
+	}
+	return affected, nil
+}
+
+// managedSetDirFlags sets the flags of a single directory. A nil flag is left
+// unchanged.
+func (r *Renter) managedSetDirFlags(siaPath modules.TurtleDexPath, pinned, excluded *bool) (err error) {
+	dir, err := r.staticFileSystem.OpenTurtleDexDir(siaPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Compose(err, dir.Close())
+	}()
+	md, err := dir.Metadata()
+	if err != nil {
+		return err
+	}
+	if pinned != nil {
+		md.Pinned = *pinned
+	}
+	if excluded != nil {
+		md.Excluded = *excluded
+	}
+	return dir.UpdateMetadata(md)
+}
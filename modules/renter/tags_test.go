@@ -0,0 +1,187 @@
+package renter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/TurtleDexCore/modules/renter/filesystem/siafile"
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestAllTags probes SetDirTags and AllTags.
+func TestAllTags(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Tag some dirs.
+	dirTags := map[string]map[string]string{
+		"a":   {"env": "prod", "team": "x"},
+		"a/b": {"env": "dev"},
+		"c":   {"env": "prod"},
+	}
+	for dir, tags := range dirTags {
+		sp := newTurtleDexPath(dir)
+		if err := r.CreateDir(sp, modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetDirTags(sp, tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string][]string{
+		"env":  {"dev", "prod"},
+		"team": {"x"},
+	}
+	tags, err := r.AllTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatal("wrong tags", tags)
+	}
+
+	// Tags should survive a bubble.
+	if err := r.managedBubbleMetadata(newTurtleDexPath("a")); err != nil {
+		t.Fatal(err)
+	}
+	di, err := r.staticFileSystem.DirInfo(newTurtleDexPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(di.Tags, dirTags["a"]) {
+		t.Fatal("tags changed by bubble", di.Tags)
+	}
+
+	// Invalid tags are rejected without changing the existing tags.
+	tooLarge := map[string]string{"env": strings.Repeat("x", siafile.MaxTagEntrySize)}
+	if err := r.SetDirTags(newTurtleDexPath("a"), tooLarge); !errors.Contains(err, siafile.ErrTagTooLarge) {
+		t.Fatal("expected ErrTagTooLarge", err)
+	}
+	if err := r.SetDirTags(newTurtleDexPath("a"), map[string]string{"": "x"}); err == nil {
+		t.Fatal("expected empty key to be rejected")
+	}
+	di, err = r.staticFileSystem.DirInfo(newTurtleDexPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(di.Tags, dirTags["a"]) {
+		t.Fatal("tags changed by invalid update", di.Tags)
+	}
+
+	// Removing the tags of a dir removes its values.
+	if err := r.SetDirTags(newTurtleDexPath("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	tags, err = r.AllTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{
+		"env": {"dev", "prod"},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatal("wrong tags", tags)
+	}
+}
+
+// TestSetFlagsByPattern probes SetFlagsByPattern.
+func TestSetFlagsByPattern(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	for _, dir := range []string{"logs/a", "logs/b", "data/a"} {
+		if err := r.CreateDir(newTurtleDexPath(dir), modules.DefaultDirPerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	yes, no := true, false
+
+	// A malformed pattern is rejected.
+	if _, err := r.SetFlagsByPattern("logs/[", &yes, nil); err == nil {
+		t.Fatal("expected malformed pattern to fail")
+	}
+
+	// Pin the log dirs.
+	affected, err := r.SetFlagsByPattern("logs/*", &yes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(affected, []modules.TurtleDexPath{newTurtleDexPath("logs/a"), newTurtleDexPath("logs/b")}) {
+		t.Fatal("wrong affected dirs", affected)
+	}
+
+	// Pinning them again doesn't affect them. Excluding all 'a' dirs only
+	// changes the excluded flag.
+	affected, err = r.SetFlagsByPattern("logs/*", &yes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 0 {
+		t.Fatal("expected no affected dirs", affected)
+	}
+	affected, err = r.SetFlagsByPattern("*/a", nil, &yes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 2 {
+		t.Fatal("expected 2 affected dirs", affected)
+	}
+	expected := map[string][2]bool{
+		"logs/a": {true, true},
+		"logs/b": {true, false},
+		"data/a": {false, true},
+	}
+	for dir, flags := range expected {
+		di, err := r.staticFileSystem.DirInfo(newTurtleDexPath(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if di.Pinned != flags[0] || di.Excluded != flags[1] {
+			t.Fatalf("%v: expected pinned %v and excluded %v but got %v and %v", dir, flags[0], flags[1], di.Pinned, di.Excluded)
+		}
+	}
+
+	// Flags survive a bubble and can be cleared.
+	if err := r.managedBubbleMetadata(newTurtleDexPath("logs/a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SetFlagsByPattern("logs/a", &no, nil); err != nil {
+		t.Fatal(err)
+	}
+	di, err := r.staticFileSystem.DirInfo(newTurtleDexPath("logs/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if di.Pinned || !di.Excluded {
+		t.Fatal("wrong flags", di.Pinned, di.Excluded)
+	}
+}
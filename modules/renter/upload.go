@@ -52,6 +52,14 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 		return errors.AddContext(err, "unable to close file after checking permissions")
 	}
 
+	// Make sure the file doesn't exceed the maximum depth or any quota.
+	if err := up.TurtleDexPath.ValidateDepth(); err != nil {
+		return err
+	}
+	if err := r.managedCheckQuota(up.TurtleDexPath, uint64(sourceInfo.Size())); err != nil {
+		return err
+	}
+
 	// Delete existing file if overwrite flag is set. Ignore ErrUnknownPath.
 	if up.Force {
 		err := r.DeleteFile(up.TurtleDexPath)
@@ -96,6 +104,7 @@ func (r *Renter) Upload(up modules.FileUploadParams) error {
 	if err != nil {
 		return errors.AddContext(err, "could not create a new sia file")
 	}
+	r.callRecordFileOp(fileOpCreated, up.TurtleDexPath, modules.TurtleDexPath{})
 	entry, err := r.staticFileSystem.OpenTurtleDexFile(up.TurtleDexPath)
 	if err != nil {
 		return errors.AddContext(err, "could not open the new sia file")
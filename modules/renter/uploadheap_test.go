@@ -512,7 +512,7 @@ func testManagedAddChunksToHeap(t *testing.T) {
 
 	// Confirm that all chunks from all the directories were added since there
 	// are not enough chunks in only one directory to fill the heap
-	totalDirs := len(siaPaths.childDirs) + len(siaPaths.parentDirs)
+	totalDirs := siaPaths.NumChildDirs() + siaPaths.NumParentDirs()
 	if totalDirs != 3 {
 		t.Fatal("Expected 3 siaPaths to be returned, got", totalDirs)
 	}
@@ -151,6 +151,18 @@ func (r *Renter) managedInitUploadStream(up modules.FileUploadParams) (*filesyst
 		return nil, errors.New("'force' and 'repair' can't both be set")
 	}
 
+	// Make sure the file doesn't exceed the maximum depth or any quota. The
+	// size of a streamed file isn't known upfront, so only the number of files
+	// is checked.
+	if !repair {
+		if err := siaPath.ValidateDepth(); err != nil {
+			return nil, err
+		}
+		if err := r.managedCheckQuota(siaPath, 0); err != nil {
+			return nil, err
+		}
+	}
+
 	// Delete existing file if overwrite flag is set. Ignore ErrUnknownPath.
 	if force {
 		err := r.DeleteFile(siaPath)
@@ -188,6 +200,7 @@ func (r *Renter) managedInitUploadStream(up modules.FileUploadParams) (*filesyst
 	if err != nil {
 		return nil, err
 	}
+	r.callRecordFileOp(fileOpCreated, siaPath, modules.TurtleDexPath{})
 	return r.staticFileSystem.OpenTurtleDexFile(siaPath)
 }
 
@@ -0,0 +1,158 @@
+package renter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/turtledex/TurtleDexCore/modules"
+	"github.com/turtledex/errors"
+)
+
+// verifycounts.go contains a lightweight consistency check which only verifies
+// the number of files and subdirectories stored in the metadata of the
+// directories. Unlike a bubble it doesn't recompute any health related fields.
+//
+// The check first derives the counts from a single cached listing of the whole
+// tree. Only directories whose derived counts disagree with their stored counts
+// are re-counted by reading the directory on disk. This rules out false
+// positives caused by files that failed to load during the cached listing or
+// that were added or removed while the listing was in progress.
+
+type (
+	// CountMismatch describes a directory whose stored counts don't match the
+	// number of files and subdirectories that it actually contains.
+	CountMismatch struct {
+		TurtleDexPath modules.TurtleDexPath `json:"siapath"`
+
+		StoredNumFiles   uint64 `json:"storednumfiles"`
+		StoredNumSubDirs uint64 `json:"storednumsubdirs"`
+		ActualNumFiles   uint64 `json:"actualnumfiles"`
+		ActualNumSubDirs uint64 `json:"actualnumsubdirs"`
+
+		// Recounted indicates whether the actual counts were obtained by
+		// re-reading the directory from disk. If it is false, re-reading the
+		// directory failed and the actual counts are based on the cached
+		// listing.
+		Recounted bool `json:"recounted"`
+	}
+
+	// dirCounts are the number of files and subdirectories of a directory.
+	dirCounts struct {
+		numFiles   uint64
+		numSubDirs uint64
+	}
+)
+
+// VerifyCounts compares the stored NumFiles and NumSubDirs of every directory
+// within siaPath, including siaPath itself, against the number of files and
+// subdirectories they actually contain. The returned mismatches are sorted by
+// path.
+func (r *Renter) VerifyCounts(siaPath modules.TurtleDexPath) ([]CountMismatch, error) {
+	if err := r.tg.Add(); err != nil {
+		return nil, err
+	}
+	defer r.tg.Done()
+
+	// Get the stored counts and derive the actual counts from the cached
+	// listing.
+	stored := make(map[modules.TurtleDexPath]dirCounts)
+	derived := make(map[modules.TurtleDexPath]dirCounts)
+	var mu sync.Mutex
+	flf := func(fi modules.FileInfo) {
+		parent, err := fi.TurtleDexPath.Dir()
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		c := derived[parent]
+		c.numFiles++
+		derived[parent] = c
+	}
+	dlf := func(di modules.DirectoryInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		stored[di.TurtleDexPath] = dirCounts{
+			numFiles:   di.NumFiles,
+			numSubDirs: di.NumSubDirs,
+		}
+		if di.TurtleDexPath.Equals(siaPath) {
+			return
+		}
+		parent, err := di.TurtleDexPath.Dir()
+		if err != nil {
+			return
+		}
+		c := derived[parent]
+		c.numSubDirs++
+		derived[parent] = c
+	}
+	release, err := r.managedAcquireListing()
+	if err != nil {
+		return nil, err
+	}
+	err = r.staticFileSystem.CachedList(siaPath, true, flf, dlf)
+	release()
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to list directory")
+	}
+
+	// Re-count the directories that look inconsistent.
+	var mismatches []CountMismatch
+	for sp, s := range stored {
+		if derived[sp] == s {
+			continue
+		}
+		actual, err := r.managedCountDirEntries(sp)
+		if os.IsNotExist(err) {
+			// The directory was deleted in the meantime.
+			continue
+		}
+		recounted := err == nil
+		if !recounted {
+			r.log.Printf("WARN: unable to re-count '%v', using cached counts: %v", sp, err)
+			actual = derived[sp]
+		} else if actual == s {
+			continue
+		}
+		mismatches = append(mismatches, CountMismatch{
+			TurtleDexPath:    sp,
+			StoredNumFiles:   s.numFiles,
+			StoredNumSubDirs: s.numSubDirs,
+			ActualNumFiles:   actual.numFiles,
+			ActualNumSubDirs: actual.numSubDirs,
+			Recounted:        recounted,
+		})
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].TurtleDexPath.String() < mismatches[j].TurtleDexPath.String()
+	})
+	return mismatches, nil
+}
+
+// managedCountDirEntries counts the files and subdirectories of a directory by
+// reading it from disk. Files and directories are identified the same way a
+// bubble identifies them.
+func (r *Renter) managedCountDirEntries(siaPath modules.TurtleDexPath) (c dirCounts, _ error) {
+	fis, err := r.staticFileSystem.ReadDir(siaPath)
+	if err != nil {
+		return dirCounts{}, err
+	}
+	for _, fi := range fis {
+		if filepath.Ext(fi.Name()) == modules.TurtleDexFileExtension {
+			c.numFiles++
+		} else if fi.IsDir() {
+			c.numSubDirs++
+		}
+	}
+	return c, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (cm CountMismatch) String() string {
+	return fmt.Sprintf("%v: stored %v files and %v dirs but found %v files and %v dirs",
+		cm.TurtleDexPath, cm.StoredNumFiles, cm.StoredNumSubDirs, cm.ActualNumFiles, cm.ActualNumSubDirs)
+}
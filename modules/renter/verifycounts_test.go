@@ -0,0 +1,96 @@
+package renter
+
+import (
+	"testing"
+
+	"github.com/turtledex/TurtleDexCore/siatest/dependencies"
+	"github.com/turtledex/errors"
+)
+
+// TestVerifyCounts probes VerifyCounts.
+func TestVerifyCounts(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rt, err := newRenterTesterWithDependency(t.Name(), &dependencies.DependencyDisableRepairAndHealthLoops{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := rt.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	r := rt.renter
+
+	// Create a dir with a file and a subdir with another file.
+	dir := newTurtleDexPath("verify")
+	subDir := newTurtleDexPath("verify/sub")
+	for _, sp := range []string{"verify/a", "verify/sub/b"} {
+		f, err := r.createRenterTestFile(newTurtleDexPath(sp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The counts haven't been bubbled yet.
+	mismatches, err := r.VerifyCounts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 2 {
+		t.Fatalf("expected 2 mismatches but got %v", len(mismatches))
+	}
+	if !mismatches[0].TurtleDexPath.Equals(dir) || !mismatches[1].TurtleDexPath.Equals(subDir) {
+		t.Fatal("wrong mismatches", mismatches)
+	}
+	m := mismatches[0]
+	if !m.Recounted || m.ActualNumFiles != 1 || m.ActualNumSubDirs != 1 || m.StoredNumFiles != 0 || m.StoredNumSubDirs != 0 {
+		t.Fatal("wrong mismatch", m)
+	}
+
+	// Bubble the dirs. Afterwards there shouldn't be any mismatches.
+	for _, sp := range []string{"verify/sub", "verify"} {
+		if err := r.managedBubbleMetadata(newTurtleDexPath(sp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mismatches, err = r.VerifyCounts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Fatal("expected no mismatches", mismatches)
+	}
+
+	// Corrupt the stored counts of the subdir.
+	sd, err := r.staticFileSystem.OpenTurtleDexDir(subDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := sd.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md.NumFiles = 5
+	err = errors.Compose(sd.UpdateMetadata(md), sd.Close())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = r.VerifyCounts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 {
+		t.Fatalf("expected 1 mismatch but got %v", len(mismatches))
+	}
+	m = mismatches[0]
+	if !m.TurtleDexPath.Equals(subDir) || !m.Recounted || m.StoredNumFiles != 5 || m.ActualNumFiles != 1 {
+		t.Fatal("wrong mismatch", m)
+	}
+}
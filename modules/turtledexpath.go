@@ -4,8 +4,10 @@ import (
 	"encoding/base32"
 	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"unicode/utf8"
 
 	"github.com/turtledex/errors"
@@ -23,6 +25,9 @@ var (
 	ErrInvalidTurtleDexPath = errors.New("invalid TurtleDexPath")
 	// ErrInvalidPathString is the error for an invalid path
 	ErrInvalidPathString = errors.New("invalid path string")
+	// ErrTurtleDexPathTooDeep is the error for a TurtleDexPath that exceeds the
+	// maximum depth
+	ErrTurtleDexPathTooDeep = errors.New("TurtleDexPath exceeds the maximum depth")
 
 	// TurtleDexDirExtension is the extension for ttdxdir metadata files on disk
 	TurtleDexDirExtension = ".ttdxdir"
@@ -64,6 +69,12 @@ var (
 	VarFolder = NewGlobalTurtleDexPath("/var")
 )
 
+var (
+	// maxTurtleDexPathDepth is the maximum number of elements a TurtleDexPath
+	// may contain. A value of 0 means that the depth is unlimited.
+	maxTurtleDexPathDepth uint64
+)
+
 type (
 	// TurtleDexPath is the struct used to uniquely identify siafiles and ttdxdirs across
 	// TurtleDex
@@ -72,6 +83,18 @@ type (
 	}
 )
 
+// MaxTurtleDexPathDepth returns the maximum number of elements a TurtleDexPath
+// may contain. 0 means that the depth is unlimited.
+func MaxTurtleDexPathDepth() uint64 {
+	return atomic.LoadUint64(&maxTurtleDexPathDepth)
+}
+
+// SetMaxTurtleDexPathDepth sets the maximum number of elements a TurtleDexPath
+// may contain. Setting it to 0 removes the limit.
+func SetMaxTurtleDexPathDepth(depth uint64) {
+	atomic.StoreUint64(&maxTurtleDexPathDepth, depth)
+}
+
 // NewTurtleDexPath returns a new TurtleDexPath with the path set
 func NewTurtleDexPath(s string) (TurtleDexPath, error) {
 	return newTurtleDexPath(s)
@@ -124,6 +147,13 @@ func newTurtleDexPath(s string) (TurtleDexPath, error) {
 	return sp, sp.Validate(false)
 }
 
+// Clean returns the TurtleDexPath with its path cleaned like NewTurtleDexPath
+// does, i.e. with forward slashes and without leading and trailing slashes.
+// The result is not validated.
+func (sp TurtleDexPath) Clean() TurtleDexPath {
+	return TurtleDexPath{Path: clean(sp.Path)}
+}
+
 // AddSuffix adds a numeric suffix to the end of the TurtleDexPath.
 func (sp TurtleDexPath) AddSuffix(suffix uint) TurtleDexPath {
 	return TurtleDexPath{
@@ -131,6 +161,15 @@ func (sp TurtleDexPath) AddSuffix(suffix uint) TurtleDexPath {
 	}
 }
 
+// Depth returns the number of elements of the TurtleDexPath. The root has a
+// depth of 0.
+func (sp TurtleDexPath) Depth() uint64 {
+	if sp.IsRoot() {
+		return 0
+	}
+	return uint64(strings.Count(sp.Path, "/") + 1)
+}
+
 // Dir returns the directory of the TurtleDexPath
 func (sp TurtleDexPath) Dir() (TurtleDexPath, error) {
 	pathElements := strings.Split(sp.Path, "/")
@@ -194,6 +233,13 @@ func (sp TurtleDexPath) MarshalJSON() ([]byte, error) {
 	return json.Marshal(sp.String())
 }
 
+// Match reports whether the TurtleDexPath matches the shell pattern. The
+// pattern syntax is the one of path.Match, so '*' doesn't match across '/'.
+// The only possible error is path.ErrBadPattern.
+func (sp TurtleDexPath) Match(pattern string) (bool, error) {
+	return path.Match(pattern, sp.Path)
+}
+
 // Name returns the name of the file.
 func (sp TurtleDexPath) Name() string {
 	pathElements := strings.Split(sp.Path, "/")
@@ -205,6 +251,19 @@ func (sp TurtleDexPath) Name() string {
 	return name
 }
 
+// RelativeDepth returns how many levels the TurtleDexPath is below the provided
+// ancestor. The relative depth of a TurtleDexPath to itself is 0. An error is
+// returned if the TurtleDexPath isn't a descendant of the ancestor.
+func (sp TurtleDexPath) RelativeDepth(ancestor TurtleDexPath) (int, error) {
+	if sp.Equals(ancestor) {
+		return 0, nil
+	}
+	if !ancestor.IsRoot() && !strings.HasPrefix(sp.Path, ancestor.Path+"/") {
+		return 0, fmt.Errorf("'%v' is not a descendant of '%v'", sp.Path, ancestor.Path)
+	}
+	return int(sp.Depth() - ancestor.Depth()), nil
+}
+
 // Rebase changes the base of a siapath from oldBase to newBase and returns a new TurtleDexPath.
 // e.g. rebasing 'a/b/myfile' from oldBase 'a/b/' to 'a/' would result in 'a/myfile'
 func (sp TurtleDexPath) Rebase(oldBase, newBase TurtleDexPath) (TurtleDexPath, error) {
@@ -278,6 +337,18 @@ func (sp TurtleDexPath) Validate(isRoot bool) error {
 	return nil
 }
 
+// ValidateDepth checks that the TurtleDexPath doesn't exceed the maximum depth.
+// It isn't part of Validate since paths which already exist need to remain
+// accessible after the limit is lowered. Instead it is checked wherever a new
+// file or directory is created.
+func (sp TurtleDexPath) ValidateDepth() error {
+	maxDepth := MaxTurtleDexPathDepth()
+	if maxDepth == 0 || sp.Depth() <= maxDepth {
+		return nil
+	}
+	return errors.AddContext(ErrTurtleDexPathTooDeep, fmt.Sprintf("depth of '%v' is %v but the limit is %v", sp.Path, sp.Depth(), maxDepth))
+}
+
 // ValidatePathString validates a path given a string.
 func ValidatePathString(path string, isRoot bool) error {
 	if err := validatePath(path, isRoot); err != nil {
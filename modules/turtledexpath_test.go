@@ -83,6 +83,68 @@ func TestTurtleDexpathValidate(t *testing.T) {
 	}
 }
 
+// TestTurtleDexpathMaxDepth verifies that ValidateDepth rejects TurtleDexPaths
+// exceeding the maximum depth while they remain valid TurtleDexPaths.
+func TestTurtleDexpathMaxDepth(t *testing.T) {
+	// Don't run in parallel since the limit is global.
+	defer SetMaxTurtleDexPathDepth(0)
+
+	// Without a limit any depth is valid.
+	if _, err := NewTurtleDexPath("a/b/c/d/e/f/g/h"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set a limit of 3.
+	SetMaxTurtleDexPathDepth(3)
+	if MaxTurtleDexPathDepth() != 3 {
+		t.Fatal("wrong max depth", MaxTurtleDexPathDepth())
+	}
+	var pathtests = []struct {
+		in    string
+		depth uint64
+		valid bool
+	}{
+		{"a", 1, true},
+		{"a/b", 2, true},
+		{"a/b/c", 3, true},
+		{"a/b/c/d", 4, false},
+		{"a/b/c/d/e", 5, false},
+	}
+	for _, pathtest := range pathtests {
+		sp := TurtleDexPath{Path: pathtest.in}
+		if sp.Depth() != pathtest.depth {
+			t.Fatalf("expected depth %v for %v but got %v", pathtest.depth, pathtest.in, sp.Depth())
+		}
+		// Existing paths beyond the limit need to remain accessible.
+		if _, err := NewTurtleDexPath(pathtest.in); err != nil {
+			t.Fatal("path should be valid", pathtest.in, err)
+		}
+		err := sp.ValidateDepth()
+		if pathtest.valid && err != nil {
+			t.Fatal("depth should be valid", pathtest.in, err)
+		}
+		if !pathtest.valid && !errors.Contains(err, ErrTurtleDexPathTooDeep) {
+			t.Fatal("expected ErrTurtleDexPathTooDeep", pathtest.in, err)
+		}
+	}
+	// Joining past the limit works but the result is too deep.
+	sp, err := NewTurtleDexPath("a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := sp.Join("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := joined.ValidateDepth(); !errors.Contains(err, ErrTurtleDexPathTooDeep) {
+		t.Fatal("expected ErrTurtleDexPathTooDeep", err)
+	}
+	// The root is always valid.
+	if err := RootTurtleDexPath().ValidateDepth(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestTurtleDexpath tests that the NewTurtleDexPath, LoadString, and Join methods function correctly
 func TestTurtleDexpath(t *testing.T) {
 	var pathtests = []struct {
@@ -253,6 +315,42 @@ func TestTurtleDexpathRebase(t *testing.T) {
 	}
 }
 
+// TestTurtleDexpathRelativeDepth tests the TurtleDexPath RelativeDepth method.
+func TestTurtleDexpathRelativeDepth(t *testing.T) {
+	var tests = []struct {
+		path     TurtleDexPath
+		ancestor TurtleDexPath
+		depth    int
+		valid    bool
+	}{
+		// Equal paths
+		{RootTurtleDexPath(), RootTurtleDexPath(), 0, true},
+		{TurtleDexPath{Path: "a/b"}, TurtleDexPath{Path: "a/b"}, 0, true},
+		// Descendants
+		{TurtleDexPath{Path: "a"}, RootTurtleDexPath(), 1, true},
+		{TurtleDexPath{Path: "a/b/c"}, RootTurtleDexPath(), 3, true},
+		{TurtleDexPath{Path: "a/b/c"}, TurtleDexPath{Path: "a"}, 2, true},
+		{TurtleDexPath{Path: "a/b/c"}, TurtleDexPath{Path: "a/b"}, 1, true},
+		// Non-descendants
+		{RootTurtleDexPath(), TurtleDexPath{Path: "a"}, 0, false},
+		{TurtleDexPath{Path: "a"}, TurtleDexPath{Path: "a/b"}, 0, false},
+		{TurtleDexPath{Path: "ab/c"}, TurtleDexPath{Path: "a"}, 0, false},
+		{TurtleDexPath{Path: "b/c"}, TurtleDexPath{Path: "a"}, 0, false},
+	}
+	for _, test := range tests {
+		depth, err := test.path.RelativeDepth(test.ancestor)
+		if test.valid && err != nil {
+			t.Fatal("unexpected error", test.path, test.ancestor, err)
+		}
+		if !test.valid && err == nil {
+			t.Fatal("expected error", test.path, test.ancestor)
+		}
+		if depth != test.depth {
+			t.Fatalf("expected relative depth of %v to %v to be %v but was %v", test.path, test.ancestor, test.depth, depth)
+		}
+	}
+}
+
 // TestTurtleDexpathDir probes the Dir function for TurtleDexPaths.
 func TestTurtleDexpathDir(t *testing.T) {
 	var pathtests = []struct {
@@ -307,3 +405,36 @@ func TestTurtleDexpathName(t *testing.T) {
 		}
 	}
 }
+
+// TestTurtleDexpathMatch probes the Match method.
+func TestTurtleDexpathMatch(t *testing.T) {
+	var matchtests = []struct {
+		path    string
+		pattern string
+		match   bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "f*", true},
+		{"foo/bar", "foo/*", true},
+		{"foo/bar", "*", false},
+		{"foo/bar/baz", "foo/*", false},
+		{"foo/bar/baz", "foo/*/b?z", true},
+		{"foo", "bar", false},
+		{"", "*", true},
+	}
+	for _, test := range matchtests {
+		siaPath := TurtleDexPath{
+			Path: test.path,
+		}
+		match, err := siaPath.Match(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match != test.match {
+			t.Errorf("Match(%v) of %v should be %v", test.pattern, test.path, test.match)
+		}
+	}
+	if _, err := RootTurtleDexPath().Match("[a-"); err == nil {
+		t.Fatal("expected bad pattern to be rejected")
+	}
+}
@@ -53,6 +53,17 @@ func (d *DependencyDisableRepairAndHealthLoopsMulti) Disrupt(s string) bool {
 	return s == "DisableRepairAndHealthLoops" || s == "DisableLHCTCorrection"
 }
 
+// DependencyPanicOnBubbleError makes the renter panic if a bubble that runs in
+// the background fails. It also disables the repair and health loops.
+type DependencyPanicOnBubbleError struct {
+	modules.ProductionDependencies
+}
+
+// Disrupt will make the renter panic on bubble errors.
+func (d *DependencyPanicOnBubbleError) Disrupt(s string) bool {
+	return s == "DisableRepairAndHealthLoops" || s == "PanicOnBubbleError"
+}
+
 // DependencyAddUnrepairableChunks will have the repair loop always add chunks
 // to the upload heap even if they are unrepairable
 type DependencyAddUnrepairableChunks struct {
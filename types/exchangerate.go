@@ -7,7 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"regexp"
+
+	"github.com/turtledex/TurtleDexCore/build"
 )
 
 type (
@@ -26,13 +27,10 @@ var (
 	// ErrZeroNotAllowed is returned when attempting to set the exchange rate to
 	// zero.
 	ErrZeroNotAllowed = errors.New("exchange rate cannot be zero")
-
-	// exchangeRateRegExp describes the format of an exchange rate as a regular
-	// expression.
-	exchangeRateRegExp = regexp.MustCompile(`^\s*([0-9.]+) ?([A-Za-z_]+)\s*$`)
 )
 
-// ParseExchangeRate parses a string into an exchange rate. It returns nil if no
+// ParseExchangeRate parses a string of the format "<rate> <currency>" or
+// "<currency>:<rate>" into an exchange rate. It returns nil if no
 // valid exchange rate could be detected. If s is not an empty string and the
 // contents could not be parsed, an error will be returned that describes the
 // parse error.
@@ -41,12 +39,14 @@ func ParseExchangeRate(s string) (*ExchangeRate, error) {
 		return nil, nil
 	}
 
-	matches := exchangeRateRegExp.FindStringSubmatch(s)
-	if len(matches) != 3 {
+	// The format is validated by the build package, so that every consumer of
+	// the exchange rate environment variable accepts the same values.
+	rateStr, symbol, err := build.SplitExchangeRate(s)
+	if err != nil || rateStr == "" {
 		return nil, ErrUnexpectedFormat
 	}
 
-	value, ok := new(big.Float).SetString(matches[1])
+	value, ok := new(big.Float).SetString(rateStr)
 	if !ok {
 		return nil, ErrUnexpectedFormat
 	}
@@ -57,7 +57,7 @@ func ParseExchangeRate(s string) (*ExchangeRate, error) {
 
 	rate := &ExchangeRate{
 		staticValue:  value,
-		staticSymbol: matches[2],
+		staticSymbol: symbol,
 	}
 	return rate, nil
 }
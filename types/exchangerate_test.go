@@ -27,6 +27,9 @@ func TestParseInvalidExchangeRate(t *testing.T) {
 		{"10 10", ErrUnexpectedFormat},
 		{"1 1", ErrUnexpectedFormat},
 		{"USD USD", ErrUnexpectedFormat},
+		{"USD:", ErrUnexpectedFormat},
+		{"USD:-1", ErrUnexpectedFormat},
+		{"USD:0", ErrZeroNotAllowed},
 	}
 	for _, test := range tests {
 		rate, err := ParseExchangeRate(test.s)
@@ -59,6 +62,8 @@ func TestParseValidExchangeRate(t *testing.T) {
 		{"1 USD ", "1", "USD"},
 		{" 1 USD ", "1", "USD"},
 		{"   1 USD   ", "1", "USD"},
+		{"USD:1.23", "1.23", "USD"},
+		{" EUR : 123.456789 ", "123.456789", "EUR"},
 	}
 	for _, test := range tests {
 		rate, err := ParseExchangeRate(test.s)